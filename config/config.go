@@ -5,6 +5,7 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"log"
 	"sync"
+	"time"
 )
 
 type Config struct {
@@ -12,7 +13,20 @@ type Config struct {
 	TimeZone    string `yaml:"time_zone" env-default:"UTC"`
 	LogRecords  int64  `yaml:"log_records" env-default:"0"`
 	FirebaseKey string `yaml:"firebase_key" env-default:""`
-	Listen      struct {
+	// Oidc lists identity providers whose JWTs authenticate users besides
+	// Firebase, each with the keys at JwksUrl or in JwksFile. The required
+	// Name prefixes the user ids of the provider.
+	Oidc []struct {
+		Name        string   `yaml:"name"`
+		Issuer      string   `yaml:"issuer"`
+		Audience    []string `yaml:"audience"`
+		JwksUrl     string   `yaml:"jwks_url"`
+		JwksFile    string   `yaml:"jwks_file"`
+		UserIdClaim string   `yaml:"user_id_claim"`
+		NameClaim   string   `yaml:"name_claim"`
+		EmailClaim  string   `yaml:"email_claim"`
+	} `yaml:"oidc"`
+	Listen struct {
 		Type     string `yaml:"type" env-default:"port"`
 		BindIP   string `yaml:"bind_ip" env-default:"0.0.0.0"`
 		Port     string `yaml:"port" env-default:"5000"`
@@ -50,8 +64,83 @@ type Config struct {
 		// If empty, the add-card web flow will refuse to build a form.
 		NotifyUrl string `yaml:"notify_url" env-default:""`
 		Currency  string `yaml:"currency" env-default:"978"`
-		ApiKey    string `yaml:"api_key" env-default:""`
+		// ApiKey is a service key with the payments.pay and payments.refund
+		// scopes, accepted besides the keys managed under /api-keys
+		ApiKey string `yaml:"api_key" env-default:""`
 	} `yaml:"redsys"`
+	// FakeGateway replaces Redsys with an offline gateway in local
+	// environments, so enrollment, payments, refunds and preauthorizations
+	// can be exercised without a merchant account. It is ignored when
+	// Redsys is enabled and refused in production.
+	FakeGateway struct {
+		Enabled bool `yaml:"enabled" env-default:"false"`
+		// Outcome is approve, decline or timeout; Outcomes overrides it per
+		// operation (enroll, pay, preauthorize, capture, cancel, refund).
+		Outcome     string            `yaml:"outcome" env-default:"approve"`
+		Outcomes    map[string]string `yaml:"outcomes"`
+		DeclineCode string            `yaml:"decline_code" env-default:"0190"`
+		Timeout     time.Duration     `yaml:"timeout" env-default:"30s"`
+		NotifyUrl   string            `yaml:"notify_url" env-default:"http://localhost:5500/api/v1/payment/notify"`
+		Currency    string            `yaml:"currency" env-default:"978"`
+	} `yaml:"fake_gateway"`
+	// Preauthorization drives the automatic lifecycle of card holds: a hold
+	// whose session has not started within StartTimeout is cancelled, and
+	// no hold stays authorized longer than MaxAge.
+	Preauthorization struct {
+		StartTimeout time.Duration `yaml:"start_timeout" env-default:"30m"`
+		MaxAge       time.Duration `yaml:"max_age" env-default:"24h"`
+	} `yaml:"preauthorization"`
+	// PaymentRetry holds the delays between automatic retries of a failed
+	// payment, per decline class: soft declines (insufficient funds, ...)
+	// and technical errors (timeouts, 9xxx codes). Hard declines (lost or
+	// stolen card, ...) are never retried.
+	PaymentRetry struct {
+		Soft      []time.Duration `yaml:"soft" env-default:"1h,24h,168h,720h"`
+		Technical []time.Duration `yaml:"technical" env-default:"15m,1h,6h,24h"`
+	} `yaml:"payment_retry"`
+	// Wallet holds the prepaid balance rules: a remote start without a
+	// selected card is accepted when the balance is at least MinStartBalance
+	// (cents), and top-ups must be between MinTopUp and MaxTopUp.
+	Wallet struct {
+		MinStartBalance int `yaml:"min_start_balance" env-default:"500"`
+		MinTopUp        int `yaml:"min_top_up" env-default:"1000"`
+		MaxTopUp        int `yaml:"max_top_up" env-default:"50000"`
+	} `yaml:"wallet"`
+	// Session sets how long a login's access token is accepted and how long
+	// its refresh token can renew it.
+	Session struct {
+		TokenTTL   time.Duration `yaml:"token_ttl" env-default:"24h"`
+		RefreshTTL time.Duration `yaml:"refresh_ttl" env-default:"720h"`
+	} `yaml:"session"`
+	// Account sets the emailed password reset and email verification links:
+	// ResetUrl and VerifyUrl are app pages receiving the token as the
+	// "token" query parameter. With VerifyEmail, registered users have no
+	// permissions until they confirm their email address. InviteRequired
+	// rejects registrations without an invite code.
+	Account struct {
+		InviteRequired bool          `yaml:"invite_required" env-default:"true"`
+		ResetUrl       string        `yaml:"reset_url" env-default:""`
+		ResetTTL       time.Duration `yaml:"reset_ttl" env-default:"1h"`
+		VerifyEmail    bool          `yaml:"verify_email" env-default:"false"`
+		VerifyUrl      string        `yaml:"verify_url" env-default:""`
+		VerifyTTL      time.Duration `yaml:"verify_ttl" env-default:"48h"`
+	} `yaml:"account"`
+	// TwoFactor sets the TOTP second factor: Issuer names the service in
+	// authenticator apps, and users of RequiredRoles have no permissions
+	// until they enroll one.
+	TwoFactor struct {
+		Issuer        string   `yaml:"issuer" env-default:"EVSys"`
+		RequiredRoles []string `yaml:"required_roles" env-default:"admin,operator"`
+	} `yaml:"two_factor"`
+	// CardNotice reminds users by email and in the app of saved cards that
+	// expire within ExpiryWindow or whose last payment was declined, at most
+	// once per ResendAfter; the email links to AddCardUrl.
+	CardNotice struct {
+		Enabled      bool          `yaml:"enabled" env-default:"false"`
+		ExpiryWindow time.Duration `yaml:"expiry_window" env-default:"720h"`
+		ResendAfter  time.Duration `yaml:"resend_after" env-default:"168h"`
+		AddCardUrl   string        `yaml:"add_card_url" env-default:""`
+	} `yaml:"card_notice"`
 	Brevo struct {
 		Enabled    bool   `yaml:"enabled" env-default:"false"`
 		ApiKey     string `yaml:"api_key" env-default:""`
@@ -59,6 +148,19 @@ type Config struct {
 		SenderMail string `yaml:"sender_email" env-default:"noreply@example.com"`
 		ApiUrl     string `yaml:"api_url" env-default:"https://api.brevo.com/v3/smtp/email"`
 	} `yaml:"brevo"`
+	Invoice struct {
+		Enabled      bool   `yaml:"enabled" env-default:"false"`
+		Series       string `yaml:"series" env-default:"F"`
+		CreditSeries string `yaml:"credit_series" env-default:"R"`
+		// VatRate is the VAT percentage included in charging prices;
+		// VatRates overrides it per location id, e.g. for stations in
+		// territories with their own indirect tax.
+		VatRate       float64            `yaml:"vat_rate" env-default:"21"`
+		VatRates      map[string]float64 `yaml:"vat_rates"`
+		IssuerName    string             `yaml:"issuer_name" env-default:""`
+		IssuerTaxId   string             `yaml:"issuer_tax_id" env-default:""`
+		IssuerAddress string             `yaml:"issuer_address" env-default:""`
+	} `yaml:"invoice"`
 }
 
 var instance *Config
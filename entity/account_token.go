@@ -0,0 +1,65 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"net/http"
+	"time"
+)
+
+// Purposes of account tokens.
+const (
+	AccountTokenPasswordReset = "password_reset"
+	AccountTokenEmailVerify   = "email_verify"
+	AccountTokenTwoFactor     = "two_factor" // login awaiting the second factor
+)
+
+// AccountToken is a single-use, expiring token of an account action: emailed
+// to reset the password or confirm the email address, or returned by a login
+// awaiting the second factor. Only a hash of the token is stored.
+type AccountToken struct {
+	Hash      string    `json:"-" bson:"_id"`
+	Purpose   string    `json:"purpose" bson:"purpose"`
+	UserId    string    `json:"-" bson:"user_id"`
+	Username  string    `json:"username" bson:"username"`
+	Email     string    `json:"email" bson:"email"` // address the token was sent to
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+
+	Token string `json:"-" bson:"-"`
+}
+
+// AccountMail carries what the password reset and email verification emails
+// show; Link holds the token.
+type AccountMail struct {
+	Username  string
+	Link      string
+	ExpiresAt time.Time
+}
+
+// PasswordForgotRequest asks for a password reset link by username or email.
+type PasswordForgotRequest struct {
+	Login string `json:"login" validate:"required"`
+}
+
+func (r *PasswordForgotRequest) Bind(_ *http.Request) error {
+	return validate.Struct(r)
+}
+
+// PasswordResetRequest sets a new password with the token of a reset link.
+type PasswordResetRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
+
+func (r *PasswordResetRequest) Bind(_ *http.Request) error {
+	return validate.Struct(r)
+}
+
+// EmailVerifyRequest confirms an email address with the token of a verification link.
+type EmailVerifyRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *EmailVerifyRequest) Bind(_ *http.Request) error {
+	return validate.Struct(r)
+}
@@ -0,0 +1,66 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// API key scopes; a scope lets a key call the routes that require the
+// permission of the same name.
+const (
+	ScopePaymentsPay    = "payments.pay"
+	ScopePaymentsRefund = PermissionPaymentsRefund
+	ScopeReportsRead    = PermissionReportsRead
+)
+
+// ApiKeyScopes lists the scopes an API key can hold.
+var ApiKeyScopes = []string{ScopePaymentsPay, ScopePaymentsRefund, ScopeReportsRead}
+
+// ApiKey authenticates a service integration. Only a hash of the secret is
+// stored; the secret is returned once, when the key is created or rotated.
+type ApiKey struct {
+	Id        string     `json:"id" bson:"_id"`
+	Name      string     `json:"name" bson:"name"`
+	Prefix    string     `json:"prefix" bson:"prefix"` // leading characters of the secret, to tell keys apart
+	KeyHash   string     `json:"-" bson:"key_hash"`
+	Scopes    []string   `json:"scopes" bson:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty" bson:"last_used,omitempty"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	CreatedBy string     `json:"created_by" bson:"created_by"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty" bson:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+
+	Key string `json:"key,omitempty" bson:"-"`
+}
+
+// Active reports whether the key is neither revoked nor expired.
+func (k *ApiKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// User returns the identity requests authenticated by the key act as; its
+// permissions are the key's scopes, its user id names the key.
+func (k *ApiKey) User() *User {
+	return &User{
+		UserId:      "apikey:" + k.Id,
+		Username:    "apikey:" + k.Name,
+		Permissions: slices.Clone(k.Scopes),
+	}
+}
+
+// ApiKeyRequest creates an API key.
+type ApiKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=64"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at" validate:"omitempty"`
+}
+
+func (r *ApiKeyRequest) Bind(_ *http.Request) error {
+	return validate.Struct(r)
+}
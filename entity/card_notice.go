@@ -0,0 +1,61 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+)
+
+// Card notice kinds.
+const (
+	CardNoticeExpiring = "expiring" // expires within the notice window, or has expired
+	CardNoticeFailed   = "failed"   // its last payment was declined
+)
+
+// CardNotice records the last reminder sent about a saved card, so the
+// reminder is not repeated before the resend interval.
+type CardNotice struct {
+	Id         string    `json:"id" bson:"_id"` // identifier/kind
+	Identifier string    `json:"identifier" bson:"identifier"`
+	UserId     string    `json:"user_id" bson:"user_id"`
+	Username   string    `json:"username" bson:"username"`
+	Kind       string    `json:"kind" bson:"kind"`
+	ExpiryDate string    `json:"expiry_date,omitempty" bson:"expiry_date,omitempty"`
+	FailCount  int       `json:"fail_count,omitempty" bson:"fail_count,omitempty"`
+	Email      bool      `json:"email" bson:"email"`
+	SentAt     time.Time `json:"sent_at" bson:"sent_at"`
+}
+
+// CardIssue is one card a reminder is about.
+type CardIssue struct {
+	Card       string // masked card number or description
+	ExpiryDate string // YYMM
+	Kind       string
+}
+
+// CardReminder carries what the reminder email and in-app notice show to
+// the owner of cards needing attention. AddCardUrl links to adding a card.
+type CardReminder struct {
+	Username   string
+	Cards      []CardIssue
+	AddCardUrl string
+}
+
+// Summary is the one-line text of the in-app notice.
+func (r *CardReminder) Summary() string {
+	if len(r.Cards) == 1 {
+		card := r.Cards[0]
+		if card.Kind == CardNoticeFailed {
+			return fmt.Sprintf("Your card %s was declined. Add a new card to keep charging.", card.Card)
+		}
+		return fmt.Sprintf("Your card %s expires %s. Add a new card to keep charging.", card.Card, FormatCardExpiry(card.ExpiryDate))
+	}
+	return fmt.Sprintf("%d of your cards need attention. Add a new card to keep charging.", len(r.Cards))
+}
+
+// FormatCardExpiry turns a YYMM card expiry into MM/YY.
+func FormatCardExpiry(expiry string) string {
+	if len(expiry) != 4 {
+		return expiry
+	}
+	return expiry[2:] + "/" + expiry[:2]
+}
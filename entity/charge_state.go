@@ -29,6 +29,7 @@ type ChargeState struct {
 	PaymentPlan        *PaymentPlan       `json:"payment_plan,omitempty" bson:"payment_plan,omitempty" validate:"omitempty"`
 	PaymentMethod      *PaymentMethod     `json:"payment_method,omitempty" bson:"payment_method,omitempty" validate:"omitempty"`
 	PaymentOrders      []PaymentOrder     `json:"payment_orders,omitempty" bson:"payment_orders,omitempty" validate:"omitempty,dive"`
+	Refunds            []*Refund          `json:"refunds,omitempty" bson:"-"`
 
 	// Carried through from Transaction so the detail view has the same data as
 	// the transactions list, which serializes Transaction directly.
@@ -7,3 +7,7 @@ import "errors"
 // so handlers can map it to HTTP 404 via errors.Is, without comparing
 // message strings.
 var ErrNotFound = errors.New("not found")
+
+// ErrAlreadyExists indicates that a resource to be created exists already;
+// handlers map it to HTTP 409.
+var ErrAlreadyExists = errors.New("already exists")
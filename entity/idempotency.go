@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrIdempotencyMismatch is returned when an idempotency key is reused
+	// for a request with a different method, path or body.
+	ErrIdempotencyMismatch = errors.New("idempotency key reused with a different request")
+	// ErrIdempotencyInProgress is returned while the first request made with
+	// an idempotency key has not finished yet.
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// IdempotencyRecord holds the first response to a request made with an
+// Idempotency-Key header. Status is zero while that request is running.
+type IdempotencyRecord struct {
+	Key         string    `bson:"_id"`
+	Fingerprint string    `bson:"fingerprint"`
+	Status      int       `bson:"status"`
+	ContentType string    `bson:"content_type,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
+
+// IsCompleted reports whether the response of the first request is stored.
+func (r *IdempotencyRecord) IsCompleted() bool {
+	return r.Status != 0
+}
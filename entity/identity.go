@@ -0,0 +1,9 @@
+package entity
+
+// Identity is a user asserted by a verified token of an external identity
+// provider; a user of an unknown UserId is registered on first login.
+type Identity struct {
+	UserId string
+	Name   string
+	Email  string
+}
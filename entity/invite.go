@@ -1,5 +1,48 @@
 package entity
 
+import (
+	"evsys-back/internal/lib/validate"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Invite admits registrations until it expires or is used up, and sets the
+// group, payment plan and role of the accounts created with it. An invite
+// without a maximum number of uses is valid once.
 type Invite struct {
-	Code string `json:"code" bson:"code" validate:"required,min=6"`
+	Code        string     `json:"code" bson:"code" validate:"required,min=6"`
+	Group       string     `json:"group,omitempty" bson:"group,omitempty"`
+	PaymentPlan string     `json:"payment_plan,omitempty" bson:"payment_plan,omitempty"`
+	Role        string     `json:"role,omitempty" bson:"role,omitempty"`
+	MaxUses     int        `json:"max_uses" bson:"max_uses,omitempty"`
+	Uses        int        `json:"uses" bson:"uses,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	CreatedBy   string     `json:"created_by,omitempty" bson:"created_by,omitempty"`
+}
+
+// Usable returns why the invite cannot admit another registration, nil if it can.
+func (i *Invite) Usable(now time.Time) error {
+	if i.ExpiresAt != nil && !now.Before(*i.ExpiresAt) {
+		return fmt.Errorf("invite code expired")
+	}
+	if i.Uses >= max(i.MaxUses, 1) {
+		return fmt.Errorf("invite code used up")
+	}
+	return nil
+}
+
+// InviteRequest generates invites sharing the same limits and targets.
+type InviteRequest struct {
+	Count       int        `json:"count" validate:"omitempty,min=1,max=100"` // one if not set
+	MaxUses     int        `json:"max_uses" validate:"omitempty,min=1"`      // one if not set
+	ExpiresAt   *time.Time `json:"expires_at" validate:"omitempty"`
+	Group       string     `json:"group" validate:"omitempty"`
+	PaymentPlan string     `json:"payment_plan" validate:"omitempty"`
+	Role        string     `json:"role" validate:"omitempty,user_role"`
+}
+
+func (r *InviteRequest) Bind(_ *http.Request) error {
+	return validate.Struct(r)
 }
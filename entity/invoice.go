@@ -0,0 +1,75 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	InvoiceTypeInvoice    = "invoice"
+	InvoiceTypeCreditNote = "credit_note"
+)
+
+// FiscalDetails identify a party on an invoice: the customer, stored on the
+// user, or the issuer, taken from the configuration.
+type FiscalDetails struct {
+	Name       string `json:"name" bson:"name" validate:"required,max=200"`
+	TaxId      string `json:"tax_id" bson:"tax_id" validate:"required,max=20"`
+	Address    string `json:"address" bson:"address" validate:"required,max=300"`
+	PostalCode string `json:"postal_code,omitempty" bson:"postal_code,omitempty" validate:"omitempty,max=20"`
+	City       string `json:"city,omitempty" bson:"city,omitempty" validate:"omitempty,max=100"`
+	Country    string `json:"country,omitempty" bson:"country,omitempty" validate:"omitempty,len=2"`
+}
+
+func (f *FiscalDetails) Bind(_ *http.Request) error {
+	return validate.Struct(f)
+}
+
+// Invoice is an issued invoice or credit note. Everything shown on the document
+// is copied in at issue time and Html holds the rendered document, so later
+// changes to the user, the configuration or the template never alter it.
+// Amounts are in cents, negative on credit notes.
+type Invoice struct {
+	Id            string         `json:"id" bson:"_id,omitempty"`
+	Type          string         `json:"type" bson:"type"`
+	Series        string         `json:"series" bson:"series"`
+	Year          int            `json:"year" bson:"year"`
+	Number        int            `json:"number" bson:"number"`
+	InvoiceNumber string         `json:"invoice_number" bson:"invoice_number"`
+	TransactionId int            `json:"transaction_id" bson:"transaction_id"`
+	Order         int            `json:"order,omitempty" bson:"order,omitempty"`
+	RefundId      string         `json:"refund_id,omitempty" bson:"refund_id,omitempty"`
+	Rectifies     string         `json:"rectifies,omitempty" bson:"rectifies,omitempty"`
+	UserId        string         `json:"user_id" bson:"user_id"`
+	Username      string         `json:"username" bson:"username"`
+	Customer      *FiscalDetails `json:"customer" bson:"customer"`
+	Issuer        *FiscalDetails `json:"issuer" bson:"issuer"`
+	Description   string         `json:"description" bson:"description"`
+	Currency      string         `json:"currency" bson:"currency"`
+	TaxableBase   int            `json:"taxable_base" bson:"taxable_base"`
+	VatRate       float64        `json:"vat_rate" bson:"vat_rate"`
+	VatAmount     int            `json:"vat_amount" bson:"vat_amount"`
+	Total         int            `json:"total" bson:"total"`
+	IssuedAt      time.Time      `json:"issued_at" bson:"issued_at"`
+	Html          string         `json:"-" bson:"html"`
+}
+
+// SetNumber assigns the sequential number and derives the printed one, e.g.
+// "F2026-000042".
+func (i *Invoice) SetNumber(number int) {
+	i.Number = number
+	i.InvoiceNumber = fmt.Sprintf("%s%d-%06d", i.Series, i.Year, number)
+}
+
+// SetAmounts splits a VAT-inclusive total into taxable base and VAT at the
+// given rate in percent. The VAT is what remains after rounding the base, so
+// the two always add up to the total.
+func (i *Invoice) SetAmounts(total int, rate float64) {
+	i.Total = total
+	i.VatRate = rate
+	i.TaxableBase = int(math.Round(float64(total) / (1 + rate/100)))
+	i.VatAmount = total - i.TaxableBase
+}
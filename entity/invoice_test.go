@@ -0,0 +1,38 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoice_SetAmounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int
+		rate     float64
+		wantBase int
+		wantVat  int
+	}{
+		{"standard rate", 1210, 21, 1000, 210},
+		{"rounding goes to the base", 1000, 21, 826, 174},
+		{"zero rate", 500, 0, 500, 0},
+		{"credit note is negative", -1210, 21, -1000, -210},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inv Invoice
+			inv.SetAmounts(tt.total, tt.rate)
+			assert.Equal(t, tt.wantBase, inv.TaxableBase)
+			assert.Equal(t, tt.wantVat, inv.VatAmount)
+			assert.Equal(t, tt.total, inv.TaxableBase+inv.VatAmount)
+		})
+	}
+}
+
+func TestInvoice_SetNumber(t *testing.T) {
+	inv := Invoice{Series: "F", Year: 2026}
+	inv.SetNumber(42)
+	assert.Equal(t, 42, inv.Number)
+	assert.Equal(t, "F2026-000042", inv.InvoiceNumber)
+}
@@ -0,0 +1,72 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Payment event types.
+const (
+	PaymentEventOrderCreated     = "order_created"     // a card is charged for a session
+	PaymentEventPaid             = "paid"              // the gateway approved a session's payment
+	PaymentEventDeclined         = "declined"          // the gateway declined a session's payment
+	PaymentEventCardExpired      = "card_expired"      // a session was not charged as the card has expired
+	PaymentEventRetryScheduled   = "retry_scheduled"   // a declined payment is retried later
+	PaymentEventRetryStarted     = "retry_started"     // a retry of a payment began
+	PaymentEventRetriesExhausted = "retries_exhausted" // a declined payment is not retried any more
+	PaymentEventRefundRequested  = "refund_requested"
+	PaymentEventRefunded         = "refunded"
+	PaymentEventRefundFailed     = "refund_failed"
+	PaymentEventHoldLinked       = "hold_linked"      // a preauthorization covers a session
+	PaymentEventHoldCapture      = "hold_capture"     // a preauthorization is captured for a session
+	PaymentEventHoldDeclined     = "hold_declined"    // a capture was declined and the card is charged instead
+	PaymentEventHoldReleased     = "hold_released"    // a preauthorization was cancelled
+	PaymentEventWalletDebited    = "wallet_debited"   // a session was paid from the wallet
+	PaymentEventWalletToppedUp   = "wallet_topped_up" // a paid top-up was credited
+	PaymentEventDiscounted       = "discounted"       // a promotion was taken off a session
+	PaymentEventGroupDeferred    = "group_deferred"   // a session waits for its group's monthly order
+	PaymentEventGroupCharged     = "group_charged"    // a group's monthly order was sent
+	PaymentEventGroupPaid        = "group_paid"
+	PaymentEventGroupDeclined    = "group_declined"
+)
+
+// PaymentEvent is a typed record of something that happened to a payment.
+// The card is identified by a hash of its token, never by the token.
+type PaymentEvent struct {
+	Time          time.Time `json:"time" bson:"time"`
+	Type          string    `json:"type" bson:"type"`
+	Level         string    `json:"level" bson:"level"`
+	TransactionId int       `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	Order         int       `json:"order,omitempty" bson:"order,omitempty"`
+	UserId        string    `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Username      string    `json:"username,omitempty" bson:"username,omitempty"`
+	Amount        int       `json:"amount,omitempty" bson:"amount,omitempty"` // in cents
+	CardHash      string    `json:"card_hash,omitempty" bson:"card_hash,omitempty"`
+	Code          string    `json:"code,omitempty" bson:"code,omitempty"` // gateway response or error code
+	Text          string    `json:"text" bson:"text"`
+}
+
+// PaymentEventFilter narrows a payment event query; zero fields do not
+// restrict.
+type PaymentEventFilter struct {
+	TransactionId int
+	Order         int
+	UserId        string
+	Username      string
+	Type          string
+	CardHash      string
+	Code          string
+	From          *time.Time
+	To            *time.Time
+	Limit         int64
+}
+
+// CardHash returns the hash a card token is recorded by in payment events.
+func CardHash(identifier string) string {
+	if identifier == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(identifier))
+	return hex.EncodeToString(sum[:8])
+}
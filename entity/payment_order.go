@@ -22,6 +22,13 @@ type PaymentOrder struct {
 	TimeClosed    time.Time `json:"time_closed" bson:"time_closed"`
 	RefundAmount  int       `json:"refund_amount" bson:"refund_amount" validate:"min=0"`
 	RefundTime    time.Time `json:"refund_time" bson:"refund_time"`
+	// TransactionType is the gateway operation that settled the order: empty
+	// for direct payments, "2" when the final amount of a preauthorization
+	// was captured under the preauthorization's order number.
+	TransactionType string `json:"transaction_type,omitempty" bson:"transaction_type,omitempty" validate:"omitempty"`
+	// Purpose is "top_up" for orders that add their amount to the user's
+	// wallet. Empty for session payments and card enrollments.
+	Purpose string `json:"purpose,omitempty" bson:"purpose,omitempty" validate:"omitempty,oneof=top_up"`
 	// Mode selects the response variant: empty (default) for the Android/native
 	// SDK flow, "web" for the Redsys TPV Virtual hosted-form redirect flow
 	// used by the "add card" page in the Angular app. Not persisted.
@@ -41,6 +48,11 @@ func (p *PaymentOrder) Bind(_ *http.Request) error {
 	return validate.Struct(p)
 }
 
+// IsPaid reports whether the order closed with an approved payment or capture.
+func (p *PaymentOrder) IsPaid() bool {
+	return p.IsCompleted && IsRedsysApproved(p.TransactionType, p.Result)
+}
+
 // WebOrderResponse is returned by POST /payment/order when mode=="web".
 // It embeds the stored PaymentOrder (so callers still see the generated
 // order number and timestamps) and adds the signed Redsys TPV Virtual
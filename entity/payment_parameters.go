@@ -3,6 +3,7 @@ package entity
 import (
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Redsys Ds_TransactionType values.
@@ -34,6 +35,9 @@ type PaymentParameters struct {
 	CardBrand          string `json:"Ds_Card_Brand" bson:"card_brand"`
 	MerchantCofTxnid   string `json:"Ds_Merchant_Cof_Txnid" bson:"merchant_cof_txnid"`
 	ProcessedPayMethod string `json:"Ds_ProcessedPayMethod" bson:"processed_pay_method"`
+
+	// ReceivedAt is set by the backend when the notification is stored.
+	ReceivedAt time.Time `json:"-" bson:"received_at,omitempty"`
 }
 
 // IsApproved reports whether this Redsys notification represents an
@@ -1,7 +1,12 @@
 package entity
 
+import (
+	"evsys-back/internal/lib/validate"
+	"net/http"
+)
+
 type PaymentPlan struct {
-	PlanId       string `json:"plan_id" bson:"plan_id" validate:"required"`
+	PlanId       string `json:"plan_id" bson:"plan_id" validate:"omitempty"`
 	Description  string `json:"description" bson:"description" validate:"omitempty"`
 	IsDefault    bool   `json:"is_default" bson:"is_default"` // global default, for all users
 	IsActive     bool   `json:"is_active" bson:"is_active"`
@@ -9,4 +14,19 @@ type PaymentPlan struct {
 	PricePerHour int    `json:"price_per_hour" bson:"price_per_hour" validate:"min=0"`
 	StartTime    string `json:"start_time" bson:"start_time" validate:"omitempty"`
 	EndTime      string `json:"end_time" bson:"end_time" validate:"omitempty"`
+	// UserGroups lists the user groups this plan is the default for; new users
+	// of those groups get it unless a plan is given explicitly.
+	UserGroups []string `json:"user_groups,omitempty" bson:"user_groups,omitempty" validate:"omitempty,dive,required"`
+}
+
+func (p *PaymentPlan) Bind(_ *http.Request) error {
+	return validate.Struct(p)
+}
+
+// PaymentPlanDeactivation reports the users assigned to a plan that is about to
+// be, or has been, deactivated.
+type PaymentPlanDeactivation struct {
+	PlanId        string  `json:"plan_id"`
+	Deactivated   bool    `json:"deactivated"`
+	AffectedUsers []*User `json:"affected_users"`
 }
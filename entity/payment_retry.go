@@ -1,14 +1,89 @@
 package entity
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// Decline classes of a failed payment; each has its own retry schedule.
+const (
+	// DeclineClassHard is a refusal that cannot succeed on the same card
+	// (lost or stolen card, closed account, ...); it is never retried.
+	DeclineClassHard = "hard"
+	// DeclineClassSoft is a refusal that may clear over time, such as
+	// insufficient funds or a refusal without a specific reason.
+	DeclineClassSoft = "soft"
+	// DeclineClassTechnical is a failure to get an answer from the issuer:
+	// timeouts, gateway errors and Redsys 9xxx codes.
+	DeclineClassTechnical = "technical"
+)
+
+// hardDeclines are the Redsys Ds_Response codes retrying cannot fix.
+var hardDeclines = map[string]bool{
+	"0101": true, // expired card
+	"0102": true, // card temporarily blocked or under suspicion of fraud
+	"0104": true, // operation not allowed for this card
+	"0118": true, // card not registered
+	"0125": true, // card not effective
+	"0129": true, // wrong security code
+	"0172": true, // declined, do not retry
+	"0173": true, // declined, do not retry without updating card data
+	"0180": true, // card outside the service
+	"0191": true, // wrong expiry date
+	"0201": true, // expired card
+	"0202": true, // card blocked, suspected fraud
+	"0204": true, // operation not allowed for this card
+	"0207": true, // contact the issuer
+	"0208": true, // lost or stolen card
+	"0209": true, // lost or stolen card
+	"0280": true, // wrong security code
+}
+
+// softDeclines are the codes that would otherwise fall into another class.
+var softDeclines = map[string]bool{
+	"9915": true, // payment cancelled by the cardholder
+}
+
+// ClassifyPaymentError returns the decline class of a failed payment result
+// as stored on the order: a Redsys response code, a SISxxxx error code, an
+// HTTP status or an error message. Unknown 0xxx refusals are soft declines;
+// anything that is not a 4-digit response code is a technical error.
+func ClassifyPaymentError(result string) string {
+	code := strings.TrimSpace(result)
+	if hardDeclines[code] {
+		return DeclineClassHard
+	}
+	if softDeclines[code] {
+		return DeclineClassSoft
+	}
+	if len(code) != 4 || strings.Trim(code, "0123456789") != "" {
+		return DeclineClassTechnical
+	}
+	if code[0] == '9' {
+		return DeclineClassTechnical
+	}
+	return DeclineClassSoft
+}
 
 type PaymentRetry struct {
 	TransactionId int       `json:"transaction_id" bson:"transaction_id"`
 	Attempt       int       `json:"attempt" bson:"attempt"`
 	NextRetryTime time.Time `json:"next_retry_time" bson:"next_retry_time"`
 	LastError     string    `json:"last_error" bson:"last_error"`
-	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at" bson:"updated_at"`
+	// DeclineClass of the last error; records written before the
+	// classification existed have none, see RetryClass.
+	DeclineClass string    `json:"decline_class,omitempty" bson:"decline_class,omitempty"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// RetryClass returns the decline class of the record, classifying the last
+// error of records that do not carry one.
+func (r *PaymentRetry) RetryClass() string {
+	if r.DeclineClass != "" {
+		return r.DeclineClass
+	}
+	return ClassifyPaymentError(r.LastError)
 }
 
 // PaymentRetryView is a read-only retry-queue entry enriched with transaction
@@ -19,6 +94,7 @@ type PaymentRetryView struct {
 	MaxAttempts   int        `json:"max_attempts"`
 	NextRetryTime time.Time  `json:"next_retry_time"`
 	LastError     string     `json:"last_error"`
+	DeclineClass  string     `json:"decline_class"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 	ChargePointId string     `json:"charge_point_id,omitempty"`
@@ -0,0 +1,38 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyPaymentError(t *testing.T) {
+	tests := []struct {
+		result string
+		want   string
+	}{
+		{"0208", DeclineClassHard},
+		{"0209", DeclineClassHard},
+		{"0101", DeclineClassHard},
+		{"0116", DeclineClassSoft},
+		{"0190", DeclineClassSoft},
+		{"9915", DeclineClassSoft},
+		{"0999", DeclineClassSoft},
+		{"9104", DeclineClassTechnical},
+		{"SIS0051", DeclineClassTechnical},
+		{"503", DeclineClassTechnical},
+		{"failed to send request: context deadline exceeded", DeclineClassTechnical},
+		{"closed without response", DeclineClassTechnical},
+		{"", DeclineClassTechnical},
+	}
+	for _, tt := range tests {
+		t.Run(tt.result, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClassifyPaymentError(tt.result))
+		})
+	}
+}
+
+func TestPaymentRetry_RetryClass(t *testing.T) {
+	assert.Equal(t, DeclineClassTechnical, (&PaymentRetry{DeclineClass: DeclineClassTechnical, LastError: "0116"}).RetryClass())
+	assert.Equal(t, DeclineClassSoft, (&PaymentRetry{LastError: "0116"}).RetryClass(), "legacy records are classified by their last error")
+}
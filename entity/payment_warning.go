@@ -14,6 +14,7 @@ type PaymentWarning struct {
 	Result        string // Redsys response/error code or message
 	Attempt       int    // retry attempt number (1-based); 0 when no retry applies
 	MaxAttempts   int
-	Exhausted     bool // true when no further retry is scheduled
+	Exhausted     bool   // true when no further retry is scheduled
+	DeclineClass  string // see ClassifyPaymentError
 	OccurredAt    time.Time
 }
@@ -0,0 +1,79 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	PriceLineEnergy     = "energy"
+	PriceLineTime       = "time"
+	PriceLineSessionFee = "session_fee"
+	PriceLineIdleFee    = "idle_fee"
+	PriceLineMinPrice   = "min_price"
+	PriceLineMaxPrice   = "max_price"
+)
+
+// PriceLine is one itemized component of a session price. Amounts are in minor
+// currency units; UnitPrice is per kWh, per minute or per hour depending on Unit.
+type PriceLine struct {
+	Type        string     `json:"type"`
+	Description string     `json:"description"`
+	Quantity    float64    `json:"quantity"`
+	Unit        string     `json:"unit,omitempty"`
+	UnitPrice   int        `json:"unit_price"`
+	Amount      int        `json:"amount"`
+	From        *time.Time `json:"from,omitempty"`
+	To          *time.Time `json:"to,omitempty"`
+}
+
+// PriceBreakdown is the backend's own calculation of what a session costs.
+// StoredAmount is the amount recorded on the transaction (computed by the
+// central system); Mismatch is set when the two disagree.
+type PriceBreakdown struct {
+	TransactionId int          `json:"transaction_id,omitempty"`
+	TariffId      string       `json:"tariff_id,omitempty"`
+	PlanId        string       `json:"plan_id,omitempty"`
+	TimeStart     time.Time    `json:"time_start"`
+	TimeStop      time.Time    `json:"time_stop"`
+	EnergyWh      int          `json:"energy_wh"`
+	Minutes       float64      `json:"minutes"`
+	IdleMinutes   float64      `json:"idle_minutes"`
+	Lines         []*PriceLine `json:"lines"`
+	Total         int          `json:"total"`
+	StoredAmount  int          `json:"stored_amount"`
+	Difference    int          `json:"difference"`
+	Mismatch      bool         `json:"mismatch"`
+}
+
+// PriceSimulationRequest describes a hypothetical session to price. The tariff
+// is taken by id, or resolved for the charge point at TimeStart. Energy is
+// spread evenly over the charging part of the session, followed by
+// IdleMinutes with no energy drawn; explicit MeterValues override that.
+type PriceSimulationRequest struct {
+	TariffId      string             `json:"tariff_id,omitempty" validate:"omitempty"`
+	ChargePointId string             `json:"charge_point_id,omitempty" validate:"omitempty"`
+	TimeStart     time.Time          `json:"time_start" validate:"required"`
+	TimeStop      time.Time          `json:"time_stop" validate:"required"`
+	EnergyWh      int                `json:"energy_wh" validate:"min=0"`
+	IdleMinutes   int                `json:"idle_minutes" validate:"min=0"`
+	MeterValues   []TransactionMeter `json:"meter_values,omitempty" validate:"omitempty"`
+}
+
+func (p *PriceSimulationRequest) Bind(_ *http.Request) error {
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+	if p.TariffId == "" && p.ChargePointId == "" {
+		return fmt.Errorf("tariff_id or charge_point_id is required")
+	}
+	if !p.TimeStop.After(p.TimeStart) {
+		return fmt.Errorf("time_stop must be after time_start")
+	}
+	if time.Duration(p.IdleMinutes)*time.Minute > p.TimeStop.Sub(p.TimeStart) {
+		return fmt.Errorf("idle_minutes exceed session duration")
+	}
+	return nil
+}
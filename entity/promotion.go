@@ -0,0 +1,104 @@
+package entity
+
+import (
+	"errors"
+	"evsys-back/internal/lib/validate"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Promotion types.
+const (
+	PromotionPercent = "percent" // Value is the percentage taken off a session
+	PromotionFixed   = "fixed"   // Value is the amount taken off a session, in cents
+	PromotionCredit  = "credit"  // Value is credited to the wallet on redemption
+)
+
+// ErrPromotionNotValid is returned when a promotion code cannot be redeemed.
+var ErrPromotionNotValid = errors.New("promotion code is not valid")
+
+// Promotion is a code users redeem for a discount on their next sessions or
+// for wallet credit. A zero validity bound leaves the window open on that
+// side; a zero MaxRedemptions allows any number of users. The lists narrow
+// the sessions a discount applies to by location and charge point, and the
+// users who may redeem it by group; an empty list does not restrict.
+type Promotion struct {
+	Code             string    `json:"code" bson:"code" validate:"required"`
+	Description      string    `json:"description,omitempty" bson:"description,omitempty" validate:"omitempty"`
+	Type             string    `json:"type" bson:"type" validate:"required,oneof=percent fixed credit"`
+	Value            int       `json:"value" bson:"value" validate:"min=1"`
+	ValidFrom        time.Time `json:"valid_from,omitempty" bson:"valid_from,omitempty"`
+	ValidTo          time.Time `json:"valid_to,omitempty" bson:"valid_to,omitempty"`
+	MaxRedemptions   int       `json:"max_redemptions" bson:"max_redemptions" validate:"min=0"`
+	SessionsPerUser  int       `json:"sessions_per_user" bson:"sessions_per_user" validate:"min=0"` // sessions discounted for each user
+	FirstSessionOnly bool      `json:"first_session_only" bson:"first_session_only"`                // only users without a finished session may redeem
+	Locations        []string  `json:"locations,omitempty" bson:"locations,omitempty"`
+	ChargePoints     []string  `json:"charge_points,omitempty" bson:"charge_points,omitempty"`
+	Groups           []string  `json:"groups,omitempty" bson:"groups,omitempty"`
+	Disabled         bool      `json:"disabled" bson:"disabled"`
+	Redemptions      int       `json:"redemptions" bson:"redemptions"`
+	CreatedAt        time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+func (p *Promotion) Bind(_ *http.Request) error {
+	return validate.Struct(p)
+}
+
+// IsActive reports whether the promotion can be used at the given time.
+func (p *Promotion) IsActive(at time.Time) bool {
+	if p.Disabled {
+		return false
+	}
+	if !p.ValidFrom.IsZero() && at.Before(p.ValidFrom) {
+		return false
+	}
+	return p.ValidTo.IsZero() || at.Before(p.ValidTo)
+}
+
+// AppliesTo reports whether a session at the charge point and location is
+// discounted by the promotion.
+func (p *Promotion) AppliesTo(chargePointId, locationId string) bool {
+	if len(p.ChargePoints) > 0 && !slices.Contains(p.ChargePoints, chargePointId) {
+		return false
+	}
+	return len(p.Locations) == 0 || slices.Contains(p.Locations, locationId)
+}
+
+// AllowsGroup reports whether a user of the group may redeem the promotion.
+func (p *Promotion) AllowsGroup(group string) bool {
+	return len(p.Groups) == 0 || slices.Contains(p.Groups, group)
+}
+
+// Discount returns the part of a session amount the promotion takes off.
+func (p *Promotion) Discount(amount int) int {
+	switch p.Type {
+	case PromotionPercent:
+		return min(amount*p.Value/100, amount)
+	case PromotionFixed:
+		return min(p.Value, amount)
+	}
+	return 0
+}
+
+// PromotionRedemption records a user's redemption of a promotion code and
+// how many sessions it still discounts.
+type PromotionRedemption struct {
+	Code       string    `json:"code" bson:"code"`
+	UserId     string    `json:"-" bson:"user_id"`
+	Username   string    `json:"username" bson:"username"`
+	Remaining  int       `json:"remaining" bson:"remaining"`
+	Discounted int       `json:"discounted" bson:"discounted"` // total taken off sessions, in cents
+	RedeemedAt time.Time `json:"redeemed_at" bson:"redeemed_at"`
+	UsedAt     time.Time `json:"used_at,omitempty" bson:"used_at,omitempty"`
+}
+
+// PromotionCode is a user's request to redeem a code.
+type PromotionCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+func (p *PromotionCode) Bind(_ *http.Request) error {
+	return validate.Struct(p)
+}
@@ -0,0 +1,55 @@
+package entity
+
+import "time"
+
+// Reconciliation discrepancy categories.
+const (
+	// DiscrepancyUnbilled: the session was collected less than it was billed and
+	// nothing (an open order or a pending retry) is going to collect the rest.
+	DiscrepancyUnbilled = "unbilled"
+	// DiscrepancyOverbilled: more was collected, net of refunds, than billed.
+	DiscrepancyOverbilled = "overbilled"
+	// DiscrepancyOrphanResult: the gateway reported a result for an order
+	// number that matches no payment order and no preauthorization.
+	DiscrepancyOrphanResult = "orphan_result"
+	// DiscrepancyStuckOpenOrder: the order never got a final answer from the
+	// gateway, so whether the card was charged is unknown.
+	DiscrepancyStuckOpenOrder = "stuck_open_order"
+	// DiscrepancyAmountMismatch: the amounts recorded on both sides of a
+	// payment disagree.
+	DiscrepancyAmountMismatch = "amount_mismatch"
+)
+
+// Discrepancy is one finding of a reconciliation run. Amounts are in cents.
+type Discrepancy struct {
+	Category      string `json:"category"`
+	TransactionId int    `json:"transaction_id,omitempty"`
+	Order         int    `json:"order,omitempty"`
+	Expected      int    `json:"expected"`
+	Actual        int    `json:"actual"`
+	Detail        string `json:"detail"`
+	// Action is the suggested way to resolve the discrepancy.
+	Action string `json:"action"`
+}
+
+// ReconciliationReport cross-checks the transactions finished, the payment
+// orders opened and the gateway results received within [From, To).
+type ReconciliationReport struct {
+	From          time.Time      `json:"from"`
+	To            time.Time      `json:"to"`
+	GeneratedAt   time.Time      `json:"generated_at"`
+	Transactions  int            `json:"transactions"`
+	Orders        int            `json:"orders"`
+	Results       int            `json:"results"`
+	Counts        map[string]int `json:"counts"`
+	Discrepancies []*Discrepancy `json:"discrepancies"`
+}
+
+// Add records a discrepancy and counts it under its category.
+func (r *ReconciliationReport) Add(d *Discrepancy) {
+	if r.Counts == nil {
+		r.Counts = make(map[string]int)
+	}
+	r.Counts[d.Category]++
+	r.Discrepancies = append(r.Discrepancies, d)
+}
@@ -0,0 +1,58 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"net/http"
+	"time"
+)
+
+const (
+	RefundStatusPending   = "PENDING"
+	RefundStatusCompleted = "COMPLETED"
+	RefundStatusFailed    = "FAILED"
+)
+
+// Refund reason codes.
+const (
+	RefundReasonCustomerRequest  = "customer_request"
+	RefundReasonBillingError     = "billing_error"
+	RefundReasonDuplicate        = "duplicate"
+	RefundReasonServiceFailure   = "service_failure"
+	RefundReasonCardVerification = "card_verification"
+	RefundReasonOther            = "other"
+)
+
+// Refund is one entry of the refund ledger: a single, possibly partial, refund
+// of a payment order together with the gateway's answer to it.
+type Refund struct {
+	Id            string `json:"id" bson:"_id,omitempty"`
+	TransactionId int    `json:"transaction_id" bson:"transaction_id"`
+	Order         int    `json:"order" bson:"order"`
+	Amount        int    `json:"amount" bson:"amount"`
+	Currency      string `json:"currency,omitempty" bson:"currency,omitempty"`
+	Reason        string `json:"reason" bson:"reason"`
+	Note          string `json:"note,omitempty" bson:"note,omitempty"`
+	Status        string `json:"status" bson:"status"`
+	// InitiatedBy is the username of the operator, or "api" / "system" for
+	// refunds requested by another service or by the backend itself.
+	InitiatedBy       string    `json:"initiated_by" bson:"initiated_by"`
+	ResponseCode      string    `json:"response_code,omitempty" bson:"response_code,omitempty"`
+	AuthorizationCode string    `json:"authorization_code,omitempty" bson:"authorization_code,omitempty"`
+	ErrorCode         string    `json:"error_code,omitempty" bson:"error_code,omitempty"`
+	ErrorMessage      string    `json:"error_message,omitempty" bson:"error_message,omitempty"`
+	CreatedAt         time.Time `json:"created_at" bson:"created_at"`
+	CompletedAt       time.Time `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// RefundOrderRequest is an operator's request to refund part of a transaction.
+// Order defaults to the transaction's last payment order.
+type RefundOrderRequest struct {
+	Order  int    `json:"order,omitempty" validate:"omitempty,min=1"`
+	Amount int    `json:"amount" validate:"required,min=1"`
+	Reason string `json:"reason" validate:"required,oneof=customer_request billing_error duplicate service_failure card_verification other"`
+	Note   string `json:"note,omitempty" validate:"omitempty,max=500"`
+}
+
+func (r *RefundOrderRequest) Bind(_ *http.Request) error {
+	return validate.Struct(r)
+}
@@ -0,0 +1,185 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Permissions; a ".write" permission covers managing its domain, including
+// the admin listings.
+const (
+	PermissionAll              = "*"
+	PermissionUsersRead        = "users.read"
+	PermissionUsersWrite       = "users.write" // users, user tags and their sessions
+	PermissionRolesWrite       = "roles.write"
+	PermissionApiKeysWrite     = "apikeys.write"
+	PermissionReportsRead      = "reports.read"
+	PermissionTransactionsRead = "transactions.read" // sessions of all users
+	PermissionPaymentsRead     = "payments.read"     // retries, refunds, events, invoices and wallets of all users
+	PermissionPaymentsWrite    = "payments.write"    // forced retries, invoices, wallet credits and group bills
+	PermissionPaymentsRefund   = "payments.refund"
+	PermissionTariffsWrite     = "tariffs.write"
+	PermissionPlansWrite       = "plans.write"
+	PermissionLimitsWrite      = "limits.write"
+	PermissionGroupsWrite      = "groups.write"
+	PermissionPromotionsWrite  = "promotions.write"
+	PermissionMailWrite        = "mail.write"
+	PermissionWebhooksWrite    = "webhooks.write"
+	PermissionCommandPrefix    = "csc."  // followed by a central system command
+	PermissionCommandsAll      = "csc.*" // every central system command
+	RoleAdmin                  = roleAdmin
+	RoleOperator               = roleOperator
+	RoleUser                   = "user" // the role of users without one
+	permissionWildcard         = ".*"
+)
+
+// Permissions lists the named permissions other than central system commands.
+var Permissions = []string{
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionRolesWrite,
+	PermissionApiKeysWrite,
+	PermissionReportsRead,
+	PermissionTransactionsRead,
+	PermissionPaymentsRead,
+	PermissionPaymentsWrite,
+	PermissionPaymentsRefund,
+	PermissionTariffsWrite,
+	PermissionPlansWrite,
+	PermissionLimitsWrite,
+	PermissionGroupsWrite,
+	PermissionPromotionsWrite,
+	PermissionMailWrite,
+	PermissionWebhooksWrite,
+}
+
+// CentralSystemCommands lists the OCPP 1.6 commands the central system
+// sends to charge points.
+var CentralSystemCommands = []string{
+	"CancelReservation",
+	"ChangeAvailability",
+	"ChangeConfiguration",
+	"ClearCache",
+	"ClearChargingProfile",
+	"DataTransfer",
+	"GetCompositeSchedule",
+	"GetConfiguration",
+	"GetDiagnostics",
+	"GetLocalListVersion",
+	"RemoteStartTransaction",
+	"RemoteStopTransaction",
+	"ReserveNow",
+	"Reset",
+	"SendLocalList",
+	"SetChargingProfile",
+	"TriggerMessage",
+	"UnlockConnector",
+	"UpdateFirmware",
+}
+
+// CommandPermission returns the permission to send a central system command.
+func CommandPermission(command string) string {
+	return PermissionCommandPrefix + command
+}
+
+// Role is a named set of permissions users are assigned by their role field.
+type Role struct {
+	Name        string    `json:"name" bson:"_id" validate:"required,user_role"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty" validate:"omitempty"`
+	Permissions []string  `json:"permissions" bson:"permissions" validate:"omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty" validate:"omitempty"`
+	// BuiltIn marks a role the system defines; a stored role of the same
+	// name overrides its permissions and deleting it restores them
+	BuiltIn bool `json:"built_in" bson:"-"`
+}
+
+func (r *Role) Bind(_ *http.Request) error {
+	return validate.Struct(r)
+}
+
+// Allows reports whether the role grants a permission.
+func (r *Role) Allows(permission string) bool {
+	return permissionGranted(r.Permissions, permission)
+}
+
+// BuiltInRoles returns the roles the system defines, with the permissions
+// they have until a stored role overrides them.
+func BuiltInRoles() []*Role {
+	operator := []string{
+		PermissionUsersWrite,
+		PermissionReportsRead,
+		PermissionTransactionsRead,
+		PermissionPaymentsRead,
+		PermissionPaymentsWrite,
+		PermissionPaymentsRefund,
+		PermissionTariffsWrite,
+		PermissionPlansWrite,
+		PermissionLimitsWrite,
+		PermissionGroupsWrite,
+		PermissionPromotionsWrite,
+		PermissionMailWrite,
+		PermissionWebhooksWrite,
+	}
+	// commands that reconfigure a charge point stay with admins
+	adminOnly := []string{"ChangeConfiguration", "SetChargingProfile", "ClearChargingProfile", "GetDiagnostics"}
+	for _, command := range CentralSystemCommands {
+		if !slices.Contains(adminOnly, command) {
+			operator = append(operator, CommandPermission(command))
+		}
+	}
+	return []*Role{
+		{Name: RoleAdmin, Description: "Full access", Permissions: []string{PermissionAll}, BuiltIn: true},
+		{Name: RoleOperator, Description: "Operations staff", Permissions: operator, BuiltIn: true},
+		{Name: RoleUser, Description: "Charging users", Permissions: []string{
+			CommandPermission("RemoteStartTransaction"),
+			CommandPermission("RemoteStopTransaction"),
+		}, BuiltIn: true},
+	}
+}
+
+// BuiltInRole returns the system-defined role of the name, nil if there is none.
+func BuiltInRole(name string) *Role {
+	name = RoleName(name)
+	for _, role := range BuiltInRoles() {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+// RoleName returns the role a user's role field refers to.
+func RoleName(role string) string {
+	if role == "" {
+		return RoleUser
+	}
+	return role
+}
+
+// KnownPermission reports whether a permission can be granted by a role.
+func KnownPermission(permission string) bool {
+	if permission == PermissionAll || permission == PermissionCommandsAll {
+		return true
+	}
+	if command, ok := strings.CutPrefix(permission, PermissionCommandPrefix); ok {
+		return slices.Contains(CentralSystemCommands, command)
+	}
+	return slices.Contains(Permissions, permission)
+}
+
+// permissionGranted matches a permission against a list holding exact
+// permissions, "*" or a domain wildcard such as "csc.*".
+func permissionGranted(granted []string, permission string) bool {
+	for _, p := range granted {
+		if p == PermissionAll || p == permission {
+			return true
+		}
+		if domain, ok := strings.CutSuffix(p, permissionWildcard); ok && strings.HasPrefix(permission, domain+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUser_Can(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       User
+		permission string
+		want       bool
+	}{
+		{"admin built-in", User{Role: "admin"}, PermissionRolesWrite, true},
+		{"operator built-in", User{Role: "operator"}, PermissionReportsRead, true},
+		{"operator admin command", User{Role: "operator"}, CommandPermission("SetChargingProfile"), false},
+		{"user without role", User{}, CommandPermission("RemoteStopTransaction"), true},
+		{"user without role denied", User{}, PermissionReportsRead, false},
+		{"unknown role", User{Role: "support"}, PermissionReportsRead, false},
+		{"resolved exact", User{Role: "support", Permissions: []string{PermissionReportsRead}}, PermissionReportsRead, true},
+		{"resolved wildcard", User{Permissions: []string{PermissionCommandsAll}}, CommandPermission("Reset"), true},
+		{"wildcard stays in domain", User{Permissions: []string{PermissionCommandsAll}}, PermissionReportsRead, false},
+		{"resolved empty overrides role", User{Role: "admin", Permissions: []string{}}, PermissionReportsRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.user.Can(tt.permission))
+		})
+	}
+}
+
+func TestKnownPermission(t *testing.T) {
+	assert.True(t, KnownPermission(PermissionPaymentsRefund))
+	assert.True(t, KnownPermission("csc.SetChargingProfile"))
+	assert.True(t, KnownPermission(PermissionCommandsAll))
+	assert.True(t, KnownPermission(PermissionAll))
+	assert.False(t, KnownPermission("csc.Unknown"))
+	assert.False(t, KnownPermission("reports.*"))
+}
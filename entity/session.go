@@ -0,0 +1,44 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"net/http"
+	"time"
+)
+
+// Session is a login of a user on one device. The access and refresh tokens
+// are stored as hashes; their values are returned only by the login or the
+// refresh that issues them.
+type Session struct {
+	Id               string    `json:"id" bson:"_id"`
+	UserId           string    `json:"-" bson:"user_id"`
+	Username         string    `json:"username" bson:"username"`
+	TokenHash        string    `json:"-" bson:"token_hash"`
+	RefreshHash      string    `json:"-" bson:"refresh_hash"`
+	Device           string    `json:"device,omitempty" bson:"device,omitempty"` // client user agent
+	CreatedAt        time.Time `json:"created_at" bson:"created_at"`
+	LastSeen         time.Time `json:"last_seen" bson:"last_seen"`
+	ExpiresAt        time.Time `json:"expires_at" bson:"expires_at"`                 // of the access token
+	RefreshExpiresAt time.Time `json:"refresh_expires_at" bson:"refresh_expires_at"` // of the refresh token and the session
+
+	// Current marks the session of the token the list was requested with
+	Current bool `json:"current" bson:"-"`
+}
+
+// SessionTokens are the credentials issued to a session on login or refresh.
+type SessionTokens struct {
+	SessionId        string    `json:"session_id"`
+	Token            string    `json:"token"`
+	RefreshToken     string    `json:"refresh_token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// RefreshRequest exchanges a refresh token for a new pair of tokens.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func (r *RefreshRequest) Bind(_ *http.Request) error {
+	return validate.Struct(r)
+}
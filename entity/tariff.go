@@ -1,7 +1,205 @@
 package entity
 
-// Tariff represents pricing tariff (placeholder - expand when CSMS definition available)
+import (
+	"evsys-back/internal/lib/validate"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Tariff describes how a charging session is priced. All amounts are in minor
+// currency units (cents), energy prices are per kWh.
+//
+// A tariff applies to the charge points and locations it lists; a tariff with
+// IsDefault set applies everywhere nothing more specific matches. ValidFrom and
+// ValidTo bound the period the tariff is effective, so a price change can be
+// prepared ahead as a new tariff instead of editing the current one in place.
 type Tariff struct {
 	TariffId    string `json:"tariff_id,omitempty" bson:"tariff_id,omitempty" validate:"omitempty"`
 	Description string `json:"description,omitempty" bson:"description,omitempty" validate:"omitempty"`
+	IsActive    bool   `json:"is_active" bson:"is_active"`
+	IsDefault   bool   `json:"is_default" bson:"is_default"`
+	// TimeZone is the IANA zone band times are expressed in; UTC when empty.
+	TimeZone string `json:"time_zone,omitempty" bson:"time_zone,omitempty" validate:"omitempty,timezone"`
+	// ValidFrom / ValidTo are optional; nil means open-ended on that side.
+	ValidFrom *time.Time `json:"valid_from,omitempty" bson:"valid_from,omitempty"`
+	ValidTo   *time.Time `json:"valid_to,omitempty" bson:"valid_to,omitempty"`
+	// Energy bands by time of day and weekday; the first matching band wins,
+	// PricePerKwh is used for any time no band covers.
+	PricePerKwh int           `json:"price_per_kwh" bson:"price_per_kwh" validate:"min=0"`
+	EnergyBands []*TariffBand `json:"energy_bands,omitempty" bson:"energy_bands,omitempty" validate:"omitempty,dive"`
+	// SessionFee is charged once per session.
+	SessionFee int `json:"session_fee" bson:"session_fee" validate:"min=0"`
+	// IdleFeePerMinute is charged for every minute the vehicle stays connected
+	// without drawing power, after IdleGraceMinutes have passed.
+	IdleFeePerMinute int `json:"idle_fee_per_minute" bson:"idle_fee_per_minute" validate:"min=0"`
+	IdleGraceMinutes int `json:"idle_grace_minutes" bson:"idle_grace_minutes" validate:"min=0"`
+	// MinPrice and MaxPrice clamp the session total; zero disables the limit.
+	MinPrice     int       `json:"min_price" bson:"min_price" validate:"min=0"`
+	MaxPrice     int       `json:"max_price" bson:"max_price" validate:"min=0"`
+	ChargePoints []string  `json:"charge_points,omitempty" bson:"charge_points,omitempty" validate:"omitempty,dive,required"`
+	Locations    []string  `json:"locations,omitempty" bson:"locations,omitempty" validate:"omitempty,dive,required"`
+	CreatedAt    time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// TariffBand is an energy price valid on the listed weekdays between StartTime
+// and EndTime ("15:04", in the tariff time zone). An EndTime earlier than StartTime wraps
+// past midnight; an empty Weekdays list means every day.
+type TariffBand struct {
+	Weekdays    []time.Weekday `json:"weekdays,omitempty" bson:"weekdays,omitempty" validate:"omitempty,dive,min=0,max=6"`
+	StartTime   string         `json:"start_time" bson:"start_time" validate:"required,datetime=15:04"`
+	EndTime     string         `json:"end_time" bson:"end_time" validate:"required,datetime=15:04"`
+	PricePerKwh int            `json:"price_per_kwh" bson:"price_per_kwh" validate:"min=0"`
+}
+
+func (t *Tariff) Bind(_ *http.Request) error {
+	if err := validate.Struct(t); err != nil {
+		return err
+	}
+	if t.MaxPrice > 0 && t.MinPrice > t.MaxPrice {
+		return fmt.Errorf("min_price exceeds max_price")
+	}
+	if t.ValidFrom != nil && t.ValidTo != nil && !t.ValidTo.After(*t.ValidFrom) {
+		return fmt.Errorf("valid_to must be after valid_from")
+	}
+	return nil
+}
+
+// IsValidAt reports whether the tariff is active and effective at the given time.
+func (t *Tariff) IsValidAt(at time.Time) bool {
+	if !t.IsActive {
+		return false
+	}
+	if t.ValidFrom != nil && at.Before(*t.ValidFrom) {
+		return false
+	}
+	if t.ValidTo != nil && !at.Before(*t.ValidTo) {
+		return false
+	}
+	return true
+}
+
+// EnergyPriceAt returns the per-kWh price in effect at the given time.
+func (t *Tariff) EnergyPriceAt(at time.Time) int {
+	at = at.In(t.location())
+	for _, band := range t.EnergyBands {
+		if band.Covers(at) {
+			return band.PricePerKwh
+		}
+	}
+	return t.PricePerKwh
+}
+
+// PriceChangesBetween returns the moments inside (from, to) where the energy
+// price may change, in time order: every band start and end, and every day
+// start, where weekday-limited bands switch.
+func (t *Tariff) PriceChangesBetween(from, to time.Time) []time.Time {
+	if len(t.EnergyBands) == 0 || !to.After(from) {
+		return nil
+	}
+	edges := []int{0}
+	for _, band := range t.EnergyBands {
+		for _, clock := range []string{band.StartTime, band.EndTime} {
+			if minute, err := clockMinutes(clock); err == nil {
+				edges = append(edges, minute)
+			}
+		}
+	}
+	loc := t.location()
+	local := from.In(loc)
+	var changes []time.Time
+	for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, minute := range edges {
+			at := time.Date(day.Year(), day.Month(), day.Day(), minute/60, minute%60, 0, 0, loc)
+			if at.After(from) && at.Before(to) {
+				changes = append(changes, at)
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Before(changes[j]) })
+	unique := changes[:0]
+	for _, at := range changes {
+		if len(unique) == 0 || !at.Equal(unique[len(unique)-1]) {
+			unique = append(unique, at)
+		}
+	}
+	return unique
+}
+
+// Covers reports whether the band applies at the given time. Malformed times
+// never match, so Bind-validated bands are assumed.
+func (b *TariffBand) Covers(at time.Time) bool {
+	start, err := clockMinutes(b.StartTime)
+	if err != nil {
+		return false
+	}
+	end, err := clockMinutes(b.EndTime)
+	if err != nil {
+		return false
+	}
+	minute := at.Hour()*60 + at.Minute()
+	day := at.Weekday()
+	if start > end && minute < end {
+		// the early-morning part of an overnight band belongs to the previous day
+		day = (day + 6) % 7
+	}
+	if len(b.Weekdays) > 0 {
+		found := false
+		for _, wd := range b.Weekdays {
+			if wd == day {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if start == end {
+		return true
+	}
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// AppliesTo reports whether the tariff is attached to the charge point, either
+// directly or through its location.
+func (t *Tariff) AppliesTo(chargePointId, locationId string) bool {
+	for _, id := range t.ChargePoints {
+		if id == chargePointId {
+			return true
+		}
+	}
+	if locationId == "" {
+		return false
+	}
+	for _, id := range t.Locations {
+		if id == locationId {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tariff) location() *time.Location {
+	if t.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(t.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func clockMinutes(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
 }
@@ -0,0 +1,90 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTariff_EnergyPriceAt(t *testing.T) {
+	tariff := Tariff{
+		PricePerKwh: 30,
+		EnergyBands: []*TariffBand{
+			// weeknight off-peak, wrapping past midnight
+			{Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, StartTime: "22:00", EndTime: "06:00", PricePerKwh: 15},
+			{StartTime: "18:00", EndTime: "22:00", PricePerKwh: 45},
+		},
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"monday late evening is off-peak", time.Date(2026, 3, 2, 23, 0, 0, 0, time.UTC), 15},
+		{"tuesday early morning belongs to monday band", time.Date(2026, 3, 3, 5, 59, 0, 0, time.UTC), 15},
+		{"saturday early morning belongs to friday band", time.Date(2026, 3, 7, 3, 0, 0, 0, time.UTC), 15},
+		{"sunday early morning follows saturday, no band", time.Date(2026, 3, 8, 3, 0, 0, 0, time.UTC), 30},
+		{"band end is exclusive", time.Date(2026, 3, 3, 6, 0, 0, 0, time.UTC), 30},
+		{"peak band any day", time.Date(2026, 3, 8, 19, 30, 0, 0, time.UTC), 45},
+		{"daytime falls back to base price", time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC), 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tariff.EnergyPriceAt(tt.at))
+		})
+	}
+}
+
+func TestTariff_EnergyPriceAtTimeZone(t *testing.T) {
+	tariff := Tariff{
+		TimeZone:    "Europe/Madrid",
+		PricePerKwh: 30,
+		EnergyBands: []*TariffBand{{StartTime: "00:00", EndTime: "08:00", PricePerKwh: 10}},
+	}
+	// 23:30 UTC in winter is 00:30 in Madrid
+	assert.Equal(t, 10, tariff.EnergyPriceAt(time.Date(2026, 1, 10, 23, 30, 0, 0, time.UTC)))
+	assert.Equal(t, 30, tariff.EnergyPriceAt(time.Date(2026, 1, 10, 22, 30, 0, 0, time.UTC)))
+}
+
+func TestTariff_IsValidAt(t *testing.T) {
+	from := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	tariff := Tariff{IsActive: true, ValidFrom: &from, ValidTo: &to}
+
+	assert.False(t, tariff.IsValidAt(from.Add(-time.Second)))
+	assert.True(t, tariff.IsValidAt(from))
+	assert.True(t, tariff.IsValidAt(to.Add(-time.Second)))
+	assert.False(t, tariff.IsValidAt(to))
+
+	tariff.IsActive = false
+	assert.False(t, tariff.IsValidAt(from))
+}
+
+func TestTariff_Bind(t *testing.T) {
+	from := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		tariff  Tariff
+		wantErr bool
+	}{
+		{"valid", Tariff{TariffId: "t1", PricePerKwh: 30, MinPrice: 100, MaxPrice: 5000}, false},
+		{"min above max", Tariff{TariffId: "t1", MinPrice: 500, MaxPrice: 100}, true},
+		{"negative fee", Tariff{TariffId: "t1", SessionFee: -1}, true},
+		{"bad band time", Tariff{TariffId: "t1", EnergyBands: []*TariffBand{{StartTime: "25:00", EndTime: "06:00"}}}, true},
+		{"bad weekday", Tariff{TariffId: "t1", EnergyBands: []*TariffBand{{Weekdays: []time.Weekday{7}, StartTime: "22:00", EndTime: "06:00"}}}, true},
+		{"bad time zone", Tariff{TariffId: "t1", TimeZone: "Mars/Olympus"}, true},
+		{"empty validity window", Tariff{TariffId: "t1", ValidFrom: &from, ValidTo: &from}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tariff.Bind(nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -36,6 +36,9 @@ type Transaction struct {
 	PaymentBilled   int                `json:"payment_billed" bson:"payment_billed" validate:"min=0"`
 	PaymentOrder    int                `json:"payment_order" bson:"payment_order" validate:"min=0"`
 	PaymentError    string             `json:"payment_error,omitempty" bson:"payment_error,omitempty"`
+	GroupBilling    string             `json:"group_billing,omitempty" bson:"group_billing,omitempty"` // group whose monthly order pays the session
+	Discount        int                `json:"discount,omitempty" bson:"discount,omitempty"`           // taken off by a promotion, counted in payment_billed
+	PromoCode       string             `json:"promo_code,omitempty" bson:"promo_code,omitempty"`       // promotion that gave the discount
 	Plan            *PaymentPlan       `json:"payment_plan,omitempty" bson:"payment_plan,omitempty" validate:"omitempty"`
 	Tariff          *Tariff            `json:"tariff,omitempty" bson:"tariff,omitempty" validate:"omitempty"`
 	MeterValues     []TransactionMeter `json:"meter_values" bson:"meter_values" validate:"omitempty,dive"`
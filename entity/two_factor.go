@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"net/http"
+)
+
+// TwoFactorEnrollment is a TOTP secret waiting for its first code; the
+// provisioning URI is shown to authenticator apps as a QR code.
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningUri string `json:"provisioning_uri"`
+}
+
+// TwoFactorRecovery holds the recovery codes of an enrollment, returned once;
+// each replaces a TOTP code one time.
+type TwoFactorRecovery struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorCodeRequest carries a TOTP or recovery code.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+func (r *TwoFactorCodeRequest) Bind(_ *http.Request) error {
+	return validate.Struct(r)
+}
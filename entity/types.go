@@ -15,4 +15,5 @@ const (
 	Info             ResponseStage  = "info"
 	LogEvent         ResponseStage  = "log-event"
 	ChargePointEvent ResponseStage  = "charge-point-event"
+	Notice           ResponseStage  = "notice" // an in-app notice to one user
 )
@@ -0,0 +1,68 @@
+package entity
+
+import (
+	"errors"
+	"evsys-back/internal/lib/validate"
+	"net/http"
+)
+
+const (
+	LimitScopeUser  = "user"
+	LimitScopeGroup = "group"
+
+	LimitPeriodDaily   = "daily"
+	LimitPeriodMonthly = "monthly"
+
+	LimitKindAmount = "amount"
+	LimitKindEnergy = "energy"
+)
+
+// ErrLimitReached is returned when a user or the user's group has used up a
+// spending or energy limit.
+var ErrLimitReached = errors.New("usage limit reached")
+
+// UsageLimit caps what a user, or all users of a group together, may spend
+// (in cents) and consume (in Wh) per calendar day and month. A zero value
+// means no limit. With StopSession a running session is stopped when it
+// reaches a limit; otherwise limits only block new sessions.
+type UsageLimit struct {
+	Scope         string `json:"scope" bson:"scope" validate:"required,oneof=user group"`
+	Subject       string `json:"subject" bson:"subject" validate:"required"` // username or group name
+	DailyAmount   int    `json:"daily_amount" bson:"daily_amount" validate:"min=0"`
+	MonthlyAmount int    `json:"monthly_amount" bson:"monthly_amount" validate:"min=0"`
+	DailyEnergy   int    `json:"daily_energy" bson:"daily_energy" validate:"min=0"`
+	MonthlyEnergy int    `json:"monthly_energy" bson:"monthly_energy" validate:"min=0"`
+	StopSession   bool   `json:"stop_session" bson:"stop_session"`
+	Note          string `json:"note,omitempty" bson:"note,omitempty" validate:"omitempty"`
+}
+
+func (l *UsageLimit) Bind(_ *http.Request) error {
+	return validate.Struct(l)
+}
+
+// UsageCounter is the running total of billed transactions of a user or a
+// group in one period; Period is a day (2006-01-02) or a month (2006-01).
+type UsageCounter struct {
+	Scope        string `json:"scope" bson:"scope"`
+	Subject      string `json:"subject" bson:"subject"`
+	Period       string `json:"period" bson:"period"`
+	Amount       int    `json:"amount" bson:"amount"`
+	Energy       int    `json:"energy" bson:"energy"`
+	Transactions int    `json:"transactions" bson:"transactions"`
+}
+
+// LimitUsage reports the consumption against one limit.
+type LimitUsage struct {
+	Scope     string `json:"scope"`
+	Subject   string `json:"subject"`
+	Period    string `json:"period"` // daily or monthly
+	Kind      string `json:"kind"`   // amount or energy
+	Limit     int    `json:"limit"`
+	Used      int    `json:"used"`
+	Remaining int    `json:"remaining"`
+}
+
+// IsReached reports whether the limit is used up.
+func (u *LimitUsage) IsReached() bool {
+	return u.Used >= u.Limit
+}
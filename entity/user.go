@@ -13,20 +13,49 @@ const (
 
 type User struct {
 	Username       string    `json:"username" bson:"username" validate:"omitempty"`
-	Password       string    `json:"password" bson:"password" validate:"required"`
+	Password       string    `json:"password" bson:"password" validate:"required_without=TwoFactorChallenge"`
 	Name           string    `json:"name" bson:"name" validate:"omitempty"`
 	Role           string    `json:"role" bson:"role" validate:"omitempty,user_role"`
 	AccessLevel    int       `json:"access_level" bson:"access_level" validate:"omitempty,min=0,max=10"`
 	Email          string    `json:"email" bson:"email" validate:"omitempty,email_rfc"`
 	PaymentPlan    string    `json:"payment_plan" bson:"payment_plan" validate:"omitempty"`
 	Group          string    `json:"group" bson:"group" validate:"omitempty"`
+	BillingMode    string    `json:"billing_mode,omitempty" bson:"billing_mode,omitempty" validate:"omitempty,oneof=session monthly"`
 	Token          string    `json:"token" bson:"token" validate:"omitempty"`
 	UserId         string    `json:"user_id" bson:"user_id" validate:"omitempty"`
 	DateRegistered time.Time `json:"date_registered" bson:"date_registered" validate:"omitempty"`
 	LastSeen       time.Time `json:"last_seen" bson:"last_seen" validate:"omitempty"`
 
+	// EmailUnverified marks a registered user who has not confirmed the
+	// email address yet; such a user is granted no permissions
+	EmailUnverified bool `json:"email_unverified,omitempty" bson:"email_unverified,omitempty" validate:"omitempty"`
+
+	// TOTP second factor; the recovery codes are stored as hashes and the
+	// last accepted time step keeps a code from being used twice
+	TwoFactorEnabled bool     `json:"two_factor_enabled,omitempty" bson:"two_factor_enabled,omitempty" validate:"omitempty"`
+	TotpSecret       string   `json:"-" bson:"totp_secret,omitempty"`
+	TotpLastStep     int64    `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodes    []string `json:"-" bson:"recovery_codes,omitempty"`
+	// TwoFactorRequired marks a user whose role requires a second factor
+	// that is not enrolled yet, or that the login could not check, as with
+	// external identity tokens; such a user is granted no permissions
+	TwoFactorRequired bool `json:"two_factor_required,omitempty" bson:"-" validate:"omitempty"`
+	// TwoFactorChallenge is returned by a password login of a user with a
+	// second factor, instead of the tokens; the login completes when it is
+	// sent back with the code in TwoFactorCode
+	TwoFactorChallenge string `json:"two_factor_challenge,omitempty" bson:"-" validate:"omitempty"`
+	TwoFactorCode      string `json:"two_factor_code,omitempty" bson:"-" validate:"omitempty"`
+
 	WarningEmailsEnabled bool   `json:"warning_emails_enabled" bson:"warning_emails_enabled" validate:"omitempty"`
 	WarningEmail         string `json:"warning_email" bson:"warning_email" validate:"omitempty,email_rfc"`
+
+	Fiscal *FiscalDetails `json:"fiscal,omitempty" bson:"fiscal,omitempty" validate:"omitempty"`
+
+	// Session carries the tokens issued by a login; Token holds the same
+	// access token for older clients
+	Session *SessionTokens `json:"session,omitempty" bson:"-" validate:"omitempty"`
+	// Permissions are resolved from the user's role on authentication
+	Permissions []string `json:"permissions,omitempty" bson:"-" validate:"omitempty"`
 }
 
 type UserInfo struct {
@@ -35,12 +64,24 @@ type UserInfo struct {
 	Role           string    `json:"role" bson:"role"`
 	AccessLevel    int       `json:"access_level" bson:"access_level"`
 	Email          string    `json:"email" bson:"email"`
+	Group          string    `json:"group" bson:"group"`
+	BillingMode    string    `json:"billing_mode,omitempty" bson:"billing_mode,omitempty"`
 	DateRegistered time.Time `json:"date_registered" bson:"date_registered"`
 	LastSeen       time.Time `json:"last_seen" bson:"last_seen"`
 
+	EmailUnverified bool `json:"email_unverified,omitempty" bson:"email_unverified,omitempty"`
+
 	WarningEmailsEnabled bool   `json:"warning_emails_enabled" bson:"warning_emails_enabled"`
 	WarningEmail         string `json:"warning_email" bson:"warning_email"`
 
+	Fiscal *FiscalDetails `json:"fiscal,omitempty" bson:"fiscal,omitempty"`
+
+	UserId        string `json:"-" bson:"user_id"`
+	WalletBalance int    `json:"wallet_balance" bson:"-"`
+
+	// Limits reports the consumption against each limit of the user and group
+	Limits []*LimitUsage `json:"limits,omitempty" bson:"-"`
+
 	PaymentPlans   []*PaymentPlan   `json:"payment_plans" bson:"payment_plans"`
 	UserTags       []*UserTag       `json:"user_tags" bson:"user_tags"`
 	PaymentMethods []*PaymentMethod `json:"payment_methods" bson:"payment_methods"`
@@ -58,6 +99,10 @@ type UserUpdate struct {
 	Role        string `json:"role" validate:"omitempty,user_role"`
 	AccessLevel int    `json:"access_level" validate:"omitempty,min=0,max=10"`
 	PaymentPlan string `json:"payment_plan" validate:"omitempty"`
+	Group       string `json:"group" validate:"omitempty"`
+	// BillingMode overrides the group's billing mode; "group" clears the
+	// override, empty keeps it
+	BillingMode string `json:"billing_mode" validate:"omitempty,oneof=session monthly group"`
 
 	WarningEmailsEnabled bool   `json:"warning_emails_enabled" validate:"omitempty"`
 	WarningEmail         string `json:"warning_email" validate:"omitempty,email_rfc"`
@@ -67,10 +112,12 @@ func (u *UserUpdate) Bind(_ *http.Request) error {
 	return validate.Struct(u)
 }
 
-func (u *User) IsAdmin() bool {
-	return u.Role == roleAdmin
-}
-
-func (u *User) IsPowerUser() bool {
-	return u.Role == roleAdmin || u.Role == roleOperator
+// Can reports whether the user holds a permission. Until the permissions are
+// resolved from the stored role, the built-in role of the name applies.
+func (u *User) Can(permission string) bool {
+	if u.Permissions != nil {
+		return permissionGranted(u.Permissions, permission)
+	}
+	role := BuiltInRole(u.Role)
+	return role != nil && role.Allows(permission)
 }
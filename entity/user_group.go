@@ -0,0 +1,95 @@
+package entity
+
+import (
+	"evsys-back/internal/lib/validate"
+	"net/http"
+	"time"
+)
+
+const (
+	// BillingModeSession charges every session to the driver's own card.
+	BillingModeSession = "session"
+	// BillingModeMonthly collects the sessions of a month into one order
+	// charged to the group's billing account.
+	BillingModeMonthly = "monthly"
+	// BillingModeGroup clears a user's billing mode in an update, so the
+	// user follows the group's mode again.
+	BillingModeGroup = "group"
+
+	OrderPurposeGroupBilling = "group_billing"
+
+	StatementStatusPending = "pending"
+	StatementStatusPaid    = "paid"
+	StatementStatusFailed  = "failed"
+)
+
+// UserGroup holds the settings shared by the users of a group. In monthly
+// billing mode the sessions of its users are paid with the default payment
+// method of BillingUser, whose fiscal details are also on the invoice. A
+// user's own billing mode, when set, takes precedence over the group's.
+type UserGroup struct {
+	Name        string    `json:"name" bson:"name" validate:"required"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty" validate:"omitempty"`
+	BillingMode string    `json:"billing_mode" bson:"billing_mode" validate:"omitempty,oneof=session monthly"`
+	BillingUser string    `json:"billing_user,omitempty" bson:"billing_user,omitempty" validate:"required_if=BillingMode monthly"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+func (g *UserGroup) Bind(_ *http.Request) error {
+	return validate.Struct(g)
+}
+
+// GroupStatement is the monthly order of a group billed monthly, with the
+// sessions it covers broken down by driver. Amounts in cents, energy in Wh.
+type GroupStatement struct {
+	Id          string             `json:"id" bson:"_id"`
+	Group       string             `json:"group" bson:"group"`
+	Period      string             `json:"period" bson:"period"` // 2006-01
+	BillingUser string             `json:"billing_user" bson:"billing_user"`
+	Order       int                `json:"order" bson:"order"`
+	Status      string             `json:"status" bson:"status"`
+	Amount      int                `json:"amount" bson:"amount"`
+	Energy      int                `json:"energy" bson:"energy"`
+	Sessions    int                `json:"sessions" bson:"sessions"`
+	Drivers     []*DriverStatement `json:"drivers" bson:"drivers"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	ClosedAt    time.Time          `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+}
+
+// DriverStatement lists one driver's sessions on a group statement.
+type DriverStatement struct {
+	Username string              `json:"username" bson:"username"`
+	Amount   int                 `json:"amount" bson:"amount"`
+	Energy   int                 `json:"energy" bson:"energy"`
+	Sessions []*StatementSession `json:"sessions" bson:"sessions"`
+}
+
+type StatementSession struct {
+	TransactionId int       `json:"transaction_id" bson:"transaction_id"`
+	ChargePointId string    `json:"charge_point_id" bson:"charge_point_id"`
+	TimeStart     time.Time `json:"time_start" bson:"time_start"`
+	TimeStop      time.Time `json:"time_stop" bson:"time_stop"`
+	Energy        int       `json:"energy" bson:"energy"`
+	Amount        int       `json:"amount" bson:"amount"`
+}
+
+// AddSession adds a session of a driver to the statement and its totals.
+func (s *GroupStatement) AddSession(username string, session *StatementSession) {
+	var driver *DriverStatement
+	for _, d := range s.Drivers {
+		if d.Username == username {
+			driver = d
+			break
+		}
+	}
+	if driver == nil {
+		driver = &DriverStatement{Username: username}
+		s.Drivers = append(s.Drivers, driver)
+	}
+	driver.Sessions = append(driver.Sessions, session)
+	driver.Amount += session.Amount
+	driver.Energy += session.Energy
+	s.Amount += session.Amount
+	s.Energy += session.Energy
+	s.Sessions++
+}
@@ -0,0 +1,63 @@
+package entity
+
+import (
+	"errors"
+	"evsys-back/internal/lib/validate"
+	"net/http"
+	"time"
+)
+
+// WalletIdentifier stands in for the card identifier on payment orders paid
+// from the wallet balance, and selects the wallet as the payment method of a
+// remote start.
+const WalletIdentifier = "wallet"
+
+// OrderPurposeTopUp marks a payment order that adds its amount to the user's
+// wallet instead of enrolling a card.
+const OrderPurposeTopUp = "top_up"
+
+// Wallet ledger entry types.
+const (
+	WalletEntryTopUp  = "top_up" // paid by card through a web order
+	WalletEntryDebit  = "debit"  // a session billed from the balance
+	WalletEntryCredit = "credit" // issued by an operator
+	WalletEntryRefund = "refund" // a refund of a session paid from the balance
+	WalletEntryPromo  = "promo"  // a promotion code redeemed for credit
+)
+
+// ErrInsufficientBalance is returned when a debit would take a wallet below zero.
+var ErrInsufficientBalance = errors.New("insufficient wallet balance")
+
+// WalletEntry is one movement of a user's prepaid balance. Amount is signed,
+// in cents: debits are negative. Balance is the balance right after the entry.
+type WalletEntry struct {
+	Id            string    `json:"id" bson:"_id,omitempty"`
+	UserId        string    `json:"-" bson:"user_id"`
+	Username      string    `json:"username" bson:"username"`
+	Type          string    `json:"type" bson:"type"`
+	Amount        int       `json:"amount" bson:"amount"`
+	Balance       int       `json:"balance" bson:"balance"`
+	TransactionId int       `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	Order         int       `json:"order,omitempty" bson:"order,omitempty"`
+	Note          string    `json:"note,omitempty" bson:"note,omitempty"`
+	Author        string    `json:"author,omitempty" bson:"author,omitempty"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Wallet is the balance of a user with its latest ledger entries.
+type Wallet struct {
+	Username string         `json:"username"`
+	Balance  int            `json:"balance"`
+	Entries  []*WalletEntry `json:"entries"`
+}
+
+// WalletCreditRequest is an operator's credit to a user's wallet.
+type WalletCreditRequest struct {
+	Username string `json:"username" validate:"required"`
+	Amount   int    `json:"amount" validate:"min=1"`
+	Note     string `json:"note" validate:"required"`
+}
+
+func (w *WalletCreditRequest) Bind(_ *http.Request) error {
+	return validate.Struct(w)
+}
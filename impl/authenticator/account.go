@@ -0,0 +1,169 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultResetTTL    = time.Hour
+	defaultVerifyTTL   = 48 * time.Hour
+	accountTokenLength = 48
+)
+
+// SetAccountTokens sets how long password reset and email verification links
+// are valid, zero keeping the default, and whether registered users have to
+// confirm their email address.
+func (a *Authenticator) SetAccountTokens(resetTTL, verifyTTL time.Duration, verifyEmail bool) {
+	if resetTTL > 0 {
+		a.resetTTL = resetTTL
+	}
+	if verifyTTL > 0 {
+		a.verifyTTL = verifyTTL
+	}
+	a.verifyEmail = verifyEmail
+}
+
+// issueAccountToken creates a token of the purpose for the user's email
+// address; tokens of the same purpose issued before stop working.
+func (a *Authenticator) issueAccountToken(ctx context.Context, user *entity.User, purpose string, ttl time.Duration) (*entity.AccountToken, error) {
+	if err := a.database.DeleteAccountTokens(ctx, user.UserId, purpose); err != nil {
+		return nil, fmt.Errorf("deleting previous tokens: %w", err)
+	}
+	now := time.Now()
+	token := &entity.AccountToken{
+		Token:     a.generateKey(accountTokenLength),
+		Purpose:   purpose,
+		UserId:    user.UserId,
+		Username:  user.Username,
+		Email:     user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	token.Hash = hashToken(token.Token)
+	if err := a.database.AddAccountToken(ctx, token); err != nil {
+		return nil, fmt.Errorf("saving token: %w", err)
+	}
+	return token, nil
+}
+
+// useAccountToken consumes a valid token of the purpose and returns it with
+// the user it was issued to; the email address must not have changed since.
+func (a *Authenticator) useAccountToken(ctx context.Context, value, purpose string) (*entity.AccountToken, *entity.User, error) {
+	hash := hashToken(value)
+	token, _ := a.database.GetAccountToken(ctx, hash)
+	if token == nil || token.Purpose != purpose {
+		return nil, nil, fmt.Errorf("invalid token")
+	}
+	if err := a.database.DeleteAccountToken(ctx, hash); err != nil {
+		return nil, nil, fmt.Errorf("deleting token: %w", err)
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, nil, fmt.Errorf("token expired")
+	}
+	user, _ := a.database.GetUserById(ctx, token.UserId)
+	if user == nil {
+		return nil, nil, fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	if user.Email != token.Email {
+		return nil, nil, fmt.Errorf("email address changed")
+	}
+	return token, user, nil
+}
+
+// CreatePasswordReset issues a reset token to the user with the username or
+// email address; the result holds the token to be emailed.
+func (a *Authenticator) CreatePasswordReset(ctx context.Context, login string) (*entity.AccountToken, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	user, _ := a.database.GetUser(ctx, login)
+	if user == nil {
+		user, _ = a.database.GetUserByEmail(ctx, login)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	if user.Email == "" {
+		return nil, fmt.Errorf("user %s has no email address", user.Username)
+	}
+	token, err := a.issueAccountToken(ctx, user, entity.AccountTokenPasswordReset, a.resetTTL)
+	if err != nil {
+		return nil, err
+	}
+	a.logger.With(
+		slog.String("username", user.Username),
+	).Info("password reset requested")
+	return token, nil
+}
+
+// ResetPassword sets a new password with a reset token and ends all sessions
+// of the user. The reset proves the email address, so it is confirmed too.
+func (a *Authenticator) ResetPassword(ctx context.Context, token, password string) error {
+	if len(password) < 6 {
+		return fmt.Errorf("password must be at least 6 characters")
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	_, user, err := a.useAccountToken(ctx, token, entity.AccountTokenPasswordReset)
+	if err != nil {
+		return err
+	}
+	user.Password = a.generatePasswordHash(password)
+	if user.Password == "" {
+		return fmt.Errorf("failed to hash password")
+	}
+	user.EmailUnverified = false
+	if err = a.database.UpdateUser(ctx, user); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	count, err := a.database.DeleteUserSessions(ctx, user.UserId)
+	if err != nil {
+		a.logger.Error("deleting user sessions", sl.Err(err))
+	}
+	a.logger.With(
+		slog.String("username", user.Username),
+		slog.Int("sessions", count),
+	).Info("password reset")
+	return nil
+}
+
+// CreateEmailVerification issues a verification token to a user who has not
+// confirmed the email address; the result holds the token to be emailed.
+func (a *Authenticator) CreateEmailVerification(ctx context.Context, username string) (*entity.AccountToken, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	user, _ := a.database.GetUser(ctx, username)
+	if user == nil {
+		return nil, fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	if !user.EmailUnverified {
+		return nil, fmt.Errorf("email address is already confirmed")
+	}
+	if user.Email == "" {
+		return nil, fmt.Errorf("user %s has no email address", user.Username)
+	}
+	return a.issueAccountToken(ctx, user, entity.AccountTokenEmailVerify, a.verifyTTL)
+}
+
+// VerifyEmail confirms the email address of the user a verification token
+// was issued to, lifting the limited access of the account.
+func (a *Authenticator) VerifyEmail(ctx context.Context, token string) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	_, user, err := a.useAccountToken(ctx, token, entity.AccountTokenEmailVerify)
+	if err != nil {
+		return err
+	}
+	user.EmailUnverified = false
+	if err = a.database.UpdateUser(ctx, user); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	a.logger.With(
+		slog.String("username", user.Username),
+	).Info("email verified")
+	return nil
+}
@@ -0,0 +1,126 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResetPassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		login   string
+		ttl     time.Duration
+		change  func(db *database_mock.MockDB)
+		wantErr bool
+	}{
+		{"by username", "driver", time.Hour, nil, false},
+		{"by email", "driver@example.com", time.Hour, nil, false},
+		{"expired", "driver", time.Nanosecond, nil, true},
+		{"email changed", "driver", time.Hour, func(db *database_mock.MockDB) {
+			user, _ := db.GetUser(context.Background(), "driver")
+			user.Email = "new@example.com"
+			_ = db.UpdateUser(context.Background(), user)
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := database_mock.NewMockDB()
+			auth := New(newTestLogger(), db)
+			auth.SetAccountTokens(tt.ttl, 0, false)
+			db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Email: "driver@example.com", Password: createHashedPassword("old-secret")})
+			login, err := auth.AuthenticateUser(ctx, "driver", "old-secret", "")
+			require.NoError(t, err)
+
+			token, err := auth.CreatePasswordReset(ctx, tt.login)
+			require.NoError(t, err)
+			assert.Equal(t, "driver@example.com", token.Email)
+			assert.Len(t, token.Token, accountTokenLength)
+			stored, _ := db.GetAccountToken(ctx, token.Hash)
+			require.NotNil(t, stored)
+			assert.Empty(t, stored.Token, "token is stored as a hash")
+
+			time.Sleep(time.Millisecond)
+			if tt.change != nil {
+				tt.change(db)
+			}
+			err = auth.ResetPassword(ctx, token.Token, "new-secret")
+			if tt.wantErr {
+				assert.Error(t, err)
+				_, err = auth.AuthenticateUser(ctx, "driver", "old-secret", "")
+				assert.NoError(t, err, "password unchanged")
+				return
+			}
+			require.NoError(t, err)
+
+			_, err = auth.AuthenticateUser(ctx, "driver", "old-secret", "")
+			assert.Error(t, err)
+			_, err = auth.AuthenticateUser(ctx, "driver", "new-secret", "")
+			assert.NoError(t, err)
+			_, err = auth.AuthenticateByToken(ctx, login.Token)
+			assert.Error(t, err, "sessions are revoked")
+			assert.Error(t, auth.ResetPassword(ctx, token.Token, "other-secret"), "token is single-use")
+		})
+	}
+}
+
+func TestCreatePasswordResetFails(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	db.SeedUser(&entity.User{Username: "nomail", UserId: "u2", Password: createHashedPassword("secret")})
+
+	_, err := auth.CreatePasswordReset(ctx, "unknown")
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+	_, err = auth.CreatePasswordReset(ctx, "nomail")
+	assert.Error(t, err)
+	assert.Error(t, auth.ResetPassword(ctx, "invalid", "new-secret"))
+
+	// a new link replaces the previous one
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Email: "driver@example.com", Password: createHashedPassword("secret")})
+	first, err := auth.CreatePasswordReset(ctx, "driver")
+	require.NoError(t, err)
+	second, err := auth.CreatePasswordReset(ctx, "driver")
+	require.NoError(t, err)
+	assert.Error(t, auth.ResetPassword(ctx, first.Token, "new-secret"))
+	assert.NoError(t, auth.ResetPassword(ctx, second.Token, "new-secret"))
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	auth.SetAccountTokens(0, 0, true)
+	db.SeedInvite("INVITE1")
+	db.SeedInvite("INVITE2")
+
+	assert.Error(t, auth.RegisterUser(ctx, &entity.User{Username: "nomail", Password: "secret", Token: "INVITE1"}), "email required")
+
+	require.NoError(t, auth.RegisterUser(ctx, &entity.User{Username: "driver", Password: "secret", Email: "driver@example.com", Token: "INVITE2"}))
+	user, err := auth.AuthenticateUser(ctx, "driver", "secret", "")
+	require.NoError(t, err)
+	assert.True(t, user.EmailUnverified)
+	assert.Error(t, auth.CommandAccess(user, "RemoteStartTransaction"), "limited access until verified")
+
+	token, err := auth.CreateEmailVerification(ctx, "driver")
+	require.NoError(t, err)
+	assert.Error(t, auth.ResetPassword(ctx, token.Token, "new-secret"), "token of another purpose")
+	token, err = auth.CreateEmailVerification(ctx, "driver")
+	require.NoError(t, err)
+	require.NoError(t, auth.VerifyEmail(ctx, token.Token))
+	assert.Error(t, auth.VerifyEmail(ctx, token.Token), "token is single-use")
+
+	user, err = auth.AuthenticateByToken(ctx, user.Token)
+	require.NoError(t, err)
+	assert.False(t, user.EmailUnverified)
+	assert.NoError(t, auth.CommandAccess(user, "RemoteStartTransaction"))
+	_, err = auth.CreateEmailVerification(ctx, "driver")
+	assert.Error(t, err, "already verified")
+}
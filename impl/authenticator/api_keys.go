@@ -0,0 +1,158 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const (
+	apiKeyPrefix       = "evk_"
+	apiKeySecretLength = 40
+	apiKeyIdLength     = 12
+	apiKeyShownLength  = 12 // characters of the key kept to identify it
+	// the key's last-used time is written at most this often
+	apiKeyTouchInterval = time.Minute
+)
+
+// newApiKeySecret generates a secret and sets its hash and prefix on the key.
+func (a *Authenticator) newApiKeySecret(key *entity.ApiKey) {
+	key.Key = apiKeyPrefix + a.generateKey(apiKeySecretLength)
+	key.KeyHash = hashToken(key.Key)
+	key.Prefix = key.Key[:apiKeyShownLength]
+}
+
+// CreateApiKey creates a key with the requested scopes; the result holds the
+// secret, which is not stored.
+func (a *Authenticator) CreateApiKey(ctx context.Context, author *entity.User, req *entity.ApiKeyRequest) (*entity.ApiKey, error) {
+	if len(req.Scopes) == 0 {
+		return nil, fmt.Errorf("no scopes")
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(entity.ApiKeyScopes, scope) {
+			return nil, fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("expiry is in the past")
+	}
+	key := &entity.ApiKey{
+		Id:        a.generateKey(apiKeyIdLength),
+		Name:      req.Name,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: now,
+		CreatedBy: author.Username,
+	}
+	a.newApiKeySecret(key)
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if err := a.database.AddApiKey(ctx, key); err != nil {
+		return nil, fmt.Errorf("saving api key: %w", err)
+	}
+	a.logger.With(
+		slog.String("name", key.Name),
+		slog.String("prefix", key.Prefix),
+		slog.Any("scopes", key.Scopes),
+		slog.String("author", author.Username),
+	).Info("api key created")
+	return key, nil
+}
+
+// ListApiKeys returns all keys, revoked ones included, without secrets.
+func (a *Authenticator) ListApiKeys(ctx context.Context) ([]*entity.ApiKey, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	keys, err := a.database.GetApiKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = make([]*entity.ApiKey, 0)
+	}
+	return keys, nil
+}
+
+// activeApiKey returns a key that can still be rotated or revoked.
+func (a *Authenticator) activeApiKey(ctx context.Context, id string) (*entity.ApiKey, error) {
+	key, err := a.database.GetApiKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("api key %w", entity.ErrNotFound)
+	}
+	if key.RevokedAt != nil {
+		return nil, fmt.Errorf("api key is revoked")
+	}
+	return key, nil
+}
+
+// RotateApiKey replaces the secret of a key, keeping its name and scopes;
+// the old secret stops working at once.
+func (a *Authenticator) RotateApiKey(ctx context.Context, id string) (*entity.ApiKey, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	key, err := a.activeApiKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	a.newApiKeySecret(key)
+	key.RotatedAt = &now
+	if err = a.database.UpdateApiKey(ctx, key); err != nil {
+		return nil, fmt.Errorf("updating api key: %w", err)
+	}
+	a.logger.With(
+		slog.String("name", key.Name),
+		slog.String("prefix", key.Prefix),
+	).Info("api key rotated")
+	return key, nil
+}
+
+// RevokeApiKey disables a key; it stays listed for reference.
+func (a *Authenticator) RevokeApiKey(ctx context.Context, id string) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	key, err := a.activeApiKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	if err = a.database.UpdateApiKey(ctx, key); err != nil {
+		return fmt.Errorf("updating api key: %w", err)
+	}
+	a.logger.With(
+		slog.String("name", key.Name),
+		slog.String("prefix", key.Prefix),
+	).Info("api key revoked")
+	return nil
+}
+
+// AuthenticateApiKey returns the identity of an active key, with the key's
+// scopes as permissions.
+func (a *Authenticator) AuthenticateApiKey(ctx context.Context, secret string) (*entity.User, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("empty api key")
+	}
+	key, _ := a.database.GetApiKeyByHash(ctx, hashToken(secret))
+	if key == nil {
+		return nil, fmt.Errorf("invalid api key")
+	}
+	now := time.Now()
+	if !key.Active(now) {
+		return nil, fmt.Errorf("api key %s is revoked or expired", key.Prefix)
+	}
+	if key.LastUsed == nil || now.Sub(*key.LastUsed) > apiKeyTouchInterval {
+		if err := a.database.SetApiKeyUsed(ctx, key.Id, now); err != nil {
+			a.logger.Warn("updating api key last use", sl.Err(err))
+		}
+	}
+	return key.User(), nil
+}
@@ -0,0 +1,109 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateApiKey(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name    string
+		req     entity.ApiKeyRequest
+		wantErr bool
+	}{
+		{"payments", entity.ApiKeyRequest{Name: "csms", Scopes: []string{entity.ScopePaymentsPay, entity.ScopePaymentsRefund}}, false},
+		{"with expiry", entity.ApiKeyRequest{Name: "bi", Scopes: []string{entity.ScopeReportsRead}, ExpiresAt: &future}, false},
+		{"no scopes", entity.ApiKeyRequest{Name: "empty"}, true},
+		{"unknown scope", entity.ApiKeyRequest{Name: "bad", Scopes: []string{entity.PermissionUsersWrite}}, true},
+		{"expired", entity.ApiKeyRequest{Name: "old", Scopes: []string{entity.ScopeReportsRead}, ExpiresAt: &past}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := database_mock.NewMockDB()
+			auth := New(newTestLogger(), db)
+			author := &entity.User{Username: "admin", Role: "admin"}
+
+			key, err := auth.CreateApiKey(ctx, author, &tt.req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				keys, _ := auth.ListApiKeys(ctx)
+				assert.Empty(t, keys)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(key.Key, apiKeyPrefix))
+			assert.Equal(t, key.Key[:apiKeyShownLength], key.Prefix)
+			assert.Equal(t, "admin", key.CreatedBy)
+
+			keys, err := auth.ListApiKeys(ctx)
+			require.NoError(t, err)
+			require.Len(t, keys, 1)
+			assert.Empty(t, keys[0].Key, "secret is not stored")
+			assert.NotContains(t, keys[0].KeyHash, key.Key)
+
+			user, err := auth.AuthenticateApiKey(ctx, key.Key)
+			require.NoError(t, err)
+			for _, scope := range tt.req.Scopes {
+				assert.True(t, user.Can(scope), scope)
+			}
+			assert.False(t, user.Can(entity.PermissionUsersWrite))
+			assert.False(t, user.Can(entity.CommandPermission("RemoteStartTransaction")))
+		})
+	}
+}
+
+func TestAuthenticateApiKey(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	author := &entity.User{Username: "admin", Role: "admin"}
+
+	key, err := auth.CreateApiKey(ctx, author, &entity.ApiKeyRequest{Name: "csms", Scopes: []string{entity.ScopePaymentsPay}})
+	require.NoError(t, err)
+
+	_, err = auth.AuthenticateApiKey(ctx, "evk_unknown")
+	assert.Error(t, err)
+	_, err = auth.AuthenticateApiKey(ctx, "")
+	assert.Error(t, err)
+
+	_, err = auth.AuthenticateApiKey(ctx, key.Key)
+	require.NoError(t, err)
+	stored, _ := db.GetApiKey(ctx, key.Id)
+	require.NotNil(t, stored.LastUsed, "last use is recorded")
+
+	rotated, err := auth.RotateApiKey(ctx, key.Id)
+	require.NoError(t, err)
+	assert.NotEqual(t, key.Key, rotated.Key)
+	assert.NotNil(t, rotated.RotatedAt)
+	_, err = auth.AuthenticateApiKey(ctx, key.Key)
+	assert.Error(t, err, "old secret stops working")
+	_, err = auth.AuthenticateApiKey(ctx, rotated.Key)
+	require.NoError(t, err)
+
+	require.NoError(t, auth.RevokeApiKey(ctx, key.Id))
+	_, err = auth.AuthenticateApiKey(ctx, rotated.Key)
+	assert.Error(t, err, "revoked key")
+	assert.Error(t, auth.RevokeApiKey(ctx, key.Id), "already revoked")
+	_, err = auth.RotateApiKey(ctx, key.Id)
+	assert.Error(t, err, "revoked key cannot be rotated")
+	_, err = auth.RotateApiKey(ctx, "missing")
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+
+	// an expired key is refused
+	expires := time.Now().Add(-time.Minute)
+	stored, _ = db.GetApiKey(ctx, key.Id)
+	require.NoError(t, db.AddApiKey(ctx, &entity.ApiKey{Id: "expired", Name: "old", KeyHash: hashToken("evk_expired"), Scopes: stored.Scopes, ExpiresAt: &expires}))
+	_, err = auth.AuthenticateApiKey(ctx, "evk_expired")
+	assert.Error(t, err)
+}
@@ -20,17 +20,23 @@ const (
 	defaultUserGroupId = "default"
 )
 
-// commands allowed only for admins
-var adminCommands = []string{"ChangeConfiguration", "SetChargingProfile", "ClearChargingProfile", "GetDiagnostics"}
-
-// commands allowed for all users
-var userCommands = []string{"RemoteStartTransaction", "RemoteStopTransaction"}
-
 type Authenticator struct {
-	logger   *slog.Logger
-	database Repository
-	firebase FirebaseAuth
-	mux      sync.Mutex
+	logger     *slog.Logger
+	database   Repository
+	firebase   FirebaseAuth
+	verifiers  []TokenVerifier
+	tokenTTL   time.Duration
+	refreshTTL time.Duration
+	resetTTL   time.Duration
+	verifyTTL  time.Duration
+	// verifyEmail requires registered users to confirm their email address
+	verifyEmail bool
+	// inviteRequired rejects registrations without an invite code
+	inviteRequired bool
+	// users of these roles need a second factor to be granted permissions
+	twoFactorRoles []string
+	totpIssuer     string
+	mux            sync.Mutex
 }
 
 func New(log *slog.Logger, repo Repository) *Authenticator {
@@ -39,9 +45,16 @@ func New(log *slog.Logger, repo Repository) *Authenticator {
 		return nil
 	}
 	return &Authenticator{
-		database: repo,
-		logger:   log.With(sl.Module("impl.authenticator")),
-		mux:      sync.Mutex{},
+		database:   repo,
+		logger:     log.With(sl.Module("impl.authenticator")),
+		tokenTTL:   defaultTokenTTL,
+		refreshTTL: defaultRefreshTTL,
+		resetTTL:   defaultResetTTL,
+		verifyTTL:  defaultVerifyTTL,
+
+		inviteRequired: true,
+		totpIssuer:     defaultIssuer,
+		mux:            sync.Mutex{},
 	}
 }
 
@@ -49,6 +62,12 @@ func (a *Authenticator) SetFirebase(firebase FirebaseAuth) {
 	a.firebase = firebase
 }
 
+// AddTokenVerifier accepts the identity tokens of the verifier's providers,
+// checked before Firebase.
+func (a *Authenticator) AddTokenVerifier(verifier TokenVerifier) {
+	a.verifiers = append(a.verifiers, verifier)
+}
+
 func (a *Authenticator) AuthenticateByToken(ctx context.Context, token string) (*entity.User, error) {
 	if token == "" {
 		return nil, fmt.Errorf("empty token")
@@ -59,13 +78,33 @@ func (a *Authenticator) AuthenticateByToken(ctx context.Context, token string) (
 		return nil, fmt.Errorf("invalid token")
 	}
 	var user *entity.User
-	// considering token is database token
+	// considering token is a session access token
 	if len(token) == tokenLength {
-		user, _ = a.database.CheckToken(ctx, token)
-		if user == nil {
-			return nil, fmt.Errorf("token check failed")
+		user, err := a.checkSession(ctx, token)
+		if err != nil {
+			return nil, err
 		}
 		_ = a.updateLastSeen(ctx, user)
+		a.resolvePermissions(ctx, user)
+		// the token identifies the current session
+		user.Token = token
+		return user, nil
+	}
+	// considering token is an identity token of a configured provider
+	for _, verifier := range a.verifiers {
+		if !verifier.Accepts(token) {
+			continue
+		}
+		identity, err := verifier.VerifyToken(token)
+		if err != nil {
+			return nil, fmt.Errorf("identity token check: %s", err)
+		}
+		user, err = a.userByIdentity(ctx, identity)
+		if err != nil {
+			return nil, fmt.Errorf("getting user by identity: %s", err)
+		}
+		a.resolveExternalPermissions(ctx, user)
+		user.Token = token
 		return user, nil
 	}
 	// considering token is firebase token
@@ -78,6 +117,7 @@ func (a *Authenticator) AuthenticateByToken(ctx context.Context, token string) (
 		if err != nil {
 			return nil, fmt.Errorf("getting user by id: %s", err)
 		}
+		a.resolveExternalPermissions(ctx, user)
 		// put token to user data, frontend uses it for further requests
 		user.Token = token
 		return user, nil
@@ -86,6 +126,13 @@ func (a *Authenticator) AuthenticateByToken(ctx context.Context, token string) (
 }
 
 func (a *Authenticator) GetUserById(ctx context.Context, userId string) (*entity.User, error) {
+	return a.userByIdentity(ctx, &entity.Identity{UserId: userId})
+}
+
+// userByIdentity returns the user of an identity, registering a new user
+// named after it on first login
+func (a *Authenticator) userByIdentity(ctx context.Context, identity *entity.Identity) (*entity.User, error) {
+	userId := identity.UserId
 	if userId == "" {
 		return nil, fmt.Errorf("empty user id")
 	}
@@ -102,11 +149,23 @@ func (a *Authenticator) GetUserById(ctx context.Context, userId string) (*entity
 			username = fmt.Sprintf("user_%s", a.generateKey(nameLength))
 		}
 
+		name := identity.Name
+		if name == "" {
+			name = "App user"
+		}
+		// an address of another account is not taken, it would find both by email
+		email := identity.Email
+		if email != "" {
+			if other, _ := a.database.GetUserByEmail(ctx, email); other != nil {
+				email = ""
+			}
+		}
 		user = &entity.User{
 			Username:       username,
-			Name:           "App user",
+			Name:           name,
+			Email:          email,
 			UserId:         userId,
-			PaymentPlan:    defaultPaymentPlan,
+			PaymentPlan:    a.defaultPlanFor(ctx, defaultUserGroupId),
 			Group:          defaultUserGroupId,
 			DateRegistered: time.Now(),
 		}
@@ -125,28 +184,6 @@ func (a *Authenticator) GetUserById(ctx context.Context, userId string) (*entity
 	return user, nil
 }
 
-func (a *Authenticator) GenerateInvites(ctx context.Context, count int) ([]string, error) {
-	a.mux.Lock()
-	defer a.mux.Unlock()
-	invites := make([]string, 0)
-	for i := 0; i < count; i++ {
-		inviteCode := a.generateKey(5)
-		invite := &entity.Invite{
-			Code: inviteCode,
-		}
-		err := a.database.AddInviteCode(ctx, invite)
-		if err != nil {
-			a.logger.Error("add invite code", sl.Err(err))
-			continue
-		}
-		invites = append(invites, inviteCode)
-	}
-	if len(invites) == 0 {
-		return nil, fmt.Errorf("no invites generated")
-	}
-	return invites, nil
-}
-
 func (a *Authenticator) GetUserTag(ctx context.Context, user *entity.User) (string, error) {
 	a.mux.Lock()
 	defer a.mux.Unlock()
@@ -183,36 +220,52 @@ func (a *Authenticator) GetUserTag(ctx context.Context, user *entity.User) (stri
 	return tags[0].IdTag, nil
 }
 
-// AuthenticateUser method returns user with blank password if authentication is successful
+// AuthenticateUser method returns user with blank password if authentication is successful;
+// each login opens a new session on the device, leaving other sessions intact.
+// A user with a second factor gets only a challenge, see AuthenticateTwoFactor
 // Note: in a database, the password should be stored as a hash
-func (a *Authenticator) AuthenticateUser(ctx context.Context, username, password string) (*entity.User, error) {
+func (a *Authenticator) AuthenticateUser(ctx context.Context, username, password, device string) (*entity.User, error) {
 	a.mux.Lock()
 	defer a.mux.Unlock()
 	user, err := a.database.GetUser(ctx, username)
-	if err != nil {
+	if err != nil || user == nil {
 		return nil, fmt.Errorf("user not found: %s", username)
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
 		return nil, fmt.Errorf("password check failed: %s", username)
 	}
-	token := a.generateKey(tokenLength)
-	user.Token = token
+	if user.TwoFactorEnabled {
+		return a.twoFactorChallenge(ctx, user)
+	}
+	return a.login(ctx, user, device)
+}
+
+// login opens a session of an authenticated user on the device.
+func (a *Authenticator) login(ctx context.Context, user *entity.User, device string) (*entity.User, error) {
+	session, err := a.createSession(ctx, user, device)
+	if err != nil {
+		return nil, err
+	}
+	user.Token = session.Token
+	user.Session = session
 	user.LastSeen = time.Now()
 	err = a.database.UpdateLastSeen(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 	user.Password = ""
+	a.resolvePermissions(ctx, user)
 	a.logger.With(
 		slog.String("username", user.Username),
 		sl.Secret("user_id", user.UserId),
-		sl.Secret("token", token),
+		slog.String("session", session.SessionId),
 	).Info("user authenticated")
 	return user, nil
 }
 
-// RegisterUser registers a new user in the system with validation checks and default assignments.
+// RegisterUser registers a new user in the system with validation checks and default assignments;
+// the invite code in the user's token field sets the group, payment plan and role of the account
 func (a *Authenticator) RegisterUser(ctx context.Context, user *entity.User) error {
 	if user.Password == "" {
 		return fmt.Errorf("empty password")
@@ -220,9 +273,12 @@ func (a *Authenticator) RegisterUser(ctx context.Context, user *entity.User) err
 	if user.Username == "" {
 		return fmt.Errorf("empty username")
 	}
-	if user.Token == "" {
+	if user.Token == "" && a.inviteRequired {
 		return fmt.Errorf("empty invite code")
 	}
+	if a.verifyEmail && user.Email == "" {
+		return fmt.Errorf("empty email")
+	}
 	a.mux.Lock()
 	defer a.mux.Unlock()
 	// check if username exists
@@ -231,45 +287,84 @@ func (a *Authenticator) RegisterUser(ctx context.Context, user *entity.User) err
 		return fmt.Errorf("user already exists")
 	}
 	// check provided invite code
-	ok, _ := a.database.CheckInviteCode(ctx, user.Token)
-	if !ok {
-		return fmt.Errorf("invalid invite code")
+	code := user.Token
+	user.Token = ""
+	var invite *entity.Invite
+	if code != "" {
+		invite, _ = a.database.GetInvite(ctx, code)
+		if invite == nil {
+			return fmt.Errorf("invalid invite code")
+		}
+		if err := invite.Usable(time.Now()); err != nil {
+			return err
+		}
 	}
 	// get password hash to store in user's data
 	user.Password = a.generatePasswordHash(user.Password)
 	if user.Password == "" {
 		return fmt.Errorf("empty password hash")
 	}
-	// assign default payment plan
-	if user.PaymentPlan == "" {
-		user.PaymentPlan = defaultPaymentPlan
-	}
-	// assign default user role
-	if user.Role == "" {
-		user.Role = defaultUserRole
+	// a registering user cannot choose the role, the access level, the payment
+	// plan nor the group and how it is billed; the invite sets the role, the
+	// group and the payment plan
+	user.Role = defaultUserRole
+	user.AccessLevel = 0
+	user.PaymentPlan = ""
+	user.Group = ""
+	user.BillingMode = ""
+	user.Fiscal = nil
+	if invite != nil {
+		if invite.Role != "" {
+			user.Role = invite.Role
+		}
+		if invite.Group != "" {
+			user.Group = invite.Group
+		}
+		if invite.PaymentPlan != "" {
+			user.PaymentPlan = invite.PaymentPlan
+		}
 	}
 	// assign default user group
 	if user.Group == "" {
 		user.Group = defaultUserGroupId
 	}
+	// assign default payment plan of the group, or check the invite's one
+	if user.PaymentPlan == "" {
+		user.PaymentPlan = a.defaultPlanFor(ctx, user.Group)
+	} else if err := a.checkPaymentPlan(ctx, user.PaymentPlan); err != nil {
+		return err
+	}
 	// generate unique user id
 	user.UserId = a.getUserId(ctx)
 	user.DateRegistered = time.Now()
+	// the account has limited access until the email address is confirmed
+	user.EmailUnverified = a.verifyEmail
+	// claim a use of the invite code before the account is stored; the claim
+	// is conditional, so concurrent registrations cannot exceed its uses
+	if invite != nil {
+		claimed, err := a.database.UseInviteCode(ctx, code, time.Now())
+		if err != nil {
+			return fmt.Errorf("using invite code: %w", err)
+		}
+		if !claimed {
+			return fmt.Errorf("invite code used up or expired")
+		}
+	}
 	// store new user in repository
 	err := a.database.AddUser(ctx, user)
 	if err != nil {
+		if invite != nil {
+			if e := a.database.ReleaseInviteCode(ctx, code); e != nil {
+				a.logger.Error("releasing invite code", sl.Err(e))
+			}
+		}
 		return err
 	}
-	// delete used invite code
-	err = a.database.DeleteInviteCode(ctx, user.Token)
-	if err != nil {
-		a.logger.Error("deleting invite code", sl.Err(err))
-	}
 	return nil
 }
 
 func (a *Authenticator) GetUsers(ctx context.Context, user *entity.User) ([]*entity.User, error) {
-	if user == nil || !user.IsAdmin() {
+	if user == nil || !user.Can(entity.PermissionUsersRead) {
 		return nil, fmt.Errorf("access denied")
 	}
 	a.mux.Lock()
@@ -281,43 +376,25 @@ func (a *Authenticator) GetUsers(ctx context.Context, user *entity.User) ([]*ent
 	return users, nil
 }
 
-// CommandAccess check user access for a specific command based on role and permissions
+// CommandAccess check user access for a specific central system command by its permission
 func (a *Authenticator) CommandAccess(user *entity.User, command string) error {
-	if user == nil {
-		return fmt.Errorf("user undefined")
-	}
-	if user.IsAdmin() {
-		return nil
-	}
-	for _, c := range userCommands {
-		if c == command {
-			return nil
-		}
-	}
-	if !user.IsPowerUser() {
-		return fmt.Errorf("access denied")
-	}
-	for _, c := range adminCommands {
-		if c == command {
-			return fmt.Errorf("access denied")
-		}
-	}
-	return nil
+	return a.HasAccess(user, entity.CommandPermission(command))
 }
 
-// HasAccess check access permission for a user to a specific subsystem based on user type
-func (a *Authenticator) HasAccess(user *entity.User, subSystem string) error {
+// HasAccess check that the user's role grants the permission
+func (a *Authenticator) HasAccess(user *entity.User, permission string) error {
 	if user == nil {
 		return fmt.Errorf("user undefined")
 	}
-	if user.IsPowerUser() {
-		return nil
+	if !user.Can(permission) {
+		return fmt.Errorf("access denied: %s permission required", permission)
 	}
-	return fmt.Errorf("access to %s denied", subSystem)
+	return nil
 }
 
-// CreateUser creates a new user (admin operation, no invite code required)
-func (a *Authenticator) CreateUser(ctx context.Context, user *entity.User) error {
+// CreateUser creates a new user (admin operation, no invite code required);
+// the role must not grant more than the author holds
+func (a *Authenticator) CreateUser(ctx context.Context, author *entity.User, user *entity.User) error {
 	if user.Password == "" {
 		return fmt.Errorf("password is required")
 	}
@@ -346,23 +423,25 @@ func (a *Authenticator) CreateUser(ctx context.Context, user *entity.User) error
 	}
 
 	// assign defaults
-	if user.PaymentPlan == "" {
-		user.PaymentPlan = defaultPaymentPlan
-	}
 	if user.Group == "" {
 		user.Group = defaultUserGroupId
 	}
+	if user.PaymentPlan == "" {
+		user.PaymentPlan = a.defaultPlanFor(ctx, user.Group)
+	} else if err := a.checkPaymentPlan(ctx, user.PaymentPlan); err != nil {
+		return err
+	}
 	if user.AccessLevel < 0 || user.AccessLevel > 10 {
 		user.AccessLevel = 0
 	}
+	if err := a.checkRole(ctx, author, user.Role); err != nil {
+		return err
+	}
 
 	// generate unique user id
 	user.UserId = a.getUserId(ctx)
 	user.DateRegistered = time.Now()
 
-	// generate token for the new user
-	user.Token = a.generateKey(tokenLength)
-
 	err := a.database.AddUser(ctx, user)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
@@ -376,8 +455,9 @@ func (a *Authenticator) CreateUser(ctx context.Context, user *entity.User) error
 	return nil
 }
 
-// UpdateUser updates an existing user's information
-func (a *Authenticator) UpdateUser(ctx context.Context, username string, updates *entity.UserUpdate) (*entity.User, error) {
+// UpdateUser updates an existing user's information; the author must hold
+// the permissions of both the user's current and new role
+func (a *Authenticator) UpdateUser(ctx context.Context, author *entity.User, username string, updates *entity.UserUpdate) (*entity.User, error) {
 	a.mux.Lock()
 	defer a.mux.Unlock()
 
@@ -386,6 +466,10 @@ func (a *Authenticator) UpdateUser(ctx context.Context, username string, updates
 	if err != nil || user == nil {
 		return nil, fmt.Errorf("user not found")
 	}
+	// a user of a higher role is out of reach, including the password
+	if err = a.checkRole(ctx, author, user.Role); err != nil {
+		return nil, err
+	}
 
 	// apply updates (only non-empty fields)
 	if updates.Name != "" {
@@ -400,14 +484,32 @@ func (a *Authenticator) UpdateUser(ctx context.Context, username string, updates
 			return nil, fmt.Errorf("failed to hash password")
 		}
 	}
-	// allow setting role to empty (regular user) or any other value
+	// allow setting role to empty (regular user) or any defined role
+	if err = a.checkRole(ctx, author, updates.Role); err != nil {
+		return nil, err
+	}
 	user.Role = updates.Role
 	if updates.AccessLevel >= 0 && updates.AccessLevel <= 10 {
 		user.AccessLevel = updates.AccessLevel
 	}
-	if updates.PaymentPlan != "" {
+	if updates.PaymentPlan != "" && updates.PaymentPlan != user.PaymentPlan {
+		if err = a.checkPaymentPlan(ctx, updates.PaymentPlan); err != nil {
+			return nil, err
+		}
 		user.PaymentPlan = updates.PaymentPlan
 	}
+	if updates.Group != "" {
+		user.Group = updates.Group
+	}
+	// the billing mode is kept unless given; the user returns to the
+	// group's mode with an explicit value
+	switch updates.BillingMode {
+	case "":
+	case entity.BillingModeGroup:
+		user.BillingMode = ""
+	default:
+		user.BillingMode = updates.BillingMode
+	}
 	// warning-email settings are always written so they can be toggled off
 	// and the address cleared
 	user.WarningEmailsEnabled = updates.WarningEmailsEnabled
@@ -425,8 +527,9 @@ func (a *Authenticator) UpdateUser(ctx context.Context, username string, updates
 	return user, nil
 }
 
-// DeleteUser deletes a user from the system
-func (a *Authenticator) DeleteUser(ctx context.Context, username string) error {
+// DeleteUser deletes a user from the system; the author must hold the
+// permissions of the user's role
+func (a *Authenticator) DeleteUser(ctx context.Context, author *entity.User, username string) error {
 	a.mux.Lock()
 	defer a.mux.Unlock()
 
@@ -435,11 +538,17 @@ func (a *Authenticator) DeleteUser(ctx context.Context, username string) error {
 	if err != nil || user == nil {
 		return fmt.Errorf("user not found")
 	}
+	if err = a.checkRole(ctx, author, user.Role); err != nil {
+		return err
+	}
 
 	err = a.database.DeleteUser(ctx, username)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
+	if _, err = a.database.DeleteUserSessions(ctx, user.UserId); err != nil {
+		a.logger.Error("deleting user sessions", sl.Err(err))
+	}
 
 	a.logger.With(
 		slog.String("username", username),
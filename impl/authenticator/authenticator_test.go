@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -23,6 +24,21 @@ func (m *mockFirebase) CheckToken(token string) (string, error) {
 	return m.returnUserId, m.returnErr
 }
 
+// mockVerifier implements TokenVerifier interface for testing
+type mockVerifier struct {
+	accepts        bool
+	returnIdentity *entity.Identity
+	returnErr      error
+}
+
+func (m *mockVerifier) Accepts(token string) bool {
+	return m.accepts
+}
+
+func (m *mockVerifier) VerifyToken(token string) (*entity.Identity, error) {
+	return m.returnIdentity, m.returnErr
+}
+
 func newTestLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
 }
@@ -70,18 +86,41 @@ func TestAuthenticateByToken(t *testing.T) {
 			wantErrMsg: "invalid token",
 		},
 		{
-			name:  "valid 32-char token in database",
+			name:  "valid 32-char session token",
 			token: "12345678901234567890123456789012",
 			setup: func(db *database_mock.MockDB, a *Authenticator) {
 				db.SeedUser(&entity.User{
 					Username: "testuser",
 					UserId:   "user123",
-					Token:    "12345678901234567890123456789012",
+				})
+				_ = db.AddSession(context.Background(), &entity.Session{
+					Id:        "s1",
+					UserId:    "user123",
+					TokenHash: hashToken("12345678901234567890123456789012"),
+					ExpiresAt: time.Now().Add(time.Hour),
 				})
 			},
 			wantErr:      false,
 			wantUsername: "testuser",
 		},
+		{
+			name:  "expired session token",
+			token: "12345678901234567890123456789012",
+			setup: func(db *database_mock.MockDB, a *Authenticator) {
+				db.SeedUser(&entity.User{
+					Username: "testuser",
+					UserId:   "user123",
+				})
+				_ = db.AddSession(context.Background(), &entity.Session{
+					Id:        "s1",
+					UserId:    "user123",
+					TokenHash: hashToken("12345678901234567890123456789012"),
+					ExpiresAt: time.Now().Add(-time.Minute),
+				})
+			},
+			wantErr:    true,
+			wantErrMsg: "token expired",
+		},
 		{
 			name:       "valid 32-char token not in database",
 			token:      "abcdefgh901234567890123456789012",
@@ -117,6 +156,49 @@ func TestAuthenticateByToken(t *testing.T) {
 			wantErr:    true,
 			wantErrMsg: "firebase token check",
 		},
+		{
+			name:  "identity token - valid",
+			token: "identity_token_longer_than_32_characters_here",
+			setup: func(db *database_mock.MockDB, a *Authenticator) {
+				db.SeedUser(&entity.User{
+					Username: "partner_user",
+					UserId:   "partner:42",
+				})
+				a.AddTokenVerifier(&mockVerifier{
+					accepts:        true,
+					returnIdentity: &entity.Identity{UserId: "partner:42"},
+				})
+				a.SetFirebase(&mockFirebase{returnErr: assert.AnError})
+			},
+			wantErr:      false,
+			wantUsername: "partner_user",
+		},
+		{
+			name:  "identity token - verifier error",
+			token: "identity_token_longer_than_32_characters_here",
+			setup: func(db *database_mock.MockDB, a *Authenticator) {
+				a.AddTokenVerifier(&mockVerifier{
+					accepts:   true,
+					returnErr: assert.AnError,
+				})
+			},
+			wantErr:    true,
+			wantErrMsg: "identity token check",
+		},
+		{
+			name:  "identity token of another issuer - firebase",
+			token: "firebase_token_longer_than_32_characters_here",
+			setup: func(db *database_mock.MockDB, a *Authenticator) {
+				db.SeedUser(&entity.User{
+					Username: "firebase_user",
+					UserId:   "firebase_user_id",
+				})
+				a.AddTokenVerifier(&mockVerifier{accepts: false, returnErr: assert.AnError})
+				a.SetFirebase(&mockFirebase{returnUserId: "firebase_user_id"})
+			},
+			wantErr:      false,
+			wantUsername: "firebase_user",
+		},
 		{
 			name:       "long token but no firebase configured",
 			token:      "long_token_without_firebase_configured_here",
@@ -154,6 +236,44 @@ func TestAuthenticateByToken(t *testing.T) {
 	}
 }
 
+func TestIdentityTokenTwoFactorRole(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	auth.SetTwoFactor("", []string{entity.RoleAdmin})
+	db.SeedUser(&entity.User{Username: "root", UserId: "partner:1", Role: entity.RoleAdmin, TwoFactorEnabled: true})
+	auth.AddTokenVerifier(&mockVerifier{accepts: true, returnIdentity: &entity.Identity{UserId: "partner:1"}})
+
+	user, err := auth.AuthenticateByToken(ctx, "identity_token_longer_than_32_characters_here")
+	require.NoError(t, err)
+	assert.Equal(t, "root", user.Username)
+	assert.True(t, user.TwoFactorRequired, "no second factor on an identity token")
+	assert.Empty(t, user.Permissions)
+}
+
+func TestUserByIdentity(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Email: "taken@example.com"})
+
+	user, err := auth.userByIdentity(ctx, &entity.Identity{UserId: "partner:42", Name: "Jane Driver", Email: "jane@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Driver", user.Name)
+	assert.Equal(t, "jane@example.com", user.Email)
+	assert.Equal(t, defaultUserGroupId, user.Group)
+
+	again, err := auth.userByIdentity(ctx, &entity.Identity{UserId: "partner:42", Name: "Other"})
+	require.NoError(t, err)
+	assert.Equal(t, user.Username, again.Username, "provisioned once")
+	assert.Equal(t, "Jane Driver", again.Name)
+
+	other, err := auth.userByIdentity(ctx, &entity.Identity{UserId: "partner:43", Email: "taken@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "App user", other.Name)
+	assert.Empty(t, other.Email, "address of another account")
+}
+
 func TestAuthenticateUser(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -181,6 +301,10 @@ func TestAuthenticateUser(t *testing.T) {
 				assert.Empty(t, user.Password, "password should be cleared")
 				assert.NotEmpty(t, user.Token, "token should be generated")
 				assert.Len(t, user.Token, tokenLength)
+				require.NotNil(t, user.Session)
+				assert.Equal(t, user.Token, user.Session.Token)
+				assert.NotEmpty(t, user.Session.RefreshToken)
+				assert.True(t, user.Session.ExpiresAt.After(time.Now()))
 			},
 		},
 		{
@@ -217,7 +341,7 @@ func TestAuthenticateUser(t *testing.T) {
 				tt.setup(db)
 			}
 
-			user, err := auth.AuthenticateUser(context.Background(), tt.username, tt.password)
+			user, err := auth.AuthenticateUser(context.Background(), tt.username, tt.password, "test-agent")
 
 			if tt.wantErr {
 				assert.Error(t, err)
@@ -473,34 +597,34 @@ func TestCommandAccess(t *testing.T) {
 
 func TestHasAccess(t *testing.T) {
 	tests := []struct {
-		name      string
-		user      *entity.User
-		subSystem string
-		wantErr   bool
+		name       string
+		user       *entity.User
+		permission string
+		wantErr    bool
 	}{
 		{
-			name:      "nil user",
-			user:      nil,
-			subSystem: "reports",
-			wantErr:   true,
+			name:       "nil user",
+			user:       nil,
+			permission: "reports.read",
+			wantErr:    true,
 		},
 		{
-			name:      "admin has access",
-			user:      &entity.User{Role: "admin"},
-			subSystem: "reports",
-			wantErr:   false,
+			name:       "admin has access",
+			user:       &entity.User{Role: "admin"},
+			permission: "reports.read",
+			wantErr:    false,
 		},
 		{
-			name:      "operator has access",
-			user:      &entity.User{Role: "operator"},
-			subSystem: "reports",
-			wantErr:   false,
+			name:       "operator has access",
+			user:       &entity.User{Role: "operator"},
+			permission: "reports.read",
+			wantErr:    false,
 		},
 		{
-			name:      "regular user denied",
-			user:      &entity.User{Role: "user"},
-			subSystem: "reports",
-			wantErr:   true,
+			name:       "regular user denied",
+			user:       &entity.User{Role: "user"},
+			permission: "reports.read",
+			wantErr:    true,
 		},
 	}
 
@@ -510,7 +634,7 @@ func TestHasAccess(t *testing.T) {
 			logger := newTestLogger()
 			auth := New(logger, db)
 
-			err := auth.HasAccess(tt.user, tt.subSystem)
+			err := auth.HasAccess(tt.user, tt.permission)
 
 			if tt.wantErr {
 				assert.Error(t, err)
@@ -527,12 +651,15 @@ func TestGenerateInvites(t *testing.T) {
 		logger := newTestLogger()
 		auth := New(logger, db)
 
-		invites, err := auth.GenerateInvites(context.Background(), 3)
+		admin := &entity.User{Username: "admin", Role: "admin"}
+		invites, err := auth.GenerateInvites(context.Background(), admin, &entity.InviteRequest{Count: 3})
 
 		assert.NoError(t, err)
 		assert.Len(t, invites, 3)
-		for _, code := range invites {
-			assert.NotEmpty(t, code)
+		for _, invite := range invites {
+			assert.Len(t, invite.Code, inviteCodeLength)
+			assert.Equal(t, 1, invite.MaxUses)
+			assert.Equal(t, "admin", invite.CreatedBy)
 		}
 	})
 }
@@ -655,3 +782,70 @@ func TestGetUserById(t *testing.T) {
 		})
 	}
 }
+
+func TestPaymentPlanAssignment(t *testing.T) {
+	ctx := context.Background()
+	admin := &entity.User{Username: "admin", Role: entity.RoleAdmin}
+
+	t.Run("group default plan assigned on create", func(t *testing.T) {
+		db := database_mock.NewMockDB()
+		require.NoError(t, db.SavePaymentPlan(ctx, &entity.PaymentPlan{PlanId: "fleet", IsActive: true, UserGroups: []string{"acme"}}))
+		auth := New(newTestLogger(), db)
+
+		user := &entity.User{Username: "driver", Password: "password", Group: "acme"}
+		require.NoError(t, auth.CreateUser(ctx, admin, user))
+		assert.Equal(t, "fleet", user.PaymentPlan)
+
+		other := &entity.User{Username: "someone", Password: "password"}
+		require.NoError(t, auth.CreateUser(ctx, admin, other))
+		assert.Equal(t, defaultPaymentPlan, other.PaymentPlan)
+	})
+
+	t.Run("unknown or inactive plan rejected on create", func(t *testing.T) {
+		db := database_mock.NewMockDB()
+		require.NoError(t, db.SavePaymentPlan(ctx, &entity.PaymentPlan{PlanId: "retired", IsActive: false}))
+		auth := New(newTestLogger(), db)
+
+		err := auth.CreateUser(ctx, admin, &entity.User{Username: "driver", Password: "password", PaymentPlan: "missing"})
+		assert.ErrorIs(t, err, entity.ErrNotFound)
+
+		err = auth.CreateUser(ctx, admin, &entity.User{Username: "driver", Password: "password", PaymentPlan: "retired"})
+		assert.ErrorContains(t, err, "not active")
+	})
+
+	t.Run("plan reference checked on update", func(t *testing.T) {
+		db := database_mock.NewMockDB()
+		require.NoError(t, db.SavePaymentPlan(ctx, &entity.PaymentPlan{PlanId: "premium", IsActive: true}))
+		db.SeedUser(&entity.User{Username: "driver", PaymentPlan: defaultPaymentPlan})
+		auth := New(newTestLogger(), db)
+
+		_, err := auth.UpdateUser(ctx, admin, "driver", &entity.UserUpdate{PaymentPlan: "missing"})
+		assert.Error(t, err)
+
+		user, err := auth.UpdateUser(ctx, admin, "driver", &entity.UserUpdate{PaymentPlan: "premium"})
+		require.NoError(t, err)
+		assert.Equal(t, "premium", user.PaymentPlan)
+	})
+}
+
+func TestUpdateUserBillingMode(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	admin := &entity.User{Username: "admin", Role: entity.RoleAdmin}
+	db.SeedUser(&entity.User{Username: "driver", Group: "acme", BillingMode: entity.BillingModeSession})
+
+	user, err := auth.UpdateUser(ctx, admin, "driver", &entity.UserUpdate{Name: "Driver", AccessLevel: -1})
+	require.NoError(t, err)
+	assert.Equal(t, entity.BillingModeSession, user.BillingMode, "an update without the mode keeps it")
+
+	user, err = auth.UpdateUser(ctx, admin, "driver", &entity.UserUpdate{BillingMode: entity.BillingModeMonthly, AccessLevel: -1})
+	require.NoError(t, err)
+	assert.Equal(t, entity.BillingModeMonthly, user.BillingMode)
+
+	user, err = auth.UpdateUser(ctx, admin, "driver", &entity.UserUpdate{BillingMode: entity.BillingModeGroup, AccessLevel: -1})
+	require.NoError(t, err)
+	assert.Empty(t, user.BillingMode, "the group's mode applies again")
+	stored, _ := db.GetUser(ctx, "driver")
+	assert.Empty(t, stored.BillingMode)
+}
@@ -55,3 +55,31 @@ func (a *Authenticator) updateLastSeen(ctx context.Context, user *entity.User) e
 	}
 	return nil
 }
+
+// defaultPlanFor returns the payment plan a new user of the group gets: the
+// group's default plan when one is configured, the global default otherwise.
+func (a *Authenticator) defaultPlanFor(ctx context.Context, group string) string {
+	plan, err := a.database.GetGroupDefaultPaymentPlan(ctx, group)
+	if err != nil {
+		a.logger.Error("getting group default payment plan", sl.Err(err))
+	}
+	if plan == nil {
+		return defaultPaymentPlan
+	}
+	return plan.PlanId
+}
+
+// checkPaymentPlan verifies that a plan reference points to an active plan.
+func (a *Authenticator) checkPaymentPlan(ctx context.Context, planId string) error {
+	plan, err := a.database.GetPaymentPlan(ctx, planId)
+	if err != nil {
+		return fmt.Errorf("getting payment plan: %w", err)
+	}
+	if plan == nil {
+		return fmt.Errorf("payment plan %s %w", planId, entity.ErrNotFound)
+	}
+	if !plan.IsActive {
+		return fmt.Errorf("payment plan %s is not active", planId)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const inviteCodeLength = 8
+
+// SetInviteRequired sets whether registration needs an invite code; without
+// one, an invite is optional and still applied when given.
+func (a *Authenticator) SetInviteRequired(required bool) {
+	a.inviteRequired = required
+}
+
+// GenerateInvites creates the requested number of invite codes with the
+// same limits and targets.
+func (a *Authenticator) GenerateInvites(ctx context.Context, author *entity.User, req *entity.InviteRequest) ([]*entity.Invite, error) {
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("expiry is in the past")
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if err := a.checkRole(ctx, author, req.Role); err != nil {
+		return nil, err
+	}
+	if req.PaymentPlan != "" {
+		if err := a.checkPaymentPlan(ctx, req.PaymentPlan); err != nil {
+			return nil, err
+		}
+	}
+	invites := make([]*entity.Invite, 0)
+	for i := 0; i < max(req.Count, 1); i++ {
+		invite := &entity.Invite{
+			Code:        a.generateKey(inviteCodeLength),
+			Group:       req.Group,
+			PaymentPlan: req.PaymentPlan,
+			Role:        req.Role,
+			MaxUses:     max(req.MaxUses, 1),
+			ExpiresAt:   req.ExpiresAt,
+			CreatedAt:   now,
+			CreatedBy:   author.Username,
+		}
+		err := a.database.AddInviteCode(ctx, invite)
+		if err != nil {
+			a.logger.Error("add invite code", sl.Err(err))
+			continue
+		}
+		invites = append(invites, invite)
+	}
+	if len(invites) == 0 {
+		return nil, fmt.Errorf("no invites generated")
+	}
+	a.logger.With(
+		slog.Int("count", len(invites)),
+		slog.String("group", req.Group),
+		slog.String("role", req.Role),
+		slog.String("author", author.Username),
+	).Info("invites generated")
+	return invites, nil
+}
+
+// ListInvites returns all invites, the expired and used up ones included.
+func (a *Authenticator) ListInvites(ctx context.Context) ([]*entity.Invite, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	invites, err := a.database.GetInvites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if invites == nil {
+		invites = make([]*entity.Invite, 0)
+	}
+	return invites, nil
+}
+
+// RevokeInvite deletes an invite code; accounts created with it are kept.
+func (a *Authenticator) RevokeInvite(ctx context.Context, code string) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	invite, _ := a.database.GetInvite(ctx, code)
+	if invite == nil {
+		return fmt.Errorf("invite %w", entity.ErrNotFound)
+	}
+	if err := a.database.DeleteInviteCode(ctx, code); err != nil {
+		return err
+	}
+	a.logger.With(
+		slog.String("code", code),
+		slog.Int("uses", invite.Uses),
+	).Info("invite revoked")
+	return nil
+}
@@ -0,0 +1,152 @@
+package authenticator
+
+import (
+	"context"
+	"encoding/json"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterWithInvite(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name      string
+		invite    *entity.Invite
+		required  bool
+		code      string
+		wantErr   string
+		wantRole  string
+		wantGroup string
+		wantPlan  string
+	}{
+		{"targets applied", &entity.Invite{Code: "TEAM0001", Role: "operator", Group: "fleet", PaymentPlan: "fleet-plan", MaxUses: 2},
+			true, "TEAM0001", "", "operator", "fleet", "fleet-plan"},
+		{"group default plan", &entity.Invite{Code: "TEAM0002", Group: "fleet"},
+			true, "TEAM0002", "", "user", "fleet", "fleet-default"},
+		{"expired", &entity.Invite{Code: "TEAM0003", ExpiresAt: &past},
+			true, "TEAM0003", "invite code expired", "", "", ""},
+		{"used up", &entity.Invite{Code: "TEAM0004", MaxUses: 1, Uses: 1},
+			true, "TEAM0004", "invite code used up", "", "", ""},
+		{"required", nil, true, "", "empty invite code", "", "", ""},
+		{"optional", nil, false, "", "", "user", "default", "default"},
+		{"optional but invalid", nil, false, "WRONG001", "invalid invite code", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := database_mock.NewMockDB()
+			auth := New(newTestLogger(), db)
+			auth.SetInviteRequired(tt.required)
+			require.NoError(t, db.SavePaymentPlan(ctx, &entity.PaymentPlan{PlanId: "fleet-plan", IsActive: true}))
+			require.NoError(t, db.SavePaymentPlan(ctx, &entity.PaymentPlan{PlanId: "fleet-default", IsActive: true, UserGroups: []string{"fleet"}}))
+			if tt.invite != nil {
+				require.NoError(t, db.AddInviteCode(ctx, tt.invite))
+			}
+
+			// the requested role, access level and payment plan are ignored
+			user := &entity.User{Username: "newuser", Password: "secret", Token: tt.code, Role: "admin", AccessLevel: 10, PaymentPlan: "fleet-plan"}
+			err := auth.RegisterUser(ctx, user)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			stored, _ := db.GetUser(ctx, "newuser")
+			require.NotNil(t, stored)
+			assert.Equal(t, tt.wantRole, stored.Role)
+			assert.Equal(t, tt.wantGroup, stored.Group)
+			assert.Equal(t, tt.wantPlan, stored.PaymentPlan)
+			assert.Zero(t, stored.AccessLevel)
+			assert.Empty(t, stored.Token)
+			if tt.invite != nil {
+				invite, _ := db.GetInvite(ctx, tt.code)
+				assert.Equal(t, 1, invite.Uses)
+			}
+		})
+	}
+}
+
+func TestRegisterIgnoresGroupAndBilling(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	auth.SetInviteRequired(false)
+	require.NoError(t, db.AddInviteCode(ctx, &entity.Invite{Code: "TEAM0001"}))
+
+	for _, code := range []string{"", "TEAM0001"} {
+		username := "driver" + code
+		var user entity.User
+		body := `{"username":"` + username + `","password":"secret","token":"` + code + `",` +
+			`"group":"acme","billing_mode":"monthly","fiscal":{"tax_id":"B00000000"}}`
+		require.NoError(t, json.Unmarshal([]byte(body), &user))
+		require.NoError(t, auth.RegisterUser(ctx, &user))
+
+		stored, _ := db.GetUser(ctx, username)
+		require.NotNil(t, stored)
+		assert.Equal(t, defaultUserGroupId, stored.Group)
+		assert.Empty(t, stored.BillingMode)
+		assert.Nil(t, stored.Fiscal)
+	}
+}
+
+func TestInviteUses(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+
+	_, err := auth.GenerateInvites(ctx, admin, &entity.InviteRequest{Role: "missing"})
+	assert.Error(t, err, "unknown role")
+	_, err = auth.GenerateInvites(ctx, admin, &entity.InviteRequest{PaymentPlan: "missing"})
+	assert.Error(t, err, "unknown plan")
+
+	invites, err := auth.GenerateInvites(ctx, admin, &entity.InviteRequest{MaxUses: 2})
+	require.NoError(t, err)
+	require.Len(t, invites, 1)
+	code := invites[0].Code
+	for _, name := range []string{"first", "second"} {
+		require.NoError(t, auth.RegisterUser(ctx, &entity.User{Username: name, Password: "secret", Token: code}))
+	}
+	assert.EqualError(t, auth.RegisterUser(ctx, &entity.User{Username: "third", Password: "secret", Token: code}), "invite code used up")
+
+	listed, err := auth.ListInvites(ctx)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, 2, listed[0].Uses)
+
+	require.NoError(t, auth.RevokeInvite(ctx, code))
+	assert.ErrorIs(t, auth.RevokeInvite(ctx, code), entity.ErrNotFound)
+	listed, _ = auth.ListInvites(ctx)
+	assert.Empty(t, listed)
+}
+
+// staleInvites serves invites as read before another instance used them up.
+type staleInvites struct {
+	*database_mock.MockDB
+	stale map[string]*entity.Invite
+}
+
+func (s *staleInvites) GetInvite(_ context.Context, code string) (*entity.Invite, error) {
+	return s.stale[code], nil
+}
+
+func TestInviteClaimedBeforeRegistration(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	require.NoError(t, db.AddInviteCode(ctx, &entity.Invite{Code: "TEAM0001", MaxUses: 1, Uses: 1}))
+	repo := &staleInvites{MockDB: db, stale: map[string]*entity.Invite{"TEAM0001": {Code: "TEAM0001", MaxUses: 1}}}
+	auth := New(newTestLogger(), repo)
+
+	err := auth.RegisterUser(ctx, &entity.User{Username: "late", Password: "secret", Token: "TEAM0001"})
+	assert.EqualError(t, err, "invite code used up or expired")
+	user, _ := db.GetUser(ctx, "late")
+	assert.Nil(t, user, "no account without a claimed invite")
+	invite, _ := db.GetInvite(ctx, "TEAM0001")
+	assert.Equal(t, 1, invite.Uses)
+}
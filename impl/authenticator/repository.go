@@ -3,19 +3,24 @@ package authenticator
 import (
 	"context"
 	"evsys-back/entity"
+	"time"
 )
 
 type Repository interface {
 	GetUser(ctx context.Context, username string) (*entity.User, error)
 	GetUserById(ctx context.Context, userId string) (*entity.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*entity.User, error)
 	UpdateLastSeen(ctx context.Context, user *entity.User) error
 	AddUser(ctx context.Context, user *entity.User) error
 	UpdateUser(ctx context.Context, user *entity.User) error
+	UpdateUserTwoFactor(ctx context.Context, user *entity.User) error
 	DeleteUser(ctx context.Context, username string) error
 	CheckUsername(ctx context.Context, username string) error
-	CheckToken(ctx context.Context, token string) (*entity.User, error)
 	AddInviteCode(ctx context.Context, invite *entity.Invite) error
-	CheckInviteCode(ctx context.Context, code string) (bool, error)
+	GetInvite(ctx context.Context, code string) (*entity.Invite, error)
+	GetInvites(ctx context.Context) ([]*entity.Invite, error)
+	UseInviteCode(ctx context.Context, code string, now time.Time) (bool, error)
+	ReleaseInviteCode(ctx context.Context, code string) error
 	DeleteInviteCode(ctx context.Context, code string) error
 	GetUserTags(ctx context.Context, userId string) ([]entity.UserTag, error)
 	GetAllUserTags(ctx context.Context) ([]*entity.UserTag, error)
@@ -26,4 +31,28 @@ type Repository interface {
 	CheckUserTag(ctx context.Context, idTag string) error
 	UpdateTagLastSeen(ctx context.Context, userTag *entity.UserTag) error
 	GetUsers(ctx context.Context) ([]*entity.User, error)
+	GetPaymentPlan(ctx context.Context, planId string) (*entity.PaymentPlan, error)
+	GetGroupDefaultPaymentPlan(ctx context.Context, group string) (*entity.PaymentPlan, error)
+	AddSession(ctx context.Context, session *entity.Session) error
+	GetSessionByToken(ctx context.Context, tokenHash string) (*entity.Session, error)
+	GetSessionByRefresh(ctx context.Context, refreshHash string) (*entity.Session, error)
+	GetUserSessions(ctx context.Context, userId string) ([]*entity.Session, error)
+	UpdateSession(ctx context.Context, session *entity.Session) error
+	DeleteSession(ctx context.Context, userId, id string) error
+	DeleteUserSessions(ctx context.Context, userId string) (int, error)
+	DeleteExpiredSessions(ctx context.Context, before time.Time) error
+	GetRole(ctx context.Context, name string) (*entity.Role, error)
+	GetRoles(ctx context.Context) ([]*entity.Role, error)
+	SaveRole(ctx context.Context, role *entity.Role) error
+	DeleteRole(ctx context.Context, name string) error
+	AddApiKey(ctx context.Context, key *entity.ApiKey) error
+	GetApiKey(ctx context.Context, id string) (*entity.ApiKey, error)
+	GetApiKeyByHash(ctx context.Context, keyHash string) (*entity.ApiKey, error)
+	GetApiKeys(ctx context.Context) ([]*entity.ApiKey, error)
+	UpdateApiKey(ctx context.Context, key *entity.ApiKey) error
+	SetApiKeyUsed(ctx context.Context, id string, at time.Time) error
+	AddAccountToken(ctx context.Context, token *entity.AccountToken) error
+	GetAccountToken(ctx context.Context, hash string) (*entity.AccountToken, error)
+	DeleteAccountToken(ctx context.Context, hash string) error
+	DeleteAccountTokens(ctx context.Context, userId, purpose string) error
 }
@@ -0,0 +1,162 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// getRole returns the stored role of the name, or the built-in one; nil if
+// neither exists.
+func (a *Authenticator) getRole(ctx context.Context, name string) (*entity.Role, error) {
+	name = entity.RoleName(name)
+	role, err := a.database.GetRole(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if role != nil {
+		role.BuiltIn = entity.BuiltInRole(name) != nil
+		return role, nil
+	}
+	return entity.BuiltInRole(name), nil
+}
+
+// resolvePermissions sets the permissions of the user's role; a role that
+// no longer exists grants none, nor does any role before the user confirms
+// the email address or enrolls the second factor the role requires.
+func (a *Authenticator) resolvePermissions(ctx context.Context, user *entity.User) {
+	user.TwoFactorRequired = !user.TwoFactorEnabled && a.twoFactorRequired(user)
+	if user.EmailUnverified || user.TwoFactorRequired {
+		user.Permissions = make([]string, 0)
+		return
+	}
+	role, err := a.getRole(ctx, user.Role)
+	if err != nil {
+		a.logger.With(
+			slog.String("role", user.Role),
+			sl.Err(err),
+		).Error("getting role")
+	}
+	user.Permissions = make([]string, 0)
+	if role != nil {
+		user.Permissions = append(user.Permissions, role.Permissions...)
+	}
+}
+
+// resolveExternalPermissions sets the permissions of a user signed in by an
+// external identity token; such a login has no second factor step, so a role
+// that requires one grants nothing.
+func (a *Authenticator) resolveExternalPermissions(ctx context.Context, user *entity.User) {
+	a.resolvePermissions(ctx, user)
+	if a.twoFactorRequired(user) {
+		user.TwoFactorRequired = true
+		user.Permissions = make([]string, 0)
+	}
+}
+
+// checkRole verifies that a role assigned by the author exists and grants
+// nothing the author does not hold, so that no one can assign a role above
+// their own.
+func (a *Authenticator) checkRole(ctx context.Context, author *entity.User, name string) error {
+	role, err := a.getRole(ctx, name)
+	if err != nil {
+		return fmt.Errorf("getting role: %w", err)
+	}
+	if role == nil {
+		return fmt.Errorf("role %s %w", name, entity.ErrNotFound)
+	}
+	return checkGrants(author, role)
+}
+
+// checkGrants verifies that the author holds every permission of a role.
+func checkGrants(author *entity.User, role *entity.Role) error {
+	for _, permission := range role.Permissions {
+		if author == nil || !author.Can(permission) {
+			return fmt.Errorf("access denied: role %s grants %s", role.Name, permission)
+		}
+	}
+	return nil
+}
+
+// ListRoles returns the built-in and the stored roles, by name.
+func (a *Authenticator) ListRoles(ctx context.Context) ([]*entity.Role, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	stored, err := a.database.GetRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string]*entity.Role)
+	for _, role := range entity.BuiltInRoles() {
+		roles[role.Name] = role
+	}
+	for _, role := range stored {
+		role.BuiltIn = roles[role.Name] != nil
+		roles[role.Name] = role
+	}
+	list := make([]*entity.Role, 0, len(roles))
+	for _, role := range roles {
+		list = append(list, role)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// SaveRole creates a role or replaces its permissions. The admin role keeps
+// full access so that roles can always be managed. The author must hold the
+// permissions of both the role as stored and as saved.
+func (a *Authenticator) SaveRole(ctx context.Context, author *entity.User, role *entity.Role) (*entity.Role, error) {
+	if role.Name == entity.RoleAdmin {
+		return nil, fmt.Errorf("the %s role cannot be changed", entity.RoleAdmin)
+	}
+	for _, permission := range role.Permissions {
+		if !entity.KnownPermission(permission) {
+			return nil, fmt.Errorf("unknown permission: %s", permission)
+		}
+	}
+	if err := checkGrants(author, role); err != nil {
+		return nil, err
+	}
+	if role.Permissions == nil {
+		role.Permissions = make([]string, 0)
+	}
+	role.UpdatedAt = time.Now()
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	current, err := a.getRole(ctx, role.Name)
+	if err != nil {
+		return nil, fmt.Errorf("getting role: %w", err)
+	}
+	if current != nil {
+		if err = checkGrants(author, current); err != nil {
+			return nil, err
+		}
+	}
+	if err = a.database.SaveRole(ctx, role); err != nil {
+		return nil, fmt.Errorf("saving role: %w", err)
+	}
+	role.BuiltIn = entity.BuiltInRole(role.Name) != nil
+	a.logger.With(
+		slog.String("role", role.Name),
+		slog.Int("permissions", len(role.Permissions)),
+	).Info("role saved")
+	return role, nil
+}
+
+// DeleteRole removes a stored role; a built-in role returns to its default
+// permissions, users of another role lose theirs.
+func (a *Authenticator) DeleteRole(ctx context.Context, name string) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if err := a.database.DeleteRole(ctx, name); err != nil {
+		return err
+	}
+	a.logger.With(
+		slog.String("role", name),
+	).Info("role deleted")
+	return nil
+}
@@ -0,0 +1,191 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePermissions(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		stored *entity.Role
+		allow  []string
+		deny   []string
+	}{
+		{"built-in admin", "admin", nil,
+			[]string{entity.PermissionRolesWrite, entity.CommandPermission("Reset")}, nil},
+		{"built-in operator", "operator", nil,
+			[]string{entity.PermissionPaymentsRefund, entity.CommandPermission("Reset")},
+			[]string{entity.PermissionRolesWrite, entity.CommandPermission("ChangeConfiguration")}},
+		{"regular user", "", nil,
+			[]string{entity.CommandPermission("RemoteStartTransaction")},
+			[]string{entity.PermissionReportsRead, entity.CommandPermission("Reset")}},
+		{"custom role", "support", &entity.Role{Name: "support", Permissions: []string{entity.PermissionReportsRead, entity.PermissionCommandsAll}},
+			[]string{entity.PermissionReportsRead, entity.CommandPermission("ChangeConfiguration")},
+			[]string{entity.PermissionPaymentsRefund}},
+		{"stored role overrides built-in", "operator", &entity.Role{Name: "operator", Permissions: []string{entity.PermissionReportsRead}},
+			[]string{entity.PermissionReportsRead},
+			[]string{entity.PermissionPaymentsRefund}},
+		{"unknown role", "deleted", nil, nil,
+			[]string{entity.PermissionReportsRead, entity.CommandPermission("RemoteStartTransaction")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := database_mock.NewMockDB()
+			auth := New(newTestLogger(), db)
+			if tt.stored != nil {
+				require.NoError(t, db.SaveRole(ctx, tt.stored))
+			}
+			db.SeedUser(&entity.User{Username: "testuser", UserId: "user123", Role: tt.role, Password: createHashedPassword("secret")})
+
+			user, err := auth.AuthenticateUser(ctx, "testuser", "secret", "")
+			require.NoError(t, err)
+			require.NotNil(t, user.Permissions)
+			for _, p := range tt.allow {
+				assert.NoError(t, auth.HasAccess(user, p), p)
+			}
+			for _, p := range tt.deny {
+				assert.Error(t, auth.HasAccess(user, p), p)
+			}
+
+			// token authentication resolves the same permissions
+			byToken, err := auth.AuthenticateByToken(ctx, user.Token)
+			require.NoError(t, err)
+			assert.Equal(t, user.Permissions, byToken.Permissions)
+		})
+	}
+}
+
+func TestSaveRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       *entity.Role
+		wantErrMsg string
+	}{
+		{"custom role", &entity.Role{Name: "support", Permissions: []string{entity.PermissionReportsRead, entity.CommandPermission("Reset")}}, ""},
+		{"override built-in", &entity.Role{Name: "operator", Permissions: []string{entity.PermissionReportsRead}}, ""},
+		{"no permissions", &entity.Role{Name: "guest"}, ""},
+		{"admin is fixed", &entity.Role{Name: "admin", Permissions: []string{entity.PermissionReportsRead}}, "cannot be changed"},
+		{"unknown permission", &entity.Role{Name: "support", Permissions: []string{"reports.write"}}, "unknown permission"},
+		{"unknown command", &entity.Role{Name: "support", Permissions: []string{"csc.Explode"}}, "unknown permission"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := database_mock.NewMockDB()
+			auth := New(newTestLogger(), db)
+
+			saved, err := auth.SaveRole(ctx, &entity.User{Role: entity.RoleAdmin}, tt.role)
+			if tt.wantErrMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, saved.Permissions)
+			assert.Equal(t, entity.BuiltInRole(tt.role.Name) != nil, saved.BuiltIn)
+
+			roles, err := auth.ListRoles(ctx)
+			require.NoError(t, err)
+			names := make([]string, 0, len(roles))
+			for _, r := range roles {
+				names = append(names, r.Name)
+				if r.Name == tt.role.Name {
+					assert.Equal(t, saved.Permissions, r.Permissions)
+				}
+			}
+			assert.Contains(t, names, tt.role.Name)
+			assert.Subset(t, names, []string{"admin", "operator", "user"})
+		})
+	}
+}
+
+func TestDeleteRole(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	admin := &entity.User{Username: "admin", Role: entity.RoleAdmin}
+	_, err := auth.SaveRole(ctx, admin, &entity.Role{Name: "operator", Permissions: []string{}})
+	require.NoError(t, err)
+	operator := &entity.User{Role: "operator"}
+	auth.resolvePermissions(ctx, operator)
+	assert.Error(t, auth.HasAccess(operator, entity.PermissionReportsRead))
+
+	require.NoError(t, auth.DeleteRole(ctx, "operator"))
+	auth.resolvePermissions(ctx, operator)
+	assert.NoError(t, auth.HasAccess(operator, entity.PermissionReportsRead), "built-in permissions restored")
+
+	assert.ErrorIs(t, auth.DeleteRole(ctx, "operator"), entity.ErrNotFound)
+}
+
+func TestUserRoleMustExist(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	admin := &entity.User{Username: "admin", Role: entity.RoleAdmin}
+
+	err := auth.CreateUser(ctx, admin, &entity.User{Username: "alice", Password: "secret1", Role: "support"})
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+
+	_, err = auth.SaveRole(ctx, admin, &entity.Role{Name: "support", Permissions: []string{entity.PermissionReportsRead}})
+	require.NoError(t, err)
+	require.NoError(t, auth.CreateUser(ctx, admin, &entity.User{Username: "alice", Password: "secret1", Role: "support"}))
+
+	_, err = auth.UpdateUser(ctx, admin, "alice", &entity.UserUpdate{Role: "nobody", AccessLevel: -1})
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+	_, err = auth.UpdateUser(ctx, admin, "alice", &entity.UserUpdate{Role: "operator", AccessLevel: -1})
+	assert.NoError(t, err)
+}
+
+func TestRoleAboveAuthor(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	operator := &entity.User{Username: "op", Role: entity.RoleOperator}
+	auth.resolvePermissions(ctx, operator)
+	db.SeedUser(&entity.User{Username: "root", UserId: "u1", Role: entity.RoleAdmin})
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u2"})
+
+	err := auth.CreateUser(ctx, operator, &entity.User{Username: "mallory", Password: "secret1", Role: entity.RoleAdmin})
+	assert.ErrorContains(t, err, "access denied")
+	_, err = auth.UpdateUser(ctx, operator, "driver", &entity.UserUpdate{Role: entity.RoleAdmin, AccessLevel: -1})
+	assert.ErrorContains(t, err, "access denied")
+	_, err = auth.UpdateUser(ctx, operator, "root", &entity.UserUpdate{Role: entity.RoleAdmin, Password: "secret1", AccessLevel: -1})
+	assert.ErrorContains(t, err, "access denied", "an admin is out of reach")
+	_, err = auth.GenerateInvites(ctx, operator, &entity.InviteRequest{Role: entity.RoleAdmin})
+	assert.ErrorContains(t, err, "access denied")
+	assert.ErrorContains(t, auth.DeleteUser(ctx, operator, "root"), "access denied")
+	_, err = auth.RevokeUserSessions(ctx, operator, "root")
+	assert.ErrorContains(t, err, "access denied")
+	assert.ErrorContains(t, auth.ResetTwoFactor(ctx, operator, "root"), "access denied")
+
+	// a roles.write role cannot grant, nor take away, more than it holds
+	manager := &entity.User{Username: "manager", Role: "manager", Permissions: []string{entity.PermissionRolesWrite, entity.PermissionReportsRead}}
+	_, err = auth.SaveRole(ctx, manager, &entity.Role{Name: "support", Permissions: []string{entity.PermissionPaymentsRefund}})
+	assert.ErrorContains(t, err, "access denied")
+	_, err = auth.SaveRole(ctx, manager, &entity.Role{Name: entity.RoleOperator, Permissions: []string{entity.PermissionReportsRead}})
+	assert.ErrorContains(t, err, "access denied", "the operator role grants more than the author")
+	_, err = auth.SaveRole(ctx, manager, &entity.Role{Name: "support", Permissions: []string{entity.PermissionReportsRead}})
+	assert.NoError(t, err)
+	root, _ := db.GetUser(ctx, "root")
+	assert.NotNil(t, root, "an admin is not deleted by an operator")
+	user, _ := db.GetUser(ctx, "driver")
+	assert.Empty(t, user.Role)
+
+	require.NoError(t, auth.CreateUser(ctx, operator, &entity.User{Username: "helper", Password: "secret1", Role: entity.RoleOperator}))
+	_, err = auth.UpdateUser(ctx, operator, "driver", &entity.UserUpdate{Role: entity.RoleUser, AccessLevel: -1})
+	assert.NoError(t, err)
+	_, err = auth.RevokeUserSessions(ctx, operator, "driver")
+	assert.NoError(t, err)
+	assert.NoError(t, auth.ResetTwoFactor(ctx, operator, "driver"))
+	assert.NoError(t, auth.DeleteUser(ctx, operator, "helper"))
+}
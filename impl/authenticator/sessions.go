@@ -0,0 +1,190 @@
+package authenticator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultTokenTTL    = 24 * time.Hour
+	defaultRefreshTTL  = 30 * 24 * time.Hour
+	refreshTokenLength = 64
+	sessionIdLength    = 16
+	// the session's last-seen time is written at most this often
+	sessionTouchInterval = time.Minute
+)
+
+// SetSessionLifetime sets how long access and refresh tokens are valid;
+// zero keeps the default.
+func (a *Authenticator) SetSessionLifetime(tokenTTL, refreshTTL time.Duration) {
+	if tokenTTL > 0 {
+		a.tokenTTL = tokenTTL
+	}
+	if refreshTTL > 0 {
+		a.refreshTTL = refreshTTL
+	}
+}
+
+// hashToken returns the hash a token is stored by.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens generates a new pair of tokens for the session.
+func (a *Authenticator) issueTokens(session *entity.Session, now time.Time) *entity.SessionTokens {
+	tokens := &entity.SessionTokens{
+		SessionId:        session.Id,
+		Token:            a.generateKey(tokenLength),
+		RefreshToken:     a.generateKey(refreshTokenLength),
+		ExpiresAt:        now.Add(a.tokenTTL),
+		RefreshExpiresAt: now.Add(a.refreshTTL),
+	}
+	session.TokenHash = hashToken(tokens.Token)
+	session.RefreshHash = hashToken(tokens.RefreshToken)
+	session.ExpiresAt = tokens.ExpiresAt
+	session.RefreshExpiresAt = tokens.RefreshExpiresAt
+	session.LastSeen = now
+	return tokens
+}
+
+// createSession opens a session of the user on a device, removing the
+// sessions that expired before.
+func (a *Authenticator) createSession(ctx context.Context, user *entity.User, device string) (*entity.SessionTokens, error) {
+	now := time.Now()
+	if err := a.database.DeleteExpiredSessions(ctx, now); err != nil {
+		a.logger.Warn("deleting expired sessions", sl.Err(err))
+	}
+	session := &entity.Session{
+		Id:        a.generateKey(sessionIdLength),
+		UserId:    user.UserId,
+		Username:  user.Username,
+		Device:    device,
+		CreatedAt: now,
+	}
+	tokens := a.issueTokens(session, now)
+	if err := a.database.AddSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("saving session: %w", err)
+	}
+	return tokens, nil
+}
+
+// checkSession returns the user of an unexpired session holding the access token.
+func (a *Authenticator) checkSession(ctx context.Context, token string) (*entity.User, error) {
+	session, _ := a.database.GetSessionByToken(ctx, hashToken(token))
+	if session == nil {
+		return nil, fmt.Errorf("token check failed")
+	}
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		return nil, fmt.Errorf("token expired")
+	}
+	user, _ := a.database.GetUserById(ctx, session.UserId)
+	if user == nil {
+		return nil, fmt.Errorf("token check failed: user not found")
+	}
+	if now.Sub(session.LastSeen) > sessionTouchInterval {
+		session.LastSeen = now
+		if err := a.database.UpdateSession(ctx, session); err != nil {
+			a.logger.Warn("updating session", sl.Err(err))
+		}
+	}
+	return user, nil
+}
+
+// RefreshSession exchanges a valid refresh token for a new pair of tokens;
+// the old ones stop working.
+func (a *Authenticator) RefreshSession(ctx context.Context, refreshToken string) (*entity.SessionTokens, error) {
+	if refreshToken == "" {
+		return nil, fmt.Errorf("empty refresh token")
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	session, _ := a.database.GetSessionByRefresh(ctx, hashToken(refreshToken))
+	if session == nil {
+		return nil, fmt.Errorf("refresh token check failed")
+	}
+	now := time.Now()
+	if now.After(session.RefreshExpiresAt) {
+		return nil, fmt.Errorf("refresh token expired")
+	}
+	tokens := a.issueTokens(session, now)
+	if err := a.database.UpdateSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("updating session: %w", err)
+	}
+	a.logger.With(
+		slog.String("username", session.Username),
+		slog.String("session", session.Id),
+	).Info("session refreshed")
+	return tokens, nil
+}
+
+// GetSessions returns the sessions of the user, marking the one of the
+// user's current token.
+func (a *Authenticator) GetSessions(ctx context.Context, user *entity.User) ([]*entity.Session, error) {
+	if user == nil || user.UserId == "" {
+		return nil, fmt.Errorf("user undefined")
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	sessions, err := a.database.GetUserSessions(ctx, user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = make([]*entity.Session, 0)
+	}
+	current := ""
+	if user.Token != "" {
+		current = hashToken(user.Token)
+	}
+	for _, s := range sessions {
+		s.Current = s.TokenHash == current
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends a session of the user.
+func (a *Authenticator) RevokeSession(ctx context.Context, user *entity.User, sessionId string) error {
+	if user == nil || user.UserId == "" {
+		return fmt.Errorf("user undefined")
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if err := a.database.DeleteSession(ctx, user.UserId, sessionId); err != nil {
+		return err
+	}
+	a.logger.With(
+		slog.String("username", user.Username),
+		slog.String("session", sessionId),
+	).Info("session revoked")
+	return nil
+}
+
+// RevokeUserSessions ends all sessions of a user and returns their number.
+func (a *Authenticator) RevokeUserSessions(ctx context.Context, author *entity.User, username string) (int, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	user, _ := a.database.GetUser(ctx, username)
+	if user == nil {
+		return 0, fmt.Errorf("user %s %w", username, entity.ErrNotFound)
+	}
+	if err := a.checkRole(ctx, author, user.Role); err != nil {
+		return 0, err
+	}
+	count, err := a.database.DeleteUserSessions(ctx, user.UserId)
+	if err != nil {
+		return 0, err
+	}
+	a.logger.With(
+		slog.String("username", username),
+		slog.Int("count", count),
+	).Info("user sessions revoked")
+	return count, nil
+}
@@ -0,0 +1,150 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSessionAuth(t *testing.T) (*Authenticator, *database_mock.MockDB) {
+	t.Helper()
+	db := database_mock.NewMockDB()
+	db.SeedUser(&entity.User{Username: "testuser", UserId: "user123", Password: createHashedPassword("secret")})
+	db.SeedUser(&entity.User{Username: "other", UserId: "user456", Password: createHashedPassword("secret")})
+	auth := New(newTestLogger(), db)
+	require.NotNil(t, auth)
+	return auth, db
+}
+
+func login(t *testing.T, auth *Authenticator, username, device string) *entity.User {
+	t.Helper()
+	user, err := auth.AuthenticateUser(context.Background(), username, "secret", device)
+	require.NoError(t, err)
+	return user
+}
+
+func TestSessions_PerDevice(t *testing.T) {
+	ctx := context.Background()
+	auth, _ := newSessionAuth(t)
+
+	phone := login(t, auth, "testuser", "phone")
+	laptop := login(t, auth, "testuser", "laptop")
+	assert.NotEqual(t, phone.Token, laptop.Token)
+
+	for _, token := range []string{phone.Token, laptop.Token} {
+		user, err := auth.AuthenticateByToken(ctx, token)
+		require.NoError(t, err, "a second login keeps the first one")
+		assert.Equal(t, "testuser", user.Username)
+	}
+
+	current, err := auth.AuthenticateByToken(ctx, laptop.Token)
+	require.NoError(t, err)
+	sessions, err := auth.GetSessions(ctx, current)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	for _, s := range sessions {
+		assert.Equal(t, s.Device == "laptop", s.Current)
+	}
+
+	require.NoError(t, auth.RevokeSession(ctx, current, phone.Session.SessionId))
+	_, err = auth.AuthenticateByToken(ctx, phone.Token)
+	assert.Error(t, err, "a revoked session is rejected")
+	_, err = auth.AuthenticateByToken(ctx, laptop.Token)
+	assert.NoError(t, err)
+	err = auth.RevokeSession(ctx, current, phone.Session.SessionId)
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+}
+
+func TestRevokeSession_OtherUser(t *testing.T) {
+	ctx := context.Background()
+	auth, _ := newSessionAuth(t)
+	owner := login(t, auth, "testuser", "phone")
+	other := login(t, auth, "other", "phone")
+
+	err := auth.RevokeSession(ctx, other, owner.Session.SessionId)
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+	_, err = auth.AuthenticateByToken(ctx, owner.Token)
+	assert.NoError(t, err)
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	ctx := context.Background()
+	auth, _ := newSessionAuth(t)
+	first := login(t, auth, "testuser", "phone")
+	second := login(t, auth, "testuser", "laptop")
+	other := login(t, auth, "other", "phone")
+
+	count, err := auth.RevokeUserSessions(ctx, &entity.User{Username: "admin", Role: entity.RoleAdmin}, "testuser")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	for _, token := range []string{first.Token, second.Token} {
+		_, err = auth.AuthenticateByToken(ctx, token)
+		assert.Error(t, err)
+	}
+	_, err = auth.RefreshSession(ctx, first.Session.RefreshToken)
+	assert.Error(t, err, "the refresh token is revoked too")
+	_, err = auth.AuthenticateByToken(ctx, other.Token)
+	assert.NoError(t, err, "other users keep their sessions")
+
+	_, err = auth.RevokeUserSessions(ctx, &entity.User{Username: "admin", Role: entity.RoleAdmin}, "nobody")
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+}
+
+func TestRefreshSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokenTTL   time.Duration
+		refreshTTL time.Duration
+		wantErrMsg string
+	}{
+		{"valid", time.Hour, time.Hour, ""},
+		{"access token expired", time.Nanosecond, time.Hour, ""},
+		{"refresh token expired", time.Nanosecond, time.Nanosecond, "refresh token expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			auth, _ := newSessionAuth(t)
+			auth.SetSessionLifetime(tt.tokenTTL, tt.refreshTTL)
+			user := login(t, auth, "testuser", "phone")
+			time.Sleep(time.Millisecond)
+
+			tokens, err := auth.RefreshSession(ctx, user.Session.RefreshToken)
+			if tt.wantErrMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, user.Session.SessionId, tokens.SessionId)
+			assert.NotEqual(t, user.Token, tokens.Token)
+
+			_, err = auth.AuthenticateByToken(ctx, user.Token)
+			assert.Error(t, err, "the old access token stops working")
+			_, err = auth.RefreshSession(ctx, user.Session.RefreshToken)
+			assert.Error(t, err, "the refresh token is used once")
+			if tt.tokenTTL > time.Millisecond {
+				_, err = auth.AuthenticateByToken(ctx, tokens.Token)
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDeleteUser_RevokesSessions(t *testing.T) {
+	ctx := context.Background()
+	auth, db := newSessionAuth(t)
+	user := login(t, auth, "testuser", "phone")
+
+	require.NoError(t, auth.DeleteUser(ctx, &entity.User{Username: "admin", Role: entity.RoleAdmin}, "testuser"))
+	sessions, _ := db.GetUserSessions(ctx, "user123")
+	assert.Empty(t, sessions)
+	_, err := auth.AuthenticateByToken(ctx, user.Token)
+	assert.Error(t, err)
+}
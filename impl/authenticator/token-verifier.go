@@ -0,0 +1,10 @@
+package authenticator
+
+import "evsys-back/entity"
+
+// TokenVerifier checks the identity tokens of external providers.
+type TokenVerifier interface {
+	// Accepts reports whether the token is issued by a provider of the verifier
+	Accepts(token string) bool
+	VerifyToken(token string) (*entity.Identity, error)
+}
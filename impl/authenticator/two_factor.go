@@ -0,0 +1,274 @@
+package authenticator
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"evsys-back/entity"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	totpDigits      = 6
+	totpPeriod      = 30 // seconds
+	totpSkew        = 1  // time steps accepted before and after the current one
+	totpSecretBytes = 20
+	defaultIssuer   = "EVSys"
+	// recovery codes are shown as two groups of five characters
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+	// a login waits this long for the second factor
+	twoFactorChallengeTTL = 5 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SetTwoFactor sets the issuer shown by authenticator apps and the roles that
+// have no permissions until the user enrolls a second factor.
+func (a *Authenticator) SetTwoFactor(issuer string, requiredRoles []string) {
+	if issuer != "" {
+		a.totpIssuer = issuer
+	}
+	a.twoFactorRoles = requiredRoles
+}
+
+// totpCode returns the RFC 6238 code of a time step, using HMAC-SHA1.
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// totpStep returns the time step of a valid code, not used before, zero if
+// the code is not valid.
+func totpStep(user *entity.User, code string, now time.Time) int64 {
+	secret, err := totpEncoding.DecodeString(user.TotpSecret)
+	if err != nil || len(code) != totpDigits {
+		return 0
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= user.TotpLastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step
+		}
+	}
+	return 0
+}
+
+// normalizeRecoveryCode removes the separator and case of a recovery code.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// checkSecondFactor accepts a TOTP code or a recovery code, which is then
+// used up; the user is saved with the code marked as used.
+func (a *Authenticator) checkSecondFactor(ctx context.Context, user *entity.User, code string) error {
+	code = strings.TrimSpace(code)
+	if step := totpStep(user, code, time.Now()); step > 0 {
+		user.TotpLastStep = step
+	} else {
+		hash := hashToken(normalizeRecoveryCode(code))
+		i := slices.Index(user.RecoveryCodes, hash)
+		if i < 0 {
+			return fmt.Errorf("invalid two-factor code")
+		}
+		user.RecoveryCodes = slices.Delete(slices.Clone(user.RecoveryCodes), i, i+1)
+		a.logger.With(
+			slog.String("username", user.Username),
+			slog.Int("left", len(user.RecoveryCodes)),
+		).Warn("recovery code used")
+	}
+	return a.database.UpdateUserTwoFactor(ctx, user)
+}
+
+// twoFactorRequired reports whether the user's role requires a second factor.
+func (a *Authenticator) twoFactorRequired(user *entity.User) bool {
+	return slices.Contains(a.twoFactorRoles, entity.RoleName(user.Role))
+}
+
+// twoFactorChallenge returns the user without tokens, holding a challenge to
+// complete the login with the second factor.
+func (a *Authenticator) twoFactorChallenge(ctx context.Context, user *entity.User) (*entity.User, error) {
+	token, err := a.issueAccountToken(ctx, user, entity.AccountTokenTwoFactor, twoFactorChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	a.logger.With(
+		slog.String("username", user.Username),
+	).Info("two-factor code requested")
+	return &entity.User{
+		Username:           user.Username,
+		TwoFactorEnabled:   true,
+		TwoFactorChallenge: token.Token,
+	}, nil
+}
+
+// AuthenticateTwoFactor completes a password login with the TOTP or recovery
+// code; the challenge is used up by any attempt.
+func (a *Authenticator) AuthenticateTwoFactor(ctx context.Context, challenge, code, device string) (*entity.User, error) {
+	if challenge == "" || code == "" {
+		return nil, fmt.Errorf("empty two-factor challenge or code")
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	_, user, err := a.useAccountToken(ctx, challenge, entity.AccountTokenTwoFactor)
+	if err != nil {
+		return nil, fmt.Errorf("two-factor challenge: %w", err)
+	}
+	if !user.TwoFactorEnabled {
+		return nil, fmt.Errorf("two-factor authentication is not enabled")
+	}
+	if err = a.checkSecondFactor(ctx, user, code); err != nil {
+		return nil, err
+	}
+	return a.login(ctx, user, device)
+}
+
+// EnrollTwoFactor generates a TOTP secret for the user, enabled when
+// ConfirmTwoFactor receives its first code.
+func (a *Authenticator) EnrollTwoFactor(ctx context.Context, username string) (*entity.TwoFactorEnrollment, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	user, _ := a.database.GetUser(ctx, username)
+	if user == nil {
+		return nil, fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	if user.TwoFactorEnabled {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating secret: %w", err)
+	}
+	user.TotpSecret = totpEncoding.EncodeToString(secret)
+	user.TotpLastStep = 0
+	user.RecoveryCodes = nil
+	if err := a.database.UpdateUserTwoFactor(ctx, user); err != nil {
+		return nil, fmt.Errorf("saving secret: %w", err)
+	}
+	return &entity.TwoFactorEnrollment{
+		Secret:          user.TotpSecret,
+		ProvisioningUri: a.provisioningUri(user),
+	}, nil
+}
+
+// provisioningUri returns the otpauth URI of the user's secret.
+func (a *Authenticator) provisioningUri(user *entity.User) string {
+	query := url.Values{}
+	query.Set("secret", user.TotpSecret)
+	query.Set("issuer", a.totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(a.totpIssuer + ":" + user.Username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// ConfirmTwoFactor enables the enrolled secret with its first code and
+// returns the recovery codes.
+func (a *Authenticator) ConfirmTwoFactor(ctx context.Context, username, code string) (*entity.TwoFactorRecovery, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	user, _ := a.database.GetUser(ctx, username)
+	if user == nil {
+		return nil, fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	if user.TwoFactorEnabled {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+	if user.TotpSecret == "" {
+		return nil, fmt.Errorf("two-factor authentication is not enrolled")
+	}
+	step := totpStep(user, strings.TrimSpace(code), time.Now())
+	if step == 0 {
+		return nil, fmt.Errorf("invalid two-factor code")
+	}
+	recovery := &entity.TwoFactorRecovery{}
+	user.RecoveryCodes = make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		value := a.generateKey(recoveryCodeLength)
+		recovery.RecoveryCodes = append(recovery.RecoveryCodes, value[:5]+"-"+value[5:])
+		user.RecoveryCodes = append(user.RecoveryCodes, hashToken(value))
+	}
+	user.TwoFactorEnabled = true
+	user.TotpLastStep = step
+	if err := a.database.UpdateUserTwoFactor(ctx, user); err != nil {
+		return nil, fmt.Errorf("saving two-factor: %w", err)
+	}
+	a.logger.With(
+		slog.String("username", user.Username),
+	).Info("two-factor enabled")
+	return recovery, nil
+}
+
+// DisableTwoFactor removes the second factor of the user, who proves having
+// it with a TOTP or recovery code.
+func (a *Authenticator) DisableTwoFactor(ctx context.Context, username, code string) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	user, _ := a.database.GetUser(ctx, username)
+	if user == nil {
+		return fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	if !user.TwoFactorEnabled {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+	if err := a.checkSecondFactor(ctx, user, code); err != nil {
+		return err
+	}
+	if err := a.clearTwoFactor(ctx, user); err != nil {
+		return err
+	}
+	a.logger.With(
+		slog.String("username", user.Username),
+	).Info("two-factor disabled")
+	return nil
+}
+
+// ResetTwoFactor removes the second factor of a user who lost it; the user
+// enrolls again on the next login.
+func (a *Authenticator) ResetTwoFactor(ctx context.Context, author *entity.User, username string) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	user, _ := a.database.GetUser(ctx, username)
+	if user == nil {
+		return fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	if err := a.checkRole(ctx, author, user.Role); err != nil {
+		return err
+	}
+	if err := a.clearTwoFactor(ctx, user); err != nil {
+		return err
+	}
+	a.logger.With(
+		slog.String("username", user.Username),
+	).Warn("two-factor reset")
+	return nil
+}
+
+func (a *Authenticator) clearTwoFactor(ctx context.Context, user *entity.User) error {
+	user.TwoFactorEnabled = false
+	user.TotpSecret = ""
+	user.TotpLastStep = 0
+	user.RecoveryCodes = nil
+	if err := a.database.UpdateUserTwoFactor(ctx, user); err != nil {
+		return fmt.Errorf("saving two-factor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package authenticator
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 test vectors for SHA1, last six digits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, totpCode(secret, tt.unix/totpPeriod))
+	}
+}
+
+// codeAt returns the TOTP code of the enrolled secret, steps from now.
+func codeAt(t *testing.T, secret string, steps int64) string {
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	return totpCode(key, time.Now().Unix()/totpPeriod+steps)
+}
+
+// enrollTwoFactor enables the second factor of the user and returns the
+// secret and recovery codes.
+func enrollTwoFactor(t *testing.T, auth *Authenticator, username string) (string, []string) {
+	ctx := context.Background()
+	enrollment, err := auth.EnrollTwoFactor(ctx, username)
+	require.NoError(t, err)
+	recovery, err := auth.ConfirmTwoFactor(ctx, username, codeAt(t, enrollment.Secret, 0))
+	require.NoError(t, err)
+	return enrollment.Secret, recovery.RecoveryCodes
+}
+
+func TestEnrollTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Password: createHashedPassword("secret")})
+
+	enrollment, err := auth.EnrollTwoFactor(ctx, "driver")
+	require.NoError(t, err)
+	uri, err := url.Parse(enrollment.ProvisioningUri)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/EVSys:driver", uri.Path)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+
+	_, err = auth.ConfirmTwoFactor(ctx, "driver", "000000")
+	assert.Error(t, err, "wrong code")
+	user, _ := db.GetUser(ctx, "driver")
+	assert.False(t, user.TwoFactorEnabled, "enabled only after a valid code")
+
+	recovery, err := auth.ConfirmTwoFactor(ctx, "driver", codeAt(t, enrollment.Secret, 0))
+	require.NoError(t, err)
+	assert.Len(t, recovery.RecoveryCodes, recoveryCodeCount)
+	user, _ = db.GetUser(ctx, "driver")
+	assert.True(t, user.TwoFactorEnabled)
+	assert.NotContains(t, user.RecoveryCodes, recovery.RecoveryCodes[0], "stored as hashes")
+
+	_, err = auth.EnrollTwoFactor(ctx, "driver")
+	assert.Error(t, err, "already enabled")
+}
+
+func TestAuthenticateTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Password: createHashedPassword("secret")})
+	secret, recovery := enrollTwoFactor(t, auth, "driver")
+
+	challenge, err := auth.AuthenticateUser(ctx, "driver", "secret", "")
+	require.NoError(t, err)
+	assert.Empty(t, challenge.Token, "no tokens before the second factor")
+	require.NotEmpty(t, challenge.TwoFactorChallenge)
+
+	_, err = auth.AuthenticateTwoFactor(ctx, challenge.TwoFactorChallenge, "000000", "")
+	assert.Error(t, err, "wrong code")
+	_, err = auth.AuthenticateTwoFactor(ctx, challenge.TwoFactorChallenge, codeAt(t, secret, 1), "")
+	assert.Error(t, err, "challenge used up by the failed attempt")
+
+	code := codeAt(t, secret, 1)
+	challenge, _ = auth.AuthenticateUser(ctx, "driver", "secret", "")
+	user, err := auth.AuthenticateTwoFactor(ctx, challenge.TwoFactorChallenge, code, "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, user.Token)
+	require.NotNil(t, user.Session)
+	assert.NotEmpty(t, user.Session.RefreshToken)
+
+	challenge, _ = auth.AuthenticateUser(ctx, "driver", "secret", "")
+	_, err = auth.AuthenticateTwoFactor(ctx, challenge.TwoFactorChallenge, code, "")
+	assert.Error(t, err, "code replayed")
+
+	challenge, _ = auth.AuthenticateUser(ctx, "driver", "secret", "")
+	_, err = auth.AuthenticateTwoFactor(ctx, challenge.TwoFactorChallenge, strings.ToUpper(recovery[0]), "")
+	require.NoError(t, err, "recovery code")
+	stored, _ := db.GetUser(ctx, "driver")
+	assert.Len(t, stored.RecoveryCodes, recoveryCodeCount-1)
+
+	challenge, _ = auth.AuthenticateUser(ctx, "driver", "secret", "")
+	_, err = auth.AuthenticateTwoFactor(ctx, challenge.TwoFactorChallenge, recovery[0], "")
+	assert.Error(t, err, "recovery code used once")
+}
+
+func TestTwoFactorRequired(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	auth.SetTwoFactor("", []string{entity.RoleAdmin})
+	db.SeedUser(&entity.User{Username: "admin", UserId: "u1", Role: entity.RoleAdmin, Password: createHashedPassword("secret")})
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u2", Password: createHashedPassword("secret")})
+
+	admin, err := auth.AuthenticateUser(ctx, "admin", "secret", "")
+	require.NoError(t, err)
+	assert.True(t, admin.TwoFactorRequired)
+	assert.Empty(t, admin.Permissions)
+	assert.False(t, admin.Can(entity.PermissionUsersWrite))
+
+	driver, err := auth.AuthenticateUser(ctx, "driver", "secret", "")
+	require.NoError(t, err)
+	assert.False(t, driver.TwoFactorRequired)
+	assert.NotEmpty(t, driver.Permissions)
+
+	secret, _ := enrollTwoFactor(t, auth, "admin")
+	challenge, err := auth.AuthenticateUser(ctx, "admin", "secret", "")
+	require.NoError(t, err)
+	admin, err = auth.AuthenticateTwoFactor(ctx, challenge.TwoFactorChallenge, codeAt(t, secret, 1), "")
+	require.NoError(t, err)
+	assert.False(t, admin.TwoFactorRequired)
+	assert.True(t, admin.Can(entity.PermissionUsersWrite))
+}
+
+func TestResetTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := New(newTestLogger(), db)
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Password: createHashedPassword("secret")})
+	secret, _ := enrollTwoFactor(t, auth, "driver")
+
+	assert.Error(t, auth.DisableTwoFactor(ctx, "driver", "000000"), "wrong code")
+	require.NoError(t, auth.DisableTwoFactor(ctx, "driver", codeAt(t, secret, 1)))
+	user, err := auth.AuthenticateUser(ctx, "driver", "secret", "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, user.Token, "password login again")
+
+	enrollTwoFactor(t, auth, "driver")
+	require.NoError(t, auth.ResetTwoFactor(ctx, &entity.User{Username: "admin", Role: entity.RoleAdmin}, "driver"))
+	stored, _ := db.GetUser(ctx, "driver")
+	assert.False(t, stored.TwoFactorEnabled)
+	assert.Empty(t, stored.TotpSecret)
+	assert.Empty(t, stored.RecoveryCodes)
+	assert.ErrorIs(t, auth.ResetTwoFactor(ctx, &entity.User{Username: "admin", Role: entity.RoleAdmin}, "nobody"), entity.ErrNotFound)
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"net/url"
+)
+
+// AccountConfig holds the app pages that password reset and email
+// verification links open; the token is added as the "token" parameter.
+type AccountConfig struct {
+	ResetUrl  string
+	VerifyUrl string
+}
+
+func (c *Core) SetAccount(conf *AccountConfig) {
+	c.account = conf
+}
+
+// accountLink adds the token to the query of a page url.
+func accountLink(page, token string) (string, error) {
+	if page == "" {
+		return "", fmt.Errorf("link url not configured")
+	}
+	u, err := url.Parse(page)
+	if err != nil {
+		return "", fmt.Errorf("link url: %w", err)
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// sendAccountMail emails the link of an account token.
+func (c *Core) sendAccountMail(ctx context.Context, token *entity.AccountToken, page string,
+	send func(ctx context.Context, to string, m entity.AccountMail) error) error {
+	link, err := accountLink(page, token.Token)
+	if err != nil {
+		return err
+	}
+	return send(ctx, token.Email, entity.AccountMail{
+		Username:  token.Username,
+		Link:      link,
+		ExpiresAt: token.ExpiresAt,
+	})
+}
+
+// ForgotPassword emails a password reset link to the user with the username
+// or email address. To not disclose which accounts exist, a failure is only
+// logged and the request always succeeds.
+func (c *Core) ForgotPassword(ctx context.Context, login string) error {
+	if err := c.requireAuth(); err != nil {
+		return err
+	}
+	if c.mail == nil || c.account == nil {
+		return fmt.Errorf("password reset not configured")
+	}
+	log := c.log.With(slog.String("login", login))
+	token, err := c.auth.CreatePasswordReset(ctx, login)
+	if err != nil {
+		log.With(sl.Err(err)).Warn("password reset not sent")
+		return nil
+	}
+	if err = c.sendAccountMail(ctx, token, c.account.ResetUrl, c.mail.SendPasswordReset); err != nil {
+		log.With(sl.Err(err)).Error("failed to send password reset")
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with the token of a reset link.
+func (c *Core) ResetPassword(ctx context.Context, req *entity.PasswordResetRequest) error {
+	if err := c.requireAuth(); err != nil {
+		return err
+	}
+	return c.auth.ResetPassword(ctx, req.Token, req.Password)
+}
+
+// VerifyEmail confirms an email address with the token of a verification link.
+func (c *Core) VerifyEmail(ctx context.Context, req *entity.EmailVerifyRequest) error {
+	if err := c.requireAuth(); err != nil {
+		return err
+	}
+	return c.auth.VerifyEmail(ctx, req.Token)
+}
+
+// ResendEmailVerification emails a new verification link to the author;
+// links sent before stop working.
+func (c *Core) ResendEmailVerification(ctx context.Context, author *entity.User) error {
+	if err := c.requireAuth(); err != nil {
+		return err
+	}
+	if c.mail == nil || c.account == nil {
+		return fmt.Errorf("email verification not configured")
+	}
+	token, err := c.auth.CreateEmailVerification(ctx, author.Username)
+	if err != nil {
+		return err
+	}
+	return c.sendAccountMail(ctx, token, c.account.VerifyUrl, c.mail.SendEmailVerification)
+}
+
+// sendEmailVerification emails the verification link to a registered user;
+// a failure is logged, the user can ask for another link.
+func (c *Core) sendEmailVerification(ctx context.Context, username string) {
+	log := c.log.With(slog.String("username", username))
+	if c.mail == nil || c.account == nil {
+		log.Warn("email verification not configured")
+		return
+	}
+	token, err := c.auth.CreateEmailVerification(ctx, username)
+	if err == nil {
+		err = c.sendAccountMail(ctx, token, c.account.VerifyUrl, c.mail.SendEmailVerification)
+	}
+	if err != nil {
+		log.With(sl.Err(err)).Error("failed to send email verification")
+	}
+}
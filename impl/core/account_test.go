@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func linkToken(t *testing.T, link string) string {
+	t.Helper()
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	return u.Query().Get("token")
+}
+
+func TestAccountMails(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	auth := authenticator.New(newTestLogger(), db)
+	auth.SetAccountTokens(0, 0, true)
+	c := New(newTestLogger(), db)
+	c.SetAuth(auth)
+	m := &stubMailer{}
+	c.SetMailService(m)
+	c.SetAccount(&AccountConfig{
+		ResetUrl:  "https://app.example.com/reset?lang=en",
+		VerifyUrl: "https://app.example.com/verify",
+	})
+	db.SeedInvite("INVITE")
+
+	_, err := c.AddUser(ctx, &entity.User{Username: "driver", Password: "secret", Email: "driver@example.com", Token: "INVITE"})
+	require.NoError(t, err)
+	mail, ok := m.account["driver@example.com"]
+	require.True(t, ok, "verification mail sent on registration")
+	assert.Contains(t, mail.Link, "https://app.example.com/verify?token=")
+	require.NoError(t, c.VerifyEmail(ctx, &entity.EmailVerifyRequest{Token: linkToken(t, mail.Link)}))
+
+	require.NoError(t, c.ForgotPassword(ctx, "unknown"), "unknown accounts are not disclosed")
+	require.NoError(t, c.ForgotPassword(ctx, "driver@example.com"))
+	mail = m.account["driver@example.com"]
+	assert.Contains(t, mail.Link, "lang=en")
+	assert.Equal(t, "driver", mail.Username)
+	require.NoError(t, c.ResetPassword(ctx, &entity.PasswordResetRequest{Token: linkToken(t, mail.Link), Password: "new-secret"}))
+	_, err = c.AuthenticateUser(ctx, "driver", "new-secret", "")
+	assert.NoError(t, err)
+
+	c.SetAccount(nil)
+	assert.Error(t, c.ForgotPassword(ctx, "driver"), "not configured")
+}
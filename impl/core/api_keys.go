@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+)
+
+// AuthenticateApiKey returns the identity of a service integration's key.
+func (c *Core) AuthenticateApiKey(ctx context.Context, secret string) (*entity.User, error) {
+	if err := c.requireAuth(); err != nil {
+		return nil, err
+	}
+	return c.auth.AuthenticateApiKey(ctx, secret)
+}
+
+// ListApiKeys returns the API keys without their secrets (admin only).
+func (c *Core) ListApiKeys(ctx context.Context, author *entity.User) ([]*entity.ApiKey, error) {
+	if err := c.requirePermission(author, entity.PermissionApiKeysWrite); err != nil {
+		return nil, err
+	}
+	return c.auth.ListApiKeys(ctx)
+}
+
+// CreateApiKey creates an API key and returns it with its secret (admin only).
+func (c *Core) CreateApiKey(ctx context.Context, author *entity.User, req *entity.ApiKeyRequest) (*entity.ApiKey, error) {
+	if err := c.requirePermission(author, entity.PermissionApiKeysWrite); err != nil {
+		return nil, err
+	}
+	return c.auth.CreateApiKey(ctx, author, req)
+}
+
+// RotateApiKey replaces the secret of an API key and returns the new one
+// (admin only).
+func (c *Core) RotateApiKey(ctx context.Context, author *entity.User, id string) (*entity.ApiKey, error) {
+	if err := c.requirePermission(author, entity.PermissionApiKeysWrite); err != nil {
+		return nil, err
+	}
+	return c.auth.RotateApiKey(ctx, id)
+}
+
+// RevokeApiKey disables an API key (admin only).
+func (c *Core) RevokeApiKey(ctx context.Context, author *entity.User, id string) error {
+	if err := c.requirePermission(author, entity.PermissionApiKeysWrite); err != nil {
+		return err
+	}
+	return c.auth.RevokeApiKey(ctx, id)
+}
@@ -7,15 +7,45 @@ import (
 
 type Authenticator interface {
 	AuthenticateByToken(ctx context.Context, token string) (*entity.User, error)
-	AuthenticateUser(ctx context.Context, username, password string) (*entity.User, error)
+	AuthenticateUser(ctx context.Context, username, password, device string) (*entity.User, error)
 	RegisterUser(ctx context.Context, user *entity.User) error
 	GetUsers(ctx context.Context, user *entity.User) ([]*entity.User, error)
 	GetUserTag(ctx context.Context, user *entity.User) (string, error)
 	CommandAccess(user *entity.User, command string) error
-	HasAccess(user *entity.User, subSystem string) error
-	CreateUser(ctx context.Context, user *entity.User) error
-	UpdateUser(ctx context.Context, username string, updates *entity.UserUpdate) (*entity.User, error)
-	DeleteUser(ctx context.Context, username string) error
+	HasAccess(user *entity.User, permission string) error
+	CreateUser(ctx context.Context, author *entity.User, user *entity.User) error
+	UpdateUser(ctx context.Context, author *entity.User, username string, updates *entity.UserUpdate) (*entity.User, error)
+	DeleteUser(ctx context.Context, author *entity.User, username string) error
+	// Session management
+	RefreshSession(ctx context.Context, refreshToken string) (*entity.SessionTokens, error)
+	GetSessions(ctx context.Context, user *entity.User) ([]*entity.Session, error)
+	RevokeSession(ctx context.Context, user *entity.User, sessionId string) error
+	RevokeUserSessions(ctx context.Context, author *entity.User, username string) (int, error)
+	// Password reset and email verification
+	CreatePasswordReset(ctx context.Context, login string) (*entity.AccountToken, error)
+	ResetPassword(ctx context.Context, token, password string) error
+	CreateEmailVerification(ctx context.Context, username string) (*entity.AccountToken, error)
+	VerifyEmail(ctx context.Context, token string) error
+	// Second factor
+	AuthenticateTwoFactor(ctx context.Context, challenge, code, device string) (*entity.User, error)
+	EnrollTwoFactor(ctx context.Context, username string) (*entity.TwoFactorEnrollment, error)
+	ConfirmTwoFactor(ctx context.Context, username, code string) (*entity.TwoFactorRecovery, error)
+	DisableTwoFactor(ctx context.Context, username, code string) error
+	ResetTwoFactor(ctx context.Context, author *entity.User, username string) error
+	// Invites
+	GenerateInvites(ctx context.Context, author *entity.User, req *entity.InviteRequest) ([]*entity.Invite, error)
+	ListInvites(ctx context.Context) ([]*entity.Invite, error)
+	RevokeInvite(ctx context.Context, code string) error
+	// Role management
+	ListRoles(ctx context.Context) ([]*entity.Role, error)
+	SaveRole(ctx context.Context, author *entity.User, role *entity.Role) (*entity.Role, error)
+	DeleteRole(ctx context.Context, name string) error
+	// API keys of service integrations
+	CreateApiKey(ctx context.Context, author *entity.User, req *entity.ApiKeyRequest) (*entity.ApiKey, error)
+	ListApiKeys(ctx context.Context) ([]*entity.ApiKey, error)
+	RotateApiKey(ctx context.Context, id string) (*entity.ApiKey, error)
+	RevokeApiKey(ctx context.Context, id string) error
+	AuthenticateApiKey(ctx context.Context, secret string) (*entity.User, error)
 	// User tag management
 	ListUserTags(ctx context.Context) ([]*entity.UserTag, error)
 	GetUserTagByIdTag(ctx context.Context, idTag string) (*entity.UserTag, error)
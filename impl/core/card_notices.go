@@ -0,0 +1,189 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"log/slog"
+	"time"
+)
+
+// Card notice defaults, used when the configuration leaves them unset.
+const (
+	defaultCardExpiryWindow = 30 * 24 * time.Hour
+	defaultCardResendAfter  = 7 * 24 * time.Hour
+	// cardNoticeInterval is how often saved cards are checked for reminders.
+	cardNoticeInterval = time.Hour
+)
+
+// CardNoticeConfig holds the rules of reminders about saved cards that
+// expire soon or were declined.
+type CardNoticeConfig struct {
+	// ExpiryWindow is how long before its expiry a card is reminded of.
+	ExpiryWindow time.Duration
+	// ResendAfter is the least time between two reminders about a card.
+	ResendAfter time.Duration
+	// AddCardUrl is the app link where a user adds a new card.
+	AddCardUrl string
+}
+
+// Notifier pushes a message to the open WebSocket sessions of a user.
+type Notifier interface {
+	NotifyUser(userId string, response *entity.WsResponse)
+}
+
+// SetCardNotice enables the reminders about saved cards.
+func (c *Core) SetCardNotice(conf *CardNoticeConfig) {
+	c.cardNotice = conf
+}
+
+func (c *Core) SetNotifier(notifier Notifier) {
+	c.notifier = notifier
+}
+
+// cardNoticeConfig returns the configured reminder rules, with defaults.
+func (c *Core) cardNoticeConfig() CardNoticeConfig {
+	conf := CardNoticeConfig{
+		ExpiryWindow: defaultCardExpiryWindow,
+		ResendAfter:  defaultCardResendAfter,
+	}
+	if c.cardNotice != nil {
+		if c.cardNotice.ExpiryWindow > 0 {
+			conf.ExpiryWindow = c.cardNotice.ExpiryWindow
+		}
+		if c.cardNotice.ResendAfter > 0 {
+			conf.ResendAfter = c.cardNotice.ResendAfter
+		}
+		conf.AddCardUrl = c.cardNotice.AddCardUrl
+	}
+	return conf
+}
+
+// processCardNotices reminds the owners of saved cards that expire within
+// the window or whose last payment was declined, by email and in the app,
+// so they add a new card before they arrive at a charger. A card is not
+// reminded of again before ResendAfter, unless its state changes.
+func (c *Core) processCardNotices(ctx context.Context) {
+	now := time.Now()
+	if c.cardNotice == nil || now.Sub(c.cardNoticesAt) < cardNoticeInterval {
+		return
+	}
+	c.cardNoticesAt = now
+	conf := c.cardNoticeConfig()
+
+	methods, err := c.repo.GetPaymentMethodsToNotice(ctx, now.Add(conf.ExpiryWindow).Format("0601"))
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to get payment methods to notice")
+		return
+	}
+
+	type pending struct {
+		reminder entity.CardReminder
+		notices  []*entity.CardNotice
+	}
+	var order []string
+	byUser := make(map[string]*pending)
+	for _, pm := range methods {
+		if pm.UserId == "" {
+			continue
+		}
+		kind := entity.CardNoticeExpiring
+		if pm.FailCount > 0 {
+			kind = entity.CardNoticeFailed
+		} else if end, ok := parseCardExpiry(pm.ExpiryDate); !ok || end.Sub(now) > conf.ExpiryWindow {
+			continue
+		}
+		if !c.cardNoticeDue(ctx, pm, kind, now, conf.ResendAfter) {
+			continue
+		}
+
+		p, ok := byUser[pm.UserId]
+		if !ok {
+			p = &pending{reminder: entity.CardReminder{Username: pm.UserName, AddCardUrl: conf.AddCardUrl}}
+			byUser[pm.UserId] = p
+			order = append(order, pm.UserId)
+		}
+		p.reminder.Cards = append(p.reminder.Cards, entity.CardIssue{
+			Card:       cardLabel(pm),
+			ExpiryDate: pm.ExpiryDate,
+			Kind:       kind,
+		})
+		p.notices = append(p.notices, &entity.CardNotice{
+			Identifier: pm.Identifier,
+			UserId:     pm.UserId,
+			Username:   pm.UserName,
+			Kind:       kind,
+			ExpiryDate: pm.ExpiryDate,
+			FailCount:  pm.FailCount,
+		})
+	}
+
+	for _, userId := range order {
+		p := byUser[userId]
+		emailed := c.sendCardReminder(ctx, p.reminder)
+		if c.notifier != nil {
+			c.notifier.NotifyUser(userId, &entity.WsResponse{
+				Status: entity.Event,
+				Stage:  entity.Notice,
+				Info:   p.reminder.Summary(),
+				Data:   conf.AddCardUrl,
+			})
+		}
+		for _, notice := range p.notices {
+			notice.Email = emailed
+			notice.SentAt = now
+			if err = c.repo.SaveCardNotice(ctx, notice); err != nil {
+				c.log.With(sl.Err(err)).Error("failed to save card notice")
+			}
+		}
+		c.log.With(
+			slog.String("user", p.reminder.Username),
+			slog.Int("cards", len(p.reminder.Cards)),
+			slog.Bool("email", emailed),
+		).Info("card reminder sent")
+	}
+}
+
+// cardNoticeDue reports whether a reminder about the card is due: none was
+// sent yet, the last one is older than resendAfter, or the card changed
+// since (a new expiry date or another decline).
+func (c *Core) cardNoticeDue(ctx context.Context, pm *entity.PaymentMethod, kind string, now time.Time, resendAfter time.Duration) bool {
+	last, err := c.repo.GetCardNotice(ctx, pm.Identifier, kind)
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to get card notice")
+		return false
+	}
+	if last == nil || now.Sub(last.SentAt) >= resendAfter {
+		return true
+	}
+	return last.ExpiryDate != pm.ExpiryDate || last.FailCount < pm.FailCount
+}
+
+// sendCardReminder emails the reminder to the user and reports whether it
+// was sent.
+func (c *Core) sendCardReminder(ctx context.Context, reminder entity.CardReminder) bool {
+	if c.mail == nil {
+		return false
+	}
+	info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, reminder.Username)
+	if err != nil || info == nil || info.Email == "" {
+		c.log.With(slog.String("user", reminder.Username)).Warn("no email address for card reminder")
+		return false
+	}
+	if err = c.mail.SendCardReminder(ctx, info.Email, reminder); err != nil {
+		c.log.With(sl.Err(err), slog.String("user", reminder.Username)).Error("failed to send card reminder")
+		return false
+	}
+	return true
+}
+
+// cardLabel is how a card is named to its owner.
+func cardLabel(pm *entity.PaymentMethod) string {
+	if pm.CardNumber != "" {
+		return pm.CardNumber
+	}
+	if pm.Description != "" {
+		return pm.Description
+	}
+	return pm.Identifier
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubNotifier records the in-app notices by user id.
+type stubNotifier struct {
+	mux     sync.Mutex
+	notices map[string][]*entity.WsResponse
+}
+
+func (n *stubNotifier) NotifyUser(userId string, response *entity.WsResponse) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.notices == nil {
+		n.notices = make(map[string][]*entity.WsResponse)
+	}
+	n.notices[userId] = append(n.notices[userId], response)
+}
+
+func TestProcessCardNotices(t *testing.T) {
+	now := time.Now()
+	soon := now.AddDate(0, 0, 10).Format("0601")
+	tests := []struct {
+		name   string
+		card   entity.PaymentMethod
+		notice *entity.CardNotice // sent earlier
+		kind   string             // empty when no reminder is due
+	}{
+		{"expires soon", entity.PaymentMethod{ExpiryDate: soon}, nil, entity.CardNoticeExpiring},
+		{"expired", entity.PaymentMethod{ExpiryDate: "2001"}, nil, entity.CardNoticeExpiring},
+		{"declined", entity.PaymentMethod{ExpiryDate: "4012", FailCount: 1}, nil, entity.CardNoticeFailed},
+		{"valid card", entity.PaymentMethod{ExpiryDate: now.AddDate(0, 3, 0).Format("0601")}, nil, ""},
+		{"reminded recently", entity.PaymentMethod{ExpiryDate: soon},
+			&entity.CardNotice{Kind: entity.CardNoticeExpiring, ExpiryDate: soon, SentAt: now.Add(-time.Hour)}, ""},
+		{"reminded long ago", entity.PaymentMethod{ExpiryDate: soon},
+			&entity.CardNotice{Kind: entity.CardNoticeExpiring, ExpiryDate: soon, SentAt: now.AddDate(0, 0, -8)}, entity.CardNoticeExpiring},
+		{"declined again", entity.PaymentMethod{ExpiryDate: "4012", FailCount: 2},
+			&entity.CardNotice{Kind: entity.CardNoticeFailed, ExpiryDate: "4012", FailCount: 1, SentAt: now.Add(-time.Hour)}, entity.CardNoticeFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, _ := newWalletCore(t, 0)
+			mailer := &stubMailer{}
+			notifier := &stubNotifier{}
+			c.SetMailService(mailer)
+			c.SetNotifier(notifier)
+			c.SetCardNotice(&CardNoticeConfig{AddCardUrl: "https://app.example.com/cards"})
+			db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Email: "driver@example.com"})
+
+			card := tt.card
+			card.Identifier, card.CardNumber, card.UserId, card.UserName = "tok-2", "**** 0004", "u1", "driver"
+			require.NoError(t, db.SavePaymentMethod(ctx, &card))
+			if tt.notice != nil {
+				tt.notice.Identifier, tt.notice.UserId = "tok-2", "u1"
+				require.NoError(t, db.SaveCardNotice(ctx, tt.notice))
+			}
+
+			c.processCardNotices(ctx)
+			if tt.kind == "" {
+				assert.Empty(t, mailer.reminders)
+				assert.Empty(t, notifier.notices)
+				return
+			}
+
+			reminder, ok := mailer.reminders["driver@example.com"]
+			require.True(t, ok, "the owner is emailed")
+			require.Len(t, reminder.Cards, 1, "only the card needing attention")
+			assert.Equal(t, "**** 0004", reminder.Cards[0].Card)
+			assert.Equal(t, tt.kind, reminder.Cards[0].Kind)
+			assert.Equal(t, "https://app.example.com/cards", reminder.AddCardUrl)
+
+			require.Len(t, notifier.notices["u1"], 1)
+			notice := notifier.notices["u1"][0]
+			assert.Equal(t, entity.Notice, notice.Stage)
+			assert.Equal(t, reminder.Summary(), notice.Info)
+
+			saved, _ := db.GetCardNotice(ctx, "tok-2", tt.kind)
+			require.NotNil(t, saved)
+			assert.True(t, saved.Email)
+			assert.Equal(t, card.FailCount, saved.FailCount)
+
+			// the next run within the resend interval stays quiet
+			mailer.reminders = nil
+			c.cardNoticesAt = time.Time{}
+			c.processCardNotices(ctx)
+			assert.Empty(t, mailer.reminders)
+		})
+	}
+}
@@ -2,46 +2,55 @@ package core
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"evsys-back/entity"
 	"evsys-back/impl/mail"
 	"evsys-back/internal/lib/sl"
 	"evsys-back/internal/lib/validate"
 	"fmt"
 	"log/slog"
-	"net/url"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	MaxAccessLevel              int = 10
 	NormalizedMeterValuesLength     = 60
-	subSystemReports                = "reports"
-	maxRetryAttempts                = 4
+	subSystemReports                = entity.PermissionReportsRead
+	// orderSequence names the sequence that numbers payment orders and
+	// preauthorizations; firstOrderNumber starts it on a new installation.
+	orderSequence    = "order"
+	firstOrderNumber = 1200
 )
 
-var retryDelays = []time.Duration{
-	1 * time.Hour,
-	24 * time.Hour,
-	7 * 24 * time.Hour,
-	30 * 24 * time.Hour,
-}
-
 type Core struct {
 	repo                 Repository
 	auth                 Authenticator
 	cs                   CentralSystem
 	reports              Reports
-	redsys               RedsysClient
+	gateway              PaymentGateway
 	mail                 MailService
+	invoicing            *InvoiceConfig
+	preauthorization     *PreauthorizationConfig
+	retryPolicy          *RetryPolicy
+	wallet               *WalletConfig
+	cardNotice           *CardNoticeConfig
+	account              *AccountConfig
+	notifier             Notifier
+	invoiceMux           sync.Mutex
+	groupBillingMux      sync.Mutex
 	currency             string
 	disablePayment       bool
 	paymentLocks         sync.Map
+	refundLocks          sync.Map    // order numbers whose refunded total is being checked or updated
+	limitStops           sync.Map    // transaction ids stopped at a usage limit
+	orderSequenced       atomic.Bool // the order sequence is past the stored orders
 	stopPaymentProcessor chan struct{}
+	reconciledUntil      time.Time
+	groupBilledAt        time.Time
+	cardNoticesAt        time.Time
 	log                  *slog.Logger
 }
 
@@ -51,95 +60,9 @@ type MailService interface {
 	SendTest(ctx context.Context, to string) error
 	SendPaymentWarning(ctx context.Context, to string, w entity.PaymentWarning) error
 	SendTransaction(ctx context.Context, to string, t entity.TransactionMail) error
-}
-
-// RedsysClient interface for Redsys operations
-type RedsysClient interface {
-	Capture(ctx context.Context, req CaptureRequest) (*CaptureResponse, error)
-	Cancel(ctx context.Context, req CaptureRequest) (*CaptureResponse, error)
-	Preauthorize(ctx context.Context, req PreauthorizeRequest) (*CaptureResponse, error)
-	Pay(ctx context.Context, req PayRequest) (*CaptureResponse, error)
-	Refund(ctx context.Context, req RefundRequest) (*CaptureResponse, error)
-	// BuildEntryForm signs a Redsys TPV Virtual hosted-form payload for
-	// the web "add card" redirect flow. Used by POST /payment/order
-	// when mode == "web".
-	BuildEntryForm(req EntryFormRequest) (*EntryFormResponse, error)
-	// VerifyNotification authenticates an inbound Redsys notification.
-	// merchantParams is the encoded Ds_MerchantParameters as received and
-	// orderNumber the Ds_Order it decodes to. Returns nil when the
-	// signature matches the merchant secret.
-	VerifyNotification(merchantParams, signature, orderNumber string) error
-}
-
-// EntryFormRequest asks the Redsys adapter for a signed hosted-form
-// payload. UrlOk / UrlKo come from the browser (so the same backend
-// can serve multiple frontends / environments).
-type EntryFormRequest struct {
-	OrderNumber string
-	Amount      int
-	Description string
-	UrlOk       string
-	UrlKo       string
-	Language    string
-}
-
-// EntryFormResponse is what the frontend needs to auto-submit an HTML
-// form to the Redsys TPV Virtual entry point.
-type EntryFormResponse struct {
-	FormUrl            string
-	SignatureVersion   string
-	MerchantParameters string
-	Signature          string
-}
-
-// CaptureRequest for Redsys capture operations
-type CaptureRequest struct {
-	OrderNumber       string
-	Amount            int
-	AuthorizationCode string
-}
-
-// CaptureResponse from Redsys operations
-type CaptureResponse struct {
-	Success           bool
-	ResponseCode      string
-	AuthorizationCode string
-	ErrorCode         string
-	ErrorMessage      string
-	// Extended fields populated by Pay/Refund for payment processing
-	MerchantIdentifier string
-	CofTxnid           string
-	CardBrand          string
-	CardCountry        string
-	ExpiryDate         string
-	Order              string
-	Amount             string
-	Currency           string
-	TransactionType    string
-	Date               string
-	Hour               string
-}
-
-// PreauthorizeRequest for Redsys MIT preauthorization
-type PreauthorizeRequest struct {
-	OrderNumber string
-	Amount      int
-	CardToken   string
-	CofTid      string // Network transaction ID from initial authorization
-}
-
-// PayRequest for Redsys MIT direct payment (transaction type "0")
-type PayRequest struct {
-	OrderNumber string
-	Amount      int
-	CardToken   string // DS_MERCHANT_IDENTIFIER
-	CofTid      string // DS_MERCHANT_COF_TXNID
-}
-
-// RefundRequest for Redsys refund (transaction type "3")
-type RefundRequest struct {
-	OrderNumber string
-	Amount      int
+	SendCardReminder(ctx context.Context, to string, r entity.CardReminder) error
+	SendPasswordReset(ctx context.Context, to string, m entity.AccountMail) error
+	SendEmailVerification(ctx context.Context, to string, m entity.AccountMail) error
 }
 
 func New(log *slog.Logger, repo Repository) *Core {
@@ -161,8 +84,8 @@ func (c *Core) SetReports(reports Reports) {
 	c.reports = reports
 }
 
-func (c *Core) SetRedsys(redsys RedsysClient) {
-	c.redsys = redsys
+func (c *Core) SetPaymentGateway(gateway PaymentGateway) {
+	c.gateway = gateway
 }
 
 func (c *Core) SetCurrency(currency string) {
@@ -179,7 +102,7 @@ func (c *Core) SetMailService(m MailService) {
 
 // ListMailSubscriptions returns all mail subscriptions (admin only).
 func (c *Core) ListMailSubscriptions(ctx context.Context, author *entity.User) ([]*entity.MailSubscription, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionMailWrite); err != nil {
 		return nil, err
 	}
 	return c.repo.ListMailSubscriptions(ctx)
@@ -188,7 +111,7 @@ func (c *Core) ListMailSubscriptions(ctx context.Context, author *entity.User) (
 // GetPaymentRetryQueue returns all active payment retry records enriched with
 // transaction summary fields for the admin preview (admin only).
 func (c *Core) GetPaymentRetryQueue(ctx context.Context, author *entity.User) ([]*entity.PaymentRetryView, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionPaymentsRead); err != nil {
 		return nil, err
 	}
 	retries, err := c.repo.GetAllPaymentRetries(ctx)
@@ -197,12 +120,14 @@ func (c *Core) GetPaymentRetryQueue(ctx context.Context, author *entity.User) ([
 	}
 	views := make([]*entity.PaymentRetryView, 0, len(retries))
 	for _, r := range retries {
+		class := r.RetryClass()
 		view := &entity.PaymentRetryView{
 			TransactionId: r.TransactionId,
 			Attempt:       r.Attempt,
-			MaxAttempts:   maxRetryAttempts,
+			MaxAttempts:   len(c.retrySchedule(class)),
 			NextRetryTime: r.NextRetryTime,
 			LastError:     r.LastError,
+			DeclineClass:  class,
 			CreatedAt:     r.CreatedAt,
 			UpdatedAt:     r.UpdatedAt,
 		}
@@ -222,7 +147,7 @@ func (c *Core) GetPaymentRetryQueue(ctx context.Context, author *entity.User) ([
 
 // SaveMailSubscription creates or updates a mail subscription (admin only).
 func (c *Core) SaveMailSubscription(ctx context.Context, author *entity.User, sub *entity.MailSubscription) (*entity.MailSubscription, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionMailWrite); err != nil {
 		return nil, err
 	}
 	return c.repo.SaveMailSubscription(ctx, sub)
@@ -230,7 +155,7 @@ func (c *Core) SaveMailSubscription(ctx context.Context, author *entity.User, su
 
 // DeleteMailSubscription removes a mail subscription (admin only).
 func (c *Core) DeleteMailSubscription(ctx context.Context, author *entity.User, id string) error {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionMailWrite); err != nil {
 		return err
 	}
 	return c.repo.DeleteMailSubscription(ctx, id)
@@ -239,7 +164,7 @@ func (c *Core) DeleteMailSubscription(ctx context.Context, author *entity.User,
 // SendTestMail dispatches a minimal diagnostic email to verify Brevo
 // credentials and sender verification. Admin-only.
 func (c *Core) SendTestMail(ctx context.Context, author *entity.User, to string) error {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionMailWrite); err != nil {
 		return err
 	}
 	if c.mail == nil {
@@ -268,7 +193,7 @@ func (c *Core) transactionReceiptData(ctx context.Context, author *entity.User,
 		return entity.TransactionMail{}, fmt.Errorf("transaction %w", entity.ErrNotFound)
 	}
 
-	if !author.IsPowerUser() {
+	if !author.Can(entity.PermissionTransactionsRead) {
 		if transaction.UserTag == nil || transaction.UserTag.UserId != author.UserId {
 			return entity.TransactionMail{}, fmt.Errorf("access denied: insufficient permissions")
 		}
@@ -282,6 +207,12 @@ func (c *Core) transactionReceiptData(ctx context.Context, author *entity.User,
 		data.ChargePointTitle = chargePoint.Title
 		data.ChargePointAddress = chargePoint.Address
 	}
+	if transaction.PromoCode != "" {
+		promotion, e := c.repo.GetPromotion(ctx, transaction.PromoCode)
+		if e == nil && promotion != nil {
+			data.Promotion = promotion.Description
+		}
+	}
 	return data, nil
 }
 
@@ -315,7 +246,7 @@ func (c *Core) GetTransactionReceipt(ctx context.Context, author *entity.User, t
 
 // SendMailSubscriptionNow triggers an immediate report email for a subscription.
 func (c *Core) SendMailSubscriptionNow(ctx context.Context, author *entity.User, id string) error {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionMailWrite); err != nil {
 		return err
 	}
 	if c.mail == nil {
@@ -338,14 +269,41 @@ func normalizeOrderNumber(orderNumber string) string {
 	return fmt.Sprintf("%012d", orderNum)
 }
 
-// nextOrderNumber returns the order number to assign to a new payment order:
-// one past the last stored order, or 1200 when there is none.
-func (c *Core) nextOrderNumber(ctx context.Context) int {
-	lastOrder, _ := c.repo.GetLastOrder(ctx)
-	if lastOrder != nil {
-		return lastOrder.Order + 1
+// nextOrderNumber allocates the order number of a new payment order or
+// preauthorization from the persistent order sequence, which is atomic across
+// goroutines and backend instances. Both share the merchant's order number
+// space, and a captured preauthorization is settled by a payment order under
+// its number. Until the sequence has been used by this instance, it is kept
+// past the orders stored before it existed.
+func (c *Core) nextOrderNumber(ctx context.Context) (int, error) {
+	floor := 0
+	if !c.orderSequenced.Load() {
+		floor = c.lastStoredOrder(ctx) + 1
+	}
+	next, err := c.repo.NextSequence(ctx, orderSequence, floor)
+	if err != nil {
+		return 0, fmt.Errorf("allocate order number: %w", err)
 	}
-	return 1200
+	c.orderSequenced.Store(true)
+	return next, nil
+}
+
+// lastStoredOrder returns the highest order number stored as a payment
+// order or preauthorization, or the one before the first order number when
+// there is none.
+func (c *Core) lastStoredOrder(ctx context.Context) int {
+	last := firstOrderNumber - 1
+	if lastOrder, _ := c.repo.GetLastOrder(ctx); lastOrder != nil && lastOrder.Order > last {
+		last = lastOrder.Order
+	}
+	if lastPreauth, _ := c.repo.GetLastPreauthorizationOrder(ctx); lastPreauth != nil {
+		var orderNum int
+		_, _ = fmt.Sscanf(lastPreauth.OrderNumber, "%d", &orderNum)
+		if orderNum > last {
+			last = orderNum
+		}
+	}
+	return last
 }
 
 // runAsync runs fn in a new goroutine with panic recovery and a fresh 30s
@@ -370,14 +328,12 @@ func (c *Core) requireAuth() error {
 	return nil
 }
 
-func (c *Core) requirePowerUser(author *entity.User) error {
+// requirePermission checks that the author's role grants the permission.
+func (c *Core) requirePermission(author *entity.User, permission string) error {
 	if c.auth == nil {
 		return fmt.Errorf("authenticator not set")
 	}
-	if !author.IsPowerUser() {
-		return fmt.Errorf("access denied: insufficient permissions")
-	}
-	return nil
+	return c.auth.HasAccess(author, permission)
 }
 
 func clearPassword(user *entity.User) *entity.User {
@@ -406,11 +362,11 @@ func (c *Core) AuthenticateByToken(ctx context.Context, token string) (*entity.U
 	return clearPassword(user), nil
 }
 
-func (c *Core) AuthenticateUser(ctx context.Context, username, password string) (*entity.User, error) {
+func (c *Core) AuthenticateUser(ctx context.Context, username, password, device string) (*entity.User, error) {
 	if err := c.requireAuth(); err != nil {
 		return nil, err
 	}
-	user, err := c.auth.AuthenticateUser(ctx, username, password)
+	user, err := c.auth.AuthenticateUser(ctx, username, password, device)
 	if err != nil {
 		return nil, err
 	}
@@ -425,14 +381,27 @@ func (c *Core) AddUser(ctx context.Context, user *entity.User) (*entity.User, er
 	if err != nil {
 		return nil, err
 	}
+	if user.EmailUnverified {
+		c.sendEmailVerification(ctx, user.Username)
+	}
 	return user, nil
 }
 
 func (c *Core) GetUser(ctx context.Context, author *entity.User, username string) (*entity.UserInfo, error) {
 	if author.AccessLevel < 10 || username == "0000" { // user can get info about himself
-		return c.repo.GetUserInfo(ctx, author.AccessLevel, author.Username)
+		username = author.Username
+	}
+	info, err := c.repo.GetUserInfo(ctx, author.AccessLevel, username)
+	if err != nil || info == nil || info.UserId == "" {
+		return info, err
 	}
-	return c.repo.GetUserInfo(ctx, author.AccessLevel, username)
+	if info.WalletBalance, err = c.repo.GetWalletBalance(ctx, info.UserId); err != nil {
+		c.log.With(sl.Err(err)).Warn("failed to get wallet balance")
+	}
+	if info.Limits, err = c.userLimitUsage(ctx, info.Username, info.Group); err != nil {
+		c.log.With(sl.Err(err)).Warn("failed to get usage limits")
+	}
+	return info, nil
 }
 
 func (c *Core) GetUsers(ctx context.Context, user *entity.User) ([]*entity.User, error) {
@@ -443,10 +412,10 @@ func (c *Core) GetUsers(ctx context.Context, user *entity.User) ([]*entity.User,
 }
 
 func (c *Core) CreateUser(ctx context.Context, author *entity.User, user *entity.User) (*entity.User, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
 		return nil, err
 	}
-	err := c.auth.CreateUser(ctx, user)
+	err := c.auth.CreateUser(ctx, author, user)
 	if err != nil {
 		return nil, err
 	}
@@ -454,10 +423,10 @@ func (c *Core) CreateUser(ctx context.Context, author *entity.User, user *entity
 }
 
 func (c *Core) UpdateUser(ctx context.Context, author *entity.User, username string, updates *entity.UserUpdate) (*entity.User, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
 		return nil, err
 	}
-	updated, err := c.auth.UpdateUser(ctx, username, updates)
+	updated, err := c.auth.UpdateUser(ctx, author, username, updates)
 	if err != nil {
 		return nil, err
 	}
@@ -465,10 +434,10 @@ func (c *Core) UpdateUser(ctx context.Context, author *entity.User, username str
 }
 
 func (c *Core) DeleteUser(ctx context.Context, author *entity.User, username string) error {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
 		return err
 	}
-	return c.auth.DeleteUser(ctx, username)
+	return c.auth.DeleteUser(ctx, author, username)
 }
 
 func (c *Core) UserTag(ctx context.Context, user *entity.User) (string, error) {
@@ -482,35 +451,35 @@ func (c *Core) UserTag(ctx context.Context, user *entity.User) (string, error) {
 }
 
 func (c *Core) ListUserTags(ctx context.Context, author *entity.User) ([]*entity.UserTag, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
 		return nil, err
 	}
 	return c.auth.ListUserTags(ctx)
 }
 
 func (c *Core) GetUserTag(ctx context.Context, author *entity.User, idTag string) (*entity.UserTag, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
 		return nil, err
 	}
 	return c.auth.GetUserTagByIdTag(ctx, idTag)
 }
 
 func (c *Core) CreateUserTag(ctx context.Context, author *entity.User, tag *entity.UserTagCreate) (*entity.UserTag, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
 		return nil, err
 	}
 	return c.auth.CreateUserTag(ctx, tag)
 }
 
 func (c *Core) UpdateUserTag(ctx context.Context, author *entity.User, idTag string, updates *entity.UserTagUpdate) (*entity.UserTag, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
 		return nil, err
 	}
 	return c.auth.UpdateUserTag(ctx, idTag, updates)
 }
 
 func (c *Core) DeleteUserTag(ctx context.Context, author *entity.User, idTag string) error {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
 		return err
 	}
 	return c.auth.DeleteUserTag(ctx, idTag)
@@ -616,7 +585,7 @@ func (c *Core) GetTransactions(ctx context.Context, userId, period string) (any,
 }
 
 func (c *Core) GetFilteredTransactions(ctx context.Context, user *entity.User, filter *entity.TransactionFilter) (any, error) {
-	if !user.IsPowerUser() {
+	if !user.Can(entity.PermissionTransactionsRead) {
 		return nil, fmt.Errorf("access denied: insufficient permissions")
 	}
 	transactions, err := c.repo.GetFilteredTransactions(ctx, filter)
@@ -643,6 +612,9 @@ func (c *Core) GetTransaction(ctx context.Context, userId string, accessLevel, i
 	}
 	state.CheckState()
 	state.MeterValues = NormalizeMeterValues(state.MeterValues, NormalizedMeterValuesLength)
+	if state.Refunds, err = c.repo.GetRefunds(ctx, id); err != nil {
+		c.log.With(slog.Int("transaction_id", id), sl.Err(err)).Warn("failed to get refunds")
+	}
 	return state, nil
 }
 
@@ -714,10 +686,10 @@ type WebOrderRequest struct {
 }
 
 // CreateWebOrder persists a new PaymentOrder (via SetOrder) and returns
-// the signed Redsys TPV Virtual hosted-form payload the browser needs
-// to POST to the Redsys entry URL for the "add card" redirect flow.
+// the signed hosted-form payload the browser needs to POST to the
+// gateway entry URL for the "add card" redirect flow.
 //
-// After the user completes card entry on Redsys, Redsys delivers the
+// After the user completes card entry, the gateway delivers the
 // permanent card token server-to-server to POST /payment/notify, which
 // the existing Notify → processNotifyResponse → processPaymentResponse
 // chain already handles: orders with no TransactionId are treated as
@@ -726,8 +698,8 @@ type WebOrderRequest struct {
 // The Android/native flow does NOT go through this path and keeps
 // using SetOrder directly.
 func (c *Core) CreateWebOrder(ctx context.Context, user *entity.User, order *entity.PaymentOrder, req *WebOrderRequest) (*entity.PaymentOrder, *EntryFormResponse, error) {
-	if c.redsys == nil {
-		return nil, nil, fmt.Errorf("redsys client not configured")
+	if c.gateway == nil {
+		return nil, nil, fmt.Errorf("payment gateway not configured")
 	}
 	if req == nil || req.UrlOk == "" || req.UrlKo == "" {
 		return nil, nil, fmt.Errorf("url_ok and url_ko are required")
@@ -740,7 +712,7 @@ func (c *Core) CreateWebOrder(ctx context.Context, user *entity.User, order *ent
 		return nil, nil, err
 	}
 
-	form, err := c.redsys.BuildEntryForm(EntryFormRequest{
+	form, err := c.gateway.BuildEntryForm(EntryFormRequest{
 		OrderNumber: normalizeOrderNumber(fmt.Sprintf("%d", stored.Order)),
 		Amount:      stored.Amount,
 		Description: stored.Description,
@@ -761,6 +733,11 @@ func (c *Core) SetOrder(ctx context.Context, user *entity.User, order *entity.Pa
 	if order == nil {
 		return nil, fmt.Errorf("order is nil")
 	}
+	if order.Purpose == entity.OrderPurposeTopUp {
+		if err := c.checkTopUp(order); err != nil {
+			return nil, err
+		}
+	}
 	if order.Order == 0 {
 
 		// if payment process was interrupted, find unclosed order and close it
@@ -774,7 +751,11 @@ func (c *Core) SetOrder(ctx context.Context, user *entity.User, order *entity.Pa
 			}
 		}
 
-		order.Order = c.nextOrderNumber(ctx)
+		orderNum, err := c.nextOrderNumber(ctx)
+		if err != nil {
+			return nil, err
+		}
+		order.Order = orderNum
 		order.TimeOpened = time.Now()
 	}
 
@@ -887,7 +868,7 @@ func (c *Core) StationStatusReport(ctx context.Context, user *entity.User, charg
 	return c.reports.StationStatus(ctx, chargePointId)
 }
 
-// CreatePreauthorizationOrder creates a new preauthorization order and performs MIT preauthorization via Redsys
+// CreatePreauthorizationOrder creates a new preauthorization order and performs MIT preauthorization via the payment gateway
 func (c *Core) CreatePreauthorizationOrder(ctx context.Context, user *entity.User, req *entity.PreauthorizationOrderRequest) (*entity.PreauthorizationOrderResponse, error) {
 	if user == nil {
 		return nil, fmt.Errorf("user is nil")
@@ -895,19 +876,13 @@ func (c *Core) CreatePreauthorizationOrder(ctx context.Context, user *entity.Use
 	if req == nil {
 		return nil, fmt.Errorf("request is nil")
 	}
-	if c.redsys == nil {
-		return nil, fmt.Errorf("redsys client not configured")
+	if c.gateway == nil {
+		return nil, fmt.Errorf("payment gateway not configured")
 	}
 
-	// Generate order number based on last order
-	lastOrder, _ := c.repo.GetLastPreauthorizationOrder(ctx)
-	var orderNum int
-	if lastOrder != nil {
-		// Parse last order number and increment
-		_, _ = fmt.Sscanf(lastOrder.OrderNumber, "%d", &orderNum)
-		orderNum++
-	} else {
-		orderNum = 3000 // Start from 3000
+	orderNum, err := c.nextOrderNumber(ctx)
+	if err != nil {
+		return nil, err
 	}
 	orderNumber := fmt.Sprintf("%012d", orderNum)
 
@@ -938,7 +913,7 @@ func (c *Core) CreatePreauthorizationOrder(ctx context.Context, user *entity.Use
 	}
 
 	// Save initial preauthorization record
-	err := c.repo.SavePreauthorization(ctx, preauth)
+	err = c.repo.SavePreauthorization(ctx, preauth)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save preauthorization: %w", err)
 	}
@@ -957,15 +932,15 @@ func (c *Core) CreatePreauthorizationOrder(ctx context.Context, user *entity.Use
 		}
 	}
 
-	// Call Redsys REST API for MIT preauthorization
+	// Call the payment gateway for MIT preauthorization
 	preauthorizeReq := PreauthorizeRequest{
 		OrderNumber: orderNumber,
 		Amount:      req.Amount,
 		CardToken:   req.PaymentMethodId,
-		CofTid:      cofTid,
+		NetworkTxId: cofTid,
 	}
 
-	resp, err := c.redsys.Preauthorize(ctx, preauthorizeReq)
+	resp, err := c.gateway.Preauthorize(ctx, preauthorizeReq)
 	if err != nil {
 		// Update preauthorization with error
 		preauth.Status = entity.PreauthorizationStatusFailed
@@ -985,7 +960,7 @@ func (c *Core) CreatePreauthorizationOrder(ctx context.Context, user *entity.Use
 		}, nil
 	}
 
-	// Update preauthorization based on Redsys response
+	// Update preauthorization based on the gateway response
 	if resp.Success {
 		preauth.Status = entity.PreauthorizationStatusAuthorized
 		preauth.AuthorizationCode = resp.AuthorizationCode
@@ -997,7 +972,7 @@ func (c *Core) CreatePreauthorizationOrder(ctx context.Context, user *entity.Use
 	preauth.UpdatedAt = time.Now()
 
 	if updateErr := c.repo.UpdatePreauthorization(ctx, preauth); updateErr != nil {
-		c.log.With(sl.Err(updateErr)).Error("failed to update preauthorization after Redsys response")
+		c.log.With(sl.Err(updateErr)).Error("failed to update preauthorization after gateway response")
 	}
 
 	response := &entity.PreauthorizationOrderResponse{
@@ -1014,7 +989,7 @@ func (c *Core) CreatePreauthorizationOrder(ctx context.Context, user *entity.Use
 	} else {
 		response.Error = resp.ErrorMessage
 		if response.Error == "" && resp.ErrorCode != "" {
-			response.Error = fmt.Sprintf("Redsys error code: %s", resp.ErrorCode)
+			response.Error = fmt.Sprintf("gateway error code: %s", resp.ErrorCode)
 		}
 	}
 
@@ -1081,14 +1056,14 @@ func (c *Core) GetPreauthorization(ctx context.Context, user *entity.User, trans
 	}
 
 	// Verify user owns this preauthorization or is admin
-	if preauth.UserId != user.UserId && !user.IsPowerUser() {
+	if preauth.UserId != user.UserId && !user.Can(entity.PermissionTransactionsRead) {
 		return nil, fmt.Errorf("access denied: preauthorization belongs to another user")
 	}
 
 	return preauth, nil
 }
 
-// CapturePreauthorization captures a preauthorized amount via Redsys
+// CapturePreauthorization captures a preauthorized amount via the payment gateway
 func (c *Core) CapturePreauthorization(ctx context.Context, user *entity.User, req *entity.CaptureOrderRequest) (*entity.CaptureOrderResponse, error) {
 	if user == nil {
 		return nil, fmt.Errorf("user is nil")
@@ -1096,8 +1071,8 @@ func (c *Core) CapturePreauthorization(ctx context.Context, user *entity.User, r
 	if req == nil {
 		return nil, fmt.Errorf("request is nil")
 	}
-	if c.redsys == nil {
-		return nil, fmt.Errorf("redsys client not configured")
+	if c.gateway == nil {
+		return nil, fmt.Errorf("payment gateway not configured")
 	}
 
 	// Normalize order number to 12-digit format
@@ -1126,14 +1101,14 @@ func (c *Core) CapturePreauthorization(ctx context.Context, user *entity.User, r
 		authCode = preauth.AuthorizationCode
 	}
 
-	// Call Redsys to capture - use normalized order number
+	// Call the gateway to capture - use normalized order number
 	captureReq := CaptureRequest{
 		OrderNumber:       orderNumber,
 		Amount:            req.Amount,
 		AuthorizationCode: authCode,
 	}
 
-	resp, err := c.redsys.Capture(ctx, captureReq)
+	resp, err := c.gateway.Capture(ctx, captureReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to capture: %w", err)
 	}
@@ -1192,7 +1167,7 @@ func (c *Core) UpdatePreauthorization(ctx context.Context, user *entity.User, re
 	}
 
 	// Verify user owns this preauthorization or is admin
-	if preauth.UserId != user.UserId && !user.IsPowerUser() {
+	if preauth.UserId != user.UserId && !user.Can(entity.PermissionTransactionsRead) {
 		return fmt.Errorf("access denied: preauthorization belongs to another user")
 	}
 
@@ -1237,8 +1212,8 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 	log := c.log.With(slog.Int("transaction_id", transactionId))
 	log.Info("pay transaction")
 
-	if c.redsys == nil {
-		return fmt.Errorf("redsys client not configured")
+	if c.gateway == nil {
+		return fmt.Errorf("payment gateway not configured")
 	}
 
 	transaction, err := c.repo.GetTransaction(ctx, transactionId)
@@ -1258,6 +1233,11 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 		log.Warn("transaction amount is zero or already billed")
 		return nil
 	}
+	if transaction.GroupBilling != "" {
+		log.With(slog.String("group", transaction.GroupBilling)).Info("transaction is paid by the group's monthly order")
+		return nil
+	}
+	c.checkTransactionPrice(ctx, transaction)
 
 	// Resolve user tag
 	tag := transaction.UserTag
@@ -1276,6 +1256,27 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 		return fmt.Errorf("empty user id for tag %s", tag.IdTag)
 	}
 
+	// A redeemed promotion is taken off before the order amount is set
+	if amount = c.applyPromotion(ctx, transaction, tag, amount); amount == 0 {
+		log.Info("transaction paid in full by a promotion")
+		return nil
+	}
+
+	// Sessions of groups billed monthly wait for the group's order
+	if group := c.billingGroup(ctx, tag.Username); group != nil {
+		c.deferToGroup(ctx, transaction, tag.Username, group)
+		return nil
+	}
+
+	// A preauthorization held for the session is captured instead
+	if !c.disablePayment && c.settleHold(ctx, transaction, tag, amount) {
+		return nil
+	}
+	// A wallet balance covering the whole amount is used before any card
+	if !c.disablePayment && c.payFromWallet(ctx, transaction, tag, amount) {
+		return nil
+	}
+
 	// Resolve payment method with fallback logic
 	paymentMethod := transaction.PaymentMethod
 	if paymentMethod == nil {
@@ -1312,8 +1313,14 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 		if e := c.repo.DeletePaymentRetry(ctx, transactionId); e != nil {
 			log.With(sl.Err(e)).Error("failed to delete payment retry for expired card")
 		}
-		c.payLog(ctx, "error", "pay",
-			"transaction %d: payment method expired (user %s); retry queue cleared",
+		c.payEvent(ctx, "error", "pay", entity.PaymentEvent{
+			Type:          entity.PaymentEventCardExpired,
+			TransactionId: transactionId,
+			UserId:        tag.UserId,
+			Username:      tag.Username,
+			Amount:        amount,
+			CardHash:      entity.CardHash(paymentMethod.Identifier),
+		}, "transaction %d: payment method expired (user %s); retry queue cleared",
 			transactionId, tag.Username)
 		return fmt.Errorf("payment method expired for transaction %d", transactionId)
 	}
@@ -1338,10 +1345,12 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 
 	// DisablePayment bypass for testing
 	if c.disablePayment {
+		firstBilling := transaction.PaymentBilled == transaction.Discount
 		transaction.PaymentBilled = transaction.PaymentAmount
 		if e := c.repo.UpdateTransactionPayment(ctx, transaction); e != nil {
 			log.With(sl.Err(e)).Error("failed to update transaction")
 		}
+		c.recordUsage(ctx, transaction, tag.Username, amount, firstBilling)
 		log.Info("payment disabled: transaction paid without request")
 		return nil
 	}
@@ -1360,7 +1369,11 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 		TimeOpened:    time.Now(),
 	}
 
-	paymentOrder.Order = c.nextOrderNumber(ctx)
+	paymentOrder.Order, err = c.nextOrderNumber(ctx)
+	if err != nil {
+		log.With(sl.Err(err)).Error("failed to allocate order number")
+		return err
+	}
 
 	if e := c.repo.SavePaymentOrder(ctx, &paymentOrder); e != nil {
 		log.With(sl.Err(e)).Error("failed to save payment order")
@@ -1374,8 +1387,15 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 		sl.Secret("cof_txnid", paymentMethod.CofTid),
 	).Info("sending payment request")
 
-	c.payLog(ctx, "info", "pay",
-		"transaction %d: charging %.2f on order %s (user %s, card %s)",
+	c.payEvent(ctx, "info", "pay", entity.PaymentEvent{
+		Type:          entity.PaymentEventOrderCreated,
+		TransactionId: transactionId,
+		Order:         paymentOrder.Order,
+		UserId:        tag.UserId,
+		Username:      tag.Username,
+		Amount:        amount,
+		CardHash:      entity.CardHash(paymentMethod.Identifier),
+	}, "transaction %d: charging %.2f on order %s (user %s, card %s)",
 		transactionId, float64(amount)/100, orderNumber, tag.Username, paymentMethod.Description)
 
 	// Process payment asynchronously
@@ -1383,7 +1403,7 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 		OrderNumber: orderNumber,
 		Amount:      amount,
 		CardToken:   paymentMethod.Identifier,
-		CofTid:      paymentMethod.CofTid,
+		NetworkTxId: paymentMethod.CofTid,
 	}
 	c.runAsync("processPay", func(ctx context.Context) {
 		c.processPay(ctx, req, paymentOrder.Order)
@@ -1392,15 +1412,14 @@ func (c *Core) PayTransaction(ctx context.Context, transactionId int) error {
 	return nil
 }
 
-// ReturnPayment processes a full refund for a charging transaction.
+// ReturnPayment refunds whatever is left to refund of a transaction's last
+// payment order. The refund is issued under the transaction's lock, so it does
+// not run alongside PayTransaction.
 func (c *Core) ReturnPayment(ctx context.Context, transactionId int) error {
-	mutex := c.lockOrder(transactionId)
-	defer c.unlockOrder(transactionId, mutex)
-
 	log := c.log.With(slog.Int("transaction_id", transactionId))
 
-	if c.redsys == nil {
-		return fmt.Errorf("redsys client not configured")
+	if c.gateway == nil {
+		return fmt.Errorf("payment gateway not configured")
 	}
 
 	transaction, err := c.repo.GetTransaction(ctx, transactionId)
@@ -1415,45 +1434,44 @@ func (c *Core) ReturnPayment(ctx context.Context, transactionId int) error {
 		return fmt.Errorf("transaction %d is not finished", transactionId)
 	}
 
-	amount := transaction.PaymentAmount
-	if amount <= 0 {
-		log.Warn("transaction amount is zero, nothing to refund")
-		return nil
+	order, err := c.repo.GetPaymentOrder(ctx, transaction.PaymentOrder)
+	if err != nil {
+		return fmt.Errorf("get payment order: %w", err)
+	}
+	if order == nil {
+		return fmt.Errorf("payment order %d %w", transaction.PaymentOrder, entity.ErrNotFound)
 	}
 
-	orderNumber := fmt.Sprintf("%d", transaction.PaymentOrder)
-
-	c.payLog(ctx, "info", "refund",
-		"transaction %d: refund requested %.2f on order %s",
-		transactionId, float64(amount)/100, orderNumber)
-
-	refund := RefundRequest{
-		OrderNumber: orderNumber,
-		Amount:      amount,
+	captured, refunded, err := c.refundBalance(ctx, order)
+	if err != nil {
+		return err
+	}
+	amount := captured - refunded
+	if amount <= 0 {
+		log.Warn("nothing left to refund")
+		return nil
 	}
-	c.runAsync("processRefund", func(ctx context.Context) {
-		c.processRefund(ctx, refund, transaction.PaymentOrder)
-	})
 
-	return nil
+	_, err = c.issueRefund(ctx, order, amount, entity.RefundReasonOther, "", refundInitiatorApi)
+	return err
 }
 
-// ReturnByOrder processes a refund for a specific payment order.
+// ReturnByOrder refunds part of a specific payment order.
 func (c *Core) ReturnByOrder(ctx context.Context, orderId string, amount int) error {
 	if amount == 0 {
 		return fmt.Errorf("amount to return is zero")
 	}
+	return c.returnByOrder(ctx, orderId, amount, entity.RefundReasonOther, refundInitiatorApi)
+}
 
+func (c *Core) returnByOrder(ctx context.Context, orderId string, amount int, reason, initiator string) error {
 	id, err := strconv.Atoi(orderId)
 	if err != nil {
 		return fmt.Errorf("invalid order id: %s", orderId)
 	}
 
-	mutex := c.lockOrder(id)
-	defer c.unlockOrder(id, mutex)
-
-	if c.redsys == nil {
-		return fmt.Errorf("redsys client not configured")
+	if c.gateway == nil {
+		return fmt.Errorf("payment gateway not configured")
 	}
 
 	order, err := c.repo.GetPaymentOrder(ctx, id)
@@ -1463,55 +1481,27 @@ func (c *Core) ReturnByOrder(ctx context.Context, orderId string, amount int) er
 	if order == nil {
 		return fmt.Errorf("payment order %d %w", id, entity.ErrNotFound)
 	}
-	if order.Amount < amount {
-		return fmt.Errorf("order amount %d is less than return amount %d", order.Amount, amount)
-	}
-
-	c.payLog(ctx, "info", "refund",
-		"order %s: refund requested %.2f", orderId, float64(amount)/100)
 
-	refund := RefundRequest{
-		OrderNumber: orderId,
-		Amount:      amount,
-	}
-	c.runAsync("processRefund", func(ctx context.Context) {
-		c.processRefund(ctx, refund, id)
-	})
-
-	return nil
+	_, err = c.issueRefund(ctx, order, amount, reason, "", initiator)
+	return err
 }
 
-// Notify processes a payment notification webhook from Redsys.
+// Notify processes a payment notification webhook from the payment gateway.
 func (c *Core) Notify(ctx context.Context, data []byte) error {
-	params, err := url.ParseQuery(string(data))
+	// The endpoint is public: nothing but the gateway's signature separates a
+	// genuine callback from a forged one, and the payload enrolls card tokens
+	// and closes orders. Reject anything that does not verify.
+	if c.gateway == nil {
+		c.log.Error("notification rejected: payment gateway not configured")
+		return fmt.Errorf("payment gateway not configured")
+	}
+	paymentResult, err := c.gateway.ParseNotification(data)
 	if err != nil {
-		c.log.With(slog.String("data", string(data))).Error("failed to parse notification data")
-		return fmt.Errorf("parse query: %w", err)
-	}
-
-	merchantParams := params.Get("Ds_MerchantParameters")
-	if merchantParams == "" {
-		return fmt.Errorf("empty merchant parameters")
-	}
-
-	paymentResult, err := c.decodePaymentParameters(merchantParams)
-	if err != nil {
-		return err
-	}
-
-	// The endpoint is public: nothing but the signature separates a genuine
-	// Redsys callback from a forged one, and the payload below enrolls card
-	// tokens and closes orders. Reject anything that does not verify.
-	if c.redsys == nil {
-		c.log.Error("notification rejected: redsys client not configured")
-		return fmt.Errorf("redsys client not configured")
-	}
-	if err = c.redsys.VerifyNotification(merchantParams, params.Get("Ds_Signature"), paymentResult.Order); err != nil {
 		c.log.With(
-			slog.String("order", paymentResult.Order),
+			slog.String("data", string(data)),
 			sl.Err(err),
-		).Error("notification signature verification failed")
-		return fmt.Errorf("verify notification: %w", err)
+		).Error("notification rejected")
+		return fmt.Errorf("parse notification: %w", err)
 	}
 
 	c.runAsync("processNotifyResponse", func(ctx context.Context) {
@@ -1520,11 +1510,11 @@ func (c *Core) Notify(ctx context.Context, data []byte) error {
 	return nil
 }
 
-// processPay sends a payment request to Redsys and processes the response.
+// processPay sends a payment request to the gateway and processes the response.
 func (c *Core) processPay(ctx context.Context, req PayRequest, orderId int) {
 	log := c.log.With(slog.String("order", req.OrderNumber), slog.Int("amount", req.Amount))
 
-	resp, err := c.redsys.Pay(ctx, req)
+	resp, err := c.gateway.Pay(ctx, req)
 	if err != nil {
 		log.With(sl.Err(err)).Error("payment request failed")
 		order, _ := c.repo.GetPaymentOrder(ctx, orderId)
@@ -1535,29 +1525,7 @@ func (c *Core) processPay(ctx context.Context, req PayRequest, orderId int) {
 	}
 
 	if !resp.Success {
-		log.With(slog.String("error_code", resp.ErrorCode)).Warn("payment rejected by Redsys")
-		order, _ := c.repo.GetPaymentOrder(ctx, orderId)
-		if order != nil {
-			c.closeOrderOnError(ctx, order, resultCode(resp))
-		}
-		return
-	}
-
-	c.processPaymentResponse(ctx, resp, orderId)
-}
-
-// processRefund sends a refund request to Redsys and processes the response.
-func (c *Core) processRefund(ctx context.Context, req RefundRequest, orderId int) {
-	log := c.log.With(slog.String("order", req.OrderNumber), slog.Int("amount", req.Amount))
-
-	resp, err := c.redsys.Refund(ctx, req)
-	if err != nil {
-		log.With(sl.Err(err)).Error("refund request failed")
-		return
-	}
-
-	if !resp.Success {
-		log.With(slog.String("error_code", resp.ErrorCode)).Warn("refund rejected by Redsys")
+		log.With(slog.String("error_code", resp.ErrorCode)).Warn("payment rejected by gateway")
 		order, _ := c.repo.GetPaymentOrder(ctx, orderId)
 		if order != nil {
 			c.closeOrderOnError(ctx, order, resultCode(resp))
@@ -1568,8 +1536,9 @@ func (c *Core) processRefund(ctx context.Context, req RefundRequest, orderId int
 	c.processPaymentResponse(ctx, resp, orderId)
 }
 
-// processNotifyResponse handles a Redsys webhook notification.
+// processNotifyResponse handles a gateway webhook notification.
 func (c *Core) processNotifyResponse(ctx context.Context, paymentResult *entity.PaymentParameters) {
+	paymentResult.ReceivedAt = time.Now()
 	if e := c.repo.SavePaymentResult(ctx, paymentResult); e != nil {
 		c.log.With(sl.Err(e)).Error("failed to save payment result")
 	}
@@ -1582,20 +1551,25 @@ func (c *Core) processNotifyResponse(ctx context.Context, paymentResult *entity.
 
 	// Convert PaymentParameters to a CaptureResponse-like structure for processPaymentResponse
 	resp := &CaptureResponse{
-		Success:            paymentResult.IsApproved(),
-		ResponseCode:       paymentResult.Response,
-		AuthorizationCode:  paymentResult.AuthorisationCode,
-		MerchantIdentifier: paymentResult.MerchantIdentifier,
-		CofTxnid:           paymentResult.MerchantCofTxnid,
-		CardBrand:          paymentResult.CardBrand,
-		CardCountry:        paymentResult.CardCountry,
-		ExpiryDate:         paymentResult.ExpiryDate,
-		Order:              paymentResult.Order,
-		Amount:             paymentResult.Amount,
-		Currency:           paymentResult.Currency,
-		TransactionType:    paymentResult.TransactionType,
-		Date:               paymentResult.Date,
-		Hour:               paymentResult.Hour,
+		Success:           paymentResult.IsApproved(),
+		ResponseCode:      paymentResult.Response,
+		AuthorizationCode: paymentResult.AuthorisationCode,
+		CardToken:         paymentResult.MerchantIdentifier,
+		NetworkTxId:       paymentResult.MerchantCofTxnid,
+		CardBrand:         paymentResult.CardBrand,
+		CardCountry:       paymentResult.CardCountry,
+		ExpiryDate:        paymentResult.ExpiryDate,
+		Order:             paymentResult.Order,
+		Amount:            paymentResult.Amount,
+		Currency:          paymentResult.Currency,
+		TransactionType:   paymentResult.TransactionType,
+		Date:              paymentResult.Date,
+		Hour:              paymentResult.Hour,
+	}
+
+	if resp.TransactionType == entity.RedsysTxRefund {
+		c.processRefundNotification(ctx, resp, orderNum)
+		return
 	}
 
 	if !resp.Success {
@@ -1609,7 +1583,7 @@ func (c *Core) processNotifyResponse(ctx context.Context, paymentResult *entity.
 	c.processPaymentResponse(ctx, resp, orderNum)
 }
 
-// processPaymentResponse handles a successful Redsys response: updates order, transaction billing, and payment methods.
+// processPaymentResponse handles a successful gateway response: updates order, transaction billing, and payment methods.
 func (c *Core) processPaymentResponse(ctx context.Context, resp *CaptureResponse, orderNum int) {
 	log := c.log.With(slog.String("order", fmt.Sprintf("%d", orderNum)))
 
@@ -1626,7 +1600,8 @@ func (c *Core) processPaymentResponse(ctx context.Context, resp *CaptureResponse
 	}
 
 	// Close the order
-	if !order.IsCompleted {
+	wasOpen := !order.IsCompleted
+	if wasOpen {
 		order.Amount = amount
 		order.IsCompleted = true
 		order.Result = resp.ResponseCode
@@ -1642,19 +1617,6 @@ func (c *Core) processPaymentResponse(ctx context.Context, resp *CaptureResponse
 	// Reset fail counter on success
 	c.updatePaymentMethodFailCounter(ctx, order.Identifier, 0)
 
-	// Handle refund response (transaction type "3")
-	if resp.TransactionType == "3" {
-		order.RefundAmount = amount
-		order.RefundTime = time.Now()
-		if e := c.repo.SavePaymentOrder(ctx, order); e != nil {
-			log.With(sl.Err(e)).Error("failed to save refund order")
-		}
-		c.payLog(ctx, "info", "refund",
-			"order %d: refunded %.2f (user %s)",
-			order.Order, float64(amount)/100, order.UserName)
-		return
-	}
-
 	// Update transaction billing
 	if order.TransactionId > 0 {
 		transaction, e := c.repo.GetTransaction(ctx, order.TransactionId)
@@ -1667,6 +1629,7 @@ func (c *Core) processPaymentResponse(ctx context.Context, resp *CaptureResponse
 			return
 		}
 
+		firstBilling := transaction.PaymentBilled == transaction.Discount
 		transaction.PaymentOrder = order.Order
 		transaction.PaymentBilled = transaction.PaymentBilled + order.Amount
 		transaction.PaymentError = ""
@@ -1680,15 +1643,38 @@ func (c *Core) processPaymentResponse(ctx context.Context, resp *CaptureResponse
 			log.With(sl.Err(e)).Error("failed to delete payment retry record")
 		}
 
-		c.payLog(ctx, "info", "pay",
-			"transaction %d: payment captured %.2f on order %d (user %s)",
+		c.payEvent(ctx, "info", "pay", entity.PaymentEvent{
+			Type:          entity.PaymentEventPaid,
+			TransactionId: order.TransactionId,
+			Order:         order.Order,
+			UserId:        order.UserId,
+			Username:      order.UserName,
+			Amount:        order.Amount,
+			CardHash:      entity.CardHash(order.Identifier),
+			Code:          resp.ResponseCode,
+		}, "transaction %d: payment captured %.2f on order %d (user %s)",
 			order.TransactionId, float64(order.Amount)/100, order.Order, order.UserName)
+
+		// a repeated notification must not count the payment twice
+		if wasOpen {
+			c.recordUsage(ctx, transaction, order.UserName, order.Amount, firstBilling)
+		}
+		c.invoicePayment(ctx, transaction, order)
+	} else if order.Purpose == entity.OrderPurposeTopUp {
+		// a repeated notification must not credit the top-up twice
+		if wasOpen {
+			c.topUpWallet(ctx, order)
+		}
+	} else if order.Purpose == entity.OrderPurposeGroupBilling {
+		if wasOpen {
+			c.settleGroupOrder(ctx, order)
+		}
 	} else {
 		// No transaction linked — this is a card enrollment response; save payment method
 		pm := entity.PaymentMethod{
 			Description: "**** **** **** ****",
-			Identifier:  resp.MerchantIdentifier,
-			CofTid:      resp.CofTxnid,
+			Identifier:  resp.CardToken,
+			CofTid:      resp.NetworkTxId,
 			CardBrand:   resp.CardBrand,
 			CardCountry: resp.CardCountry,
 			ExpiryDate:  resp.ExpiryDate,
@@ -1710,7 +1696,7 @@ func (c *Core) processPaymentResponse(ctx context.Context, resp *CaptureResponse
 		// Refund the enrollment charge
 		if order.Amount > 0 {
 			id := fmt.Sprintf("%d", order.Order)
-			if e := c.ReturnByOrder(ctx, id, order.Amount); e != nil {
+			if e := c.returnByOrder(ctx, id, order.Amount, entity.RefundReasonCardVerification, refundInitiatorSystem); e != nil {
 				log.With(sl.Err(e)).Error("failed to refund enrollment charge")
 			}
 		}
@@ -1751,6 +1737,7 @@ func (c *Core) closeOrderOnError(ctx context.Context, order *entity.PaymentOrder
 		}
 	}
 
+	class := entity.ClassifyPaymentError(result)
 	warning := entity.PaymentWarning{
 		TransactionId: order.TransactionId,
 		OrderNumber:   order.Order,
@@ -1758,10 +1745,15 @@ func (c *Core) closeOrderOnError(ctx context.Context, order *entity.PaymentOrder
 		Amount:        order.Amount,
 		Currency:      order.Currency,
 		Result:        result,
-		MaxAttempts:   maxRetryAttempts,
+		MaxAttempts:   len(c.retrySchedule(class)),
+		DeclineClass:  class,
 		OccurredAt:    time.Now(),
 	}
 
+	if order.Purpose == entity.OrderPurposeGroupBilling {
+		c.failGroupOrder(ctx, order, result)
+	}
+
 	if order.TransactionId > 0 {
 		c.log.With(slog.Int("transaction_id", order.TransactionId)).Info("closing transaction on payment error")
 		transaction, e := c.repo.GetTransaction(ctx, order.TransactionId)
@@ -1781,17 +1773,30 @@ func (c *Core) closeOrderOnError(ctx context.Context, order *entity.PaymentOrder
 			c.schedulePaymentRetry(ctx, order.TransactionId, result)
 
 			warning.ChargePointId = transaction.ChargePointId
+			event := entity.PaymentEvent{
+				Type:          entity.PaymentEventDeclined,
+				TransactionId: order.TransactionId,
+				Order:         order.Order,
+				UserId:        order.UserId,
+				Username:      order.UserName,
+				Amount:        order.Amount,
+				CardHash:      entity.CardHash(order.Identifier),
+				Code:          result,
+			}
+			c.payEvent(ctx, "error", "pay", event,
+				"transaction %d: payment failed on order %d (%s, %s decline)",
+				order.TransactionId, order.Order, result, class)
 			if retry, _ := c.repo.GetPaymentRetry(ctx, order.TransactionId); retry != nil {
 				warning.Attempt = retry.Attempt
-				c.payLog(ctx, "error", "pay",
-					"transaction %d: payment failed on order %d (%s); next retry attempt %d at %s",
-					order.TransactionId, order.Order, result, retry.Attempt,
-					retry.NextRetryTime.Format(time.RFC3339))
-			} else {
+				event.Type = entity.PaymentEventRetryScheduled
+				c.payEvent(ctx, "info", "retry", event,
+					"transaction %d: next retry attempt %d at %s",
+					order.TransactionId, retry.Attempt, retry.NextRetryTime.Format(time.RFC3339))
+			} else if class != entity.DeclineClassHard {
 				warning.Exhausted = true
-				c.payLog(ctx, "error", "pay",
-					"transaction %d: payment failed on order %d (%s); retries exhausted",
-					order.TransactionId, order.Order, result)
+				event.Type = entity.PaymentEventRetriesExhausted
+				c.payEvent(ctx, "error", "retry", event,
+					"transaction %d: retries exhausted", order.TransactionId)
 			}
 		}
 	}
@@ -1837,7 +1842,9 @@ func (c *Core) dispatchPaymentWarning(ctx context.Context, w entity.PaymentWarni
 // validateStartTransactionPaymentMethod ensures the user has a usable payment
 // method (fail_count == 0) before a remote start is dispatched. If the request
 // names a specific PaymentMethodId, that method must belong to the user and be
-// usable; otherwise at least one usable method must exist.
+// usable; otherwise a sufficient wallet balance or at least one usable method
+// must exist. The "wallet" identifier selects the wallet balance. A user who,
+// or whose group, has used up a usage limit cannot start at all.
 func (c *Core) validateStartTransactionPaymentMethod(ctx context.Context, request *entity.UserRequest) error {
 	tag, err := c.repo.GetUserTag(ctx, request.Token)
 	if err != nil {
@@ -1846,6 +1853,22 @@ func (c *Core) validateStartTransactionPaymentMethod(ctx context.Context, reques
 	if tag.UserId == "" {
 		return fmt.Errorf("user tag %s has no user id", tag.IdTag)
 	}
+	if err = c.checkUsageLimits(ctx, tag.Username); err != nil {
+		return err
+	}
+
+	switch request.PaymentMethodId {
+	case entity.WalletIdentifier:
+		if c.walletCoversStart(ctx, tag.UserId) {
+			return nil
+		}
+		return fmt.Errorf("wallet balance is below %.2f; top up or choose a card",
+			float64(c.walletConfig().MinStartBalance)/100)
+	case "":
+		if c.walletCoversStart(ctx, tag.UserId) {
+			return nil
+		}
+	}
 
 	methods, err := c.repo.GetPaymentMethods(ctx, tag.UserId)
 	if err != nil {
@@ -1899,21 +1922,11 @@ func (c *Core) updatePaymentMethodFailCounter(ctx context.Context, identifier st
 	}
 }
 
-// decodePaymentParameters decodes base64-encoded Redsys merchant parameters.
-func (c *Core) decodePaymentParameters(encodedParams string) (*entity.PaymentParameters, error) {
-	decoded, err := base64.StdEncoding.DecodeString(encodedParams)
-	if err != nil {
-		return nil, fmt.Errorf("decode parameters: %w", err)
-	}
-	var result entity.PaymentParameters
-	if err := json.Unmarshal(decoded, &result); err != nil {
-		return nil, fmt.Errorf("parse parameters: %w", err)
-	}
-	return &result, nil
-}
-
 // StartPaymentProcessor launches a background goroutine that periodically checks for
-// unbilled transactions and processes payment retries.
+// unbilled transactions, processes payment retries, sweeps preauthorizations,
+// reconciles the previous day's payments, stops sessions at usage limits,
+// bills the previous month of groups billed monthly and reminds users of
+// saved cards that expire soon or were declined.
 func (c *Core) StartPaymentProcessor() {
 	c.stopPaymentProcessor = make(chan struct{})
 	go func() {
@@ -1925,6 +1938,11 @@ func (c *Core) StartPaymentProcessor() {
 				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 				c.processUnbilledTransactions(ctx)
 				c.processPaymentRetries(ctx)
+				c.processPreauthorizations(ctx)
+				c.processReconciliation(ctx)
+				c.processUsageLimits(ctx)
+				c.processGroupBilling(ctx)
+				c.processCardNotices(ctx)
 				cancel()
 			case <-c.stopPaymentProcessor:
 				return
@@ -1944,7 +1962,7 @@ func (c *Core) StopPaymentProcessor() {
 
 // processUnbilledTransactions finds all unbilled transactions and initiates payment for each.
 func (c *Core) processUnbilledTransactions(ctx context.Context) {
-	if c.redsys == nil {
+	if c.gateway == nil {
 		return
 	}
 
@@ -1973,7 +1991,7 @@ func (c *Core) processUnbilledTransactions(ctx context.Context) {
 
 // processPaymentRetries finds pending retry records and re-attempts payment.
 func (c *Core) processPaymentRetries(ctx context.Context) {
-	if c.redsys == nil {
+	if c.gateway == nil {
 		return
 	}
 
@@ -2097,7 +2115,8 @@ func (c *Core) retryOne(ctx context.Context, transactionId int, attempt int) err
 		return fmt.Errorf("transaction %d %w", transactionId, entity.ErrNotFound)
 	}
 
-	transaction.PaymentBilled = 0
+	// a promotion's discount stays billed
+	transaction.PaymentBilled = transaction.Discount
 	transaction.PaymentError = ""
 	if e := c.repo.UpdateTransactionPayment(ctx, transaction); e != nil {
 		log.With(sl.Err(e)).Error("failed to reset transaction for retry")
@@ -2105,8 +2124,11 @@ func (c *Core) retryOne(ctx context.Context, transactionId int, attempt int) err
 	}
 
 	log.Info("retrying payment")
-	c.payLog(ctx, "info", "retry",
-		"transaction %d: retry attempt %d starting", transactionId, attempt)
+	c.payEvent(ctx, "info", "retry", entity.PaymentEvent{
+		Type:          entity.PaymentEventRetryStarted,
+		TransactionId: transactionId,
+		Amount:        transaction.PaymentAmount - transaction.Discount,
+	}, "transaction %d: retry attempt %d starting", transactionId, attempt)
 	if e := c.PayTransaction(ctx, transactionId); e != nil {
 		log.With(sl.Err(e)).Warn("payment retry failed")
 		return e
@@ -2117,10 +2139,10 @@ func (c *Core) retryOne(ctx context.Context, transactionId int, attempt int) err
 // ForcePaymentRetry triggers an immediate retry of the payment for the given
 // transaction, bypassing the scheduled next_retry_time. Power user only.
 func (c *Core) ForcePaymentRetry(ctx context.Context, author *entity.User, transactionId int) error {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionPaymentsWrite); err != nil {
 		return err
 	}
-	if c.redsys == nil {
+	if c.gateway == nil {
 		return fmt.Errorf("payment provider not configured")
 	}
 
@@ -2145,10 +2167,20 @@ func (c *Core) ForcePaymentRetry(ctx context.Context, author *entity.User, trans
 	return c.retryOne(ctx, transactionId, attempt)
 }
 
-// schedulePaymentRetry creates or updates a retry record for a failed payment.
+// schedulePaymentRetry creates or updates a retry record for a failed payment,
+// following the retry schedule of the error's decline class. Hard declines and
+// exhausted schedules drop the record instead.
 func (c *Core) schedulePaymentRetry(ctx context.Context, transactionId int, lastError string) {
 	log := c.log.With(slog.Int("transaction_id", transactionId))
 
+	class := entity.ClassifyPaymentError(lastError)
+	if class == entity.DeclineClassHard {
+		log.With(slog.String("result", lastError)).Warn("hard decline, payment not retried")
+		_ = c.repo.DeletePaymentRetry(ctx, transactionId)
+		return
+	}
+	schedule := c.retrySchedule(class)
+
 	existing, _ := c.repo.GetPaymentRetry(ctx, transactionId)
 
 	var attempt int
@@ -2158,7 +2190,7 @@ func (c *Core) schedulePaymentRetry(ctx context.Context, transactionId int, last
 		attempt = 1
 	}
 
-	if attempt > maxRetryAttempts {
+	if attempt > len(schedule) {
 		log.With(slog.Int("attempts", attempt-1)).Warn("payment retries exhausted")
 		_ = c.repo.DeletePaymentRetry(ctx, transactionId)
 		return
@@ -2168,8 +2200,9 @@ func (c *Core) schedulePaymentRetry(ctx context.Context, transactionId int, last
 	retry := &entity.PaymentRetry{
 		TransactionId: transactionId,
 		Attempt:       attempt,
-		NextRetryTime: now.Add(retryDelays[attempt-1]),
+		NextRetryTime: now.Add(schedule[attempt-1]),
 		LastError:     lastError,
+		DeclineClass:  class,
 		UpdatedAt:     now,
 	}
 	if existing == nil {
@@ -2185,13 +2218,14 @@ func (c *Core) schedulePaymentRetry(ctx context.Context, transactionId int, last
 
 	log.With(
 		slog.Int("attempt", attempt),
+		slog.String("class", class),
 		slog.Time("next_retry", retry.NextRetryTime),
 	).Info("payment retry scheduled")
 }
 
 // ListWebhookSubscribers returns all webhook subscribers (admin only).
 func (c *Core) ListWebhookSubscribers(ctx context.Context, author *entity.User) ([]*entity.WebhookSubscriber, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionWebhooksWrite); err != nil {
 		return nil, err
 	}
 	return c.repo.ListWebhookSubscribers(ctx)
@@ -2199,7 +2233,7 @@ func (c *Core) ListWebhookSubscribers(ctx context.Context, author *entity.User)
 
 // SaveWebhookSubscriber creates or updates a webhook subscriber (admin only).
 func (c *Core) SaveWebhookSubscriber(ctx context.Context, author *entity.User, sub *entity.WebhookSubscriber) (*entity.WebhookSubscriber, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionWebhooksWrite); err != nil {
 		return nil, err
 	}
 	return c.repo.SaveWebhookSubscriber(ctx, sub)
@@ -2207,7 +2241,7 @@ func (c *Core) SaveWebhookSubscriber(ctx context.Context, author *entity.User, s
 
 // DeleteWebhookSubscriber removes a webhook subscriber (admin only).
 func (c *Core) DeleteWebhookSubscriber(ctx context.Context, author *entity.User, id string) error {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionWebhooksWrite); err != nil {
 		return err
 	}
 	return c.repo.DeleteWebhookSubscriber(ctx, id)
@@ -2218,7 +2252,7 @@ func (c *Core) DeleteWebhookSubscriber(ctx context.Context, author *entity.User,
 // zero counters; outbox rows whose subscriber config was deleted are still listed,
 // marked as not configured.
 func (c *Core) GetWebhookHealth(ctx context.Context, author *entity.User) ([]*entity.WebhookHealthView, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionWebhooksWrite); err != nil {
 		return nil, err
 	}
 	subscribers, err := c.repo.ListWebhookSubscribers(ctx)
@@ -2267,7 +2301,7 @@ func (c *Core) GetWebhookHealth(ctx context.Context, author *entity.User) ([]*en
 
 // ListWebhookFailures returns recent failed or retrying deliveries (admin only).
 func (c *Core) ListWebhookFailures(ctx context.Context, author *entity.User) ([]*entity.WebhookDeliveryView, error) {
-	if err := c.requirePowerUser(author); err != nil {
+	if err := c.requirePermission(author, entity.PermissionWebhooksWrite); err != nil {
 		return nil, err
 	}
 	return c.repo.ListWebhookProblemDeliveries(ctx, 100)
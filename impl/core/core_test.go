@@ -6,6 +6,7 @@ import (
 	database_mock "evsys-back/impl/database-mock"
 	"log/slog"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -328,6 +329,49 @@ func TestSetOrder(t *testing.T) {
 	}
 }
 
+func TestNextOrderNumber_Concurrent(t *testing.T) {
+	const (
+		instances = 4 // backend replicas sharing the database
+		workers   = 25
+		perWorker = 20
+	)
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	db.SeedPaymentOrder(&entity.PaymentOrder{Order: 1500})
+	require.NoError(t, db.SavePreauthorization(ctx, &entity.Preauthorization{OrderNumber: "000000001600"}))
+
+	cores := make([]*Core, instances)
+	for i := range cores {
+		cores[i] = New(newTestLogger(), db)
+	}
+
+	var (
+		mux  sync.Mutex
+		seen = make(map[int]int)
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < instances*workers; w++ {
+		wg.Add(1)
+		go func(c *Core) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				next, err := c.nextOrderNumber(ctx)
+				assert.NoError(t, err)
+				mux.Lock()
+				seen[next]++
+				mux.Unlock()
+			}
+		}(cores[w%instances])
+	}
+	wg.Wait()
+
+	total := instances * workers * perWorker
+	require.Len(t, seen, total, "every number is allocated once")
+	for n := 1601; n <= 1600+total; n++ {
+		assert.Equal(t, 1, seen[n], "order %d", n)
+	}
+}
+
 // --- Transaction Tests ---
 
 func TestGetActiveTransactions(t *testing.T) {
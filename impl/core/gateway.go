@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+)
+
+// PaymentGateway is the card acquirer the payment flow runs against: Redsys in
+// production, the built-in fake gateway in local environments. Requests carry
+// amounts in minor units and the stored card credentials; response codes
+// follow the 4-digit authorization response convention the payment orders
+// store (see entity.IsRedsysApproved).
+type PaymentGateway interface {
+	Capture(ctx context.Context, req CaptureRequest) (*CaptureResponse, error)
+	Cancel(ctx context.Context, req CaptureRequest) (*CaptureResponse, error)
+	Preauthorize(ctx context.Context, req PreauthorizeRequest) (*CaptureResponse, error)
+	Pay(ctx context.Context, req PayRequest) (*CaptureResponse, error)
+	Refund(ctx context.Context, req RefundRequest) (*CaptureResponse, error)
+	// BuildEntryForm prepares the hosted card-entry form for the web "add
+	// card" redirect flow. Used by POST /payment/order when mode == "web".
+	BuildEntryForm(req EntryFormRequest) (*EntryFormResponse, error)
+	// ParseNotification authenticates and decodes the body of an
+	// asynchronous notification posted to /payment/notify. It must reject
+	// anything the gateway did not sign: the endpoint is public and the
+	// notification enrolls card tokens and closes orders.
+	ParseNotification(data []byte) (*entity.PaymentParameters, error)
+}
+
+// EntryFormRequest asks the gateway for a hosted-form payload. UrlOk / UrlKo
+// come from the browser (so the same backend can serve multiple frontends /
+// environments).
+type EntryFormRequest struct {
+	OrderNumber string
+	Amount      int
+	Description string
+	UrlOk       string
+	UrlKo       string
+	Language    string
+}
+
+// EntryFormResponse is what the frontend needs to auto-submit an HTML
+// form to the gateway entry point.
+type EntryFormResponse struct {
+	FormUrl            string
+	SignatureVersion   string
+	MerchantParameters string
+	Signature          string
+}
+
+// CaptureRequest for capture and cancel operations on a preauthorization
+type CaptureRequest struct {
+	OrderNumber       string
+	Amount            int
+	AuthorizationCode string
+}
+
+// CaptureResponse from gateway operations
+type CaptureResponse struct {
+	Success           bool
+	ResponseCode      string
+	AuthorizationCode string
+	ErrorCode         string
+	ErrorMessage      string
+	// Extended fields populated by Pay/Refund for payment processing
+	CardToken       string
+	NetworkTxId     string
+	CardBrand       string
+	CardCountry     string
+	ExpiryDate      string
+	Order           string
+	Amount          string
+	Currency        string
+	TransactionType string
+	Date            string
+	Hour            string
+}
+
+// PreauthorizeRequest for a merchant-initiated preauthorization
+type PreauthorizeRequest struct {
+	OrderNumber string
+	Amount      int
+	CardToken   string
+	NetworkTxId string // network transaction ID from the initial authorization
+}
+
+// PayRequest for a merchant-initiated direct payment with a stored card
+type PayRequest struct {
+	OrderNumber string
+	Amount      int
+	CardToken   string
+	NetworkTxId string
+}
+
+// RefundRequest for a refund of a captured payment
+type RefundRequest struct {
+	OrderNumber string
+	Amount      int
+}
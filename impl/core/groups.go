@@ -0,0 +1,389 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// groupBillingRetryDelay is how long a group statement whose payment
+	// failed waits before the monthly order is attempted again.
+	groupBillingRetryDelay = 24 * time.Hour
+	// groupBillingInterval is how often the groups are checked for a month
+	// to bill.
+	groupBillingInterval = time.Hour
+)
+
+// ListUserGroups returns all user groups (admin only).
+func (c *Core) ListUserGroups(ctx context.Context, author *entity.User) ([]*entity.UserGroup, error) {
+	if err := c.requirePermission(author, entity.PermissionGroupsWrite); err != nil {
+		return nil, err
+	}
+	return c.repo.ListUserGroups(ctx)
+}
+
+// SaveUserGroup creates or updates a user group (admin only). A group billed
+// monthly needs a billing user with a user id, whose payment method pays it.
+func (c *Core) SaveUserGroup(ctx context.Context, author *entity.User, group *entity.UserGroup) (*entity.UserGroup, error) {
+	if err := c.requirePermission(author, entity.PermissionGroupsWrite); err != nil {
+		return nil, err
+	}
+	if group.BillingMode == "" {
+		group.BillingMode = entity.BillingModeSession
+	}
+	if group.BillingUser != "" {
+		info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, group.BillingUser)
+		if err != nil || info == nil || info.UserId == "" {
+			return nil, fmt.Errorf("billing user %s %w", group.BillingUser, entity.ErrNotFound)
+		}
+	}
+	group.UpdatedAt = time.Now()
+	if err := c.repo.SaveUserGroup(ctx, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// ListGroupStatements returns the monthly statements of a group (admin only).
+func (c *Core) ListGroupStatements(ctx context.Context, author *entity.User, group string) ([]*entity.GroupStatement, error) {
+	if err := c.requirePermission(author, entity.PermissionGroupsWrite); err != nil {
+		return nil, err
+	}
+	statements, err := c.repo.GetGroupStatements(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	if statements == nil {
+		statements = make([]*entity.GroupStatement, 0)
+	}
+	return statements, nil
+}
+
+// GetGroupStatement returns one statement with its per-driver breakdown
+// (admin only).
+func (c *Core) GetGroupStatement(ctx context.Context, author *entity.User, id string) (*entity.GroupStatement, error) {
+	if err := c.requirePermission(author, entity.PermissionGroupsWrite); err != nil {
+		return nil, err
+	}
+	statement, err := c.repo.GetGroupStatement(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if statement == nil {
+		return nil, fmt.Errorf("statement %w", entity.ErrNotFound)
+	}
+	return statement, nil
+}
+
+// BillGroup bills the sessions of a group waiting for the monthly order of
+// the given month (2006-01) right away, e.g. after the billing account's card
+// was replaced (admin only). A statement already pending or paid is returned
+// as is.
+func (c *Core) BillGroup(ctx context.Context, author *entity.User, name, period string) (*entity.GroupStatement, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsWrite); err != nil {
+		return nil, err
+	}
+	if c.gateway == nil {
+		return nil, fmt.Errorf("payment gateway not configured")
+	}
+	month, err := time.ParseInLocation("2006-01", period, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid period %q: %w", period, err)
+	}
+	group, err := c.repo.GetUserGroup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("group %s %w", name, entity.ErrNotFound)
+	}
+	statement, err := c.billGroup(ctx, group, period, month.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	if statement == nil {
+		return nil, fmt.Errorf("no sessions to bill for group %s in %s", name, period)
+	}
+	return statement, nil
+}
+
+// billingGroup returns the group whose monthly order pays the sessions of a
+// user, or nil when the user's sessions are charged one by one.
+func (c *Core) billingGroup(ctx context.Context, username string) *entity.UserGroup {
+	if username == "" {
+		return nil
+	}
+	info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, username)
+	if err != nil || info == nil || info.Group == "" {
+		return nil
+	}
+	group, err := c.repo.GetUserGroup(ctx, info.Group)
+	if err != nil || group == nil {
+		return nil
+	}
+	mode := info.BillingMode
+	if mode == "" {
+		mode = group.BillingMode
+	}
+	if mode != entity.BillingModeMonthly || group.BillingUser == "" {
+		return nil
+	}
+	return group
+}
+
+// deferToGroup marks a finished session as paid by its group's monthly
+// order, so it is left out of the per-session billing. Its consumption
+// counts towards usage limits right away.
+func (c *Core) deferToGroup(ctx context.Context, transaction *entity.Transaction, username string, group *entity.UserGroup) {
+	transaction.GroupBilling = group.Name
+	if err := c.repo.UpdateTransactionPayment(ctx, transaction); err != nil {
+		c.log.With(slog.Int("transaction_id", transaction.TransactionId), sl.Err(err)).
+			Error("failed to defer transaction to group billing")
+		return
+	}
+	c.recordUsage(ctx, transaction, username, transaction.PaymentAmount-transaction.PaymentBilled, transaction.PaymentBilled == transaction.Discount)
+	c.payEvent(ctx, "info", "group", entity.PaymentEvent{
+		Type:          entity.PaymentEventGroupDeferred,
+		TransactionId: transaction.TransactionId,
+		Username:      username,
+		Amount:        transaction.PaymentAmount - transaction.PaymentBilled,
+	}, "transaction %d: %.2f deferred to the monthly order of group %s (user %s)",
+		transaction.TransactionId, float64(transaction.PaymentAmount-transaction.PaymentBilled)/100, group.Name, username)
+}
+
+// processGroupBilling bills the previous month of every group billed monthly.
+// Statements already pending or paid are left alone; a failed one is tried
+// again once groupBillingRetryDelay has passed.
+func (c *Core) processGroupBilling(ctx context.Context) {
+	now := time.Now()
+	if c.gateway == nil || now.Sub(c.groupBilledAt) < groupBillingInterval {
+		return
+	}
+	c.groupBilledAt = now
+	groups, err := c.repo.ListUserGroups(ctx)
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to list user groups")
+		return
+	}
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	period := monthStart.AddDate(0, -1, 0).Format("2006-01")
+
+	for _, group := range groups {
+		if group.BillingMode != entity.BillingModeMonthly || group.BillingUser == "" {
+			continue
+		}
+		existing, e := c.repo.GetGroupStatement(ctx, statementId(group.Name, period))
+		if e != nil {
+			c.log.With(slog.String("group", group.Name), sl.Err(e)).Error("failed to get group statement")
+			continue
+		}
+		if existing != nil && (existing.Status != entity.StatementStatusFailed ||
+			now.Sub(existing.ClosedAt) < groupBillingRetryDelay) {
+			continue
+		}
+		if _, e = c.billGroup(ctx, group, period, monthStart); e != nil {
+			c.log.With(slog.String("group", group.Name), sl.Err(e)).Error("failed to bill group")
+			c.payLog(ctx, "error", "group", "group %s: %s not billed: %v", group.Name, period, e)
+		}
+	}
+}
+
+// billGroup collects the unpaid sessions of a group started before the given
+// time into a statement and charges its total as one order to the billing
+// user's default payment method. It returns nil when there is nothing to bill.
+func (c *Core) billGroup(ctx context.Context, group *entity.UserGroup, period string, before time.Time) (*entity.GroupStatement, error) {
+	c.groupBillingMux.Lock()
+	defer c.groupBillingMux.Unlock()
+
+	id := statementId(group.Name, period)
+	existing, err := c.repo.GetGroupStatement(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Status != entity.StatementStatusFailed {
+		return existing, nil
+	}
+
+	account, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, group.BillingUser)
+	if err != nil || account == nil || account.UserId == "" {
+		return nil, fmt.Errorf("billing user %s %w", group.BillingUser, entity.ErrNotFound)
+	}
+	paymentMethod, err := c.repo.GetDefaultPaymentMethod(ctx, account.UserId)
+	if err != nil || paymentMethod == nil {
+		return nil, fmt.Errorf("no payment method for billing user %s", group.BillingUser)
+	}
+
+	transactions, err := c.repo.GetGroupBillingTransactions(ctx, group.Name, before)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	statement := &entity.GroupStatement{
+		Id:          id,
+		Group:       group.Name,
+		Period:      period,
+		BillingUser: group.BillingUser,
+		Status:      entity.StatementStatusPending,
+		CreatedAt:   now,
+	}
+	for _, transaction := range transactions {
+		statement.AddSession(c.transactionUsername(ctx, transaction), &entity.StatementSession{
+			TransactionId: transaction.TransactionId,
+			ChargePointId: transaction.ChargePointId,
+			TimeStart:     transaction.TimeStart,
+			TimeStop:      transaction.TimeStop,
+			Energy:        max(transaction.MeterStop-transaction.MeterStart, 0),
+			Amount:        transaction.PaymentAmount - transaction.PaymentBilled,
+		})
+	}
+
+	order := entity.PaymentOrder{
+		Amount: statement.Amount,
+		Description: fmt.Sprintf("Charging of group %s, %s: %d sessions, %.3f kWh",
+			group.Name, period, statement.Sessions, float64(statement.Energy)/1000),
+		Identifier: paymentMethod.Identifier,
+		UserId:     account.UserId,
+		UserName:   account.Username,
+		Purpose:    entity.OrderPurposeGroupBilling,
+		TimeOpened: now,
+	}
+	if order.Order, err = c.nextOrderNumber(ctx); err != nil {
+		return nil, err
+	}
+	if err = c.repo.SavePaymentOrder(ctx, &order); err != nil {
+		return nil, fmt.Errorf("save payment order: %w", err)
+	}
+	statement.Order = order.Order
+	if err = c.repo.SaveGroupStatement(ctx, statement); err != nil {
+		return nil, fmt.Errorf("save group statement: %w", err)
+	}
+
+	c.payEvent(ctx, "info", "group", entity.PaymentEvent{
+		Type:     entity.PaymentEventGroupCharged,
+		Order:    order.Order,
+		UserId:   order.UserId,
+		Username: order.UserName,
+		Amount:   order.Amount,
+		CardHash: entity.CardHash(order.Identifier),
+	}, "group %s: charging %.2f for %s on order %d (%d sessions, %d drivers, billing user %s)",
+		group.Name, float64(statement.Amount)/100, period, order.Order, statement.Sessions, len(statement.Drivers), group.BillingUser)
+
+	// DisablePayment bypass for testing
+	if c.disablePayment {
+		order.IsCompleted = true
+		order.Result = "payment disabled"
+		order.TimeClosed = now
+		if err = c.repo.SavePaymentOrder(ctx, &order); err != nil {
+			return nil, fmt.Errorf("save payment order: %w", err)
+		}
+		c.settleGroupStatement(ctx, statement, &order)
+		return statement, nil
+	}
+	req := PayRequest{
+		OrderNumber: fmt.Sprintf("%d", order.Order),
+		Amount:      order.Amount,
+		CardToken:   paymentMethod.Identifier,
+		NetworkTxId: paymentMethod.CofTid,
+	}
+	c.runAsync("processPay", func(ctx context.Context) {
+		c.processPay(ctx, req, order.Order)
+	})
+	return statement, nil
+}
+
+// settleGroupOrder marks the sessions of a paid group order as billed.
+func (c *Core) settleGroupOrder(ctx context.Context, order *entity.PaymentOrder) {
+	statement, err := c.repo.GetGroupStatementByOrder(ctx, order.Order)
+	if err != nil || statement == nil {
+		c.log.With(slog.Int("order", order.Order), sl.Err(err)).Error("group statement not found for order")
+		return
+	}
+	c.settleGroupStatement(ctx, statement, order)
+	c.invoicePayment(ctx, nil, order)
+}
+
+func (c *Core) settleGroupStatement(ctx context.Context, statement *entity.GroupStatement, order *entity.PaymentOrder) {
+	for _, driver := range statement.Drivers {
+		for _, session := range driver.Sessions {
+			transaction, err := c.repo.GetTransaction(ctx, session.TransactionId)
+			if err != nil || transaction == nil {
+				c.log.With(slog.Int("transaction_id", session.TransactionId), sl.Err(err)).
+					Error("statement transaction not found")
+				continue
+			}
+			transaction.PaymentBilled += session.Amount
+			transaction.PaymentOrder = order.Order
+			transaction.PaymentError = ""
+			transaction.AddOrder(*order)
+			if e := c.repo.UpdateTransactionPayment(ctx, transaction); e != nil {
+				c.log.With(slog.Int("transaction_id", session.TransactionId), sl.Err(e)).
+					Error("failed to update transaction billing")
+			}
+		}
+	}
+	statement.Status = entity.StatementStatusPaid
+	statement.ClosedAt = time.Now()
+	if err := c.repo.SaveGroupStatement(ctx, statement); err != nil {
+		c.log.With(slog.String("statement", statement.Id), sl.Err(err)).Error("failed to save group statement")
+	}
+	c.payEvent(ctx, "info", "group", entity.PaymentEvent{
+		Type:     entity.PaymentEventGroupPaid,
+		Order:    order.Order,
+		UserId:   order.UserId,
+		Username: order.UserName,
+		Amount:   order.Amount,
+		CardHash: entity.CardHash(order.Identifier),
+	}, "group %s: %s paid %.2f on order %d",
+		statement.Group, statement.Period, float64(statement.Amount)/100, order.Order)
+}
+
+// failGroupOrder records a declined group order; its sessions stay unpaid and
+// are billed again by processGroupBilling.
+func (c *Core) failGroupOrder(ctx context.Context, order *entity.PaymentOrder, result string) {
+	statement, err := c.repo.GetGroupStatementByOrder(ctx, order.Order)
+	if err != nil || statement == nil {
+		c.log.With(slog.Int("order", order.Order), sl.Err(err)).Error("group statement not found for order")
+		return
+	}
+	statement.Status = entity.StatementStatusFailed
+	statement.ClosedAt = time.Now()
+	if e := c.repo.SaveGroupStatement(ctx, statement); e != nil {
+		c.log.With(slog.String("statement", statement.Id), sl.Err(e)).Error("failed to save group statement")
+	}
+	c.payEvent(ctx, "error", "group", entity.PaymentEvent{
+		Type:     entity.PaymentEventGroupDeclined,
+		Order:    order.Order,
+		UserId:   order.UserId,
+		Username: order.UserName,
+		Amount:   order.Amount,
+		CardHash: entity.CardHash(order.Identifier),
+		Code:     result,
+	}, "group %s: payment of %s failed on order %d (%s); retried in %s",
+		statement.Group, statement.Period, order.Order, result, groupBillingRetryDelay)
+}
+
+// transactionUsername returns the username of the driver of a session.
+func (c *Core) transactionUsername(ctx context.Context, transaction *entity.Transaction) string {
+	if transaction.Username != "" {
+		return transaction.Username
+	}
+	if transaction.UserTag != nil && transaction.UserTag.Username != "" {
+		return transaction.UserTag.Username
+	}
+	if tag, err := c.repo.GetUserTag(ctx, transaction.IdTag); err == nil && tag != nil {
+		return tag.Username
+	}
+	return transaction.IdTag
+}
+
+func statementId(group, period string) string {
+	return group + "-" + period
+}
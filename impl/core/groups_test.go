@@ -0,0 +1,172 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// declineGateway rejects every payment.
+type declineGateway struct {
+	PaymentGateway
+}
+
+func (g *declineGateway) Pay(_ context.Context, req PayRequest) (*CaptureResponse, error) {
+	return &CaptureResponse{ResponseCode: "0190", Order: req.OrderNumber, TransactionType: entity.RedsysTxPay}, nil
+}
+
+// newGroupCore returns a core with the driver of newWalletCore in group
+// "acme", billed monthly to the card of user "acme-fleet".
+func newGroupCore(t *testing.T) (*Core, *database_mock.MockDB, *holdGateway) {
+	t.Helper()
+	ctx := context.Background()
+	c, db, g := newWalletCore(t, 0)
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Group: "acme"})
+	db.SeedUser(&entity.User{Username: "driver2", UserId: "u2", Group: "acme"})
+	require.NoError(t, db.AddUserTag(ctx, &entity.UserTag{UserId: "u2", Username: "driver2", IdTag: "TAG2"}))
+	db.SeedUser(&entity.User{Username: "acme-fleet", UserId: "u9"})
+	require.NoError(t, db.SavePaymentMethod(ctx, &entity.PaymentMethod{
+		Identifier: "tok-9", CofTid: "cof-9", ExpiryDate: "4012", IsDefault: true, UserId: "u9", UserName: "acme-fleet",
+	}))
+
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	_, err := c.SaveUserGroup(ctx, admin, &entity.UserGroup{
+		Name: "acme", BillingMode: entity.BillingModeMonthly, BillingUser: "acme-fleet",
+	})
+	require.NoError(t, err)
+	return c, db, g
+}
+
+func TestPayTransaction_GroupBilling(t *testing.T) {
+	tests := []struct {
+		name     string
+		override string // the driver's own billing mode
+		deferred bool
+	}{
+		{"group mode applies", "", true},
+		{"driver billed per session", entity.BillingModeSession, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, g := newGroupCore(t)
+			db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Group: "acme", BillingMode: tt.override})
+			db.SeedTransaction(&entity.Transaction{TransactionId: 1, IdTag: "TAG1", IsFinished: true, PaymentAmount: 1500})
+
+			require.NoError(t, c.PayTransaction(ctx, 1))
+			if !tt.deferred {
+				waitBilled(t, db, 1500)
+				assert.Equal(t, []string{"pay 1200 1500"}, g.Calls())
+				return
+			}
+			tx, _ := db.GetTransaction(ctx, 1)
+			assert.Equal(t, "acme", tx.GroupBilling)
+			assert.Equal(t, 0, tx.PaymentBilled)
+			unbilled, _ := db.GetUnbilledTransactions(ctx)
+			assert.Empty(t, unbilled, "the session is left out of per-session billing")
+
+			require.NoError(t, c.PayTransaction(ctx, 1))
+			assert.Empty(t, g.Calls())
+		})
+	}
+}
+
+func TestBillGroup(t *testing.T) {
+	ctx := context.Background()
+	c, db, g := newGroupCore(t)
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	lastMonth := monthStart.AddDate(0, 0, -10)
+	period := lastMonth.Format("2006-01")
+	sessions := []struct {
+		id     int
+		tag    string
+		start  time.Time
+		amount int
+	}{
+		{1, "TAG1", lastMonth, 1000},
+		{2, "TAG1", lastMonth.Add(time.Hour), 500},
+		{3, "TAG2", lastMonth.Add(2 * time.Hour), 700},
+		{4, "TAG2", monthStart.Add(time.Hour), 900}, // billed next month
+	}
+	for _, s := range sessions {
+		db.SeedTransaction(&entity.Transaction{
+			TransactionId: s.id, IdTag: s.tag, ChargePointId: "CP1", IsFinished: true, TimeStart: s.start,
+			MeterStart: 0, MeterStop: 10000, PaymentAmount: s.amount, GroupBilling: "acme",
+		})
+	}
+
+	c.processGroupBilling(ctx)
+	statement, err := c.GetGroupStatement(ctx, &entity.User{Role: "admin"}, "acme-"+period)
+	require.NoError(t, err)
+	assert.Equal(t, 2200, statement.Amount)
+	assert.Equal(t, 30000, statement.Energy)
+	assert.Equal(t, 3, statement.Sessions)
+	require.Len(t, statement.Drivers, 2)
+	assert.Equal(t, "driver", statement.Drivers[0].Username)
+	assert.Equal(t, 1500, statement.Drivers[0].Amount)
+	assert.Len(t, statement.Drivers[0].Sessions, 2)
+	assert.Equal(t, "driver2", statement.Drivers[1].Username)
+	assert.Equal(t, 700, statement.Drivers[1].Amount)
+
+	require.Eventually(t, func() bool {
+		s, _ := db.GetGroupStatement(ctx, statement.Id)
+		return s.Status == entity.StatementStatusPaid
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{fmt.Sprintf("pay %d 2200", statement.Order)}, g.Calls())
+
+	order, _ := db.GetPaymentOrder(ctx, statement.Order)
+	assert.Equal(t, "u9", order.UserId, "the billing account is charged")
+	assert.Equal(t, "tok-9", order.Identifier)
+	for _, s := range sessions {
+		tx, _ := db.GetTransaction(ctx, s.id)
+		if s.id == 4 {
+			assert.Equal(t, 0, tx.PaymentBilled)
+			continue
+		}
+		assert.Equal(t, s.amount, tx.PaymentBilled)
+		assert.Equal(t, statement.Order, tx.PaymentOrder)
+	}
+
+	// a repeated run bills nothing twice
+	c.groupBilledAt = time.Time{}
+	c.processGroupBilling(ctx)
+	assert.Len(t, g.Calls(), 1)
+}
+
+func TestBillGroup_Declined(t *testing.T) {
+	ctx := context.Background()
+	c, db, _ := newGroupCore(t)
+	c.SetPaymentGateway(&declineGateway{})
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	db.SeedTransaction(&entity.Transaction{
+		TransactionId: 1, IdTag: "TAG1", IsFinished: true, TimeStart: start, PaymentAmount: 1000, GroupBilling: "acme",
+	})
+
+	statement, err := c.BillGroup(ctx, admin, "acme", "2026-03")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s, _ := db.GetGroupStatement(ctx, statement.Id)
+		return s.Status == entity.StatementStatusFailed
+	}, time.Second, 5*time.Millisecond)
+	tx, _ := db.GetTransaction(ctx, 1)
+	assert.Equal(t, 0, tx.PaymentBilled, "the session waits for the next attempt")
+	assert.Empty(t, tx.PaymentError)
+
+	c.SetPaymentGateway(&holdGateway{})
+	retried, err := c.BillGroup(ctx, admin, "acme", "2026-03")
+	require.NoError(t, err)
+	assert.NotEqual(t, statement.Order, retried.Order)
+	waitBilled(t, db, 1000)
+
+	_, err = c.BillGroup(ctx, admin, "acme", "2026-04")
+	assert.Error(t, err, "nothing to bill")
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"fmt"
+	"time"
+)
+
+const (
+	// idempotencyKeyTTL is how long a stored response is replayed for its key.
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long a key stays claimed by a request
+	// that never stored its response, e.g. because the instance stopped.
+	idempotencyLockTimeout = time.Minute
+)
+
+// ReserveIdempotencyKey claims an idempotency key for a request identified by
+// its fingerprint. It returns nil when the request has to be executed, or the
+// completed record of the earlier request made with the same key, whose
+// response is to be replayed. A key reused for another request fails with
+// entity.ErrIdempotencyMismatch, a key whose first request is still running
+// with entity.ErrIdempotencyInProgress.
+func (c *Core) ReserveIdempotencyKey(ctx context.Context, key, fingerprint string) (*entity.IdempotencyRecord, error) {
+	now := time.Now()
+	record := &entity.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+	}
+	inserted, err := c.repo.InsertIdempotencyRecord(ctx, record)
+	if err != nil {
+		return nil, fmt.Errorf("insert idempotency record: %w", err)
+	}
+	if inserted {
+		return nil, nil
+	}
+
+	existing, err := c.repo.GetIdempotencyRecord(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("get idempotency record: %w", err)
+	}
+	// a missing, expired or abandoned record is taken over; the takeover only
+	// succeeds for the record as read, so of concurrent requests one executes
+	if existing == nil {
+		if inserted, err = c.repo.InsertIdempotencyRecord(ctx, record); err != nil {
+			return nil, fmt.Errorf("insert idempotency record: %w", err)
+		}
+		if !inserted {
+			return nil, entity.ErrIdempotencyInProgress
+		}
+		return nil, nil
+	}
+	if existing.IsCompleted() && now.Sub(existing.CreatedAt) > idempotencyKeyTTL ||
+		!existing.IsCompleted() && now.Sub(existing.CreatedAt) > idempotencyLockTimeout {
+		replaced, e := c.repo.ReplaceIdempotencyRecord(ctx, record, existing.CreatedAt)
+		if e != nil {
+			return nil, fmt.Errorf("replace idempotency record: %w", e)
+		}
+		if !replaced {
+			return nil, entity.ErrIdempotencyInProgress
+		}
+		return nil, nil
+	}
+
+	if existing.Fingerprint != fingerprint {
+		return nil, entity.ErrIdempotencyMismatch
+	}
+	if !existing.IsCompleted() {
+		return nil, entity.ErrIdempotencyInProgress
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request that claimed the
+// key; it is replayed for idempotencyKeyTTL from now.
+func (c *Core) CompleteIdempotencyKey(ctx context.Context, record *entity.IdempotencyRecord) error {
+	record.CreatedAt = time.Now()
+	return c.repo.SaveIdempotencyRecord(ctx, record)
+}
+
+// ReleaseIdempotencyKey frees a claimed key without storing a response, so a
+// retry with the same key executes the request again.
+func (c *Core) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return c.repo.DeleteIdempotencyRecord(ctx, key)
+}
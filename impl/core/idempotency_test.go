@@ -0,0 +1,34 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveIdempotencyKey_Takeover(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	c := New(newTestLogger(), db)
+	// the request that claimed the key never finished
+	abandoned := &entity.IdempotencyRecord{Key: "k1", Fingerprint: "fp", CreatedAt: time.Now().Add(-2 * idempotencyLockTimeout)}
+	inserted, err := db.InsertIdempotencyRecord(ctx, abandoned)
+	require.NoError(t, err)
+	require.True(t, inserted)
+
+	stored, err := c.ReserveIdempotencyKey(ctx, "k1", "fp")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// a request that read the abandoned record too late loses the takeover
+	replaced, err := db.ReplaceIdempotencyRecord(ctx, &entity.IdempotencyRecord{Key: "k1", Fingerprint: "fp", CreatedAt: time.Now()}, abandoned.CreatedAt)
+	require.NoError(t, err)
+	assert.False(t, replaced)
+	_, err = c.ReserveIdempotencyKey(ctx, "k1", "fp")
+	assert.ErrorIs(t, err, entity.ErrIdempotencyInProgress)
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+)
+
+// ListInvites returns the invite codes (admin only).
+func (c *Core) ListInvites(ctx context.Context, author *entity.User) ([]*entity.Invite, error) {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
+		return nil, err
+	}
+	return c.auth.ListInvites(ctx)
+}
+
+// GenerateInvites creates invite codes for registration (admin only).
+func (c *Core) GenerateInvites(ctx context.Context, author *entity.User, req *entity.InviteRequest) ([]*entity.Invite, error) {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
+		return nil, err
+	}
+	return c.auth.GenerateInvites(ctx, author, req)
+}
+
+// RevokeInvite deletes an invite code (admin only).
+func (c *Core) RevokeInvite(ctx context.Context, author *entity.User, code string) error {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
+		return err
+	}
+	return c.auth.RevokeInvite(ctx, code)
+}
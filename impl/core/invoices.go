@@ -0,0 +1,335 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/mail"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// InvoiceConfig holds the invoicing settings: numbering series, VAT rates and
+// the issuer printed on every document.
+type InvoiceConfig struct {
+	Series       string
+	CreditSeries string
+	VatRate      float64
+	// VatRates overrides VatRate per location id.
+	VatRates map[string]float64
+	Issuer   entity.FiscalDetails
+}
+
+func (c *Core) SetInvoicing(conf *InvoiceConfig) {
+	c.invoicing = conf
+}
+
+// SaveFiscalDetails stores the fiscal details printed on the user's future
+// invoices. Invoices already issued keep the details they were issued with.
+func (c *Core) SaveFiscalDetails(ctx context.Context, author *entity.User, fiscal *entity.FiscalDetails) (*entity.FiscalDetails, error) {
+	if author == nil {
+		return nil, fmt.Errorf("user is nil")
+	}
+	if fiscal == nil {
+		return nil, fmt.Errorf("fiscal details are nil")
+	}
+	if err := c.repo.UpdateUserFiscal(ctx, author.Username, fiscal); err != nil {
+		return nil, err
+	}
+	return fiscal, nil
+}
+
+// ListUserInvoices returns the invoices and credit notes issued to the user.
+func (c *Core) ListUserInvoices(ctx context.Context, author *entity.User) ([]*entity.Invoice, error) {
+	if author == nil {
+		return nil, fmt.Errorf("user is nil")
+	}
+	invoices, err := c.repo.GetUserInvoices(ctx, author.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if invoices == nil {
+		invoices = make([]*entity.Invoice, 0)
+	}
+	return invoices, nil
+}
+
+// ListInvoices returns all invoices issued within [from, to) (admin only).
+func (c *Core) ListInvoices(ctx context.Context, author *entity.User, from, to time.Time) ([]*entity.Invoice, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsRead); err != nil {
+		return nil, err
+	}
+	invoices, err := c.repo.GetInvoices(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if invoices == nil {
+		invoices = make([]*entity.Invoice, 0)
+	}
+	return invoices, nil
+}
+
+// GetInvoice returns an invoice with its frozen document. Regular users may
+// only read their own invoices.
+func (c *Core) GetInvoice(ctx context.Context, author *entity.User, id string) (*entity.Invoice, error) {
+	if author == nil {
+		return nil, fmt.Errorf("user is nil")
+	}
+	invoice, err := c.repo.GetInvoice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if invoice == nil {
+		return nil, fmt.Errorf("invoice %w", entity.ErrNotFound)
+	}
+	if invoice.UserId != author.UserId && !author.Can(entity.PermissionPaymentsRead) {
+		return nil, fmt.Errorf("access denied: invoice belongs to another user")
+	}
+	return invoice, nil
+}
+
+// IssueTransactionInvoice issues the invoice of a transaction's last payment,
+// e.g. for a customer who asks for it after the session (admin only). An
+// invoice already issued for that payment is returned as is.
+func (c *Core) IssueTransactionInvoice(ctx context.Context, author *entity.User, transactionId int) (*entity.Invoice, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsWrite); err != nil {
+		return nil, err
+	}
+	if c.invoicing == nil {
+		return nil, fmt.Errorf("invoicing not configured")
+	}
+	transaction, err := c.repo.GetTransaction(ctx, transactionId)
+	if err != nil {
+		return nil, err
+	}
+	if transaction == nil {
+		return nil, fmt.Errorf("transaction %d %w", transactionId, entity.ErrNotFound)
+	}
+	order, err := c.repo.GetPaymentOrder(ctx, transaction.PaymentOrder)
+	if err != nil {
+		return nil, fmt.Errorf("get payment order: %w", err)
+	}
+	if order == nil {
+		return nil, fmt.Errorf("payment order %d %w", transaction.PaymentOrder, entity.ErrNotFound)
+	}
+	if !order.IsPaid() {
+		return nil, fmt.Errorf("payment order %d is not paid", order.Order)
+	}
+	return c.issueInvoice(ctx, transaction, order)
+}
+
+// issueInvoice issues the invoice of a captured payment order. It is
+// idempotent: the invoice already issued for the order is returned instead.
+func (c *Core) issueInvoice(ctx context.Context, transaction *entity.Transaction, order *entity.PaymentOrder) (*entity.Invoice, error) {
+	issued := func() (*entity.Invoice, error) {
+		existing, err := c.repo.GetInvoiceByOrder(ctx, order.Order)
+		if err != nil {
+			return nil, fmt.Errorf("get invoice: %w", err)
+		}
+		return existing, nil
+	}
+	if existing, err := issued(); err != nil || existing != nil {
+		return existing, err
+	}
+
+	invoice := &entity.Invoice{
+		Type:          entity.InvoiceTypeInvoice,
+		Series:        c.invoicing.Series,
+		TransactionId: order.TransactionId,
+		Order:         order.Order,
+		UserId:        order.UserId,
+		Username:      order.UserName,
+		Currency:      order.Currency,
+	}
+	if transaction != nil {
+		consumed := float64(transaction.MeterStop-transaction.MeterStart) / 1000
+		invoice.Description = fmt.Sprintf("Charging session #%d at %s, %.3f kWh",
+			transaction.TransactionId, transaction.ChargePointId, consumed)
+	} else {
+		invoice.Description = order.Description
+	}
+	invoice.SetAmounts(order.Amount, c.vatRate(ctx, transaction))
+	c.setInvoiceParties(ctx, invoice)
+
+	saved, err := c.saveInvoice(ctx, invoice, issued)
+	if err != nil || saved != invoice {
+		return saved, err
+	}
+	c.payLog(ctx, "info", "invoice",
+		"transaction %d: invoice %s issued for order %d, total %.2f (user %s)",
+		invoice.TransactionId, invoice.InvoiceNumber, order.Order, float64(invoice.Total)/100, invoice.Username)
+	return invoice, nil
+}
+
+// issueCreditNote issues the credit note rectifying the invoice of a refunded
+// order. Refunds of orders that were never invoiced, such as card enrollment
+// charges, need none.
+func (c *Core) issueCreditNote(ctx context.Context, refund *entity.Refund) (*entity.Invoice, error) {
+	issued := func() (*entity.Invoice, error) {
+		existing, err := c.repo.GetInvoiceByRefund(ctx, refund.Id)
+		if err != nil {
+			return nil, fmt.Errorf("get credit note: %w", err)
+		}
+		return existing, nil
+	}
+	if existing, err := issued(); err != nil || existing != nil {
+		return existing, err
+	}
+	original, err := c.repo.GetInvoiceByOrder(ctx, refund.Order)
+	if err != nil {
+		return nil, fmt.Errorf("get invoice: %w", err)
+	}
+	if original == nil {
+		return nil, nil
+	}
+
+	note := &entity.Invoice{
+		Type:          entity.InvoiceTypeCreditNote,
+		Series:        c.invoicing.CreditSeries,
+		TransactionId: original.TransactionId,
+		Order:         original.Order,
+		RefundId:      refund.Id,
+		Rectifies:     original.InvoiceNumber,
+		UserId:        original.UserId,
+		Username:      original.Username,
+		Description:   fmt.Sprintf("Refund of invoice %s (%s)", original.InvoiceNumber, refund.Reason),
+		Currency:      original.Currency,
+	}
+	// The credit note rectifies the original document, so it keeps its rate
+	// even if the configuration changed in between.
+	note.SetAmounts(-refund.Amount, original.VatRate)
+	c.setInvoiceParties(ctx, note)
+
+	saved, err := c.saveInvoice(ctx, note, issued)
+	if err != nil || saved != note {
+		return saved, err
+	}
+	c.payLog(ctx, "info", "invoice",
+		"order %d: credit note %s issued for invoice %s, total %.2f",
+		refund.Order, note.InvoiceNumber, original.InvoiceNumber, float64(note.Total)/100)
+	return note, nil
+}
+
+// invoiceSequence names the sequence numbering a series in a year.
+func invoiceSequence(series string, year int) string {
+	return fmt.Sprintf("invoice:%s:%d", series, year)
+}
+
+// saveInvoice numbers, renders and stores an invoice, unless issued finds the
+// document it would duplicate, which is returned instead. Numbers come from
+// the persistent sequence of the series and year, atomic across backend
+// instances, and are kept past the last stored number. The check is repeated
+// under the lock, and the store rejects a second document of the same order or
+// refund, so concurrent payment notifications issue one invoice; a number is
+// only lost when the store fails after allocating it.
+func (c *Core) saveInvoice(ctx context.Context, invoice *entity.Invoice, issued func() (*entity.Invoice, error)) (*entity.Invoice, error) {
+	c.invoiceMux.Lock()
+	defer c.invoiceMux.Unlock()
+
+	if existing, err := issued(); err != nil || existing != nil {
+		return existing, err
+	}
+	invoice.IssuedAt = time.Now().UTC()
+	invoice.Year = invoice.IssuedAt.Year()
+	last, err := c.repo.GetLastInvoice(ctx, invoice.Series, invoice.Year)
+	if err != nil {
+		return nil, fmt.Errorf("get last invoice: %w", err)
+	}
+	floor := 1
+	if last != nil {
+		floor = last.Number + 1
+	}
+	number, err := c.repo.NextSequence(ctx, invoiceSequence(invoice.Series, invoice.Year), floor)
+	if err != nil {
+		return nil, fmt.Errorf("allocate invoice number: %w", err)
+	}
+	invoice.SetNumber(number)
+	invoice.Html = mail.RenderInvoice(invoice)
+
+	inserted, err := c.repo.InsertInvoice(ctx, invoice)
+	if err != nil {
+		c.log.With(
+			slog.String("invoice", invoice.InvoiceNumber),
+			sl.Err(err),
+		).Error("invoice number allocated but not stored")
+		return nil, fmt.Errorf("save invoice: %w", err)
+	}
+	if !inserted {
+		// another instance issued the document meanwhile
+		c.log.With(
+			slog.String("invoice", invoice.InvoiceNumber),
+		).Error("invoice number allocated for a document issued meanwhile")
+		existing, err := issued()
+		if err == nil && existing == nil {
+			err = fmt.Errorf("invoice %s already exists", invoice.InvoiceNumber)
+		}
+		return existing, err
+	}
+	return invoice, nil
+}
+
+// setInvoiceParties copies the issuer from the configuration and the customer
+// from the user's fiscal details. Without fiscal details the invoice is a
+// simplified one, addressed to the user's name.
+func (c *Core) setInvoiceParties(ctx context.Context, invoice *entity.Invoice) {
+	issuer := c.invoicing.Issuer
+	invoice.Issuer = &issuer
+
+	info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, invoice.Username)
+	if err != nil || info == nil {
+		c.log.With(slog.String("username", invoice.Username), sl.Err(err)).Warn("invoice customer not found")
+		invoice.Customer = &entity.FiscalDetails{Name: invoice.Username}
+		return
+	}
+	if info.Fiscal != nil {
+		customer := *info.Fiscal
+		invoice.Customer = &customer
+		return
+	}
+	name := info.Name
+	if name == "" {
+		name = info.Username
+	}
+	invoice.Customer = &entity.FiscalDetails{Name: name}
+}
+
+// vatRate returns the VAT rate of the location the session took place at,
+// falling back to the default rate.
+func (c *Core) vatRate(ctx context.Context, transaction *entity.Transaction) float64 {
+	if transaction == nil || len(c.invoicing.VatRates) == 0 {
+		return c.invoicing.VatRate
+	}
+	cp, err := c.repo.GetChargePoint(ctx, MaxAccessLevel, transaction.ChargePointId)
+	if err != nil || cp == nil {
+		return c.invoicing.VatRate
+	}
+	if rate, ok := c.invoicing.VatRates[cp.LocationId]; ok {
+		return rate
+	}
+	return c.invoicing.VatRate
+}
+
+// invoicePayment issues the invoice of a captured payment when invoicing is
+// enabled. Failures are logged: the payment itself has already succeeded.
+func (c *Core) invoicePayment(ctx context.Context, transaction *entity.Transaction, order *entity.PaymentOrder) {
+	if c.invoicing == nil {
+		return
+	}
+	if _, err := c.issueInvoice(ctx, transaction, order); err != nil {
+		c.log.With(slog.Int("order", order.Order), sl.Err(err)).Error("failed to issue invoice")
+		c.payLog(ctx, "error", "invoice", "order %d: invoice not issued: %v", order.Order, err)
+	}
+}
+
+// invoiceRefund issues the credit note of a completed refund when invoicing is
+// enabled.
+func (c *Core) invoiceRefund(ctx context.Context, refund *entity.Refund) {
+	if c.invoicing == nil {
+		return
+	}
+	if _, err := c.issueCreditNote(ctx, refund); err != nil {
+		c.log.With(slog.Int("order", refund.Order), sl.Err(err)).Error("failed to issue credit note")
+		c.payLog(ctx, "error", "invoice", "order %d: credit note not issued: %v", refund.Order, err)
+	}
+}
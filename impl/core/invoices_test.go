@@ -0,0 +1,198 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInvoiceCore(t *testing.T) (*Core, *database_mock.MockDB) {
+	t.Helper()
+	db := database_mock.NewMockDB()
+	c := New(newTestLogger(), db)
+	c.SetAuth(authenticator.New(newTestLogger(), db))
+	c.SetPaymentGateway(&stubGateway{code: "0900"})
+	c.SetInvoicing(&InvoiceConfig{
+		Series:       "F",
+		CreditSeries: "R",
+		VatRate:      21,
+		VatRates:     map[string]float64{"canarias": 7},
+		Issuer:       entity.FiscalDetails{Name: "EVSys SL", TaxId: "B12345678", Address: "Calle Mayor 1"},
+	})
+	db.SeedUser(&entity.User{
+		Username: "driver",
+		UserId:   "u1",
+		Fiscal:   &entity.FiscalDetails{Name: "Acme SA", TaxId: "A87654321", Address: "Gran Via 2"},
+	})
+	db.SeedChargePoint(&entity.ChargePoint{Id: "CP1"})
+	db.SeedChargePoint(&entity.ChargePoint{Id: "CP2", LocationId: "canarias"})
+	return c, db
+}
+
+func seedPaidSession(db *database_mock.MockDB, transactionId, order int, chargePointId string, amount int) {
+	db.SeedTransaction(&entity.Transaction{
+		TransactionId: transactionId,
+		ChargePointId: chargePointId,
+		IsFinished:    true,
+		MeterStop:     10000,
+		PaymentAmount: amount,
+		PaymentOrder:  order,
+	})
+	db.SeedPaymentOrder(&entity.PaymentOrder{
+		TransactionId: transactionId,
+		Order:         order,
+		Amount:        amount,
+		Currency:      "978",
+		UserId:        "u1",
+		UserName:      "driver",
+		IsCompleted:   true,
+		Result:        "0000",
+	})
+}
+
+func TestIssueTransactionInvoice_NumbersAndVat(t *testing.T) {
+	ctx := context.Background()
+	c, db := newInvoiceCore(t)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	seedPaidSession(db, 7, 1300, "CP1", 1210)
+	seedPaidSession(db, 8, 1301, "CP2", 1070)
+
+	first, err := c.IssueTransactionInvoice(ctx, admin, 7)
+	require.NoError(t, err)
+	year := time.Now().UTC().Year()
+	assert.Equal(t, 1, first.Number)
+	assert.Equal(t, 1000, first.TaxableBase)
+	assert.Equal(t, 210, first.VatAmount)
+	assert.Equal(t, "A87654321", first.Customer.TaxId)
+	assert.Equal(t, "B12345678", first.Issuer.TaxId)
+	assert.True(t, strings.Contains(first.Html, first.InvoiceNumber))
+
+	second, err := c.IssueTransactionInvoice(ctx, admin, 8)
+	require.NoError(t, err)
+	assert.Equal(t, 2, second.Number)
+	assert.Equal(t, float64(7), second.VatRate, "location rate overrides the default")
+	assert.Equal(t, 1000, second.TaxableBase)
+
+	// issuing again returns the same invoice instead of a new number
+	again, err := c.IssueTransactionInvoice(ctx, admin, 7)
+	require.NoError(t, err)
+	assert.Equal(t, first.Id, again.Id)
+
+	// later changes to the user do not alter the issued invoice
+	_, err = c.SaveFiscalDetails(ctx, &entity.User{Username: "driver", UserId: "u1"},
+		&entity.FiscalDetails{Name: "Other SL", TaxId: "B00000000", Address: "Elsewhere"})
+	require.NoError(t, err)
+	stored, err := c.GetInvoice(ctx, &entity.User{Username: "driver", UserId: "u1"}, first.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "A87654321", stored.Customer.TaxId)
+	assert.Equal(t, first.Html, stored.Html)
+
+	list, err := c.ListInvoices(ctx, admin, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, year, list[0].Year)
+}
+
+func TestIssueTransactionInvoice_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	c, db := newInvoiceCore(t)
+	// a second backend instance on the same database
+	replica := New(newTestLogger(), db)
+	replica.SetAuth(authenticator.New(newTestLogger(), db))
+	replica.SetInvoicing(c.invoicing)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	seedPaidSession(db, 7, 1300, "CP1", 1210)
+	seedPaidSession(db, 8, 1301, "CP1", 1210)
+
+	var wg sync.WaitGroup
+	ids := make([]string, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			core := c
+			if i%2 == 1 {
+				core = replica
+			}
+			invoice, err := core.IssueTransactionInvoice(ctx, admin, 7)
+			if assert.NoError(t, err) {
+				ids[i] = invoice.Id
+			}
+		}()
+	}
+	wg.Wait()
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id, "one invoice per order")
+	}
+
+	next, err := replica.IssueTransactionInvoice(ctx, admin, 8)
+	require.NoError(t, err)
+	list, err := c.ListInvoices(ctx, admin, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.NotEqual(t, list[0].Number, list[1].Number)
+	assert.Equal(t, next.Number, list[1].Number)
+}
+
+func TestIssueTransactionInvoice_Validation(t *testing.T) {
+	ctx := context.Background()
+	c, db := newInvoiceCore(t)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	seedPaidSession(db, 7, 1300, "CP1", 1210)
+
+	_, err := c.IssueTransactionInvoice(ctx, &entity.User{Username: "driver"}, 7)
+	assert.Error(t, err)
+
+	_, err = c.IssueTransactionInvoice(ctx, admin, 99)
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+
+	db.SeedTransaction(&entity.Transaction{TransactionId: 9, IsFinished: true, PaymentOrder: 1302})
+	db.SeedPaymentOrder(&entity.PaymentOrder{TransactionId: 9, Order: 1302, Amount: 500, IsCompleted: true, Result: "0190"})
+	_, err = c.IssueTransactionInvoice(ctx, admin, 9)
+	assert.ErrorContains(t, err, "is not paid")
+
+	invoice, err := c.IssueTransactionInvoice(ctx, admin, 7)
+	require.NoError(t, err)
+	_, err = c.GetInvoice(ctx, &entity.User{Username: "other", UserId: "u2"}, invoice.Id)
+	assert.ErrorContains(t, err, "access denied")
+}
+
+func TestRefund_IssuesCreditNote(t *testing.T) {
+	ctx := context.Background()
+	c, db := newInvoiceCore(t)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	seedPaidSession(db, 7, 1300, "CP1", 1210)
+
+	invoice, err := c.IssueTransactionInvoice(ctx, admin, 7)
+	require.NoError(t, err)
+
+	_, err = c.IssueRefund(ctx, admin, 7, &entity.RefundOrderRequest{Amount: 605, Reason: entity.RefundReasonBillingError})
+	require.NoError(t, err)
+	refunds := waitRefunds(t, db, 7)
+	require.Len(t, refunds, 1)
+
+	var invoices []*entity.Invoice
+	require.Eventually(t, func() bool {
+		invoices, _ = c.ListUserInvoices(ctx, &entity.User{Username: "driver", UserId: "u1"})
+		return len(invoices) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	note, err := db.GetInvoiceByRefund(ctx, refunds[0].Id)
+	require.NoError(t, err)
+	require.NotNil(t, note)
+	assert.Equal(t, entity.InvoiceTypeCreditNote, note.Type)
+	assert.Equal(t, "R", note.Series)
+	assert.Equal(t, 1, note.Number, "credit notes have their own sequence")
+	assert.Equal(t, invoice.InvoiceNumber, note.Rectifies)
+	assert.Equal(t, -605, note.Total)
+	assert.Equal(t, -500, note.TaxableBase)
+	assert.Equal(t, -105, note.VatAmount)
+}
@@ -0,0 +1,365 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ListUsageLimits returns the limits of all users and groups (admin only).
+func (c *Core) ListUsageLimits(ctx context.Context, author *entity.User) ([]*entity.UsageLimit, error) {
+	if err := c.requirePermission(author, entity.PermissionLimitsWrite); err != nil {
+		return nil, err
+	}
+	return c.repo.ListUsageLimits(ctx)
+}
+
+// SaveUsageLimit sets the limits of a user or a group, replacing earlier ones
+// (admin only).
+func (c *Core) SaveUsageLimit(ctx context.Context, author *entity.User, limit *entity.UsageLimit) (*entity.UsageLimit, error) {
+	if err := c.requirePermission(author, entity.PermissionLimitsWrite); err != nil {
+		return nil, err
+	}
+	if limit.Scope == entity.LimitScopeUser {
+		info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, limit.Subject)
+		if err != nil || info == nil {
+			return nil, fmt.Errorf("user %s %w", limit.Subject, entity.ErrNotFound)
+		}
+	}
+	if err := c.repo.SaveUsageLimit(ctx, limit); err != nil {
+		return nil, err
+	}
+	return limit, nil
+}
+
+// DeleteUsageLimit removes the limits of a user or a group (admin only).
+func (c *Core) DeleteUsageLimit(ctx context.Context, author *entity.User, scope, subject string) error {
+	if err := c.requirePermission(author, entity.PermissionLimitsWrite); err != nil {
+		return err
+	}
+	return c.repo.DeleteUsageLimit(ctx, scope, subject)
+}
+
+// usageLimits returns the limits applying to a user: the user's own and the
+// ones of the user's group.
+func (c *Core) usageLimits(ctx context.Context, username, group string) ([]*entity.UsageLimit, error) {
+	var limits []*entity.UsageLimit
+	subjects := [][2]string{{entity.LimitScopeUser, username}, {entity.LimitScopeGroup, group}}
+	for _, s := range subjects {
+		if s[1] == "" {
+			continue
+		}
+		limit, err := c.repo.GetUsageLimit(ctx, s[0], s[1])
+		if err != nil {
+			return nil, err
+		}
+		if limit != nil {
+			limits = append(limits, limit)
+		}
+	}
+	return limits, nil
+}
+
+// limitUsage reports the consumption against each limit set in a usage limit,
+// for the day and month of at. The amount and energy of a session not billed
+// yet are added to the running totals.
+func (c *Core) limitUsage(ctx context.Context, limit *entity.UsageLimit, at time.Time, amount, energy int) ([]*entity.LimitUsage, error) {
+	day, month := usagePeriods(at)
+	daily, err := c.repo.GetUsage(ctx, limit.Scope, limit.Subject, day)
+	if err != nil {
+		return nil, err
+	}
+	monthly, err := c.repo.GetUsage(ctx, limit.Scope, limit.Subject, month)
+	if err != nil {
+		return nil, err
+	}
+	if daily == nil {
+		daily = &entity.UsageCounter{}
+	}
+	if monthly == nil {
+		monthly = &entity.UsageCounter{}
+	}
+
+	checks := []struct {
+		period, kind string
+		limit, used  int
+	}{
+		{entity.LimitPeriodDaily, entity.LimitKindAmount, limit.DailyAmount, daily.Amount + amount},
+		{entity.LimitPeriodMonthly, entity.LimitKindAmount, limit.MonthlyAmount, monthly.Amount + amount},
+		{entity.LimitPeriodDaily, entity.LimitKindEnergy, limit.DailyEnergy, daily.Energy + energy},
+		{entity.LimitPeriodMonthly, entity.LimitKindEnergy, limit.MonthlyEnergy, monthly.Energy + energy},
+	}
+	var usage []*entity.LimitUsage
+	for _, check := range checks {
+		if check.limit <= 0 {
+			continue
+		}
+		usage = append(usage, &entity.LimitUsage{
+			Scope:     limit.Scope,
+			Subject:   limit.Subject,
+			Period:    check.period,
+			Kind:      check.kind,
+			Limit:     check.limit,
+			Used:      check.used,
+			Remaining: max(check.limit-check.used, 0),
+		})
+	}
+	return usage, nil
+}
+
+// userLimitUsage reports the current consumption against every limit of a user.
+func (c *Core) userLimitUsage(ctx context.Context, username, group string) ([]*entity.LimitUsage, error) {
+	limits, err := c.usageLimits(ctx, username, group)
+	if err != nil {
+		return nil, err
+	}
+	var usage []*entity.LimitUsage
+	for _, limit := range limits {
+		u, e := c.limitUsage(ctx, limit, time.Now(), 0, 0)
+		if e != nil {
+			return nil, e
+		}
+		usage = append(usage, u...)
+	}
+	return usage, nil
+}
+
+// consumption is the amount and energy of running sessions not billed yet.
+type consumption struct {
+	amount, energy int
+}
+
+// reachedLimit returns the first limit of a user that is used up, counting
+// the unbilled consumption of running sessions, keyed by limit scope and
+// subject. With stopOnly, only limits that stop running sessions are considered.
+func (c *Core) reachedLimit(ctx context.Context, username, group string, at time.Time, unbilled map[[2]string]consumption, stopOnly bool) (*entity.LimitUsage, error) {
+	limits, err := c.usageLimits(ctx, username, group)
+	if err != nil {
+		return nil, err
+	}
+	for _, limit := range limits {
+		if stopOnly && !limit.StopSession {
+			continue
+		}
+		running := unbilled[[2]string{limit.Scope, limit.Subject}]
+		usage, e := c.limitUsage(ctx, limit, at, running.amount, running.energy)
+		if e != nil {
+			return nil, e
+		}
+		for _, u := range usage {
+			if u.IsReached() {
+				return u, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// checkUsageLimits rejects a new session of a user who, or whose group, has
+// used up a limit.
+func (c *Core) checkUsageLimits(ctx context.Context, username string) error {
+	if username == "" {
+		return nil
+	}
+	group := ""
+	info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, username)
+	if err == nil && info != nil {
+		group = info.Group
+	}
+	reached, err := c.reachedLimit(ctx, username, group, time.Now(), nil, false)
+	if err != nil {
+		return fmt.Errorf("check usage limits: %w", err)
+	}
+	if reached != nil {
+		return fmt.Errorf("%w: %s", entity.ErrLimitReached, describeLimit(reached))
+	}
+	return nil
+}
+
+// recordUsage adds a billed amount to the running totals of the transaction's
+// user and group. The session's energy is counted with its first payment
+// only, so a transaction billed in several orders is not counted twice.
+func (c *Core) recordUsage(ctx context.Context, transaction *entity.Transaction, username string, amount int, firstBilling bool) {
+	if username == "" {
+		return
+	}
+	energy := 0
+	if firstBilling && transaction.MeterStop > transaction.MeterStart {
+		energy = transaction.MeterStop - transaction.MeterStart
+	}
+	group := ""
+	info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, username)
+	if err == nil && info != nil {
+		group = info.Group
+	}
+
+	day, month := usagePeriods(transaction.TimeStart)
+	subjects := [][2]string{{entity.LimitScopeUser, username}, {entity.LimitScopeGroup, group}}
+	for _, s := range subjects {
+		if s[1] == "" {
+			continue
+		}
+		for _, period := range []string{day, month} {
+			if e := c.repo.AddUsage(ctx, s[0], s[1], period, amount, energy); e != nil {
+				c.log.With(
+					slog.Int("transaction_id", transaction.TransactionId),
+					slog.String("subject", s[1]),
+					sl.Err(e),
+				).Error("failed to record usage")
+			}
+		}
+	}
+}
+
+// processUsageLimits stops running sessions whose user, or the user's group,
+// reaches a limit that stops sessions. A session's consumption so far is
+// taken from its last meter reading.
+func (c *Core) processUsageLimits(ctx context.Context) {
+	if c.cs == nil {
+		return
+	}
+	limits, err := c.repo.ListUsageLimits(ctx)
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to list usage limits")
+		return
+	}
+	stopping := false
+	for _, limit := range limits {
+		stopping = stopping || limit.StopSession
+	}
+	if !stopping {
+		return
+	}
+
+	transactions, err := c.repo.GetUnfinishedTransactions(ctx)
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to get running sessions")
+		return
+	}
+	running := make(map[int]bool, len(transactions))
+	for _, transaction := range transactions {
+		running[transaction.TransactionId] = true
+	}
+	c.limitStops.Range(func(key, _ any) bool {
+		if !running[key.(int)] {
+			c.limitStops.Delete(key)
+		}
+		return true
+	})
+
+	// every running session counts against its user's and group's limits,
+	// so sessions sharing a limit are checked against their joint consumption
+	sessions := make([]*runningSession, 0, len(transactions))
+	unbilled := make(map[[2]string]consumption)
+	for _, transaction := range transactions {
+		session := c.runningSession(ctx, transaction)
+		if session == nil {
+			continue
+		}
+		sessions = append(sessions, session)
+		for _, key := range [][2]string{{entity.LimitScopeUser, session.username}, {entity.LimitScopeGroup, session.group}} {
+			if key[1] == "" {
+				continue
+			}
+			total := unbilled[key]
+			total.amount += session.amount
+			total.energy += session.energy
+			unbilled[key] = total
+		}
+	}
+
+	for _, session := range sessions {
+		if _, stopped := c.limitStops.Load(session.transaction.TransactionId); stopped {
+			continue
+		}
+		c.checkRunningSession(ctx, session, unbilled)
+	}
+}
+
+// runningSession is a session in progress with the user it is charged to and
+// its consumption so far.
+type runningSession struct {
+	transaction     *entity.Transaction
+	username, group string
+	consumption
+}
+
+// runningSession resolves the user of a running session and takes its
+// consumption from the last meter reading. It returns nil for sessions
+// without a known user.
+func (c *Core) runningSession(ctx context.Context, transaction *entity.Transaction) *runningSession {
+	log := c.log.With(slog.Int("transaction_id", transaction.TransactionId))
+
+	tag := transaction.UserTag
+	if tag == nil {
+		var err error
+		if tag, err = c.repo.GetUserTag(ctx, transaction.IdTag); err != nil || tag == nil {
+			return nil
+		}
+	}
+	if tag.Username == "" {
+		return nil
+	}
+	session := &runningSession{transaction: transaction, username: tag.Username}
+	info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, tag.Username)
+	if err == nil && info != nil {
+		session.group = info.Group
+	}
+
+	last, err := c.repo.GetLastMeterValue(ctx, transaction.TransactionId)
+	if err != nil {
+		log.With(sl.Err(err)).Warn("failed to get last meter value")
+		return nil
+	}
+	if last != nil {
+		session.amount = last.Price
+		session.energy = max(last.Value-transaction.MeterStart, 0)
+	}
+	return session
+}
+
+func (c *Core) checkRunningSession(ctx context.Context, session *runningSession, unbilled map[[2]string]consumption) {
+	transaction := session.transaction
+	log := c.log.With(slog.Int("transaction_id", transaction.TransactionId))
+
+	reached, err := c.reachedLimit(ctx, session.username, session.group, transaction.TimeStart, unbilled, true)
+	if err != nil {
+		log.With(sl.Err(err)).Warn("failed to check usage limits")
+		return
+	}
+	if reached == nil {
+		return
+	}
+
+	command := entity.NewCommandStopTransaction(transaction.ChargePointId, transaction.ConnectorId, transaction.TransactionId)
+	response := c.cs.SendCommand(command)
+	if response.IsError() {
+		log.With(slog.String("info", response.Info)).Warn("failed to stop session at usage limit")
+		return
+	}
+	c.limitStops.Store(transaction.TransactionId, true)
+	log.With(slog.String("user", session.username)).Info("session stopped at usage limit")
+	c.payLog(ctx, "warning", "limits",
+		"transaction %d stopped: %s reached (user %s)",
+		transaction.TransactionId, describeLimit(reached), session.username)
+}
+
+// usagePeriods returns the day and month keys of the running totals.
+func usagePeriods(t time.Time) (day, month string) {
+	return t.Format("2006-01-02"), t.Format("2006-01")
+}
+
+// describeLimit names a limit for messages, e.g. "daily spending limit of
+// 50.00 for group acme".
+func describeLimit(u *entity.LimitUsage) string {
+	value := fmt.Sprintf("%.2f", float64(u.Limit)/100)
+	kind := "spending"
+	if u.Kind == entity.LimitKindEnergy {
+		value = fmt.Sprintf("%.1f kWh", float64(u.Limit)/1000)
+		kind = "energy"
+	}
+	return fmt.Sprintf("%s %s limit of %s for %s %s", u.Period, kind, value, u.Scope, u.Subject)
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commandRecorder is a central system that accepts every command.
+type commandRecorder struct {
+	mu       sync.Mutex
+	commands []*entity.CentralSystemCommand
+}
+
+func (r *commandRecorder) SendCommand(command *entity.CentralSystemCommand) *entity.CentralSystemResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, command)
+	return entity.NewCentralSystemResponse(command.ChargePointId, command.ConnectorId)
+}
+
+func (r *commandRecorder) Commands() []*entity.CentralSystemCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.commands
+}
+
+func TestUsageLimits_RecordedAtBilling(t *testing.T) {
+	ctx := context.Background()
+	c, db, _ := newWalletCore(t, 0)
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Group: "acme"})
+	require.NoError(t, db.SaveUsageLimit(ctx, &entity.UsageLimit{Scope: entity.LimitScopeGroup, Subject: "acme", DailyAmount: 5000}))
+	now := time.Now()
+	db.SeedTransaction(&entity.Transaction{
+		TransactionId: 1, IdTag: "TAG1", IsFinished: true, PaymentAmount: 1500,
+		MeterStart: 1000, MeterStop: 13000, TimeStart: now,
+	})
+
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	waitBilled(t, db, 1500)
+	day, month := usagePeriods(now)
+	require.Eventually(t, func() bool {
+		usage, _ := db.GetUsage(ctx, entity.LimitScopeGroup, "acme", month)
+		return usage != nil
+	}, time.Second, 5*time.Millisecond)
+
+	for _, scope := range [][2]string{{entity.LimitScopeUser, "driver"}, {entity.LimitScopeGroup, "acme"}} {
+		for _, period := range []string{day, month} {
+			usage, _ := db.GetUsage(ctx, scope[0], scope[1], period)
+			require.NotNil(t, usage, "%s %s", scope[1], period)
+			assert.Equal(t, 1500, usage.Amount)
+			assert.Equal(t, 12000, usage.Energy)
+			assert.Equal(t, 1, usage.Transactions)
+		}
+	}
+
+	info, err := c.GetUser(ctx, &entity.User{Username: "driver", UserId: "u1"}, "0000")
+	require.NoError(t, err)
+	require.Len(t, info.Limits, 1)
+	assert.Equal(t, entity.LimitUsage{
+		Scope: entity.LimitScopeGroup, Subject: "acme", Period: entity.LimitPeriodDaily, Kind: entity.LimitKindAmount,
+		Limit: 5000, Used: 1500, Remaining: 3500,
+	}, *info.Limits[0])
+}
+
+func TestValidateStartTransactionPaymentMethod_UsageLimits(t *testing.T) {
+	now := time.Now()
+	day, month := usagePeriods(now)
+	tests := []struct {
+		name    string
+		limit   entity.UsageLimit
+		period  string
+		amount  int
+		energy  int
+		wantErr bool
+	}{
+		{"user daily amount left", entity.UsageLimit{Scope: entity.LimitScopeUser, Subject: "driver", DailyAmount: 2000}, day, 1999, 0, false},
+		{"user daily amount used up", entity.UsageLimit{Scope: entity.LimitScopeUser, Subject: "driver", DailyAmount: 2000}, day, 2000, 0, true},
+		{"group monthly energy used up", entity.UsageLimit{Scope: entity.LimitScopeGroup, Subject: "acme", MonthlyEnergy: 50000}, month, 0, 50000, true},
+		{"yesterday does not count", entity.UsageLimit{Scope: entity.LimitScopeUser, Subject: "driver", DailyEnergy: 1000}, now.AddDate(0, 0, -1).Format("2006-01-02"), 0, 5000, false},
+		{"other group", entity.UsageLimit{Scope: entity.LimitScopeGroup, Subject: "other", DailyAmount: 100}, day, 500, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, _ := newWalletCore(t, 0)
+			db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Group: "acme"})
+			require.NoError(t, db.SaveUsageLimit(ctx, &tt.limit))
+			require.NoError(t, db.AddUsage(ctx, tt.limit.Scope, tt.limit.Subject, tt.period, tt.amount, tt.energy))
+
+			err := c.validateStartTransactionPaymentMethod(ctx, &entity.UserRequest{Token: "TAG1"})
+			if tt.wantErr {
+				assert.ErrorIs(t, err, entity.ErrLimitReached)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProcessUsageLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		stopSession bool
+		price       int
+		stopped     bool
+	}{
+		{"running session reaches the limit", true, 800, true},
+		{"running session below the limit", true, 700, false},
+		{"limit without session stop", false, 800, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, _ := newWalletCore(t, 0)
+			cs := &commandRecorder{}
+			c.SetCentralSystem(cs)
+			now := time.Now()
+			day, _ := usagePeriods(now)
+			require.NoError(t, db.SaveUsageLimit(ctx, &entity.UsageLimit{
+				Scope: entity.LimitScopeUser, Subject: "driver", DailyAmount: 2000, StopSession: tt.stopSession,
+			}))
+			require.NoError(t, db.AddUsage(ctx, entity.LimitScopeUser, "driver", day, 1200, 0))
+			db.SeedTransaction(&entity.Transaction{
+				TransactionId: 7, ChargePointId: "CP1", ConnectorId: 2, IdTag: "TAG1", TimeStart: now,
+			})
+			db.SeedMeterValue(7, entity.TransactionMeter{Value: 4000, Price: tt.price, Time: now})
+
+			c.processUsageLimits(ctx)
+			c.processUsageLimits(ctx) // a stopped session is not stopped again
+			if !tt.stopped {
+				assert.Empty(t, cs.Commands())
+				return
+			}
+			require.Len(t, cs.Commands(), 1)
+			assert.Equal(t, *entity.NewCommandStopTransaction("CP1", 2, 7), *cs.Commands()[0])
+		})
+	}
+}
+
+func TestProcessUsageLimits_GroupSessions(t *testing.T) {
+	ctx := context.Background()
+	c, db, _ := newWalletCore(t, 0)
+	cs := &commandRecorder{}
+	c.SetCentralSystem(cs)
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Group: "acme"})
+	db.SeedUser(&entity.User{Username: "mate", UserId: "u2", Group: "acme"})
+	require.NoError(t, db.AddUserTag(ctx, &entity.UserTag{UserId: "u2", Username: "mate", IdTag: "TAG2"}))
+	require.NoError(t, db.SaveUsageLimit(ctx, &entity.UsageLimit{
+		Scope: entity.LimitScopeGroup, Subject: "acme", DailyAmount: 1000, StopSession: true,
+	}))
+	// each session alone stays below the group limit, together they reach it
+	now := time.Now()
+	for id, tag := range map[int]string{7: "TAG1", 8: "TAG2"} {
+		db.SeedTransaction(&entity.Transaction{TransactionId: id, ChargePointId: "CP1", IdTag: tag, TimeStart: now})
+		db.SeedMeterValue(id, entity.TransactionMeter{Value: 4000, Price: 600, Time: now})
+	}
+
+	c.processUsageLimits(ctx)
+
+	require.Len(t, cs.Commands(), 2)
+}
+
+func TestUsageLimits_RecordedWithPaymentDisabled(t *testing.T) {
+	ctx := context.Background()
+	c, db, g := newWalletCore(t, 0)
+	c.SetDisablePayment(true)
+	now := time.Now()
+	db.SeedTransaction(&entity.Transaction{
+		TransactionId: 1, IdTag: "TAG1", IsFinished: true, PaymentAmount: 1500,
+		MeterStart: 1000, MeterStop: 13000, TimeStart: now,
+	})
+
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	assert.Empty(t, g.Calls())
+	day, _ := usagePeriods(now)
+	usage, err := db.GetUsage(ctx, entity.LimitScopeUser, "driver", day)
+	require.NoError(t, err)
+	require.NotNil(t, usage)
+	assert.Equal(t, 1500, usage.Amount)
+	assert.Equal(t, 12000, usage.Energy)
+}
+
+func TestUsageLimits_AdminOnly(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newWalletCore(t, 0)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	driver := &entity.User{Username: "driver", UserId: "u1"}
+	limit := &entity.UsageLimit{Scope: entity.LimitScopeUser, Subject: "driver", DailyAmount: 1000}
+
+	_, err := c.SaveUsageLimit(ctx, driver, limit)
+	assert.Error(t, err)
+	_, err = c.SaveUsageLimit(ctx, admin, &entity.UsageLimit{Scope: entity.LimitScopeUser, Subject: "nobody", DailyAmount: 1000})
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+
+	_, err = c.SaveUsageLimit(ctx, admin, limit)
+	require.NoError(t, err)
+	limits, err := c.ListUsageLimits(ctx, admin)
+	require.NoError(t, err)
+	assert.Len(t, limits, 1)
+	require.NoError(t, c.DeleteUsageLimit(ctx, admin, entity.LimitScopeUser, "driver"))
+	assert.ErrorIs(t, c.DeleteUsageLimit(ctx, admin, entity.LimitScopeUser, "driver"), entity.ErrNotFound)
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// payEvent records a typed payment event and writes its text to the
+// payment log, like payLog. Failures are logged and swallowed.
+func (c *Core) payEvent(ctx context.Context, level, category string, event entity.PaymentEvent, format string, args ...any) {
+	if c.repo == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	event.Level = level
+	event.Text = fmt.Sprintf(format, args...)
+	if err := c.repo.AddPaymentEvent(ctx, &event); err != nil {
+		c.log.With(sl.Err(err)).Warn("failed to write payment event")
+	}
+	c.payLog(ctx, level, category, "%s", event.Text)
+}
+
+// GetPaymentEvents returns the payment events matching the filter, newest
+// first (admin only).
+func (c *Core) GetPaymentEvents(ctx context.Context, author *entity.User, filter *entity.PaymentEventFilter) ([]*entity.PaymentEvent, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsRead); err != nil {
+		return nil, err
+	}
+	events, err := c.repo.GetPaymentEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = make([]*entity.PaymentEvent, 0)
+	}
+	return events, nil
+}
+
+// GetPaymentTimeline returns the payment events of a transaction, oldest
+// first (admin only).
+func (c *Core) GetPaymentTimeline(ctx context.Context, author *entity.User, transactionId int) ([]*entity.PaymentEvent, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsRead); err != nil {
+		return nil, err
+	}
+	transaction, err := c.repo.GetTransaction(ctx, transactionId)
+	if err != nil {
+		return nil, err
+	}
+	if transaction == nil {
+		return nil, fmt.Errorf("transaction %d %w", transactionId, entity.ErrNotFound)
+	}
+	events, err := c.repo.GetPaymentEvents(ctx, &entity.PaymentEventFilter{TransactionId: transactionId})
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = make([]*entity.PaymentEvent, 0)
+	}
+	slices.Reverse(events)
+	return events, nil
+}
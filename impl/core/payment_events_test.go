@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func eventTypes(events []*entity.PaymentEvent) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestGetPaymentTimeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		decline bool
+		types   []string
+	}{
+		{"paid", false, []string{entity.PaymentEventOrderCreated, entity.PaymentEventPaid}},
+		{"declined", true, []string{entity.PaymentEventOrderCreated, entity.PaymentEventDeclined, entity.PaymentEventRetryScheduled}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			admin := &entity.User{Username: "admin", Role: "admin"}
+			c, db, _ := newWalletCore(t, 0)
+			if tt.decline {
+				c.SetPaymentGateway(&declineGateway{})
+			}
+			db.SeedTransaction(&entity.Transaction{TransactionId: 1, IdTag: "TAG1", IsFinished: true, PaymentAmount: 1500})
+			db.SeedTransaction(&entity.Transaction{TransactionId: 2, IdTag: "TAG1", IsFinished: true})
+
+			require.NoError(t, c.PayTransaction(ctx, 1))
+			var timeline []*entity.PaymentEvent
+			require.Eventually(t, func() bool {
+				var err error
+				timeline, err = c.GetPaymentTimeline(ctx, admin, 1)
+				return err == nil && len(timeline) == len(tt.types)
+			}, time.Second, 5*time.Millisecond)
+			assert.Equal(t, tt.types, eventTypes(timeline), "oldest first")
+
+			created := timeline[0]
+			assert.Equal(t, 1, created.TransactionId)
+			assert.Equal(t, 1200, created.Order)
+			assert.Equal(t, "u1", created.UserId)
+			assert.Equal(t, "driver", created.Username)
+			assert.Equal(t, 1500, created.Amount)
+			assert.Equal(t, entity.CardHash("tok-1"), created.CardHash)
+			assert.NotContains(t, created.CardHash, "tok-1")
+			assert.NotEmpty(t, created.Text)
+			if tt.decline {
+				assert.Equal(t, "0190", timeline[1].Code)
+			}
+
+			empty, err := c.GetPaymentTimeline(ctx, admin, 2)
+			require.NoError(t, err)
+			assert.Empty(t, empty)
+			_, err = c.GetPaymentTimeline(ctx, admin, 3)
+			assert.ErrorIs(t, err, entity.ErrNotFound)
+		})
+	}
+}
+
+func TestGetPaymentEvents(t *testing.T) {
+	ctx := context.Background()
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	c, db, _ := newWalletCore(t, 0)
+	for _, id := range []int{1, 2} {
+		db.SeedTransaction(&entity.Transaction{TransactionId: id, IdTag: "TAG1", IsFinished: true, PaymentAmount: 1000 * id})
+		require.NoError(t, c.PayTransaction(ctx, id))
+		require.Eventually(t, func() bool {
+			tx, _ := db.GetTransaction(ctx, id)
+			return tx.PaymentOrder != 0
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	tests := []struct {
+		name   string
+		filter entity.PaymentEventFilter
+		want   int
+	}{
+		{"all", entity.PaymentEventFilter{}, 4},
+		{"by type", entity.PaymentEventFilter{Type: entity.PaymentEventPaid}, 2},
+		{"by transaction", entity.PaymentEventFilter{TransactionId: 2}, 2},
+		{"by order", entity.PaymentEventFilter{Order: 1200}, 2},
+		{"by card", entity.PaymentEventFilter{CardHash: entity.CardHash("tok-1")}, 4},
+		{"by user", entity.PaymentEventFilter{Username: "other"}, 0},
+		{"limited", entity.PaymentEventFilter{Limit: 1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := c.GetPaymentEvents(ctx, admin, &tt.filter)
+			require.NoError(t, err)
+			assert.Len(t, events, tt.want)
+		})
+	}
+
+	events, _ := c.GetPaymentEvents(ctx, admin, &entity.PaymentEventFilter{})
+	assert.Equal(t, entity.PaymentEventPaid, events[0].Type, "newest first")
+	assert.Equal(t, 2, events[0].TransactionId)
+
+	_, err := c.GetPaymentEvents(ctx, &entity.User{Username: "driver"}, &entity.PaymentEventFilter{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"fmt"
+)
+
+// ListPaymentPlans returns all payment plans (admin only).
+func (c *Core) ListPaymentPlans(ctx context.Context, author *entity.User) ([]*entity.PaymentPlan, error) {
+	if err := c.requirePermission(author, entity.PermissionPlansWrite); err != nil {
+		return nil, err
+	}
+	return c.repo.ListPaymentPlans(ctx)
+}
+
+// GetPaymentPlan returns one payment plan by id (admin only).
+func (c *Core) GetPaymentPlan(ctx context.Context, author *entity.User, planId string) (*entity.PaymentPlan, error) {
+	if err := c.requirePermission(author, entity.PermissionPlansWrite); err != nil {
+		return nil, err
+	}
+	return c.paymentPlan(ctx, planId)
+}
+
+// CreatePaymentPlan stores a new payment plan (admin only).
+func (c *Core) CreatePaymentPlan(ctx context.Context, author *entity.User, plan *entity.PaymentPlan) (*entity.PaymentPlan, error) {
+	if err := c.requirePermission(author, entity.PermissionPlansWrite); err != nil {
+		return nil, err
+	}
+	if plan.PlanId == "" {
+		return nil, fmt.Errorf("plan id is required")
+	}
+	existing, err := c.repo.GetPaymentPlan(ctx, plan.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("payment plan %s already exists", plan.PlanId)
+	}
+	if err = c.checkGroupDefaults(ctx, plan); err != nil {
+		return nil, err
+	}
+	if err = c.repo.SavePaymentPlan(ctx, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// UpdatePaymentPlan edits a payment plan (admin only). The active flag is kept
+// as stored: plans are activated and deactivated through their own calls so
+// that deactivation always goes past the affected-users check.
+func (c *Core) UpdatePaymentPlan(ctx context.Context, author *entity.User, plan *entity.PaymentPlan) (*entity.PaymentPlan, error) {
+	if err := c.requirePermission(author, entity.PermissionPlansWrite); err != nil {
+		return nil, err
+	}
+	existing, err := c.paymentPlan(ctx, plan.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	plan.IsActive = existing.IsActive
+	if err = c.checkGroupDefaults(ctx, plan); err != nil {
+		return nil, err
+	}
+	if err = c.repo.SavePaymentPlan(ctx, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ActivatePaymentPlan makes a plan assignable again (admin only).
+func (c *Core) ActivatePaymentPlan(ctx context.Context, author *entity.User, planId string) (*entity.PaymentPlan, error) {
+	if err := c.requirePermission(author, entity.PermissionPlansWrite); err != nil {
+		return nil, err
+	}
+	plan, err := c.paymentPlan(ctx, planId)
+	if err != nil {
+		return nil, err
+	}
+	plan.IsActive = true
+	if err = c.checkGroupDefaults(ctx, plan); err != nil {
+		return nil, err
+	}
+	if err = c.repo.SavePaymentPlan(ctx, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// DeactivatePaymentPlan retires a plan (admin only). Without confirm it only
+// reports the users still assigned to the plan, so the caller can review them
+// first; with confirm the plan is deactivated and the same list is returned.
+// Users keep their assignment, the plan just cannot be assigned anymore.
+func (c *Core) DeactivatePaymentPlan(ctx context.Context, author *entity.User, planId string, confirm bool) (*entity.PaymentPlanDeactivation, error) {
+	if err := c.requirePermission(author, entity.PermissionPlansWrite); err != nil {
+		return nil, err
+	}
+	plan, err := c.paymentPlan(ctx, planId)
+	if err != nil {
+		return nil, err
+	}
+	users, err := c.repo.GetUsersByPaymentPlan(ctx, planId)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		clearPassword(user)
+	}
+	result := &entity.PaymentPlanDeactivation{
+		PlanId:        planId,
+		AffectedUsers: users,
+	}
+	if !confirm {
+		return result, nil
+	}
+	plan.IsActive = false
+	if err = c.repo.SavePaymentPlan(ctx, plan); err != nil {
+		return nil, err
+	}
+	result.Deactivated = true
+	return result, nil
+}
+
+func (c *Core) paymentPlan(ctx context.Context, planId string) (*entity.PaymentPlan, error) {
+	plan, err := c.repo.GetPaymentPlan(ctx, planId)
+	if err != nil {
+		return nil, err
+	}
+	if plan == nil {
+		return nil, fmt.Errorf("payment plan %w", entity.ErrNotFound)
+	}
+	return plan, nil
+}
+
+// checkGroupDefaults rejects an active plan claiming a user group that another
+// active plan is already the default for.
+func (c *Core) checkGroupDefaults(ctx context.Context, plan *entity.PaymentPlan) error {
+	if !plan.IsActive || len(plan.UserGroups) == 0 {
+		return nil
+	}
+	plans, err := c.repo.ListPaymentPlans(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range plans {
+		if other.PlanId == plan.PlanId || !other.IsActive {
+			continue
+		}
+		for _, group := range other.UserGroups {
+			for _, g := range plan.UserGroups {
+				if g == group {
+					return fmt.Errorf("group %s already has default plan %s", group, other.PlanId)
+				}
+			}
+		}
+	}
+	return nil
+}
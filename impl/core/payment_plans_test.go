@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPlanCore(t *testing.T) (*Core, *database_mock.MockDB) {
+	t.Helper()
+	db := database_mock.NewMockDB()
+	c := New(newTestLogger(), db)
+	c.SetAuth(authenticator.New(newTestLogger(), db))
+	return c, db
+}
+
+func TestCreatePaymentPlan_GroupDefaultConflict(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newPlanCore(t)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+
+	_, err := c.CreatePaymentPlan(ctx, admin, &entity.PaymentPlan{PlanId: "fleet", IsActive: true, UserGroups: []string{"acme"}})
+	require.NoError(t, err)
+
+	_, err = c.CreatePaymentPlan(ctx, admin, &entity.PaymentPlan{PlanId: "fleet", IsActive: true})
+	assert.ErrorContains(t, err, "already exists")
+
+	_, err = c.CreatePaymentPlan(ctx, admin, &entity.PaymentPlan{PlanId: "fleet2", IsActive: true, UserGroups: []string{"acme"}})
+	assert.ErrorContains(t, err, "already has default plan fleet")
+
+	// an inactive plan may name the group; activating it is what conflicts
+	_, err = c.CreatePaymentPlan(ctx, admin, &entity.PaymentPlan{PlanId: "fleet2", UserGroups: []string{"acme"}})
+	require.NoError(t, err)
+	_, err = c.ActivatePaymentPlan(ctx, admin, "fleet2")
+	assert.Error(t, err)
+
+	_, err = c.CreatePaymentPlan(ctx, &entity.User{Username: "user"}, &entity.PaymentPlan{PlanId: "x"})
+	assert.Error(t, err)
+}
+
+func TestUpdatePaymentPlan_KeepsActiveFlag(t *testing.T) {
+	ctx := context.Background()
+	c, db := newPlanCore(t)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	require.NoError(t, db.SavePaymentPlan(ctx, &entity.PaymentPlan{PlanId: "basic", IsActive: true, PricePerKwh: 30}))
+
+	plan, err := c.UpdatePaymentPlan(ctx, admin, &entity.PaymentPlan{PlanId: "basic", PricePerKwh: 35})
+	require.NoError(t, err)
+	assert.True(t, plan.IsActive)
+	assert.Equal(t, 35, plan.PricePerKwh)
+
+	_, err = c.UpdatePaymentPlan(ctx, admin, &entity.PaymentPlan{PlanId: "missing"})
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+}
+
+func TestDeactivatePaymentPlan(t *testing.T) {
+	ctx := context.Background()
+	c, db := newPlanCore(t)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	require.NoError(t, db.SavePaymentPlan(ctx, &entity.PaymentPlan{PlanId: "basic", IsActive: true}))
+	db.SeedUser(&entity.User{Username: "a", Password: "hash", PaymentPlan: "basic"})
+	db.SeedUser(&entity.User{Username: "b", Password: "hash", PaymentPlan: "other"})
+
+	preview, err := c.DeactivatePaymentPlan(ctx, admin, "basic", false)
+	require.NoError(t, err)
+	assert.False(t, preview.Deactivated)
+	require.Len(t, preview.AffectedUsers, 1)
+	assert.Equal(t, "a", preview.AffectedUsers[0].Username)
+	assert.Empty(t, preview.AffectedUsers[0].Password)
+	plan, _ := db.GetPaymentPlan(ctx, "basic")
+	assert.True(t, plan.IsActive)
+
+	result, err := c.DeactivatePaymentPlan(ctx, admin, "basic", true)
+	require.NoError(t, err)
+	assert.True(t, result.Deactivated)
+	plan, _ = db.GetPaymentPlan(ctx, "basic")
+	assert.False(t, plan.IsActive)
+}
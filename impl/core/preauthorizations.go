@@ -0,0 +1,376 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Preauthorization lifecycle defaults, used when the configuration leaves them unset.
+const (
+	defaultHoldStartTimeout = 30 * time.Minute
+	defaultHoldMaxAge       = 24 * time.Hour
+)
+
+// PreauthorizationConfig drives the automatic lifecycle of preauthorizations.
+type PreauthorizationConfig struct {
+	// StartTimeout is how long a hold waits for its charging session to
+	// start; a hold with no session by then is cancelled.
+	StartTimeout time.Duration
+	// MaxAge is how long a hold may stay authorized at all; older holds are
+	// captured when their session is finished and cancelled otherwise.
+	MaxAge time.Duration
+}
+
+func (c *Core) SetPreauthorization(conf *PreauthorizationConfig) {
+	c.preauthorization = conf
+}
+
+// holdTimeouts returns the configured lifecycle timeouts, with defaults.
+func (c *Core) holdTimeouts() (startTimeout, maxAge time.Duration) {
+	startTimeout, maxAge = defaultHoldStartTimeout, defaultHoldMaxAge
+	if c.preauthorization != nil {
+		if c.preauthorization.StartTimeout > 0 {
+			startTimeout = c.preauthorization.StartTimeout
+		}
+		if c.preauthorization.MaxAge > 0 {
+			maxAge = c.preauthorization.MaxAge
+		}
+	}
+	return startTimeout, maxAge
+}
+
+// transactionHold returns the authorized preauthorization covering a
+// transaction. A hold created for the session is used as is; otherwise the
+// user's latest unlinked hold is linked to the transaction if the session
+// started within the start timeout after it was authorized.
+func (c *Core) transactionHold(ctx context.Context, transaction *entity.Transaction, userId string) *entity.Preauthorization {
+	hold, err := c.repo.GetPreauthorizationByTransaction(ctx, transaction.TransactionId)
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to get preauthorization of transaction")
+		return nil
+	}
+	if hold != nil {
+		if hold.Status != entity.PreauthorizationStatusAuthorized {
+			return nil
+		}
+		return hold
+	}
+
+	hold, err = c.repo.GetUserAuthorizedPreauthorization(ctx, userId)
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to get user preauthorization")
+		return nil
+	}
+	if hold == nil {
+		return nil
+	}
+	startTimeout, _ := c.holdTimeouts()
+	if transaction.TimeStart.Before(hold.CreatedAt) || transaction.TimeStart.Sub(hold.CreatedAt) > startTimeout {
+		return nil
+	}
+	if err = c.linkHold(ctx, hold, transaction.TransactionId); err != nil {
+		return nil
+	}
+	return hold
+}
+
+// linkHold attaches a preauthorization to the transaction it covers.
+func (c *Core) linkHold(ctx context.Context, hold *entity.Preauthorization, transactionId int) error {
+	hold.TransactionId = transactionId
+	hold.UpdatedAt = time.Now()
+	if err := c.repo.SavePreauthorization(ctx, hold); err != nil {
+		c.log.With(
+			slog.String("order", hold.OrderNumber),
+			slog.Int("transaction_id", transactionId),
+			sl.Err(err),
+		).Error("failed to link preauthorization")
+		return err
+	}
+	order, _ := strconv.Atoi(hold.OrderNumber)
+	c.payEvent(ctx, "info", "preauth", entity.PaymentEvent{
+		Type:          entity.PaymentEventHoldLinked,
+		TransactionId: transactionId,
+		Order:         order,
+		UserId:        hold.UserId,
+		Username:      hold.UserName,
+		Amount:        hold.PreauthorizedAmount,
+		CardHash:      entity.CardHash(hold.PaymentMethodId),
+	}, "transaction %d: linked to preauthorization %s of %.2f (user %s)",
+		transactionId, hold.OrderNumber, float64(hold.PreauthorizedAmount)/100, hold.UserName)
+	return nil
+}
+
+// settleHold bills a finished transaction from its preauthorization. It
+// returns false when the transaction has no usable hold and has to be charged
+// with a direct MIT payment instead. A hold smaller than the amount due is
+// released first. Called by PayTransaction with the transaction lock held.
+func (c *Core) settleHold(ctx context.Context, transaction *entity.Transaction, tag *entity.UserTag, amount int) bool {
+	hold := c.transactionHold(ctx, transaction, tag.UserId)
+	if hold == nil {
+		return false
+	}
+
+	if amount > hold.PreauthorizedAmount {
+		c.payLog(ctx, "warning", "preauth",
+			"transaction %d: amount %.2f exceeds preauthorization %s of %.2f; releasing it and charging the card",
+			transaction.TransactionId, float64(amount)/100, hold.OrderNumber, float64(hold.PreauthorizedAmount)/100)
+		c.runAsync("cancelHold", func(ctx context.Context) {
+			c.cancelHold(ctx, hold, "amount exceeds preauthorization")
+		})
+		return false
+	}
+
+	orderNum, err := strconv.Atoi(hold.OrderNumber)
+	if err != nil {
+		c.log.With(slog.String("order", hold.OrderNumber), sl.Err(err)).Error("invalid preauthorization order number")
+		return false
+	}
+
+	// Record the held card on the transaction, as a direct payment does
+	if pm, _ := c.repo.GetPaymentMethodByIdentifier(ctx, hold.PaymentMethodId); pm != nil {
+		transaction.PaymentMethod = pm
+		if e := c.repo.UpdateTransactionPayment(ctx, transaction); e != nil {
+			c.log.With(sl.Err(e)).Error("failed to persist payment method on transaction")
+		}
+	}
+
+	consumed := (transaction.MeterStop - transaction.MeterStart) / 1000
+	paymentOrder := entity.PaymentOrder{
+		Order:           orderNum,
+		Amount:          amount,
+		Description:     fmt.Sprintf("%s:%d %dkW", transaction.ChargePointId, transaction.ConnectorId, consumed),
+		Identifier:      hold.PaymentMethodId,
+		TransactionId:   transaction.TransactionId,
+		UserId:          tag.UserId,
+		UserName:        tag.Username,
+		TransactionType: entity.RedsysTxCapture,
+		// Ordered with the other orders by when its number was taken.
+		TimeOpened: hold.CreatedAt,
+	}
+	if e := c.repo.SavePaymentOrder(ctx, &paymentOrder); e != nil {
+		c.log.With(sl.Err(e)).Error("failed to save capture order")
+		return false
+	}
+
+	c.payEvent(ctx, "info", "preauth", entity.PaymentEvent{
+		Type:          entity.PaymentEventHoldCapture,
+		TransactionId: transaction.TransactionId,
+		Order:         orderNum,
+		UserId:        tag.UserId,
+		Username:      tag.Username,
+		Amount:        amount,
+		CardHash:      entity.CardHash(hold.PaymentMethodId),
+	}, "transaction %d: capturing %.2f of preauthorization %s (user %s)",
+		transaction.TransactionId, float64(amount)/100, hold.OrderNumber, tag.Username)
+
+	req := CaptureRequest{
+		OrderNumber:       hold.OrderNumber,
+		Amount:            amount,
+		AuthorizationCode: hold.AuthorizationCode,
+	}
+	c.runAsync("processCapture", func(ctx context.Context) {
+		c.processCapture(ctx, hold, req, orderNum)
+	})
+	return true
+}
+
+// processCapture captures the final amount of a session from its hold. An
+// approved capture bills the transaction like a payment; a declined one fails
+// the hold and falls back to a direct payment. A capture that got no answer
+// is handled as a failed payment and retried, with the hold still in place.
+func (c *Core) processCapture(ctx context.Context, hold *entity.Preauthorization, req CaptureRequest, orderId int) {
+	log := c.log.With(slog.String("order", req.OrderNumber), slog.Int("amount", req.Amount))
+
+	resp, err := c.gateway.Capture(ctx, req)
+	if err != nil {
+		log.With(sl.Err(err)).Error("capture request failed")
+		order, _ := c.repo.GetPaymentOrder(ctx, orderId)
+		if order != nil {
+			c.closeOrderOnError(ctx, order, err.Error())
+		}
+		return
+	}
+
+	if !resp.Success {
+		result := resultCode(resp)
+		log.With(slog.String("result", result)).Warn("capture rejected by gateway")
+		hold.Status = entity.PreauthorizationStatusFailed
+		hold.ErrorCode = result
+		hold.ErrorMessage = resp.ErrorMessage
+		hold.UpdatedAt = time.Now()
+		if e := c.repo.UpdatePreauthorization(ctx, hold); e != nil {
+			log.With(sl.Err(e)).Error("failed to update preauthorization after capture")
+		}
+
+		order, _ := c.repo.GetPaymentOrder(ctx, orderId)
+		if order == nil {
+			return
+		}
+		order.IsCompleted = true
+		order.Result = result
+		order.TimeClosed = time.Now()
+		if e := c.repo.SavePaymentOrder(ctx, order); e != nil {
+			log.With(sl.Err(e)).Error("failed to close capture order")
+		}
+		c.payEvent(ctx, "warning", "preauth", entity.PaymentEvent{
+			Type:          entity.PaymentEventHoldDeclined,
+			TransactionId: order.TransactionId,
+			Order:         order.Order,
+			UserId:        order.UserId,
+			Username:      order.UserName,
+			Amount:        order.Amount,
+			CardHash:      entity.CardHash(order.Identifier),
+			Code:          result,
+		}, "transaction %d: capture of preauthorization %s declined (%s); charging the card",
+			order.TransactionId, hold.OrderNumber, result)
+		if e := c.PayTransaction(ctx, order.TransactionId); e != nil {
+			log.With(sl.Err(e)).Error("failed to charge transaction after declined capture")
+		}
+		return
+	}
+
+	hold.Status = entity.PreauthorizationStatusCaptured
+	hold.CapturedAmount = req.Amount
+	hold.UpdatedAt = time.Now()
+	if e := c.repo.UpdatePreauthorization(ctx, hold); e != nil {
+		log.With(sl.Err(e)).Error("failed to update preauthorization after capture")
+	}
+
+	c.processPaymentResponse(ctx, resp, orderId)
+}
+
+// cancelHold releases a preauthorization at the gateway. A hold the gateway
+// refuses to cancel stays authorized so the next sweep tries again.
+func (c *Core) cancelHold(ctx context.Context, hold *entity.Preauthorization, reason string) {
+	log := c.log.With(slog.String("order", hold.OrderNumber), slog.String("reason", reason))
+
+	resp, err := c.gateway.Cancel(ctx, CaptureRequest{
+		OrderNumber:       hold.OrderNumber,
+		Amount:            hold.PreauthorizedAmount,
+		AuthorizationCode: hold.AuthorizationCode,
+	})
+	if err != nil {
+		log.With(sl.Err(err)).Error("cancel request failed")
+		return
+	}
+	if !resp.Success {
+		result := resultCode(resp)
+		log.With(slog.String("result", result)).Warn("cancel rejected by gateway")
+		c.payLog(ctx, "error", "preauth",
+			"preauthorization %s: release failed (%s), %s", hold.OrderNumber, result, reason)
+		return
+	}
+
+	hold.Status = entity.PreauthorizationStatusCancelled
+	hold.ErrorMessage = reason
+	hold.UpdatedAt = time.Now()
+	if e := c.repo.UpdatePreauthorization(ctx, hold); e != nil {
+		log.With(sl.Err(e)).Error("failed to update preauthorization after cancel")
+	}
+	log.Info("preauthorization released")
+	order, _ := strconv.Atoi(hold.OrderNumber)
+	c.payEvent(ctx, "info", "preauth", entity.PaymentEvent{
+		Type:          entity.PaymentEventHoldReleased,
+		TransactionId: hold.TransactionId,
+		Order:         order,
+		UserId:        hold.UserId,
+		Username:      hold.UserName,
+		Amount:        hold.PreauthorizedAmount,
+		CardHash:      entity.CardHash(hold.PaymentMethodId),
+	}, "preauthorization %s: released %.2f (user %s), %s",
+		hold.OrderNumber, float64(hold.PreauthorizedAmount)/100, hold.UserName, reason)
+}
+
+// processPreauthorizations sweeps authorized holds older than the start
+// timeout: holds whose session never started are cancelled, holds of finished
+// sessions are settled, and holds older than the max age are released.
+func (c *Core) processPreauthorizations(ctx context.Context) {
+	if c.gateway == nil {
+		return
+	}
+
+	startTimeout, maxAge := c.holdTimeouts()
+	now := time.Now()
+	holds, err := c.repo.GetAuthorizedPreauthorizations(ctx, now.Add(-startTimeout))
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to get authorized preauthorizations")
+		return
+	}
+
+	for _, hold := range holds {
+		c.sweepHold(ctx, hold, now.Sub(hold.CreatedAt) > maxAge)
+	}
+}
+
+func (c *Core) sweepHold(ctx context.Context, hold *entity.Preauthorization, expired bool) {
+	if hold.TransactionId == 0 {
+		transaction := c.findHoldTransaction(ctx, hold)
+		if transaction == nil {
+			c.cancelHold(ctx, hold, "session never started")
+			return
+		}
+		if c.linkHold(ctx, hold, transaction.TransactionId) != nil {
+			return
+		}
+	}
+
+	transaction, err := c.repo.GetTransaction(ctx, hold.TransactionId)
+	if err != nil {
+		c.log.With(slog.Int("transaction_id", hold.TransactionId), sl.Err(err)).Error("failed to get transaction of preauthorization")
+		return
+	}
+	if transaction == nil {
+		c.cancelHold(ctx, hold, "session never started")
+		return
+	}
+
+	if transaction.IsFinished {
+		// a failed capture is settled by the payment retries
+		if retry, _ := c.repo.GetPaymentRetry(ctx, transaction.TransactionId); retry != nil {
+			return
+		}
+		if transaction.PaymentBilled < transaction.PaymentAmount {
+			if e := c.PayTransaction(ctx, transaction.TransactionId); e != nil {
+				c.log.With(slog.Int("transaction_id", transaction.TransactionId), sl.Err(e)).Warn("failed to settle preauthorization")
+			}
+			return
+		}
+		c.cancelHold(ctx, hold, "session billed")
+		return
+	}
+
+	// the session is still running: release the hold and charge the card at the end
+	if expired {
+		c.cancelHold(ctx, hold, "preauthorization expired")
+	}
+}
+
+// findHoldTransaction looks up the session a user started with any of their
+// tags within the start timeout after the hold was authorized.
+func (c *Core) findHoldTransaction(ctx context.Context, hold *entity.Preauthorization) *entity.Transaction {
+	tags, err := c.repo.GetUserTags(ctx, hold.UserId)
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to get user tags")
+		return nil
+	}
+	startTimeout, _ := c.holdTimeouts()
+	for _, tag := range tags {
+		transaction, e := c.repo.GetTransactionByTag(ctx, tag.IdTag, hold.CreatedAt)
+		if e != nil || transaction == nil {
+			continue
+		}
+		if transaction.TimeStart.Sub(hold.CreatedAt) > startTimeout {
+			continue
+		}
+		if linked, _ := c.repo.GetPreauthorizationByTransaction(ctx, transaction.TransactionId); linked != nil {
+			continue
+		}
+		return transaction
+	}
+	return nil
+}
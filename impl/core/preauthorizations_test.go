@@ -0,0 +1,236 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// holdGateway records captures, cancellations and payments and answers them
+// with the configured capture code; cancellations and payments are approved.
+type holdGateway struct {
+	mu          sync.Mutex
+	captureCode string
+	calls       []string
+	PaymentGateway
+}
+
+func (g *holdGateway) record(op, order string, amount int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls = append(g.calls, fmt.Sprintf("%s %s %d", op, order, amount))
+}
+
+func (g *holdGateway) Calls() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.calls...)
+}
+
+func (g *holdGateway) Capture(_ context.Context, req CaptureRequest) (*CaptureResponse, error) {
+	g.record("capture", req.OrderNumber, req.Amount)
+	return &CaptureResponse{
+		Success:         entity.IsRedsysApproved(entity.RedsysTxCapture, g.captureCode),
+		ResponseCode:    g.captureCode,
+		Order:           req.OrderNumber,
+		Amount:          fmt.Sprintf("%d", req.Amount),
+		TransactionType: entity.RedsysTxCapture,
+	}, nil
+}
+
+func (g *holdGateway) Cancel(_ context.Context, req CaptureRequest) (*CaptureResponse, error) {
+	g.record("cancel", req.OrderNumber, req.Amount)
+	return &CaptureResponse{Success: true, ResponseCode: "0400", Order: req.OrderNumber, TransactionType: entity.RedsysTxCancel}, nil
+}
+
+func (g *holdGateway) Pay(_ context.Context, req PayRequest) (*CaptureResponse, error) {
+	g.record("pay", req.OrderNumber, req.Amount)
+	return &CaptureResponse{
+		Success:         true,
+		ResponseCode:    "0000",
+		Order:           req.OrderNumber,
+		Amount:          fmt.Sprintf("%d", req.Amount),
+		TransactionType: entity.RedsysTxPay,
+	}, nil
+}
+
+// newHoldCore returns a core with a user holding one card and one 20.00
+// preauthorization on it, authorized an hour ago and not linked yet.
+func newHoldCore(t *testing.T, captureCode string) (*Core, *database_mock.MockDB, *holdGateway, time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	c := New(newTestLogger(), db)
+	g := &holdGateway{captureCode: captureCode}
+	c.SetPaymentGateway(g)
+	c.SetPreauthorization(&PreauthorizationConfig{StartTimeout: 30 * time.Minute, MaxAge: 12 * time.Hour})
+
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1"})
+	require.NoError(t, db.AddUserTag(ctx, &entity.UserTag{UserId: "u1", Username: "driver", IdTag: "TAG1"}))
+	require.NoError(t, db.SavePaymentMethod(ctx, &entity.PaymentMethod{
+		Identifier: "tok-1", CofTid: "cof-1", ExpiryDate: "4012", IsDefault: true, UserId: "u1", UserName: "driver",
+	}))
+
+	authorized := time.Now().Add(-time.Hour)
+	require.NoError(t, db.SavePreauthorization(ctx, &entity.Preauthorization{
+		OrderNumber:         "000000003000",
+		AuthorizationCode:   "123456",
+		PreauthorizedAmount: 2000,
+		Status:              entity.PreauthorizationStatusAuthorized,
+		PaymentMethodId:     "tok-1",
+		UserId:              "u1",
+		UserName:            "driver",
+		CreatedAt:           authorized,
+	}))
+	return c, db, g, authorized
+}
+
+func seedHoldSession(db *database_mock.MockDB, start time.Time, finished bool, amount int) {
+	db.SeedTransaction(&entity.Transaction{
+		TransactionId: 1,
+		IdTag:         "TAG1",
+		ChargePointId: "CP1",
+		TimeStart:     start,
+		IsFinished:    finished,
+		PaymentAmount: amount,
+	})
+}
+
+func waitBilled(t *testing.T, db *database_mock.MockDB, amount int) *entity.Transaction {
+	t.Helper()
+	var tx *entity.Transaction
+	require.Eventually(t, func() bool {
+		tx, _ = db.GetTransaction(context.Background(), 1)
+		return tx.PaymentBilled == amount && tx.PaymentOrder != 0
+	}, time.Second, 5*time.Millisecond)
+	return tx
+}
+
+func TestPayTransaction_CapturesHold(t *testing.T) {
+	ctx := context.Background()
+	c, db, g, authorized := newHoldCore(t, "0900")
+	seedHoldSession(db, authorized.Add(5*time.Minute), true, 1500)
+
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	tx := waitBilled(t, db, 1500)
+	assert.Equal(t, 3000, tx.PaymentOrder, "the capture is billed under the hold's order number")
+	assert.Empty(t, tx.PaymentError)
+	assert.Equal(t, []string{"capture 000000003000 1500"}, g.Calls())
+
+	order, _ := db.GetPaymentOrder(ctx, 3000)
+	require.NotNil(t, order)
+	assert.Equal(t, entity.RedsysTxCapture, order.TransactionType)
+	assert.True(t, order.IsPaid())
+
+	hold, _ := db.GetPreauthorization(ctx, "000000003000")
+	assert.Equal(t, entity.PreauthorizationStatusCaptured, hold.Status)
+	assert.Equal(t, 1500, hold.CapturedAmount)
+	assert.Equal(t, 1, hold.TransactionId)
+
+	// the next order number skips the one taken by the hold
+	next, err := c.nextOrderNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3001, next)
+}
+
+func TestPayTransaction_HoldFallsBackToPayment(t *testing.T) {
+	tests := []struct {
+		name        string
+		captureCode string
+		amount      int
+		calls       []string
+		status      entity.PreauthorizationStatus
+	}{
+		{
+			name:        "amount exceeds hold",
+			captureCode: "0900",
+			amount:      2500,
+			calls:       []string{"cancel 000000003000 2000", "pay 3001 2500"},
+			status:      entity.PreauthorizationStatusCancelled,
+		},
+		{
+			name:        "capture declined",
+			captureCode: "0190",
+			amount:      1500,
+			calls:       []string{"capture 000000003000 1500", "pay 3001 1500"},
+			status:      entity.PreauthorizationStatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, g, authorized := newHoldCore(t, tt.captureCode)
+			seedHoldSession(db, authorized.Add(5*time.Minute), true, tt.amount)
+
+			require.NoError(t, c.PayTransaction(ctx, 1))
+			tx := waitBilled(t, db, tt.amount)
+			assert.Equal(t, 3001, tx.PaymentOrder)
+			require.Eventually(t, func() bool {
+				hold, _ := db.GetPreauthorization(ctx, "000000003000")
+				return hold.Status == tt.status
+			}, time.Second, 5*time.Millisecond)
+			assert.ElementsMatch(t, tt.calls, g.Calls())
+		})
+	}
+}
+
+func TestPayTransaction_IgnoresStaleHold(t *testing.T) {
+	ctx := context.Background()
+	c, db, g, authorized := newHoldCore(t, "0900")
+	// the session started long after the hold's start timeout
+	seedHoldSession(db, authorized.Add(45*time.Minute), true, 1500)
+
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	waitBilled(t, db, 1500)
+	assert.Equal(t, []string{"pay 3001 1500"}, g.Calls())
+	hold, _ := db.GetPreauthorization(ctx, "000000003000")
+	assert.Equal(t, 0, hold.TransactionId)
+}
+
+func TestProcessPreauthorizations(t *testing.T) {
+	tests := []struct {
+		name    string
+		age     time.Duration // of the hold
+		started bool
+		// finished sessions are billed 15.00
+		finished bool
+		calls    []string
+		status   entity.PreauthorizationStatus
+	}{
+		{"session never started", time.Hour, false, false, []string{"cancel 000000003000 2000"}, entity.PreauthorizationStatusCancelled},
+		{"session running", time.Hour, true, false, nil, entity.PreauthorizationStatusAuthorized},
+		{"session running past max age", 13 * time.Hour, true, false, []string{"cancel 000000003000 2000"}, entity.PreauthorizationStatusCancelled},
+		{"session finished", time.Hour, true, true, []string{"capture 000000003000 1500"}, entity.PreauthorizationStatusCaptured},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, g, _ := newHoldCore(t, "0900")
+			hold, _ := db.GetPreauthorization(ctx, "000000003000")
+			hold.CreatedAt = time.Now().Add(-tt.age)
+			require.NoError(t, db.SavePreauthorization(ctx, hold))
+			if tt.started {
+				seedHoldSession(db, hold.CreatedAt.Add(time.Minute), tt.finished, 1500)
+			}
+
+			c.processPreauthorizations(ctx)
+			require.Eventually(t, func() bool {
+				hold, _ = db.GetPreauthorization(ctx, "000000003000")
+				return hold.Status == tt.status
+			}, time.Second, 5*time.Millisecond)
+			assert.Equal(t, tt.calls, g.Calls())
+			if tt.started {
+				assert.Equal(t, 1, hold.TransactionId, "the hold is linked to the session found for it")
+			}
+		})
+	}
+}
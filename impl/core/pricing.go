@@ -0,0 +1,349 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+)
+
+// priceMismatchTolerance absorbs rounding differences between the central
+// system's running price and the backend calculation, in minor units.
+const priceMismatchTolerance = 1
+
+// meterReading is a point on the energy register curve of a session.
+type meterReading struct {
+	time  time.Time
+	value int
+}
+
+// PriceTransaction recomputes the cost of a transaction from its meter values
+// and the applicable tariff or payment plan, and compares it with the amount
+// stored on the transaction. Users may price their own transactions only.
+func (c *Core) PriceTransaction(ctx context.Context, author *entity.User, transactionId int) (*entity.PriceBreakdown, error) {
+	transaction, err := c.repo.GetTransaction(ctx, transactionId)
+	if err != nil {
+		return nil, err
+	}
+	if transaction == nil {
+		return nil, fmt.Errorf("transaction %w", entity.ErrNotFound)
+	}
+	if !author.Can(entity.PermissionTransactionsRead) {
+		if transaction.UserTag == nil || transaction.UserTag.UserId != author.UserId {
+			return nil, fmt.Errorf("access denied: insufficient permissions")
+		}
+	}
+	return c.priceTransaction(ctx, transaction)
+}
+
+// SimulatePrice prices a hypothetical session (admin only).
+func (c *Core) SimulatePrice(ctx context.Context, author *entity.User, req *entity.PriceSimulationRequest) (*entity.PriceBreakdown, error) {
+	if err := c.requirePermission(author, entity.PermissionTariffsWrite); err != nil {
+		return nil, err
+	}
+
+	var tariff *entity.Tariff
+	var err error
+	if req.TariffId != "" {
+		tariff, err = c.repo.GetTariff(ctx, req.TariffId)
+	} else {
+		tariff, err = c.resolveTariff(ctx, req.ChargePointId, c.chargePointLocation(ctx, req.ChargePointId), req.TimeStart)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tariff == nil {
+		return nil, fmt.Errorf("tariff %w", entity.ErrNotFound)
+	}
+
+	var readings []meterReading
+	if len(req.MeterValues) > 0 {
+		// register values are absolute; the lowest one marks the session start
+		meterStart := req.MeterValues[0].Value
+		for _, mv := range req.MeterValues {
+			meterStart = min(meterStart, mv.Value)
+		}
+		readings = sessionReadings(req.TimeStart, req.TimeStop, meterStart, 0, req.MeterValues)
+	} else {
+		chargeStop := req.TimeStop.Add(-time.Duration(req.IdleMinutes) * time.Minute)
+		readings = simulatedReadings(req.TimeStart, chargeStop, req.EnergyWh)
+		readings = append(readings, meterReading{time: req.TimeStop, value: req.EnergyWh})
+	}
+	return calculatePrice(tariff, nil, readings), nil
+}
+
+// priceTransaction calculates the breakdown for a stored transaction. A session
+// still running is priced up to its last meter reading.
+func (c *Core) priceTransaction(ctx context.Context, transaction *entity.Transaction) (*entity.PriceBreakdown, error) {
+	tariff, err := c.transactionTariff(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+	plan := transaction.Plan
+	if tariff == nil && plan == nil {
+		return nil, fmt.Errorf("no tariff or payment plan applies to transaction %d", transaction.TransactionId)
+	}
+
+	stop := transaction.TimeStop
+	meterStop := transaction.MeterStop
+	if !transaction.IsFinished {
+		stop = time.Now()
+		meterStop = 0
+	}
+	readings := sessionReadings(transaction.TimeStart, stop, transaction.MeterStart, meterStop, transaction.MeterValues)
+
+	breakdown := calculatePrice(tariff, plan, readings)
+	breakdown.TransactionId = transaction.TransactionId
+	breakdown.StoredAmount = transaction.PaymentAmount
+	breakdown.Difference = breakdown.Total - transaction.PaymentAmount
+	breakdown.Mismatch = transaction.IsFinished && abs(breakdown.Difference) > priceMismatchTolerance
+	return breakdown, nil
+}
+
+// transactionTariff returns the tariff a transaction is priced with: the
+// snapshot recorded on the transaction when the session started, otherwise the
+// tariff that was effective at the charge point at that time. The live tariff
+// may have been edited since, so it is never read by id.
+func (c *Core) transactionTariff(ctx context.Context, transaction *entity.Transaction) (*entity.Tariff, error) {
+	if transaction.Tariff != nil {
+		return transaction.Tariff, nil
+	}
+	locationId := c.chargePointLocation(ctx, transaction.ChargePointId)
+	return c.resolveTariff(ctx, transaction.ChargePointId, locationId, transaction.TimeStart)
+}
+
+// chargePointLocation returns the location of a charge point, or an empty
+// string when the charge point is unknown; a tariff can still match it directly.
+func (c *Core) chargePointLocation(ctx context.Context, chargePointId string) string {
+	cp, err := c.repo.GetChargePoint(ctx, MaxAccessLevel, chargePointId)
+	if err != nil || cp == nil {
+		return ""
+	}
+	return cp.LocationId
+}
+
+// checkTransactionPrice compares the billed amount with the backend
+// calculation and records a warning when they disagree. It never blocks billing.
+func (c *Core) checkTransactionPrice(ctx context.Context, transaction *entity.Transaction) {
+	log := c.log.With(slog.Int("transaction_id", transaction.TransactionId))
+	breakdown, err := c.priceTransaction(ctx, transaction)
+	if err != nil {
+		log.With(sl.Err(err)).Debug("price check skipped")
+		return
+	}
+	if !breakdown.Mismatch {
+		return
+	}
+	log.With(
+		slog.Int("stored", breakdown.StoredAmount),
+		slog.Int("calculated", breakdown.Total),
+	).Warn("transaction amount does not match calculated price")
+	c.payLog(ctx, "warning", "pricing",
+		"transaction %d: stored amount %.2f differs from calculated %.2f (tariff %s)",
+		transaction.TransactionId, float64(breakdown.StoredAmount)/100, float64(breakdown.Total)/100, breakdown.TariffId)
+}
+
+// sessionReadings turns meter values into a monotonic register curve bounded by
+// the session start and stop. Readings outside the session or going backwards
+// are dropped.
+func sessionReadings(start, stop time.Time, meterStart, meterStop int, values []entity.TransactionMeter) []meterReading {
+	sorted := make([]entity.TransactionMeter, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	readings := []meterReading{{time: start, value: meterStart}}
+	for _, mv := range sorted {
+		last := readings[len(readings)-1]
+		if !mv.Time.After(last.time) || mv.Time.After(stop) || mv.Value < last.value {
+			continue
+		}
+		readings = append(readings, meterReading{time: mv.Time, value: mv.Value})
+	}
+	last := readings[len(readings)-1]
+	if stop.After(last.time) {
+		readings = append(readings, meterReading{time: stop, value: max(meterStop, last.value)})
+	} else if meterStop > last.value {
+		readings[len(readings)-1].value = meterStop
+	}
+	return readings
+}
+
+// simulatedReadings spreads energy evenly over [start, stop] in one-minute steps,
+// so every time band the charging crosses gets its share.
+func simulatedReadings(start, stop time.Time, energy int) []meterReading {
+	readings := []meterReading{{time: start, value: 0}}
+	total := stop.Sub(start)
+	if total <= 0 {
+		return readings
+	}
+	for t := start.Add(time.Minute); t.Before(stop); t = t.Add(time.Minute) {
+		share := float64(t.Sub(start)) / float64(total)
+		readings = append(readings, meterReading{time: t, value: int(math.Round(float64(energy) * share))})
+	}
+	return append(readings, meterReading{time: stop, value: energy})
+}
+
+// calculatePrice prices a register curve. With a tariff, energy is priced per
+// time band, idle time beyond the grace period is charged per minute and the
+// total is clamped to the tariff's limits. Without one, the payment plan's flat
+// energy and hourly prices are used.
+func calculatePrice(tariff *entity.Tariff, plan *entity.PaymentPlan, readings []meterReading) *entity.PriceBreakdown {
+	first, last := readings[0], readings[len(readings)-1]
+	breakdown := &entity.PriceBreakdown{
+		TimeStart: first.time,
+		TimeStop:  last.time,
+		EnergyWh:  last.value - first.value,
+		Minutes:   last.time.Sub(first.time).Minutes(),
+		Lines:     []*entity.PriceLine{},
+	}
+
+	var idle time.Duration
+	for i := 1; i < len(readings); i++ {
+		if readings[i].value == readings[i-1].value {
+			idle += readings[i].time.Sub(readings[i-1].time)
+		}
+	}
+	breakdown.IdleMinutes = idle.Minutes()
+
+	if tariff == nil {
+		breakdown.PlanId = plan.PlanId
+		breakdown.Lines = append(breakdown.Lines, energyLine(breakdown.EnergyWh, plan.PricePerKwh, nil, nil))
+		if plan.PricePerHour > 0 {
+			hours := breakdown.Minutes / 60
+			breakdown.Lines = append(breakdown.Lines, &entity.PriceLine{
+				Type:        entity.PriceLineTime,
+				Description: "Charging time",
+				Quantity:    round2(hours),
+				Unit:        "h",
+				UnitPrice:   plan.PricePerHour,
+				Amount:      int(math.Round(hours * float64(plan.PricePerHour))),
+			})
+		}
+		breakdown.Total = sumLines(breakdown.Lines)
+		return breakdown
+	}
+
+	breakdown.TariffId = tariff.TariffId
+	if tariff.SessionFee > 0 {
+		breakdown.Lines = append(breakdown.Lines, &entity.PriceLine{
+			Type:        entity.PriceLineSessionFee,
+			Description: "Session fee",
+			Quantity:    1,
+			UnitPrice:   tariff.SessionFee,
+			Amount:      tariff.SessionFee,
+		})
+	}
+
+	// Consecutive segments at the same energy price make one line, so each
+	// time band the session crossed is shown with its own period.
+	var current *entity.PriceLine
+	currentWh := 0
+	flush := func() {
+		if current != nil && currentWh > 0 {
+			line := energyLine(currentWh, current.UnitPrice, current.From, current.To)
+			breakdown.Lines = append(breakdown.Lines, line)
+		}
+		current, currentWh = nil, 0
+	}
+	bands := splitAtPriceChanges(tariff, readings)
+	for i := 1; i < len(bands); i++ {
+		from, to := bands[i-1].time, bands[i].time
+		wh := bands[i].value - bands[i-1].value
+		if wh == 0 {
+			continue
+		}
+		price := tariff.EnergyPriceAt(from)
+		if current == nil || current.UnitPrice != price {
+			flush()
+			current = &entity.PriceLine{UnitPrice: price, From: &from}
+		}
+		current.To = &to
+		currentWh += wh
+	}
+	flush()
+
+	billableIdle := math.Floor(breakdown.IdleMinutes) - float64(tariff.IdleGraceMinutes)
+	if tariff.IdleFeePerMinute > 0 && billableIdle > 0 {
+		breakdown.Lines = append(breakdown.Lines, &entity.PriceLine{
+			Type:        entity.PriceLineIdleFee,
+			Description: "Idle time",
+			Quantity:    billableIdle,
+			Unit:        "min",
+			UnitPrice:   tariff.IdleFeePerMinute,
+			Amount:      int(billableIdle) * tariff.IdleFeePerMinute,
+		})
+	}
+
+	subtotal := sumLines(breakdown.Lines)
+	if tariff.MinPrice > 0 && subtotal < tariff.MinPrice {
+		breakdown.Lines = append(breakdown.Lines, &entity.PriceLine{
+			Type:        entity.PriceLineMinPrice,
+			Description: "Minimum session price",
+			Amount:      tariff.MinPrice - subtotal,
+		})
+	}
+	if tariff.MaxPrice > 0 && subtotal > tariff.MaxPrice {
+		breakdown.Lines = append(breakdown.Lines, &entity.PriceLine{
+			Type:        entity.PriceLineMaxPrice,
+			Description: "Maximum session price",
+			Amount:      tariff.MaxPrice - subtotal,
+		})
+	}
+	breakdown.Total = sumLines(breakdown.Lines)
+	return breakdown
+}
+
+// splitAtPriceChanges inserts a reading wherever a charging segment crosses a
+// band edge, spreading the segment's energy evenly over its duration, so each
+// part is priced at its own band rather than at the price of the segment start.
+func splitAtPriceChanges(tariff *entity.Tariff, readings []meterReading) []meterReading {
+	out := []meterReading{readings[0]}
+	for i := 1; i < len(readings); i++ {
+		prev, next := readings[i-1], readings[i]
+		if wh := next.value - prev.value; wh > 0 {
+			total := next.time.Sub(prev.time)
+			for _, at := range tariff.PriceChangesBetween(prev.time, next.time) {
+				share := float64(at.Sub(prev.time)) / float64(total)
+				out = append(out, meterReading{time: at, value: prev.value + int(math.Round(float64(wh)*share))})
+			}
+		}
+		out = append(out, next)
+	}
+	return out
+}
+
+func energyLine(wh, pricePerKwh int, from, to *time.Time) *entity.PriceLine {
+	return &entity.PriceLine{
+		Type:        entity.PriceLineEnergy,
+		Description: "Energy",
+		Quantity:    round2(float64(wh) / 1000),
+		Unit:        "kWh",
+		UnitPrice:   pricePerKwh,
+		Amount:      int(math.Round(float64(wh) * float64(pricePerKwh) / 1000)),
+		From:        from,
+		To:          to,
+	}
+}
+
+func sumLines(lines []*entity.PriceLine) int {
+	total := 0
+	for _, line := range lines {
+		total += line.Amount
+	}
+	return total
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func meter(at time.Time, value int) entity.TransactionMeter {
+	return entity.TransactionMeter{Time: at, Value: value, Measurand: "Energy.Active.Import.Register"}
+}
+
+func linesOfType(b *entity.PriceBreakdown, lineType string) []*entity.PriceLine {
+	var out []*entity.PriceLine
+	for _, l := range b.Lines {
+		if l.Type == lineType {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func TestCalculatePrice_TariffBands(t *testing.T) {
+	tariff := &entity.Tariff{
+		TariffId:         "tou",
+		IsActive:         true,
+		PricePerKwh:      30,
+		EnergyBands:      []*entity.TariffBand{{StartTime: "00:00", EndTime: "08:00", PricePerKwh: 10}},
+		SessionFee:       50,
+		IdleFeePerMinute: 5,
+		IdleGraceMinutes: 10,
+	}
+	// 07:00-09:00 charging 10 kWh per hour, then 30 minutes idle
+	start := time.Date(2026, 3, 2, 7, 0, 0, 0, time.UTC)
+	values := []entity.TransactionMeter{
+		meter(start.Add(30*time.Minute), 5000),
+		meter(start.Add(60*time.Minute), 10000),
+		meter(start.Add(90*time.Minute), 15000),
+		meter(start.Add(120*time.Minute), 20000),
+	}
+	readings := sessionReadings(start, start.Add(150*time.Minute), 0, 20000, values)
+
+	b := calculatePrice(tariff, nil, readings)
+
+	assert.Equal(t, 20000, b.EnergyWh)
+	assert.Equal(t, float64(30), b.IdleMinutes)
+	energy := linesOfType(b, entity.PriceLineEnergy)
+	require.Len(t, energy, 2)
+	assert.Equal(t, 10, energy[0].UnitPrice)
+	assert.Equal(t, 100, energy[0].Amount)
+	assert.Equal(t, 30, energy[1].UnitPrice)
+	assert.Equal(t, 300, energy[1].Amount)
+	idle := linesOfType(b, entity.PriceLineIdleFee)
+	require.Len(t, idle, 1)
+	assert.Equal(t, 100, idle[0].Amount) // 20 billable minutes
+	assert.Equal(t, 50+100+300+100, b.Total)
+}
+
+func TestCalculatePrice_SegmentAcrossBands(t *testing.T) {
+	tariff := &entity.Tariff{
+		IsActive:    true,
+		PricePerKwh: 30,
+		EnergyBands: []*entity.TariffBand{{StartTime: "00:00", EndTime: "08:00", PricePerKwh: 10}},
+	}
+	// a single meter segment 07:00-09:00 straddles the 08:00 band edge
+	start := time.Date(2026, 3, 2, 7, 0, 0, 0, time.UTC)
+	readings := sessionReadings(start, start.Add(2*time.Hour), 0, 20000, nil)
+
+	b := calculatePrice(tariff, nil, readings)
+
+	energy := linesOfType(b, entity.PriceLineEnergy)
+	require.Len(t, energy, 2)
+	assert.Equal(t, 100, energy[0].Amount)
+	assert.Equal(t, 300, energy[1].Amount)
+	assert.Equal(t, start.Add(time.Hour), *energy[0].To)
+	assert.Equal(t, 400, b.Total)
+}
+
+func TestCalculatePrice_Limits(t *testing.T) {
+	start := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	readings := sessionReadings(start, start.Add(time.Hour), 0, 1000, nil)
+
+	b := calculatePrice(&entity.Tariff{PricePerKwh: 30, MinPrice: 100}, nil, readings)
+	assert.Equal(t, 100, b.Total)
+	assert.Len(t, linesOfType(b, entity.PriceLineMinPrice), 1)
+
+	b = calculatePrice(&entity.Tariff{PricePerKwh: 300, MaxPrice: 200}, nil, readings)
+	assert.Equal(t, 200, b.Total)
+	assert.Equal(t, -100, linesOfType(b, entity.PriceLineMaxPrice)[0].Amount)
+}
+
+func TestCalculatePrice_PlanFallback(t *testing.T) {
+	start := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	readings := sessionReadings(start, start.Add(90*time.Minute), 1000, 13000, nil)
+
+	b := calculatePrice(nil, &entity.PaymentPlan{PlanId: "default", PricePerKwh: 25, PricePerHour: 100}, readings)
+
+	assert.Equal(t, "default", b.PlanId)
+	assert.Equal(t, 300+150, b.Total)
+}
+
+func TestSessionReadings_DropsOutliers(t *testing.T) {
+	start := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	values := []entity.TransactionMeter{
+		meter(start.Add(-time.Minute), 500), // before start
+		meter(start.Add(20*time.Minute), 3000),
+		meter(start.Add(10*time.Minute), 2000), // out of order, sorted in
+		meter(start.Add(30*time.Minute), 2500), // register going backwards
+		meter(stop.Add(time.Minute), 9000),     // after stop
+	}
+	readings := sessionReadings(start, stop, 1000, 4000, values)
+
+	require.Len(t, readings, 4)
+	assert.Equal(t, []int{1000, 2000, 3000, 4000}, []int{readings[0].value, readings[1].value, readings[2].value, readings[3].value})
+}
+
+func TestPriceTransaction_Mismatch(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	core := New(newTestLogger(), db)
+	core.SetAuth(authenticator.New(newTestLogger(), db))
+
+	start := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	_, err := db.SaveTariff(ctx, &entity.Tariff{TariffId: "flat", IsActive: true, IsDefault: true, PricePerKwh: 30})
+	require.NoError(t, err)
+	db.SeedTransaction(&entity.Transaction{
+		TransactionId: 10, ChargePointId: "CP1", IsFinished: true,
+		TimeStart: start, TimeStop: start.Add(time.Hour),
+		MeterStart: 0, MeterStop: 10000, PaymentAmount: 350,
+		UserTag: &entity.UserTag{UserId: "owner"},
+	})
+
+	b, err := core.PriceTransaction(ctx, &entity.User{UserId: "owner"}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 300, b.Total)
+	assert.Equal(t, 50, -b.Difference)
+	assert.True(t, b.Mismatch)
+
+	_, err = core.PriceTransaction(ctx, &entity.User{UserId: "other"}, 10)
+	assert.Error(t, err)
+}
+
+func TestPriceTransaction_TariffSnapshot(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	core := New(newTestLogger(), db)
+	core.SetAuth(authenticator.New(newTestLogger(), db))
+
+	start := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	// the live tariff was edited after the session started
+	_, err := db.SaveTariff(ctx, &entity.Tariff{TariffId: "flat", IsActive: true, IsDefault: true, PricePerKwh: 50})
+	require.NoError(t, err)
+	db.SeedTransaction(&entity.Transaction{
+		TransactionId: 11, ChargePointId: "CP1", IsFinished: true,
+		TimeStart: start, TimeStop: start.Add(time.Hour),
+		MeterStart: 0, MeterStop: 10000, PaymentAmount: 300,
+		Tariff:  &entity.Tariff{TariffId: "flat", IsActive: true, PricePerKwh: 30},
+		UserTag: &entity.UserTag{UserId: "owner"},
+	})
+
+	b, err := core.PriceTransaction(ctx, &entity.User{UserId: "owner"}, 11)
+	require.NoError(t, err)
+	assert.Equal(t, 300, b.Total)
+	assert.False(t, b.Mismatch)
+}
+
+func TestSimulatePrice(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	core := New(newTestLogger(), db)
+	core.SetAuth(authenticator.New(newTestLogger(), db))
+	admin := &entity.User{Username: "admin", Role: "admin"}
+
+	_, err := db.SaveTariff(ctx, &entity.Tariff{
+		TariffId: "night", IsActive: true, PricePerKwh: 30,
+		EnergyBands:      []*entity.TariffBand{{StartTime: "22:00", EndTime: "06:00", PricePerKwh: 10}},
+		IdleFeePerMinute: 2,
+	})
+	require.NoError(t, err)
+
+	// 21:00-23:00 charging 20 kWh evenly, then 15 minutes idle
+	start := time.Date(2026, 3, 2, 21, 0, 0, 0, time.UTC)
+	b, err := core.SimulatePrice(ctx, admin, &entity.PriceSimulationRequest{
+		TariffId:    "night",
+		TimeStart:   start,
+		TimeStop:    start.Add(135 * time.Minute),
+		EnergyWh:    20000,
+		IdleMinutes: 15,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 300+100+30, b.Total)
+	assert.Len(t, linesOfType(b, entity.PriceLineEnergy), 2)
+
+	_, err = core.SimulatePrice(ctx, &entity.User{Username: "user"}, &entity.PriceSimulationRequest{TariffId: "night"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ListPromotions returns all promotions (admin only).
+func (c *Core) ListPromotions(ctx context.Context, author *entity.User) ([]*entity.Promotion, error) {
+	if err := c.requirePermission(author, entity.PermissionPromotionsWrite); err != nil {
+		return nil, err
+	}
+	return c.repo.ListPromotions(ctx)
+}
+
+// SavePromotion creates a promotion or updates the one with the same code
+// (admin only). Codes are stored in upper case; an update keeps the
+// redemption count and creation time.
+func (c *Core) SavePromotion(ctx context.Context, author *entity.User, promotion *entity.Promotion) (*entity.Promotion, error) {
+	if err := c.requirePermission(author, entity.PermissionPromotionsWrite); err != nil {
+		return nil, err
+	}
+	promotion.Code = normalizePromoCode(promotion.Code)
+	if promotion.Type == entity.PromotionPercent && promotion.Value > 100 {
+		return nil, fmt.Errorf("a percent discount cannot exceed 100")
+	}
+	if !promotion.ValidTo.IsZero() && !promotion.ValidTo.After(promotion.ValidFrom) {
+		return nil, fmt.Errorf("valid_to must be after valid_from")
+	}
+	if promotion.Type == entity.PromotionCredit {
+		promotion.SessionsPerUser = 0
+	} else if promotion.SessionsPerUser == 0 {
+		promotion.SessionsPerUser = 1
+	}
+
+	existing, err := c.repo.GetPromotion(ctx, promotion.Code)
+	if err != nil {
+		return nil, fmt.Errorf("get promotion: %w", err)
+	}
+	now := time.Now()
+	promotion.CreatedAt = now
+	promotion.Redemptions = 0
+	if existing != nil {
+		promotion.CreatedAt = existing.CreatedAt
+		promotion.Redemptions = existing.Redemptions
+	}
+	promotion.UpdatedAt = now
+	if err = c.repo.SavePromotion(ctx, promotion); err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+// GetPromotionRedemptions returns the promotion codes the user has redeemed.
+func (c *Core) GetPromotionRedemptions(ctx context.Context, author *entity.User) ([]*entity.PromotionRedemption, error) {
+	redemptions, err := c.repo.GetPromotionRedemptions(ctx, author.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if redemptions == nil {
+		redemptions = make([]*entity.PromotionRedemption, 0)
+	}
+	return redemptions, nil
+}
+
+// RedeemPromotion redeems a promotion code for the user. A discount code is
+// kept for the user's next sessions; a credit code is added to the wallet
+// at once. Each user redeems a code once.
+func (c *Core) RedeemPromotion(ctx context.Context, author *entity.User, code string) (*entity.PromotionRedemption, error) {
+	code = normalizePromoCode(code)
+	promotion, err := c.repo.GetPromotion(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("get promotion: %w", err)
+	}
+	now := time.Now()
+	if promotion == nil || !promotion.IsActive(now) {
+		return nil, fmt.Errorf("%w: %s", entity.ErrPromotionNotValid, code)
+	}
+	info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, author.Username)
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	if info == nil || info.UserId == "" {
+		return nil, fmt.Errorf("user %s %w", author.Username, entity.ErrNotFound)
+	}
+	if !promotion.AllowsGroup(info.Group) {
+		return nil, fmt.Errorf("%w: %s is not available to your group", entity.ErrPromotionNotValid, code)
+	}
+
+	if promotion.FirstSessionOnly {
+		transactions, e := c.repo.GetTransactions(ctx, info.UserId, "")
+		if e != nil {
+			return nil, fmt.Errorf("get transactions: %w", e)
+		}
+		if len(transactions) > 0 {
+			return nil, fmt.Errorf("%w: %s is for a first session only", entity.ErrPromotionNotValid, code)
+		}
+	}
+
+	redemption := &entity.PromotionRedemption{
+		Code:       code,
+		UserId:     info.UserId,
+		Username:   info.Username,
+		Remaining:  promotion.SessionsPerUser,
+		RedeemedAt: now,
+	}
+	if promotion.Type == entity.PromotionCredit {
+		redemption.Discounted = promotion.Value
+		redemption.UsedAt = now
+	}
+	// the redemption is stored first: its (code, user) key is unique, so of
+	// concurrent requests only one gets past this point
+	inserted, err := c.repo.InsertPromotionRedemption(ctx, redemption)
+	if err != nil {
+		return nil, fmt.Errorf("save redemption: %w", err)
+	}
+	if !inserted {
+		return nil, fmt.Errorf("%w: %s is already redeemed", entity.ErrPromotionNotValid, code)
+	}
+	claimed, err := c.repo.ClaimPromotion(ctx, code)
+	if err != nil || !claimed {
+		c.revokeRedemption(ctx, redemption, false)
+		if err != nil {
+			return nil, fmt.Errorf("claim promotion: %w", err)
+		}
+		return nil, fmt.Errorf("%w: %s is fully redeemed", entity.ErrPromotionNotValid, code)
+	}
+
+	if promotion.Type == entity.PromotionCredit {
+		entry := &entity.WalletEntry{
+			UserId:    info.UserId,
+			Username:  info.Username,
+			Type:      entity.WalletEntryPromo,
+			Amount:    promotion.Value,
+			Note:      "promotion " + code,
+			CreatedAt: now,
+		}
+		if err = c.repo.AddWalletEntry(ctx, entry); err != nil {
+			c.revokeRedemption(ctx, redemption, true)
+			return nil, fmt.Errorf("add wallet entry: %w", err)
+		}
+	}
+	c.payLog(ctx, "info", "promotion", "user %s redeemed promotion %s (%s %d)",
+		info.Username, code, promotion.Type, promotion.Value)
+	return redemption, nil
+}
+
+// revokeRedemption undoes a redemption that could not be completed, so the
+// user can try the code again; a claimed one is also given back to the
+// promotion's redemption count.
+func (c *Core) revokeRedemption(ctx context.Context, redemption *entity.PromotionRedemption, claimed bool) {
+	log := c.log.With(slog.String("code", redemption.Code), slog.String("user_id", redemption.UserId))
+	if claimed {
+		if err := c.repo.ReleasePromotion(ctx, redemption.Code); err != nil {
+			log.With(sl.Err(err)).Error("failed to release promotion claim")
+		}
+	}
+	if err := c.repo.DeletePromotionRedemption(ctx, redemption.Code, redemption.UserId); err != nil {
+		log.With(sl.Err(err)).Error("failed to delete promotion redemption")
+	}
+}
+
+// applyPromotion takes the largest discount among the user's redeemed
+// promotions off a session before it is billed, and returns the amount left
+// to charge. The discount is recorded on the transaction and counted as
+// billed, so payment orders, retries and statements only cover the rest.
+func (c *Core) applyPromotion(ctx context.Context, transaction *entity.Transaction, tag *entity.UserTag, amount int) int {
+	if transaction.PromoCode != "" {
+		// applied on an earlier attempt
+		return amount
+	}
+	log := c.log.With(slog.Int("transaction_id", transaction.TransactionId))
+
+	redemptions, err := c.repo.GetPromotionRedemptions(ctx, tag.UserId)
+	if err != nil {
+		log.With(sl.Err(err)).Error("failed to get promotion redemptions")
+		return amount
+	}
+	var best *entity.Promotion
+	discount := 0
+	location, located := "", false
+	for _, redemption := range redemptions {
+		if redemption.Remaining <= 0 {
+			continue
+		}
+		promotion, e := c.repo.GetPromotion(ctx, redemption.Code)
+		if e != nil || promotion == nil || !promotion.IsActive(transaction.TimeStart) {
+			continue
+		}
+		if len(promotion.Locations) > 0 && !located {
+			location, located = c.chargePointLocation(ctx, transaction.ChargePointId), true
+		}
+		if !promotion.AppliesTo(transaction.ChargePointId, location) {
+			continue
+		}
+		if d := promotion.Discount(amount); d > discount {
+			best, discount = promotion, d
+		}
+	}
+	if best == nil {
+		return amount
+	}
+
+	used, err := c.repo.UsePromotionRedemption(ctx, best.Code, tag.UserId, discount)
+	if err != nil {
+		log.With(sl.Err(err)).Error("failed to use promotion redemption")
+		return amount
+	}
+	if !used {
+		return amount
+	}
+	transaction.Discount = discount
+	transaction.PromoCode = best.Code
+	transaction.PaymentBilled += discount
+	if e := c.repo.UpdateTransactionPayment(ctx, transaction); e != nil {
+		log.With(sl.Err(e)).Error("failed to record discount on transaction")
+	}
+	c.payEvent(ctx, "info", "promotion", entity.PaymentEvent{
+		Type:          entity.PaymentEventDiscounted,
+		TransactionId: transaction.TransactionId,
+		UserId:        tag.UserId,
+		Username:      tag.Username,
+		Amount:        discount,
+		Code:          best.Code,
+	}, "transaction %d: %.2f off with promotion %s (user %s)",
+		transaction.TransactionId, float64(discount)/100, best.Code, tag.Username)
+
+	if discount == amount {
+		// no payment follows to count the session's energy
+		c.recordUsage(ctx, transaction, tag.Username, 0, true)
+	}
+	return amount - discount
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func savePromotion(t *testing.T, c *Core, promotion entity.Promotion) {
+	t.Helper()
+	_, err := c.SavePromotion(context.Background(), &entity.User{Username: "admin", Role: "admin"}, &promotion)
+	require.NoError(t, err)
+}
+
+func TestRedeemPromotion(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		promotion entity.Promotion
+		code      string
+		prepare   func(t *testing.T, c *Core, db *database_mock.MockDB)
+		wantErr   bool
+	}{
+		{"redeemed", entity.Promotion{Code: "spring", Type: entity.PromotionPercent, Value: 20}, " Spring ", nil, false},
+		{"unknown code", entity.Promotion{Code: "SPRING", Type: entity.PromotionPercent, Value: 20}, "SUMMER", nil, true},
+		{"not started", entity.Promotion{Code: "SPRING", Type: entity.PromotionPercent, Value: 20, ValidFrom: now.Add(time.Hour)}, "SPRING", nil, true},
+		{"expired", entity.Promotion{Code: "SPRING", Type: entity.PromotionPercent, Value: 20, ValidTo: now.Add(-time.Hour)}, "SPRING", nil, true},
+		{"disabled", entity.Promotion{Code: "SPRING", Type: entity.PromotionPercent, Value: 20, Disabled: true}, "SPRING", nil, true},
+		{"group allowed", entity.Promotion{Code: "FLEET", Type: entity.PromotionFixed, Value: 500, Groups: []string{"acme"}}, "FLEET", nil, false},
+		{"other group", entity.Promotion{Code: "FLEET", Type: entity.PromotionFixed, Value: 500, Groups: []string{"other"}}, "FLEET", nil, true},
+		{"first session only", entity.Promotion{Code: "WELCOME", Type: entity.PromotionPercent, Value: 100, FirstSessionOnly: true}, "WELCOME",
+			func(t *testing.T, c *Core, db *database_mock.MockDB) {
+				db.SeedTransaction(&entity.Transaction{TransactionId: 1, IdTag: "TAG1", IsFinished: true})
+			}, true},
+		{"fully redeemed", entity.Promotion{Code: "SPRING", Type: entity.PromotionPercent, Value: 20, MaxRedemptions: 1}, "SPRING",
+			func(t *testing.T, c *Core, db *database_mock.MockDB) {
+				db.SeedUser(&entity.User{Username: "other", UserId: "u2"})
+				_, err := c.RedeemPromotion(context.Background(), &entity.User{Username: "other", UserId: "u2"}, "SPRING")
+				require.NoError(t, err)
+			}, true},
+		{"already redeemed", entity.Promotion{Code: "SPRING", Type: entity.PromotionPercent, Value: 20}, "SPRING",
+			func(t *testing.T, c *Core, db *database_mock.MockDB) {
+				_, err := c.RedeemPromotion(context.Background(), &entity.User{Username: "driver", UserId: "u1"}, "SPRING")
+				require.NoError(t, err)
+			}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, db, _ := newWalletCore(t, 0)
+			db.SeedUser(&entity.User{Username: "driver", UserId: "u1", Group: "acme"})
+			savePromotion(t, c, tt.promotion)
+			if tt.prepare != nil {
+				tt.prepare(t, c, db)
+			}
+
+			redemption, err := c.RedeemPromotion(context.Background(), &entity.User{Username: "driver", UserId: "u1"}, tt.code)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, entity.ErrPromotionNotValid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 1, redemption.Remaining)
+			redemptions, _ := c.GetPromotionRedemptions(context.Background(), &entity.User{UserId: "u1"})
+			assert.Len(t, redemptions, 1)
+		})
+	}
+}
+
+func TestRedeemPromotion_Credit(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newWalletCore(t, 0)
+	savePromotion(t, c, entity.Promotion{Code: "GIFT10", Type: entity.PromotionCredit, Value: 1000})
+	driver := &entity.User{Username: "driver", UserId: "u1"}
+
+	redemption, err := c.RedeemPromotion(ctx, driver, "gift10")
+	require.NoError(t, err)
+	assert.Equal(t, 0, redemption.Remaining, "credit is not taken off sessions")
+	wallet, err := c.GetWallet(ctx, driver, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1000, wallet.Balance)
+	assert.Equal(t, entity.WalletEntryPromo, wallet.Entries[0].Type)
+
+	_, err = c.RedeemPromotion(ctx, driver, "GIFT10")
+	assert.ErrorIs(t, err, entity.ErrPromotionNotValid)
+	wallet, _ = c.GetWallet(ctx, driver, "")
+	assert.Equal(t, 1000, wallet.Balance, "a code is credited once")
+}
+
+func TestRedeemPromotion_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newWalletCore(t, 0)
+	savePromotion(t, c, entity.Promotion{Code: "GIFT10", Type: entity.PromotionCredit, Value: 1000})
+	driver := &entity.User{Username: "driver", UserId: "u1"}
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	redeemed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.RedeemPromotion(ctx, driver, "GIFT10"); err == nil {
+				mux.Lock()
+				redeemed++
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, redeemed)
+	wallet, err := c.GetWallet(ctx, driver, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1000, wallet.Balance)
+	promotion, _ := c.repo.GetPromotion(ctx, "GIFT10")
+	assert.Equal(t, 1, promotion.Redemptions)
+}
+
+func TestRedeemPromotion_FullyRedeemedNotKept(t *testing.T) {
+	ctx := context.Background()
+	c, db, _ := newWalletCore(t, 0)
+	db.SeedUser(&entity.User{Username: "other", UserId: "u2"})
+	savePromotion(t, c, entity.Promotion{Code: "ONCE", Type: entity.PromotionPercent, Value: 20, MaxRedemptions: 1})
+	_, err := c.RedeemPromotion(ctx, &entity.User{Username: "other", UserId: "u2"}, "ONCE")
+	require.NoError(t, err)
+
+	_, err = c.RedeemPromotion(ctx, &entity.User{Username: "driver", UserId: "u1"}, "ONCE")
+	assert.ErrorIs(t, err, entity.ErrPromotionNotValid)
+	redemption, err := db.GetPromotionRedemption(ctx, "ONCE", "u1")
+	require.NoError(t, err)
+	assert.Nil(t, redemption, "a redemption without a claim is withdrawn")
+}
+
+func TestPayTransaction_Promotion(t *testing.T) {
+	tests := []struct {
+		name      string
+		promotion entity.Promotion
+		calls     []string
+		discount  int
+	}{
+		{"percent off", entity.Promotion{Code: "P20", Type: entity.PromotionPercent, Value: 20}, []string{"pay 1200 1200"}, 300},
+		{"fixed off", entity.Promotion{Code: "F5", Type: entity.PromotionFixed, Value: 500}, []string{"pay 1200 1000"}, 500},
+		{"free session", entity.Promotion{Code: "FREE", Type: entity.PromotionPercent, Value: 100}, nil, 1500},
+		{"fixed above the amount", entity.Promotion{Code: "F50", Type: entity.PromotionFixed, Value: 5000}, nil, 1500},
+		{"location matches", entity.Promotion{Code: "LOC", Type: entity.PromotionPercent, Value: 20, Locations: []string{"LOC1"}}, []string{"pay 1200 1200"}, 300},
+		{"other location", entity.Promotion{Code: "LOC", Type: entity.PromotionPercent, Value: 20, Locations: []string{"LOC2"}}, []string{"pay 1200 1500"}, 0},
+		{"other charge point", entity.Promotion{Code: "CP", Type: entity.PromotionPercent, Value: 20, ChargePoints: []string{"CP2"}}, []string{"pay 1200 1500"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, g := newWalletCore(t, 0)
+			db.SeedChargePoint(&entity.ChargePoint{Id: "CP1", LocationId: "LOC1"})
+			savePromotion(t, c, tt.promotion)
+			_, err := c.RedeemPromotion(ctx, &entity.User{Username: "driver", UserId: "u1"}, tt.promotion.Code)
+			require.NoError(t, err)
+			db.SeedTransaction(&entity.Transaction{
+				TransactionId: 1, IdTag: "TAG1", ChargePointId: "CP1", IsFinished: true, PaymentAmount: 1500, TimeStart: time.Now(),
+			})
+
+			require.NoError(t, c.PayTransaction(ctx, 1))
+			var tx *entity.Transaction
+			if tt.calls != nil {
+				tx = waitBilled(t, db, 1500)
+			} else {
+				tx, _ = db.GetTransaction(ctx, 1)
+				assert.Equal(t, 1500, tx.PaymentBilled)
+			}
+			assert.Equal(t, tt.calls, g.Calls())
+			assert.Equal(t, tt.discount, tx.Discount)
+			if tt.discount == 0 {
+				assert.Empty(t, tx.PromoCode)
+				return
+			}
+			assert.Equal(t, tt.promotion.Code, tx.PromoCode)
+
+			redemption, _ := db.GetPromotionRedemption(ctx, tt.promotion.Code, "u1")
+			assert.Equal(t, 0, redemption.Remaining)
+			assert.Equal(t, tt.discount, redemption.Discounted)
+		})
+	}
+}
+
+func TestPayTransaction_PromotionUsedOnce(t *testing.T) {
+	ctx := context.Background()
+	c, db, g := newWalletCore(t, 0)
+	savePromotion(t, c, entity.Promotion{Code: "P20", Type: entity.PromotionPercent, Value: 20})
+	_, err := c.RedeemPromotion(ctx, &entity.User{Username: "driver", UserId: "u1"}, "P20")
+	require.NoError(t, err)
+	for _, id := range []int{1, 2} {
+		db.SeedTransaction(&entity.Transaction{
+			TransactionId: id, IdTag: "TAG1", ChargePointId: "CP1", IsFinished: true, PaymentAmount: 1500, TimeStart: time.Now(),
+		})
+	}
+
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	waitBilled(t, db, 1500)
+	require.NoError(t, c.PayTransaction(ctx, 2))
+	require.Eventually(t, func() bool { return len(g.Calls()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"pay 1200 1200", "pay 1201 1500"}, g.Calls())
+
+	// a retry keeps the discount and charges the rest only
+	tx, _ := db.GetTransaction(ctx, 1)
+	assert.Equal(t, 300, tx.Discount)
+	require.NoError(t, c.retryOne(ctx, 1, 1))
+	require.Eventually(t, func() bool { return len(g.Calls()) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "pay 1202 1200", g.Calls()[2])
+}
+
+func TestSavePromotion(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newWalletCore(t, 0)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+
+	_, err := c.SavePromotion(ctx, &entity.User{Username: "driver"}, &entity.Promotion{Code: "X", Type: entity.PromotionFixed, Value: 1})
+	assert.Error(t, err)
+	_, err = c.SavePromotion(ctx, admin, &entity.Promotion{Code: "X", Type: entity.PromotionPercent, Value: 120})
+	assert.Error(t, err)
+
+	saved, err := c.SavePromotion(ctx, admin, &entity.Promotion{Code: "x", Type: entity.PromotionFixed, Value: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "X", saved.Code)
+	assert.Equal(t, 1, saved.SessionsPerUser)
+	_, err = c.RedeemPromotion(ctx, &entity.User{Username: "driver", UserId: "u1"}, "X")
+	require.NoError(t, err)
+
+	updated, err := c.SavePromotion(ctx, admin, &entity.Promotion{Code: "X", Type: entity.PromotionFixed, Value: 200})
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated.Redemptions, "an update keeps the redemption count")
+	assert.Equal(t, saved.CreatedAt, updated.CreatedAt)
+	list, err := c.ListPromotions(ctx, admin)
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+}
@@ -0,0 +1,324 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// stuckOrderAge is how long an order may wait for the gateway's answer before
+// it is reported as stuck.
+const stuckOrderAge = time.Hour
+
+// maxReconciliationPeriod bounds the period of an on-demand report.
+const maxReconciliationPeriod = 93 * 24 * time.Hour
+
+// GetReconciliationReport cross-checks the payments of [from, to) (admin only).
+func (c *Core) GetReconciliationReport(ctx context.Context, author *entity.User, from, to time.Time) (*entity.ReconciliationReport, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsRead); err != nil {
+		return nil, err
+	}
+	if !to.After(from) {
+		return nil, fmt.Errorf("invalid period: 'to' must be after 'from'")
+	}
+	if to.Sub(from) > maxReconciliationPeriod {
+		return nil, fmt.Errorf("invalid period: at most %d days", int(maxReconciliationPeriod.Hours()/24))
+	}
+	return c.reconcile(ctx, from, to)
+}
+
+// reconcile compares, for the transactions finished within [from, to), what was
+// billed with what the payment orders collected, then checks the orders opened
+// and the gateway results received in the same period against each other.
+func (c *Core) reconcile(ctx context.Context, from, to time.Time) (*entity.ReconciliationReport, error) {
+	report := &entity.ReconciliationReport{
+		From:          from,
+		To:            to,
+		GeneratedAt:   time.Now(),
+		Counts:        make(map[string]int),
+		Discrepancies: make([]*entity.Discrepancy, 0),
+	}
+
+	transactions, err := c.repo.GetFilteredTransactions(ctx, &entity.TransactionFilter{From: &from, To: &to})
+	if err != nil {
+		return nil, fmt.Errorf("get transactions: %w", err)
+	}
+	retries, err := c.repo.GetAllPaymentRetries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get payment retries: %w", err)
+	}
+	pending := make(map[int]bool, len(retries))
+	for _, r := range retries {
+		pending[r.TransactionId] = true
+	}
+	for _, tx := range transactions {
+		report.Transactions++
+		if err = c.reconcileTransaction(ctx, report, tx, pending[tx.TransactionId]); err != nil {
+			return nil, err
+		}
+	}
+
+	orders, err := c.repo.GetPaymentOrders(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("get payment orders: %w", err)
+	}
+	byNumber := make(map[int]*entity.PaymentOrder, len(orders))
+	for _, order := range orders {
+		report.Orders++
+		byNumber[order.Order] = order
+		reconcileOrder(report, order, report.GeneratedAt)
+	}
+
+	results, err := c.repo.GetPaymentResults(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("get payment results: %w", err)
+	}
+	for _, result := range results {
+		report.Results++
+		c.reconcileResult(ctx, report, result, byNumber)
+	}
+	return report, nil
+}
+
+// reconcileTransaction compares the amount billed for a session with what its
+// payment orders collected.
+func (c *Core) reconcileTransaction(ctx context.Context, report *entity.ReconciliationReport, tx *entity.Transaction, retryPending bool) error {
+	if tx.GroupBilling != "" {
+		return c.reconcileGroupTransaction(ctx, report, tx)
+	}
+	orders, err := c.repo.GetTransactionPaymentOrders(ctx, tx.TransactionId)
+	if err != nil {
+		return fmt.Errorf("get orders of transaction %d: %w", tx.TransactionId, err)
+	}
+	paid, refunded := 0, 0
+	for _, order := range orders {
+		if !order.IsCompleted {
+			// still waiting for the gateway; stuck orders are reported on their own
+			return nil
+		}
+		captured, returned, e := c.refundBalance(ctx, order)
+		if e != nil {
+			return e
+		}
+		paid += captured
+		refunded += returned
+	}
+
+	// a promotion's discount is counted as billed but collected by no order
+	due := tx.PaymentAmount - tx.Discount
+	switch {
+	case paid-refunded > due:
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyOverbilled,
+			TransactionId: tx.TransactionId,
+			Order:         tx.PaymentOrder,
+			Expected:      due,
+			Actual:        paid - refunded,
+			Detail:        fmt.Sprintf("collected %d in %d orders, refunded %d", paid, len(orders), refunded),
+			Action:        fmt.Sprintf("refund %d to the customer", paid-refunded-due),
+		})
+	case paid < due:
+		if retryPending {
+			return nil
+		}
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyUnbilled,
+			TransactionId: tx.TransactionId,
+			Order:         tx.PaymentOrder,
+			Expected:      due,
+			Actual:        paid,
+			Detail:        unbilledDetail(tx),
+			Action:        unbilledAction(tx),
+		})
+	case tx.PaymentBilled-tx.Discount != paid:
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyAmountMismatch,
+			TransactionId: tx.TransactionId,
+			Order:         tx.PaymentOrder,
+			Expected:      paid + tx.Discount,
+			Actual:        tx.PaymentBilled,
+			Detail:        fmt.Sprintf("payment_billed is %d, the orders collected %d", tx.PaymentBilled, paid),
+			Action:        "set payment_billed to the collected amount so the session is not billed again",
+		})
+	}
+	return nil
+}
+
+// reconcileGroupTransaction checks a session billed with its group's monthly
+// statement. The statement's order collects all its sessions, so the session
+// is matched with its line of the paid statement; until the statement is paid,
+// the group billing charges it and there is nothing to reconcile.
+func (c *Core) reconcileGroupTransaction(ctx context.Context, report *entity.ReconciliationReport, tx *entity.Transaction) error {
+	if tx.PaymentOrder == 0 {
+		return nil
+	}
+	statement, err := c.repo.GetGroupStatementByOrder(ctx, tx.PaymentOrder)
+	if err != nil {
+		return fmt.Errorf("get statement of transaction %d: %w", tx.TransactionId, err)
+	}
+	if statement == nil || statement.Status != entity.StatementStatusPaid {
+		return nil
+	}
+	var line *entity.StatementSession
+	for _, driver := range statement.Drivers {
+		for _, session := range driver.Sessions {
+			if session.TransactionId == tx.TransactionId {
+				line = session
+			}
+		}
+	}
+	switch {
+	case line == nil:
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyAmountMismatch,
+			TransactionId: tx.TransactionId,
+			Order:         tx.PaymentOrder,
+			Expected:      tx.PaymentAmount,
+			Actual:        tx.PaymentBilled,
+			Detail:        fmt.Sprintf("order %d pays group statement %s, which does not list the session", tx.PaymentOrder, statement.Id),
+			Action:        "check the group statements; the session may be billed twice or not at all",
+		})
+	case tx.PaymentBilled > tx.PaymentAmount:
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyOverbilled,
+			TransactionId: tx.TransactionId,
+			Order:         tx.PaymentOrder,
+			Expected:      tx.PaymentAmount,
+			Actual:        tx.PaymentBilled,
+			Detail:        fmt.Sprintf("billed %d on group statement %s", line.Amount, statement.Id),
+			Action:        fmt.Sprintf("refund %d to the group's billing user", tx.PaymentBilled-tx.PaymentAmount),
+		})
+	}
+	return nil
+}
+
+func unbilledDetail(tx *entity.Transaction) string {
+	if tx.PaymentError != "" {
+		return fmt.Sprintf("last payment failed: %s (%s decline)", tx.PaymentError, entity.ClassifyPaymentError(tx.PaymentError))
+	}
+	if tx.PaymentOrder == 0 {
+		return "no payment order was opened"
+	}
+	return fmt.Sprintf("billed %d, payment order %d did not collect it", tx.PaymentBilled, tx.PaymentOrder)
+}
+
+func unbilledAction(tx *entity.Transaction) string {
+	switch {
+	case tx.PaymentError != "" && entity.ClassifyPaymentError(tx.PaymentError) == entity.DeclineClassHard:
+		return "ask the customer for another card, then force a payment retry"
+	case tx.PaymentOrder == 0 && tx.PaymentBilled >= tx.PaymentAmount:
+		return "the session had no user or card to charge: collect it manually or write it off"
+	default:
+		return "force a payment retry"
+	}
+}
+
+// reconcileOrder reports orders the gateway never answered.
+func reconcileOrder(report *entity.ReconciliationReport, order *entity.PaymentOrder, now time.Time) {
+	switch {
+	case !order.IsCompleted && now.Sub(order.TimeOpened) > stuckOrderAge:
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyStuckOpenOrder,
+			TransactionId: order.TransactionId,
+			Order:         order.Order,
+			Expected:      order.Amount,
+			Detail:        fmt.Sprintf("open since %s", order.TimeOpened.Format(time.RFC3339)),
+			Action:        "look the order up at the gateway; close it and retry the payment if it was not charged",
+		})
+	case order.IsCompleted && order.Result == "closed without response":
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyStuckOpenOrder,
+			TransactionId: order.TransactionId,
+			Order:         order.Order,
+			Expected:      order.Amount,
+			Detail:        "closed without a response from the gateway",
+			Action:        "look the order up at the gateway; refund or bill the session according to its real result",
+		})
+	}
+}
+
+// reconcileResult matches a gateway result with its order. Orders of the
+// period are looked up in byNumber first.
+func (c *Core) reconcileResult(ctx context.Context, report *entity.ReconciliationReport, result *entity.PaymentParameters, byNumber map[int]*entity.PaymentOrder) {
+	amount, _ := strconv.Atoi(result.Amount)
+	number, err := strconv.Atoi(result.Order)
+	var order *entity.PaymentOrder
+	if err == nil {
+		order = byNumber[number]
+		if order == nil {
+			order, _ = c.repo.GetPaymentOrder(ctx, number)
+		}
+	}
+
+	if order == nil {
+		if hold, _ := c.repo.GetPreauthorization(ctx, result.Order); hold != nil {
+			return
+		}
+		action := "no action: the gateway declined it"
+		if result.IsApproved() {
+			action = "find the charge at the gateway; refund it or attach it to its session"
+		}
+		report.Add(&entity.Discrepancy{
+			Category: entity.DiscrepancyOrphanResult,
+			Order:    number,
+			Actual:   amount,
+			Detail:   fmt.Sprintf("result %s for order %s, type %s, matches no order", result.Response, result.Order, result.TransactionType),
+			Action:   action,
+		})
+		return
+	}
+
+	// only payment results carry the amount collected by the order
+	if result.TransactionType != entity.RedsysTxPay && result.TransactionType != entity.RedsysTxCapture {
+		return
+	}
+	if !result.IsApproved() || !order.IsCompleted {
+		return
+	}
+	switch {
+	case !order.IsPaid():
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyAmountMismatch,
+			TransactionId: order.TransactionId,
+			Order:         order.Order,
+			Expected:      amount,
+			Detail:        fmt.Sprintf("the gateway approved %d, the order was closed with %q", amount, order.Result),
+			Action:        "mark the order paid and update the session's payment, or refund the charge",
+		})
+	case amount != order.Amount:
+		report.Add(&entity.Discrepancy{
+			Category:      entity.DiscrepancyAmountMismatch,
+			TransactionId: order.TransactionId,
+			Order:         order.Order,
+			Expected:      order.Amount,
+			Actual:        amount,
+			Detail:        "the gateway approved another amount than the order's",
+			Action:        "correct the order amount, then refund or bill the difference",
+		})
+	}
+}
+
+// processReconciliation reconciles the previous UTC day once a day and writes
+// what it finds to the payment log.
+func (c *Core) processReconciliation(ctx context.Context) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if !c.reconciledUntil.Before(today) {
+		return
+	}
+	from := today.Add(-24 * time.Hour)
+	report, err := c.reconcile(ctx, from, today)
+	if err != nil {
+		c.payLog(ctx, "error", "reconcile", "reconciliation of %s failed: %v", from.Format(time.DateOnly), err)
+		return
+	}
+	c.reconciledUntil = today
+
+	for _, d := range report.Discrepancies {
+		c.payLog(ctx, "warning", "reconcile", "%s: transaction %d, order %d, expected %d, actual %d: %s; %s",
+			d.Category, d.TransactionId, d.Order, d.Expected, d.Actual, d.Detail, d.Action)
+	}
+	c.payLog(ctx, "info", "reconcile", "reconciled %s: %d transactions, %d orders, %d results, %d discrepancies",
+		from.Format(time.DateOnly), report.Transactions, report.Orders, report.Results, len(report.Discrepancies))
+}
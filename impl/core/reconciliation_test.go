@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedReconciledSession adds a session billed `billed` of `amount`, and a
+// closed payment order for it when result is set.
+func seedReconciledSession(t *testing.T, db *database_mock.MockDB, start time.Time, id, amount, billed, collected int, result string) {
+	t.Helper()
+	tx := &entity.Transaction{
+		TransactionId: id,
+		IsFinished:    true,
+		TimeStart:     start,
+		PaymentAmount: amount,
+		PaymentBilled: billed,
+	}
+	if result != "" {
+		tx.PaymentOrder = 1200 + id
+		if !entity.IsRedsysApproved(entity.RedsysTxPay, result) {
+			tx.PaymentError = result
+		}
+		require.NoError(t, db.SavePaymentOrder(context.Background(), &entity.PaymentOrder{
+			TransactionId: id,
+			Order:         1200 + id,
+			Amount:        collected,
+			Currency:      "EUR",
+			IsCompleted:   true,
+			Result:        result,
+			TimeOpened:    start,
+			TimeClosed:    start,
+		}))
+	}
+	db.SeedTransaction(tx)
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	c := New(newTestLogger(), db)
+	c.SetAuth(authenticator.New(newTestLogger(), db))
+	admin := &entity.User{Username: "admin", Role: "admin"}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	from := to.Add(-48 * time.Hour)
+	start := time.Now().Add(-3 * time.Hour)
+
+	seedReconciledSession(t, db, start, 1, 1000, 1000, 1000, "0000") // paid
+	seedReconciledSession(t, db, start, 2, 1000, 1000, 1500, "0000") // overbilled
+	seedReconciledSession(t, db, start, 3, 500, 500, 500, "0190")    // declined, retries exhausted
+	seedReconciledSession(t, db, start, 4, 500, 500, 500, "0190")    // declined, retry pending
+	require.NoError(t, db.SavePaymentRetry(ctx, &entity.PaymentRetry{TransactionId: 4, Attempt: 1}))
+	seedReconciledSession(t, db, start, 5, 700, 0, 700, "0000") // paid but not marked billed
+	seedReconciledSession(t, db, start, 6, 300, 0, 0, "")       // waiting for the gateway
+	require.NoError(t, db.SavePaymentOrder(ctx, &entity.PaymentOrder{
+		TransactionId: 6, Order: 1206, Amount: 300, Currency: "EUR", TimeOpened: start,
+	}))
+	seedReconciledSession(t, db, start, 7, 400, 400, 0, "") // no user to charge
+	seedReconciledSession(t, db, start, 8, 400, 400, 400, "closed without response")
+
+	require.NoError(t, db.SavePreauthorization(ctx, &entity.Preauthorization{OrderNumber: "000000003000"}))
+	for _, result := range []*entity.PaymentParameters{
+		{Order: "000000001201", Amount: "900", Response: "0000", TransactionType: entity.RedsysTxPay}, // another amount
+		{Order: "000000001208", Amount: "400", Response: "0000", TransactionType: entity.RedsysTxPay}, // approved after all
+		{Order: "000000009999", Amount: "250", Response: "0000", TransactionType: entity.RedsysTxPay}, // orphan
+		{Order: "000000003000", Amount: "2000", Response: "0000", TransactionType: entity.RedsysTxPreauthorize},
+	} {
+		result.ReceivedAt = start
+		require.NoError(t, db.SavePaymentResult(ctx, result))
+	}
+
+	report, err := c.GetReconciliationReport(ctx, admin, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 8, report.Transactions)
+	assert.Equal(t, 7, report.Orders)
+	assert.Equal(t, 4, report.Results)
+
+	type finding struct {
+		category string
+		tx       int
+		order    int
+		expected int
+		actual   int
+	}
+	var got []finding
+	for _, d := range report.Discrepancies {
+		got = append(got, finding{d.Category, d.TransactionId, d.Order, d.Expected, d.Actual})
+		assert.NotEmpty(t, d.Action)
+	}
+	assert.ElementsMatch(t, []finding{
+		{entity.DiscrepancyOverbilled, 2, 1202, 1000, 1500},
+		{entity.DiscrepancyUnbilled, 3, 1203, 500, 0},
+		{entity.DiscrepancyAmountMismatch, 5, 1205, 700, 0},
+		{entity.DiscrepancyStuckOpenOrder, 6, 1206, 300, 0},
+		{entity.DiscrepancyUnbilled, 7, 0, 400, 0},
+		{entity.DiscrepancyUnbilled, 8, 1208, 400, 0},
+		{entity.DiscrepancyStuckOpenOrder, 8, 1208, 400, 0},
+		{entity.DiscrepancyAmountMismatch, 1, 1201, 1000, 900},
+		{entity.DiscrepancyAmountMismatch, 8, 1208, 400, 0},
+		{entity.DiscrepancyOrphanResult, 0, 9999, 0, 250},
+	}, got)
+	assert.Equal(t, map[string]int{
+		entity.DiscrepancyOverbilled:     1,
+		entity.DiscrepancyUnbilled:       3,
+		entity.DiscrepancyAmountMismatch: 3,
+		entity.DiscrepancyStuckOpenOrder: 2,
+		entity.DiscrepancyOrphanResult:   1,
+	}, report.Counts)
+
+	_, err = c.GetReconciliationReport(ctx, &entity.User{Username: "driver"}, from, to)
+	assert.Error(t, err, "regular users cannot reconcile")
+	_, err = c.GetReconciliationReport(ctx, admin, to, from)
+	assert.Error(t, err, "the period must not be empty")
+}
+
+func TestProcessReconciliation_OncePerDay(t *testing.T) {
+	db := database_mock.NewMockDB()
+	c := New(newTestLogger(), db)
+
+	c.processReconciliation(context.Background())
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	assert.Equal(t, today, c.reconciledUntil)
+
+	c.reconciledUntil = today.Add(time.Minute)
+	c.processReconciliation(context.Background())
+	assert.Equal(t, today.Add(time.Minute), c.reconciledUntil, "the day is not reconciled twice")
+}
+
+func TestReconcile_GroupBilling(t *testing.T) {
+	ctx := context.Background()
+	c, db, _ := newGroupCore(t)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	lastMonth := monthStart.AddDate(0, 0, -10)
+	for _, s := range []struct {
+		id    int
+		start time.Time
+	}{
+		{1, lastMonth},                   // settled by last month's statement
+		{2, monthStart.Add(time.Minute)}, // deferred to the next statement
+	} {
+		db.SeedTransaction(&entity.Transaction{
+			TransactionId: s.id, IdTag: "TAG1", ChargePointId: "CP1", IsFinished: true, TimeStart: s.start,
+			MeterStop: 10000, PaymentAmount: 1000, GroupBilling: "acme",
+		})
+	}
+	c.processGroupBilling(ctx)
+	require.Eventually(t, func() bool {
+		tx, _ := db.GetTransaction(ctx, 1)
+		return tx.PaymentBilled == 1000
+	}, time.Second, 5*time.Millisecond)
+
+	report, err := c.GetReconciliationReport(ctx, admin, lastMonth.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Transactions)
+	assert.Empty(t, report.Discrepancies, "group sessions are not unbilled")
+
+	// a session claiming the group order without a line on its statement
+	tx, _ := db.GetTransaction(ctx, 1)
+	db.SeedTransaction(&entity.Transaction{
+		TransactionId: 3, IsFinished: true, TimeStart: lastMonth, PaymentAmount: 500, PaymentBilled: 500,
+		PaymentOrder: tx.PaymentOrder, GroupBilling: "acme",
+	})
+	report, err = c.GetReconciliationReport(ctx, admin, lastMonth.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, report.Discrepancies, 1)
+	assert.Equal(t, entity.DiscrepancyAmountMismatch, report.Discrepancies[0].Category)
+	assert.Equal(t, 3, report.Discrepancies[0].TransactionId)
+}
@@ -0,0 +1,282 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Refund initiators used when no operator is behind the request.
+const (
+	refundInitiatorApi    = "api"
+	refundInitiatorSystem = "system"
+)
+
+// ListRefunds returns the refund ledger of a transaction (admin only).
+func (c *Core) ListRefunds(ctx context.Context, author *entity.User, transactionId int) ([]*entity.Refund, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsRead); err != nil {
+		return nil, err
+	}
+	return c.repo.GetRefunds(ctx, transactionId)
+}
+
+// IssueRefund refunds part of a transaction's payment on behalf of an operator
+// (admin only). The refund is recorded as pending and sent to the gateway in the
+// background; the returned entry is the pending one.
+func (c *Core) IssueRefund(ctx context.Context, author *entity.User, transactionId int, req *entity.RefundOrderRequest) (*entity.Refund, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsRefund); err != nil {
+		return nil, err
+	}
+	if c.gateway == nil {
+		return nil, fmt.Errorf("payment gateway not configured")
+	}
+	transaction, err := c.repo.GetTransaction(ctx, transactionId)
+	if err != nil {
+		return nil, err
+	}
+	if transaction == nil {
+		return nil, fmt.Errorf("transaction %d %w", transactionId, entity.ErrNotFound)
+	}
+	orderId := req.Order
+	if orderId == 0 {
+		orderId = transaction.PaymentOrder
+	}
+	order, err := c.repo.GetPaymentOrder(ctx, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("get payment order: %w", err)
+	}
+	if order == nil {
+		return nil, fmt.Errorf("payment order %d %w", orderId, entity.ErrNotFound)
+	}
+	if order.TransactionId != transactionId {
+		return nil, fmt.Errorf("payment order %d does not belong to transaction %d", orderId, transactionId)
+	}
+	return c.issueRefund(ctx, order, req.Amount, req.Reason, req.Note, author.Username)
+}
+
+// issueRefund checks the amount against what is left to refund on the order,
+// records a pending ledger entry and sends the refund to the gateway. The
+// transaction lock keeps a refund from running alongside PayTransaction on the
+// same transaction; the refund lock of the order is held across the check and
+// the insert so concurrent requests cannot both pass the cap, and pending
+// entries count towards it. Both locks are in-process: one instance is assumed.
+func (c *Core) issueRefund(ctx context.Context, order *entity.PaymentOrder, amount int, reason, note, initiator string) (*entity.Refund, error) {
+	txMutex := c.lockOrder(order.TransactionId)
+	defer c.unlockOrder(order.TransactionId, txMutex)
+	mutex := c.lockRefund(order.Order)
+	defer c.unlockRefund(order.Order, mutex)
+
+	captured, refunded, err := c.refundBalance(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	if amount > captured-refunded {
+		return nil, fmt.Errorf("refund amount %d exceeds refundable amount %d (captured %d, refunded %d)",
+			amount, captured-refunded, captured, refunded)
+	}
+
+	refund := &entity.Refund{
+		TransactionId: order.TransactionId,
+		Order:         order.Order,
+		Amount:        amount,
+		Currency:      order.Currency,
+		Reason:        reason,
+		Note:          note,
+		Status:        entity.RefundStatusPending,
+		InitiatedBy:   initiator,
+		CreatedAt:     time.Now(),
+	}
+	if err = c.repo.SaveRefund(ctx, refund); err != nil {
+		return nil, fmt.Errorf("save refund: %w", err)
+	}
+
+	c.payEvent(ctx, "info", "refund", entity.PaymentEvent{
+		Type:          entity.PaymentEventRefundRequested,
+		TransactionId: order.TransactionId,
+		Order:         order.Order,
+		UserId:        order.UserId,
+		Username:      order.UserName,
+		Amount:        amount,
+		CardHash:      entity.CardHash(order.Identifier),
+	}, "order %d: refund requested %.2f by %s (%s)",
+		order.Order, float64(amount)/100, initiator, reason)
+
+	pending := *refund
+	if order.Identifier == entity.WalletIdentifier {
+		paid := *order
+		c.runAsync("refundToWallet", func(ctx context.Context) {
+			c.refundToWallet(ctx, &pending, &paid)
+		})
+		return refund, nil
+	}
+	c.runAsync("processRefund", func(ctx context.Context) {
+		c.processRefund(ctx, &pending)
+	})
+
+	return refund, nil
+}
+
+// lockRefund acquires the mutex guarding the refunded total of an order. It is
+// kept apart from the transaction locks, which are held around it.
+func (c *Core) lockRefund(order int) *sync.Mutex {
+	value, _ := c.refundLocks.LoadOrStore(order, &sync.Mutex{})
+	mutex := value.(*sync.Mutex)
+	mutex.Lock()
+	return mutex
+}
+
+func (c *Core) unlockRefund(order int, mutex *sync.Mutex) {
+	mutex.Unlock()
+	c.refundLocks.Delete(order)
+}
+
+// refundBalance returns the captured amount of an order and how much of it is
+// already refunded or being refunded. Only approved payments and captures are
+// refundable. RefundAmount on the order covers refunds made before the ledger
+// existed.
+func (c *Core) refundBalance(ctx context.Context, order *entity.PaymentOrder) (captured, refunded int, err error) {
+	if order.IsPaid() {
+		captured = order.Amount
+	}
+	ledger, err := c.repo.GetRefundsByOrder(ctx, order.Order)
+	if err != nil {
+		return 0, 0, fmt.Errorf("get refunds: %w", err)
+	}
+	for _, r := range ledger {
+		if r.Status != entity.RefundStatusFailed {
+			refunded += r.Amount
+		}
+	}
+	if order.RefundAmount > refunded {
+		refunded = order.RefundAmount
+	}
+	return captured, refunded, nil
+}
+
+// processRefund sends a refund request to the gateway and records the answer on the
+// ledger entry. A request that never got an answer leaves the entry pending:
+// the gateway may still have executed it, and its notification settles the entry.
+func (c *Core) processRefund(ctx context.Context, refund *entity.Refund) {
+	orderNumber := fmt.Sprintf("%d", refund.Order)
+	log := c.log.With(slog.String("order", orderNumber), slog.Int("amount", refund.Amount))
+
+	resp, err := c.gateway.Refund(ctx, RefundRequest{
+		OrderNumber: orderNumber,
+		Amount:      refund.Amount,
+	})
+	if err != nil {
+		log.With(sl.Err(err)).Error("refund request failed")
+		refund.ErrorMessage = err.Error()
+		if e := c.repo.SaveRefund(ctx, refund); e != nil {
+			log.With(sl.Err(e)).Error("failed to save refund")
+		}
+		c.payLog(ctx, "error", "refund",
+			"order %d: refund %.2f outcome unknown: %v",
+			refund.Order, float64(refund.Amount)/100, err)
+		return
+	}
+
+	if !resp.Success {
+		log.With(slog.String("error_code", resp.ErrorCode)).Warn("refund rejected by gateway")
+		c.failRefund(ctx, refund, resp)
+		return
+	}
+
+	c.completeRefund(ctx, refund, resp)
+}
+
+// processRefundNotification settles the pending ledger entry a gateway refund
+// notification refers to. Notifications for refunds already settled by the
+// REST response are only logged.
+func (c *Core) processRefundNotification(ctx context.Context, resp *CaptureResponse, orderNum int) {
+	log := c.log.With(slog.Int("order", orderNum), slog.String("amount", resp.Amount))
+
+	amount, _ := strconv.Atoi(resp.Amount)
+	ledger, err := c.repo.GetRefundsByOrder(ctx, orderNum)
+	if err != nil {
+		log.With(sl.Err(err)).Error("failed to get refunds")
+		return
+	}
+	for _, refund := range ledger {
+		if refund.Status != entity.RefundStatusPending || refund.Amount != amount {
+			continue
+		}
+		if resp.Success {
+			c.completeRefund(ctx, refund, resp)
+		} else {
+			c.failRefund(ctx, refund, resp)
+		}
+		return
+	}
+	log.With(slog.String("response", resp.ResponseCode)).Info("refund notification without pending refund")
+}
+
+// completeRefund marks a ledger entry as completed and adds its amount to the
+// order's refunded total.
+func (c *Core) completeRefund(ctx context.Context, refund *entity.Refund, resp *CaptureResponse) {
+	log := c.log.With(slog.Int("order", refund.Order), slog.Int("amount", refund.Amount))
+
+	refund.Status = entity.RefundStatusCompleted
+	refund.ResponseCode = resp.ResponseCode
+	refund.AuthorizationCode = resp.AuthorizationCode
+	refund.ErrorMessage = ""
+	refund.CompletedAt = time.Now()
+	if e := c.repo.SaveRefund(ctx, refund); e != nil {
+		log.With(sl.Err(e)).Error("failed to save refund")
+	}
+
+	mutex := c.lockRefund(refund.Order)
+	defer c.unlockRefund(refund.Order, mutex)
+
+	order, err := c.repo.GetPaymentOrder(ctx, refund.Order)
+	if err != nil || order == nil {
+		log.With(sl.Err(err)).Error("failed to get payment order for refund")
+		return
+	}
+	order.RefundAmount += refund.Amount
+	order.RefundTime = refund.CompletedAt
+	if e := c.repo.SavePaymentOrder(ctx, order); e != nil {
+		log.With(sl.Err(e)).Error("failed to save refund order")
+	}
+
+	c.payEvent(ctx, "info", "refund", entity.PaymentEvent{
+		Type:          entity.PaymentEventRefunded,
+		TransactionId: order.TransactionId,
+		Order:         order.Order,
+		UserId:        order.UserId,
+		Username:      order.UserName,
+		Amount:        refund.Amount,
+		CardHash:      entity.CardHash(order.Identifier),
+		Code:          refund.ResponseCode,
+	}, "order %d: refunded %.2f (user %s), total refunded %.2f",
+		order.Order, float64(refund.Amount)/100, order.UserName, float64(order.RefundAmount)/100)
+
+	c.invoiceRefund(ctx, refund)
+}
+
+// failRefund marks a ledger entry as failed, releasing its amount for another
+// attempt.
+func (c *Core) failRefund(ctx context.Context, refund *entity.Refund, resp *CaptureResponse) {
+	refund.Status = entity.RefundStatusFailed
+	refund.ResponseCode = resp.ResponseCode
+	refund.ErrorCode = resp.ErrorCode
+	refund.ErrorMessage = resp.ErrorMessage
+	refund.CompletedAt = time.Now()
+	if e := c.repo.SaveRefund(ctx, refund); e != nil {
+		c.log.With(slog.Int("order", refund.Order), sl.Err(e)).Error("failed to save refund")
+	}
+
+	c.payEvent(ctx, "error", "refund", entity.PaymentEvent{
+		Type:          entity.PaymentEventRefundFailed,
+		TransactionId: refund.TransactionId,
+		Order:         refund.Order,
+		Amount:        refund.Amount,
+		Code:          resultCode(resp),
+	}, "order %d: refund %.2f failed (%s)",
+		refund.Order, float64(refund.Amount)/100, resultCode(resp))
+}
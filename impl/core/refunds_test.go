@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubGateway answers every refund with the configured response code.
+type stubGateway struct {
+	mu      sync.Mutex
+	code    string
+	refunds []RefundRequest
+	PaymentGateway
+}
+
+func (s *stubGateway) Refund(_ context.Context, req RefundRequest) (*CaptureResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refunds = append(s.refunds, req)
+	return &CaptureResponse{
+		Success:         entity.IsRedsysApproved(entity.RedsysTxRefund, s.code),
+		ResponseCode:    s.code,
+		Order:           req.OrderNumber,
+		Amount:          fmt.Sprintf("%d", req.Amount),
+		TransactionType: entity.RedsysTxRefund,
+	}, nil
+}
+
+func newRefundCore(t *testing.T, code string) (*Core, *database_mock.MockDB) {
+	t.Helper()
+	db := database_mock.NewMockDB()
+	c := New(newTestLogger(), db)
+	c.SetAuth(authenticator.New(newTestLogger(), db))
+	c.SetPaymentGateway(&stubGateway{code: code})
+	db.SeedTransaction(&entity.Transaction{TransactionId: 7, IsFinished: true, PaymentAmount: 1000, PaymentOrder: 1300})
+	db.SeedPaymentOrder(&entity.PaymentOrder{TransactionId: 7, Order: 1300, Amount: 1000, Currency: "EUR", IsCompleted: true, Result: "0000"})
+	return c, db
+}
+
+// waitRefunds waits until no refund of the transaction is pending.
+func waitRefunds(t *testing.T, db *database_mock.MockDB, transactionId int) []*entity.Refund {
+	t.Helper()
+	var refunds []*entity.Refund
+	require.Eventually(t, func() bool {
+		refunds, _ = db.GetRefunds(context.Background(), transactionId)
+		for _, r := range refunds {
+			if r.Status == entity.RefundStatusPending {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 5*time.Millisecond)
+	return refunds
+}
+
+func TestIssueRefund_PartialRefundsUpToCaptured(t *testing.T) {
+	ctx := context.Background()
+	c, db := newRefundCore(t, "0900")
+	admin := &entity.User{Username: "admin", Role: "admin"}
+
+	refund, err := c.IssueRefund(ctx, admin, 7, &entity.RefundOrderRequest{Amount: 300, Reason: entity.RefundReasonBillingError})
+	require.NoError(t, err)
+	assert.Equal(t, entity.RefundStatusPending, refund.Status)
+	assert.Equal(t, "admin", refund.InitiatedBy)
+	assert.Equal(t, 1300, refund.Order)
+
+	_, err = c.IssueRefund(ctx, admin, 7, &entity.RefundOrderRequest{Amount: 800, Reason: entity.RefundReasonBillingError})
+	assert.ErrorContains(t, err, "exceeds refundable amount 700")
+
+	_, err = c.IssueRefund(ctx, admin, 7, &entity.RefundOrderRequest{Amount: 700, Reason: entity.RefundReasonDuplicate})
+	require.NoError(t, err)
+
+	refunds := waitRefunds(t, db, 7)
+	require.Len(t, refunds, 2)
+	for _, r := range refunds {
+		assert.Equal(t, entity.RefundStatusCompleted, r.Status)
+		assert.Equal(t, "0900", r.ResponseCode)
+	}
+	order, _ := db.GetPaymentOrder(ctx, 1300)
+	assert.Equal(t, 1000, order.RefundAmount)
+
+	_, err = c.IssueRefund(ctx, admin, 7, &entity.RefundOrderRequest{Amount: 1, Reason: entity.RefundReasonOther})
+	assert.ErrorContains(t, err, "exceeds refundable amount 0")
+
+	// nothing left: a full return is a no-op
+	require.NoError(t, c.ReturnPayment(ctx, 7))
+	refunds, _ = db.GetRefunds(ctx, 7)
+	assert.Len(t, refunds, 2)
+
+	db.SeedChargeState(&entity.ChargeState{TransactionId: 7})
+	result, err := c.GetTransaction(ctx, "", 10, 7)
+	require.NoError(t, err)
+	state, ok := result.(*entity.ChargeState)
+	require.True(t, ok)
+	assert.Len(t, state.Refunds, 2)
+}
+
+func TestIssueRefund_FailedRefundReleasesAmount(t *testing.T) {
+	ctx := context.Background()
+	c, db := newRefundCore(t, "0190")
+	admin := &entity.User{Username: "admin", Role: "admin"}
+
+	_, err := c.IssueRefund(ctx, admin, 7, &entity.RefundOrderRequest{Amount: 1000, Reason: entity.RefundReasonServiceFailure})
+	require.NoError(t, err)
+	refunds := waitRefunds(t, db, 7)
+	require.Len(t, refunds, 1)
+	assert.Equal(t, entity.RefundStatusFailed, refunds[0].Status)
+
+	order, _ := db.GetPaymentOrder(ctx, 1300)
+	assert.Equal(t, 0, order.RefundAmount)
+	assert.Equal(t, "0000", order.Result, "a rejected refund must not touch the payment result")
+	retry, _ := db.GetPaymentRetry(ctx, 7)
+	assert.Nil(t, retry, "a rejected refund must not schedule a payment retry")
+
+	c.SetPaymentGateway(&stubGateway{code: "0900"})
+	require.NoError(t, c.ReturnPayment(ctx, 7))
+	refunds = waitRefunds(t, db, 7)
+	require.Len(t, refunds, 2)
+	assert.Equal(t, 1000, refunds[1].Amount)
+	assert.Equal(t, entity.RefundStatusCompleted, refunds[1].Status)
+	assert.Equal(t, "api", refunds[1].InitiatedBy)
+}
+
+func TestIssueRefund_Validation(t *testing.T) {
+	ctx := context.Background()
+	c, db := newRefundCore(t, "0900")
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	req := &entity.RefundOrderRequest{Amount: 100, Reason: entity.RefundReasonOther}
+
+	_, err := c.IssueRefund(ctx, &entity.User{Username: "user"}, 7, req)
+	assert.Error(t, err)
+
+	_, err = c.IssueRefund(ctx, admin, 99, req)
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+
+	db.SeedPaymentOrder(&entity.PaymentOrder{TransactionId: 8, Order: 1301, Amount: 500, IsCompleted: true, Result: "0000"})
+	_, err = c.IssueRefund(ctx, admin, 7, &entity.RefundOrderRequest{Order: 1301, Amount: 100, Reason: entity.RefundReasonOther})
+	assert.ErrorContains(t, err, "does not belong")
+
+	// declined payments have nothing to refund
+	db.SeedPaymentOrder(&entity.PaymentOrder{TransactionId: 7, Order: 1302, Amount: 500, IsCompleted: true, Result: "0190"})
+	_, err = c.IssueRefund(ctx, admin, 7, &entity.RefundOrderRequest{Order: 1302, Amount: 100, Reason: entity.RefundReasonOther})
+	assert.ErrorContains(t, err, "exceeds refundable amount 0")
+
+	// refunds made before the ledger count towards the cap
+	db.SeedPaymentOrder(&entity.PaymentOrder{TransactionId: 7, Order: 1303, Amount: 500, IsCompleted: true, Result: "0000", RefundAmount: 450})
+	err = c.ReturnByOrder(ctx, "1303", 100)
+	assert.ErrorContains(t, err, "exceeds refundable amount 50")
+}
+
+func TestReturnPayment_WaitsForPayment(t *testing.T) {
+	ctx := context.Background()
+	c, db := newRefundCore(t, "0900")
+
+	// a payment of the transaction is in progress
+	mutex := c.lockOrder(7)
+	done := make(chan error, 1)
+	go func() { done <- c.ReturnPayment(ctx, 7) }()
+
+	time.Sleep(50 * time.Millisecond)
+	refunds, _ := db.GetRefunds(ctx, 7)
+	assert.Empty(t, refunds, "the refund waits for the payment")
+
+	c.unlockOrder(7, mutex)
+	require.NoError(t, <-done)
+	refunds = waitRefunds(t, db, 7)
+	require.Len(t, refunds, 1)
+	assert.Equal(t, 1000, refunds[0].Amount)
+}
@@ -12,6 +12,7 @@ type Repository interface {
 
 	GetUserInfo(ctx context.Context, accessLevel int, username string) (*entity.UserInfo, error)
 	GetWarningEmailRecipients(ctx context.Context) ([]*entity.User, error)
+	GetUsersByPaymentPlan(ctx context.Context, planId string) ([]*entity.User, error)
 
 	GetLocations(ctx context.Context) ([]*entity.Location, error)
 	GetChargePoints(ctx context.Context, level int, searchTerm string) ([]*entity.ChargePoint, error)
@@ -29,6 +30,9 @@ type Repository interface {
 	UpdatePaymentMethod(ctx context.Context, paymentMethod *entity.PaymentMethod) error
 	DeletePaymentMethod(ctx context.Context, paymentMethod *entity.PaymentMethod) error
 	GetLastOrder(ctx context.Context) (*entity.PaymentOrder, error)
+	// NextSequence atomically increments the named sequence and returns its
+	// new value, which is at least floor.
+	NextSequence(ctx context.Context, name string, floor int) (int, error)
 	SavePaymentOrder(ctx context.Context, order *entity.PaymentOrder) error
 	GetPaymentOrderByTransaction(ctx context.Context, transactionId int) (*entity.PaymentOrder, error)
 
@@ -36,6 +40,8 @@ type Repository interface {
 	GetTransaction(ctx context.Context, id int) (*entity.Transaction, error)
 	UpdateTransactionPayment(ctx context.Context, transaction *entity.Transaction) error
 	GetUserTag(ctx context.Context, idTag string) (*entity.UserTag, error)
+	GetUserTags(ctx context.Context, userId string) ([]entity.UserTag, error)
+	GetTransactionByTag(ctx context.Context, idTag string, timeStart time.Time) (*entity.Transaction, error)
 	GetDefaultPaymentMethod(ctx context.Context, userId string) (*entity.PaymentMethod, error)
 	GetPaymentMethodByIdentifier(ctx context.Context, identifier string) (*entity.PaymentMethod, error)
 	UpdatePaymentMethodFailCount(ctx context.Context, identifier string, count int) error
@@ -44,6 +50,8 @@ type Repository interface {
 
 	// Payment processing methods
 	GetUnbilledTransactions(ctx context.Context) ([]*entity.Transaction, error)
+	GetUnfinishedTransactions(ctx context.Context) ([]*entity.Transaction, error)
+	GetLastMeterValue(ctx context.Context, transactionId int) (*entity.TransactionMeter, error)
 
 	// Payment retry methods
 	SavePaymentRetry(ctx context.Context, retry *entity.PaymentRetry) error
@@ -58,9 +66,14 @@ type Repository interface {
 	GetPreauthorizationByTransaction(ctx context.Context, transactionId int) (*entity.Preauthorization, error)
 	UpdatePreauthorization(ctx context.Context, preauth *entity.Preauthorization) error
 	GetLastPreauthorizationOrder(ctx context.Context) (*entity.Preauthorization, error)
+	GetUserAuthorizedPreauthorization(ctx context.Context, userId string) (*entity.Preauthorization, error)
+	GetAuthorizedPreauthorizations(ctx context.Context, createdBefore time.Time) ([]*entity.Preauthorization, error)
 
 	// Payment activity log
 	WritePaymentLog(ctx context.Context, msg *entity.LogMessage) error
+	AddPaymentEvent(ctx context.Context, event *entity.PaymentEvent) error
+	// GetPaymentEvents returns the events matching the filter, newest first.
+	GetPaymentEvents(ctx context.Context, filter *entity.PaymentEventFilter) ([]*entity.PaymentEvent, error)
 
 	// Mail subscriptions
 	ListMailSubscriptions(ctx context.Context) ([]*entity.MailSubscription, error)
@@ -69,6 +82,88 @@ type Repository interface {
 	SaveMailSubscription(ctx context.Context, sub *entity.MailSubscription) (*entity.MailSubscription, error)
 	DeleteMailSubscription(ctx context.Context, id string) error
 
+	// Payment plans
+	ListPaymentPlans(ctx context.Context) ([]*entity.PaymentPlan, error)
+	GetPaymentPlan(ctx context.Context, planId string) (*entity.PaymentPlan, error)
+	SavePaymentPlan(ctx context.Context, plan *entity.PaymentPlan) error
+
+	// Tariffs
+	ListTariffs(ctx context.Context) ([]*entity.Tariff, error)
+	GetTariff(ctx context.Context, id string) (*entity.Tariff, error)
+	InsertTariff(ctx context.Context, tariff *entity.Tariff) (bool, error)
+	SaveTariff(ctx context.Context, tariff *entity.Tariff) (*entity.Tariff, error)
+	DeleteTariff(ctx context.Context, id string) error
+
+	// Refund ledger
+	SaveRefund(ctx context.Context, refund *entity.Refund) error
+	GetRefunds(ctx context.Context, transactionId int) ([]*entity.Refund, error)
+	GetRefundsByOrder(ctx context.Context, order int) ([]*entity.Refund, error)
+
+	// Invoices
+	// InsertInvoice stores a newly issued invoice or credit note; it reports
+	// false, without error, when its number, or the order of an invoice or
+	// the refund of a credit note, already has a document.
+	InsertInvoice(ctx context.Context, invoice *entity.Invoice) (bool, error)
+	GetInvoice(ctx context.Context, id string) (*entity.Invoice, error)
+	GetLastInvoice(ctx context.Context, series string, year int) (*entity.Invoice, error)
+	GetInvoiceByOrder(ctx context.Context, order int) (*entity.Invoice, error)
+	GetInvoiceByRefund(ctx context.Context, refundId string) (*entity.Invoice, error)
+	GetUserInvoices(ctx context.Context, userId string) ([]*entity.Invoice, error)
+	GetInvoices(ctx context.Context, from, to time.Time) ([]*entity.Invoice, error)
+	UpdateUserFiscal(ctx context.Context, username string, fiscal *entity.FiscalDetails) error
+
+	// Prepaid wallets
+	GetWalletBalance(ctx context.Context, userId string) (int, error)
+	AddWalletEntry(ctx context.Context, entry *entity.WalletEntry) error
+	GetWalletEntries(ctx context.Context, userId string, limit int) ([]*entity.WalletEntry, error)
+
+	// Usage limits and running totals
+	ListUsageLimits(ctx context.Context) ([]*entity.UsageLimit, error)
+	GetUsageLimit(ctx context.Context, scope, subject string) (*entity.UsageLimit, error)
+	SaveUsageLimit(ctx context.Context, limit *entity.UsageLimit) error
+	DeleteUsageLimit(ctx context.Context, scope, subject string) error
+	AddUsage(ctx context.Context, scope, subject, period string, amount, energy int) error
+	GetUsage(ctx context.Context, scope, subject, period string) (*entity.UsageCounter, error)
+
+	// User groups and their monthly statements
+	ListUserGroups(ctx context.Context) ([]*entity.UserGroup, error)
+	GetUserGroup(ctx context.Context, name string) (*entity.UserGroup, error)
+	SaveUserGroup(ctx context.Context, group *entity.UserGroup) error
+	GetGroupBillingTransactions(ctx context.Context, group string, before time.Time) ([]*entity.Transaction, error)
+	SaveGroupStatement(ctx context.Context, statement *entity.GroupStatement) error
+	GetGroupStatement(ctx context.Context, id string) (*entity.GroupStatement, error)
+	GetGroupStatementByOrder(ctx context.Context, order int) (*entity.GroupStatement, error)
+	GetGroupStatements(ctx context.Context, group string) ([]*entity.GroupStatement, error)
+
+	// Promotions and their redemptions
+	ListPromotions(ctx context.Context) ([]*entity.Promotion, error)
+	GetPromotion(ctx context.Context, code string) (*entity.Promotion, error)
+	SavePromotion(ctx context.Context, promotion *entity.Promotion) error
+	ClaimPromotion(ctx context.Context, code string) (bool, error)
+	ReleasePromotion(ctx context.Context, code string) error
+	InsertPromotionRedemption(ctx context.Context, redemption *entity.PromotionRedemption) (bool, error)
+	DeletePromotionRedemption(ctx context.Context, code, userId string) error
+	GetPromotionRedemption(ctx context.Context, code, userId string) (*entity.PromotionRedemption, error)
+	GetPromotionRedemptions(ctx context.Context, userId string) ([]*entity.PromotionRedemption, error)
+	UsePromotionRedemption(ctx context.Context, code, userId string, discount int) (bool, error)
+
+	// Reminders about saved cards
+	GetPaymentMethodsToNotice(ctx context.Context, expiresBy string) ([]*entity.PaymentMethod, error)
+	GetCardNotice(ctx context.Context, identifier, kind string) (*entity.CardNotice, error)
+	SaveCardNotice(ctx context.Context, notice *entity.CardNotice) error
+
+	// Idempotency keys of the service-to-service payment endpoints
+	InsertIdempotencyRecord(ctx context.Context, record *entity.IdempotencyRecord) (bool, error)
+	GetIdempotencyRecord(ctx context.Context, key string) (*entity.IdempotencyRecord, error)
+	SaveIdempotencyRecord(ctx context.Context, record *entity.IdempotencyRecord) error
+	ReplaceIdempotencyRecord(ctx context.Context, record *entity.IdempotencyRecord, createdAt time.Time) (bool, error)
+	DeleteIdempotencyRecord(ctx context.Context, key string) error
+
+	// Reconciliation
+	GetPaymentOrders(ctx context.Context, from, to time.Time) ([]*entity.PaymentOrder, error)
+	GetTransactionPaymentOrders(ctx context.Context, transactionId int) ([]*entity.PaymentOrder, error)
+	GetPaymentResults(ctx context.Context, from, to time.Time) ([]*entity.PaymentParameters, error)
+
 	// Webhook subscribers and delivery health (collections written by evsys)
 	ListWebhookSubscribers(ctx context.Context) ([]*entity.WebhookSubscriber, error)
 	SaveWebhookSubscriber(ctx context.Context, sub *entity.WebhookSubscriber) (*entity.WebhookSubscriber, error)
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"evsys-back/entity"
+	"time"
+)
+
+// RetryPolicy holds the delays between automatic payment retries for each
+// decline class; the number of delays is the number of retries. Hard declines
+// are never retried.
+type RetryPolicy struct {
+	Soft      []time.Duration
+	Technical []time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	Soft: []time.Duration{
+		1 * time.Hour,
+		24 * time.Hour,
+		7 * 24 * time.Hour,
+		30 * 24 * time.Hour,
+	},
+	Technical: []time.Duration{
+		15 * time.Minute,
+		1 * time.Hour,
+		6 * time.Hour,
+		24 * time.Hour,
+	},
+}
+
+func (c *Core) SetRetryPolicy(policy *RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// retrySchedule returns the retry delays of a decline class. A class left
+// unset in the configured policy falls back to the default schedule.
+func (c *Core) retrySchedule(class string) []time.Duration {
+	policy := &defaultRetryPolicy
+	if c.retryPolicy != nil {
+		policy = c.retryPolicy
+	}
+	switch class {
+	case entity.DeclineClassHard:
+		return nil
+	case entity.DeclineClassTechnical:
+		if len(policy.Technical) > 0 {
+			return policy.Technical
+		}
+		return defaultRetryPolicy.Technical
+	default:
+		if len(policy.Soft) > 0 {
+			return policy.Soft
+		}
+		return defaultRetryPolicy.Soft
+	}
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulePaymentRetry_Classes(t *testing.T) {
+	policy := &RetryPolicy{
+		Soft:      []time.Duration{time.Hour, 24 * time.Hour},
+		Technical: []time.Duration{10 * time.Minute},
+	}
+
+	tests := []struct {
+		name    string
+		errors  []string
+		class   string
+		attempt int
+		delay   time.Duration // of the last scheduled retry; 0 when none is left
+	}{
+		{"soft decline", []string{"0116"}, entity.DeclineClassSoft, 1, time.Hour},
+		{"soft decline second attempt", []string{"0116", "0190"}, entity.DeclineClassSoft, 2, 24 * time.Hour},
+		{"soft schedule exhausted", []string{"0116", "0116", "0116"}, "", 0, 0},
+		{"technical error", []string{"SIS0051"}, entity.DeclineClassTechnical, 1, 10 * time.Minute},
+		{"technical schedule exhausted", []string{"9104", "failed to send request"}, "", 0, 0},
+		{"hard decline", []string{"0208"}, "", 0, 0},
+		{"hard decline drops a pending retry", []string{"0116", "0208"}, "", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := database_mock.NewMockDB()
+			c := New(newTestLogger(), db)
+			c.SetRetryPolicy(policy)
+
+			for _, e := range tt.errors {
+				c.schedulePaymentRetry(ctx, 1, e)
+			}
+			retry, err := db.GetPaymentRetry(ctx, 1)
+			require.NoError(t, err)
+			if tt.attempt == 0 {
+				assert.Nil(t, retry)
+				return
+			}
+			require.NotNil(t, retry)
+			assert.Equal(t, tt.class, retry.DeclineClass)
+			assert.Equal(t, tt.attempt, retry.Attempt)
+			assert.WithinDuration(t, time.Now().Add(tt.delay), retry.NextRetryTime, time.Minute)
+		})
+	}
+}
+
+func TestRetrySchedule_Defaults(t *testing.T) {
+	c := New(newTestLogger(), database_mock.NewMockDB())
+	assert.Len(t, c.retrySchedule(entity.DeclineClassSoft), 4)
+	assert.Len(t, c.retrySchedule(entity.DeclineClassTechnical), 4)
+	assert.Empty(t, c.retrySchedule(entity.DeclineClassHard))
+
+	c.SetRetryPolicy(&RetryPolicy{Technical: []time.Duration{time.Minute}})
+	assert.Equal(t, []time.Duration{time.Minute}, c.retrySchedule(entity.DeclineClassTechnical))
+	assert.Len(t, c.retrySchedule(entity.DeclineClassSoft), 4, "a class left unset keeps its default")
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+)
+
+// ListRoles returns the built-in and stored roles (admin only).
+func (c *Core) ListRoles(ctx context.Context, author *entity.User) ([]*entity.Role, error) {
+	if err := c.requirePermission(author, entity.PermissionRolesWrite); err != nil {
+		return nil, err
+	}
+	return c.auth.ListRoles(ctx)
+}
+
+// ListPermissions returns the permissions a role can grant, central system
+// commands included (admin only).
+func (c *Core) ListPermissions(_ context.Context, author *entity.User) ([]string, error) {
+	if err := c.requirePermission(author, entity.PermissionRolesWrite); err != nil {
+		return nil, err
+	}
+	permissions := []string{entity.PermissionAll}
+	permissions = append(permissions, entity.Permissions...)
+	permissions = append(permissions, entity.PermissionCommandsAll)
+	for _, command := range entity.CentralSystemCommands {
+		permissions = append(permissions, entity.CommandPermission(command))
+	}
+	return permissions, nil
+}
+
+// SaveRole creates or replaces a role (admin only).
+func (c *Core) SaveRole(ctx context.Context, author *entity.User, role *entity.Role) (*entity.Role, error) {
+	if err := c.requirePermission(author, entity.PermissionRolesWrite); err != nil {
+		return nil, err
+	}
+	return c.auth.SaveRole(ctx, author, role)
+}
+
+// DeleteRole removes a stored role (admin only).
+func (c *Core) DeleteRole(ctx context.Context, author *entity.User, name string) error {
+	if err := c.requirePermission(author, entity.PermissionRolesWrite); err != nil {
+		return err
+	}
+	return c.auth.DeleteRole(ctx, name)
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+)
+
+// RefreshSession exchanges a refresh token for a new pair of tokens.
+func (c *Core) RefreshSession(ctx context.Context, refreshToken string) (*entity.SessionTokens, error) {
+	if err := c.requireAuth(); err != nil {
+		return nil, err
+	}
+	return c.auth.RefreshSession(ctx, refreshToken)
+}
+
+// GetSessions returns the sessions of the author.
+func (c *Core) GetSessions(ctx context.Context, author *entity.User) ([]*entity.Session, error) {
+	if err := c.requireAuth(); err != nil {
+		return nil, err
+	}
+	return c.auth.GetSessions(ctx, author)
+}
+
+// RevokeSession ends one of the author's sessions.
+func (c *Core) RevokeSession(ctx context.Context, author *entity.User, sessionId string) error {
+	if err := c.requireAuth(); err != nil {
+		return err
+	}
+	return c.auth.RevokeSession(ctx, author, sessionId)
+}
+
+// RevokeUserSessions ends all sessions of a user (admin only).
+func (c *Core) RevokeUserSessions(ctx context.Context, author *entity.User, username string) (int, error) {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
+		return 0, err
+	}
+	return c.auth.RevokeUserSessions(ctx, author, username)
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"fmt"
+	"time"
+)
+
+// ListTariffs returns all tariffs (admin only).
+func (c *Core) ListTariffs(ctx context.Context, author *entity.User) ([]*entity.Tariff, error) {
+	if err := c.requirePermission(author, entity.PermissionTariffsWrite); err != nil {
+		return nil, err
+	}
+	return c.repo.ListTariffs(ctx)
+}
+
+// GetTariff returns one tariff by id (admin only).
+func (c *Core) GetTariff(ctx context.Context, author *entity.User, id string) (*entity.Tariff, error) {
+	if err := c.requirePermission(author, entity.PermissionTariffsWrite); err != nil {
+		return nil, err
+	}
+	tariff, err := c.repo.GetTariff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if tariff == nil {
+		return nil, fmt.Errorf("tariff %w", entity.ErrNotFound)
+	}
+	return tariff, nil
+}
+
+// CreateTariff adds a new tariff (admin only); an existing tariff with the
+// same id is not replaced.
+func (c *Core) CreateTariff(ctx context.Context, author *entity.User, tariff *entity.Tariff) (*entity.Tariff, error) {
+	if err := c.requirePermission(author, entity.PermissionTariffsWrite); err != nil {
+		return nil, err
+	}
+	if tariff.TariffId == "" {
+		return nil, fmt.Errorf("tariff id is required")
+	}
+	inserted, err := c.repo.InsertTariff(ctx, tariff)
+	if err != nil {
+		return nil, err
+	}
+	if !inserted {
+		return nil, fmt.Errorf("tariff %s %w", tariff.TariffId, entity.ErrAlreadyExists)
+	}
+	return tariff, nil
+}
+
+// SaveTariff creates or replaces a tariff (admin only).
+func (c *Core) SaveTariff(ctx context.Context, author *entity.User, tariff *entity.Tariff) (*entity.Tariff, error) {
+	if err := c.requirePermission(author, entity.PermissionTariffsWrite); err != nil {
+		return nil, err
+	}
+	if tariff.TariffId == "" {
+		return nil, fmt.Errorf("tariff id is required")
+	}
+	return c.repo.SaveTariff(ctx, tariff)
+}
+
+// DeleteTariff removes a tariff (admin only). Transactions keep the copy of the
+// tariff they were priced with.
+func (c *Core) DeleteTariff(ctx context.Context, author *entity.User, id string) error {
+	if err := c.requirePermission(author, entity.PermissionTariffsWrite); err != nil {
+		return err
+	}
+	return c.repo.DeleteTariff(ctx, id)
+}
+
+// GetChargePointTariff returns the tariff currently in effect at a charge point,
+// so users can see the price before they start charging.
+func (c *Core) GetChargePointTariff(ctx context.Context, user *entity.User, chargePointId string) (*entity.Tariff, error) {
+	cp, err := c.repo.GetChargePoint(ctx, user.AccessLevel, chargePointId)
+	if err != nil {
+		return nil, err
+	}
+	if cp == nil {
+		return nil, fmt.Errorf("charge point %w", entity.ErrNotFound)
+	}
+	tariff, err := c.resolveTariff(ctx, cp.Id, cp.LocationId, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if tariff == nil {
+		return nil, fmt.Errorf("tariff %w", entity.ErrNotFound)
+	}
+	return tariff, nil
+}
+
+// resolveTariff picks the tariff effective at the given time: one attached to
+// the charge point wins over one attached to its location, which wins over a
+// default tariff. Among equals the most recently effective one is used.
+// Returns nil when no tariff applies.
+func (c *Core) resolveTariff(ctx context.Context, chargePointId, locationId string, at time.Time) (*entity.Tariff, error) {
+	tariffs, err := c.repo.ListTariffs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list tariffs: %w", err)
+	}
+	var best *entity.Tariff
+	bestRank := 0
+	for _, t := range tariffs {
+		if !t.IsValidAt(at) {
+			continue
+		}
+		rank := 0
+		switch {
+		case t.AppliesTo(chargePointId, ""):
+			rank = 3
+		case t.AppliesTo("", locationId):
+			rank = 2
+		case t.IsDefault:
+			rank = 1
+		default:
+			continue
+		}
+		if rank > bestRank || (rank == bestRank && effectiveSince(t).After(effectiveSince(best))) {
+			best = t
+			bestRank = rank
+		}
+	}
+	return best, nil
+}
+
+func effectiveSince(t *entity.Tariff) time.Time {
+	if t.ValidFrom == nil {
+		return time.Time{}
+	}
+	return *t.ValidFrom
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveTariff(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	core := New(newTestLogger(), db)
+
+	q1 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	q2 := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	for _, tariff := range []*entity.Tariff{
+		{TariffId: "default", IsActive: true, IsDefault: true},
+		{TariffId: "loc-q1", IsActive: true, Locations: []string{"LOC1"}, ValidFrom: &q1, ValidTo: &q2},
+		{TariffId: "loc-q2", IsActive: true, Locations: []string{"LOC1"}, ValidFrom: &q2},
+		{TariffId: "cp", IsActive: true, ChargePoints: []string{"CP2"}},
+		{TariffId: "inactive", IsActive: false, ChargePoints: []string{"CP1"}},
+	} {
+		_, err := db.SaveTariff(ctx, tariff)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name        string
+		chargePoint string
+		location    string
+		at          time.Time
+		want        string
+	}{
+		{"location tariff in first quarter", "CP1", "LOC1", q1.Add(time.Hour), "loc-q1"},
+		{"location tariff after price change", "CP1", "LOC1", q2.Add(time.Hour), "loc-q2"},
+		{"charge point tariff wins over location", "CP2", "LOC1", q2, "cp"},
+		{"default for unassigned charge point", "CP3", "", q2, "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tariff, err := core.resolveTariff(ctx, tt.chargePoint, tt.location, tt.at)
+			require.NoError(t, err)
+			require.NotNil(t, tariff)
+			assert.Equal(t, tt.want, tariff.TariffId)
+		})
+	}
+}
+
+func TestSaveTariff_AccessAndValidation(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	core := New(newTestLogger(), db)
+	core.SetAuth(authenticator.New(newTestLogger(), db))
+
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	user := &entity.User{Username: "user"}
+
+	_, err := core.SaveTariff(ctx, user, &entity.Tariff{TariffId: "t1"})
+	assert.Error(t, err)
+
+	_, err = core.SaveTariff(ctx, admin, &entity.Tariff{})
+	assert.Error(t, err)
+
+	saved, err := core.SaveTariff(ctx, admin, &entity.Tariff{TariffId: "t1", PricePerKwh: 30})
+	require.NoError(t, err)
+	assert.False(t, saved.CreatedAt.IsZero())
+
+	_, err = core.GetTariff(ctx, admin, "missing")
+	assert.True(t, errors.Is(err, entity.ErrNotFound))
+}
+
+func TestCreateTariff_Existing(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	core := New(newTestLogger(), db)
+	core.SetAuth(authenticator.New(newTestLogger(), db))
+	admin := &entity.User{Username: "admin", Role: "admin"}
+
+	created, err := core.CreateTariff(ctx, admin, &entity.Tariff{TariffId: "t1", PricePerKwh: 30})
+	require.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+
+	_, err = core.CreateTariff(ctx, admin, &entity.Tariff{TariffId: "t1", PricePerKwh: 90})
+	assert.ErrorIs(t, err, entity.ErrAlreadyExists)
+	stored, err := core.GetTariff(ctx, admin, "t1")
+	require.NoError(t, err)
+	assert.Equal(t, 30, stored.PricePerKwh, "the live tariff is kept")
+
+	_, err = core.SaveTariff(ctx, admin, &entity.Tariff{TariffId: "t1", PricePerKwh: 90})
+	require.NoError(t, err)
+}
+
+func TestGetChargePointTariff(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	core := New(newTestLogger(), db)
+
+	db.SeedChargePoint(&entity.ChargePoint{Id: "CP1", LocationId: "LOC1"})
+	_, err := db.SaveTariff(ctx, &entity.Tariff{TariffId: "loc", IsActive: true, Locations: []string{"LOC1"}})
+	require.NoError(t, err)
+
+	tariff, err := core.GetChargePointTariff(ctx, &entity.User{Username: "user"}, "CP1")
+	require.NoError(t, err)
+	assert.Equal(t, "loc", tariff.TariffId)
+
+	_, err = core.GetChargePointTariff(ctx, &entity.User{Username: "user"}, "CP9")
+	assert.True(t, errors.Is(err, entity.ErrNotFound))
+}
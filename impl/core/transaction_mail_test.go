@@ -12,12 +12,15 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// stubMailer records the last SendTransaction call.
+// stubMailer records the last SendTransaction call, every card reminder and
+// every account mail.
 type stubMailer struct {
-	sentTo   string
-	sentData entity.TransactionMail
-	calls    int
-	err      error
+	sentTo    string
+	sentData  entity.TransactionMail
+	calls     int
+	err       error
+	reminders map[string]entity.CardReminder // by recipient
+	account   map[string]entity.AccountMail  // password reset and verification mails by recipient
 }
 
 func (s *stubMailer) SendNow(context.Context, *entity.MailSubscription) error { return nil }
@@ -26,6 +29,26 @@ func (s *stubMailer) SendPaymentWarning(context.Context, string, entity.PaymentW
 	return nil
 }
 
+func (s *stubMailer) SendCardReminder(_ context.Context, to string, r entity.CardReminder) error {
+	if s.reminders == nil {
+		s.reminders = make(map[string]entity.CardReminder)
+	}
+	s.reminders[to] = r
+	return s.err
+}
+
+func (s *stubMailer) SendPasswordReset(_ context.Context, to string, m entity.AccountMail) error {
+	if s.account == nil {
+		s.account = make(map[string]entity.AccountMail)
+	}
+	s.account[to] = m
+	return s.err
+}
+
+func (s *stubMailer) SendEmailVerification(ctx context.Context, to string, m entity.AccountMail) error {
+	return s.SendPasswordReset(ctx, to, m)
+}
+
 func (s *stubMailer) SendTransaction(_ context.Context, to string, t entity.TransactionMail) error {
 	s.calls++
 	s.sentTo = to
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+)
+
+// AuthenticateTwoFactor completes a login with the challenge returned for
+// the password and the TOTP or recovery code.
+func (c *Core) AuthenticateTwoFactor(ctx context.Context, challenge, code, device string) (*entity.User, error) {
+	if err := c.requireAuth(); err != nil {
+		return nil, err
+	}
+	user, err := c.auth.AuthenticateTwoFactor(ctx, challenge, code, device)
+	if err != nil {
+		return nil, err
+	}
+	return clearPassword(user), nil
+}
+
+// EnrollTwoFactor generates a TOTP secret for the author.
+func (c *Core) EnrollTwoFactor(ctx context.Context, author *entity.User) (*entity.TwoFactorEnrollment, error) {
+	if err := c.requireAuth(); err != nil {
+		return nil, err
+	}
+	return c.auth.EnrollTwoFactor(ctx, author.Username)
+}
+
+// ConfirmTwoFactor enables the author's second factor with its first code.
+func (c *Core) ConfirmTwoFactor(ctx context.Context, author *entity.User, code string) (*entity.TwoFactorRecovery, error) {
+	if err := c.requireAuth(); err != nil {
+		return nil, err
+	}
+	return c.auth.ConfirmTwoFactor(ctx, author.Username, code)
+}
+
+// DisableTwoFactor removes the author's second factor.
+func (c *Core) DisableTwoFactor(ctx context.Context, author *entity.User, code string) error {
+	if err := c.requireAuth(); err != nil {
+		return err
+	}
+	return c.auth.DisableTwoFactor(ctx, author.Username, code)
+}
+
+// ResetTwoFactor removes the second factor of a user who lost it (admin only).
+func (c *Core) ResetTwoFactor(ctx context.Context, author *entity.User, username string) error {
+	if err := c.requirePermission(author, entity.PermissionUsersWrite); err != nil {
+		return err
+	}
+	return c.auth.ResetTwoFactor(ctx, author, username)
+}
@@ -0,0 +1,280 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Wallet defaults, used when the configuration leaves them unset. Amounts in cents.
+const (
+	defaultMinStartBalance = 500
+	defaultMinTopUp        = 1000
+	defaultMaxTopUp        = 50000
+)
+
+// walletEntriesLimit is the number of ledger entries returned with a wallet.
+const walletEntriesLimit = 50
+
+// WalletConfig holds the prepaid balance rules. Amounts in cents.
+type WalletConfig struct {
+	// MinStartBalance is the balance that lets a user start a session
+	// without a usable card.
+	MinStartBalance int
+	MinTopUp        int
+	MaxTopUp        int
+}
+
+func (c *Core) SetWallet(conf *WalletConfig) {
+	c.wallet = conf
+}
+
+// walletConfig returns the configured wallet rules, with defaults.
+func (c *Core) walletConfig() WalletConfig {
+	conf := WalletConfig{
+		MinStartBalance: defaultMinStartBalance,
+		MinTopUp:        defaultMinTopUp,
+		MaxTopUp:        defaultMaxTopUp,
+	}
+	if c.wallet != nil {
+		if c.wallet.MinStartBalance > 0 {
+			conf.MinStartBalance = c.wallet.MinStartBalance
+		}
+		if c.wallet.MinTopUp > 0 {
+			conf.MinTopUp = c.wallet.MinTopUp
+		}
+		if c.wallet.MaxTopUp > 0 {
+			conf.MaxTopUp = c.wallet.MaxTopUp
+		}
+	}
+	return conf
+}
+
+// GetWallet returns the balance and latest entries of a user's wallet. Users
+// read their own wallet; power users may name any user.
+func (c *Core) GetWallet(ctx context.Context, author *entity.User, username string) (*entity.Wallet, error) {
+	userId := author.UserId
+	if username == "" {
+		username = author.Username
+	}
+	if username != author.Username {
+		if err := c.requirePermission(author, entity.PermissionPaymentsRead); err != nil {
+			return nil, err
+		}
+		var err error
+		if userId, err = c.walletUserId(ctx, username); err != nil {
+			return nil, err
+		}
+	}
+
+	balance, err := c.repo.GetWalletBalance(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("get wallet balance: %w", err)
+	}
+	entries, err := c.repo.GetWalletEntries(ctx, userId, walletEntriesLimit)
+	if err != nil {
+		return nil, fmt.Errorf("get wallet entries: %w", err)
+	}
+	if entries == nil {
+		entries = make([]*entity.WalletEntry, 0)
+	}
+	return &entity.Wallet{Username: username, Balance: balance, Entries: entries}, nil
+}
+
+// CreditWallet adds an operator's credit to a user's wallet (admin only).
+func (c *Core) CreditWallet(ctx context.Context, author *entity.User, req *entity.WalletCreditRequest) (*entity.WalletEntry, error) {
+	if err := c.requirePermission(author, entity.PermissionPaymentsWrite); err != nil {
+		return nil, err
+	}
+	userId, err := c.walletUserId(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+	entry := &entity.WalletEntry{
+		UserId:    userId,
+		Username:  req.Username,
+		Type:      entity.WalletEntryCredit,
+		Amount:    req.Amount,
+		Note:      req.Note,
+		Author:    author.Username,
+		CreatedAt: time.Now(),
+	}
+	if err = c.repo.AddWalletEntry(ctx, entry); err != nil {
+		return nil, fmt.Errorf("add wallet entry: %w", err)
+	}
+	c.payLog(ctx, "info", "wallet", "user %s: credit %.2f by %s (%s), balance %.2f",
+		req.Username, float64(req.Amount)/100, author.Username, req.Note, float64(entry.Balance)/100)
+	return entry, nil
+}
+
+func (c *Core) walletUserId(ctx context.Context, username string) (string, error) {
+	info, err := c.repo.GetUserInfo(ctx, MaxAccessLevel, username)
+	if err != nil {
+		return "", fmt.Errorf("get user: %w", err)
+	}
+	if info == nil || info.UserId == "" {
+		return "", fmt.Errorf("user %s %w", username, entity.ErrNotFound)
+	}
+	return info.UserId, nil
+}
+
+// checkTopUp validates a top-up order before it is sent to the gateway.
+func (c *Core) checkTopUp(order *entity.PaymentOrder) error {
+	if order.TransactionId != 0 {
+		return fmt.Errorf("a top-up order cannot pay a transaction")
+	}
+	conf := c.walletConfig()
+	if order.Amount < conf.MinTopUp || order.Amount > conf.MaxTopUp {
+		return fmt.Errorf("top-up amount must be between %.2f and %.2f",
+			float64(conf.MinTopUp)/100, float64(conf.MaxTopUp)/100)
+	}
+	return nil
+}
+
+// topUpWallet credits the amount of a paid top-up order to its user's wallet.
+func (c *Core) topUpWallet(ctx context.Context, order *entity.PaymentOrder) {
+	entry := &entity.WalletEntry{
+		UserId:    order.UserId,
+		Username:  order.UserName,
+		Type:      entity.WalletEntryTopUp,
+		Amount:    order.Amount,
+		Order:     order.Order,
+		CreatedAt: time.Now(),
+	}
+	if err := c.repo.AddWalletEntry(ctx, entry); err != nil {
+		c.log.With(slog.Int("order", order.Order), sl.Err(err)).Error("failed to credit wallet top-up")
+		c.payLog(ctx, "error", "wallet", "order %d: top-up %.2f of user %s paid but not credited: %v",
+			order.Order, float64(order.Amount)/100, order.UserName, err)
+		return
+	}
+	c.payEvent(ctx, "info", "wallet", entity.PaymentEvent{
+		Type:     entity.PaymentEventWalletToppedUp,
+		Order:    order.Order,
+		UserId:   order.UserId,
+		Username: order.UserName,
+		Amount:   order.Amount,
+		CardHash: entity.CardHash(order.Identifier),
+	}, "order %d: user %s topped up %.2f, balance %.2f",
+		order.Order, order.UserName, float64(order.Amount)/100, float64(entry.Balance)/100)
+}
+
+// payFromWallet bills a transaction from the user's wallet when the balance
+// covers the whole amount. The payment is recorded as a paid order with the
+// wallet identifier, so refunds, invoices and reconciliation treat it like a
+// card payment. It reports false when the card has to be charged instead.
+func (c *Core) payFromWallet(ctx context.Context, transaction *entity.Transaction, tag *entity.UserTag, amount int) bool {
+	log := c.log.With(slog.Int("transaction_id", transaction.TransactionId))
+
+	balance, err := c.repo.GetWalletBalance(ctx, tag.UserId)
+	if err != nil {
+		log.With(sl.Err(err)).Error("failed to get wallet balance")
+		return false
+	}
+	if balance < amount {
+		return false
+	}
+
+	currency := c.currency
+	if currency == "" {
+		currency = "978"
+	}
+	consumed := (transaction.MeterStop - transaction.MeterStart) / 1000
+	order := &entity.PaymentOrder{
+		Amount:        amount,
+		Currency:      currency,
+		Description:   fmt.Sprintf("%s:%d %dkW", transaction.ChargePointId, transaction.ConnectorId, consumed),
+		Identifier:    entity.WalletIdentifier,
+		TransactionId: transaction.TransactionId,
+		UserId:        tag.UserId,
+		UserName:      tag.Username,
+		TimeOpened:    time.Now(),
+	}
+	if order.Order, err = c.nextOrderNumber(ctx); err != nil {
+		log.With(sl.Err(err)).Error("failed to allocate wallet payment order number")
+		return false
+	}
+	if err = c.repo.SavePaymentOrder(ctx, order); err != nil {
+		log.With(sl.Err(err)).Error("failed to save wallet payment order")
+		return false
+	}
+
+	entry := &entity.WalletEntry{
+		UserId:        tag.UserId,
+		Username:      tag.Username,
+		Type:          entity.WalletEntryDebit,
+		Amount:        -amount,
+		TransactionId: transaction.TransactionId,
+		Order:         order.Order,
+		CreatedAt:     time.Now(),
+	}
+	if err = c.repo.AddWalletEntry(ctx, entry); err != nil {
+		if !errors.Is(err, entity.ErrInsufficientBalance) {
+			log.With(sl.Err(err)).Error("failed to debit wallet")
+		}
+		order.IsCompleted = true
+		order.Result = "wallet: " + err.Error()
+		order.TimeClosed = time.Now()
+		if e := c.repo.SavePaymentOrder(ctx, order); e != nil {
+			log.With(sl.Err(e)).Error("failed to close wallet payment order")
+		}
+		return false
+	}
+
+	c.payEvent(ctx, "info", "wallet", entity.PaymentEvent{
+		Type:          entity.PaymentEventWalletDebited,
+		TransactionId: transaction.TransactionId,
+		Order:         order.Order,
+		UserId:        tag.UserId,
+		Username:      tag.Username,
+		Amount:        amount,
+	}, "transaction %d: debited %.2f from user %s on order %d, balance %.2f",
+		transaction.TransactionId, float64(amount)/100, tag.Username, order.Order, float64(entry.Balance)/100)
+
+	now := time.Now()
+	c.processPaymentResponse(ctx, &CaptureResponse{
+		Success:      true,
+		ResponseCode: "0000",
+		Order:        strconv.Itoa(order.Order),
+		Amount:       strconv.Itoa(amount),
+		Currency:     currency,
+		Date:         now.Format("02/01/2006"),
+		Hour:         now.Format("15:04"),
+	}, order.Order)
+	return true
+}
+
+// refundToWallet settles a refund of a wallet payment by crediting the wallet
+// back; the gateway never saw the payment.
+func (c *Core) refundToWallet(ctx context.Context, refund *entity.Refund, order *entity.PaymentOrder) {
+	entry := &entity.WalletEntry{
+		UserId:        order.UserId,
+		Username:      order.UserName,
+		Type:          entity.WalletEntryRefund,
+		Amount:        refund.Amount,
+		TransactionId: refund.TransactionId,
+		Order:         refund.Order,
+		Note:          refund.Reason,
+		CreatedAt:     time.Now(),
+	}
+	if err := c.repo.AddWalletEntry(ctx, entry); err != nil {
+		c.failRefund(ctx, refund, &CaptureResponse{ErrorMessage: err.Error()})
+		return
+	}
+	c.completeRefund(ctx, refund, &CaptureResponse{Success: true})
+}
+
+// walletCoversStart reports whether the user's balance allows starting a
+// session without a card.
+func (c *Core) walletCoversStart(ctx context.Context, userId string) bool {
+	balance, err := c.repo.GetWalletBalance(ctx, userId)
+	if err != nil {
+		c.log.With(sl.Err(err)).Error("failed to get wallet balance")
+		return false
+	}
+	return balance >= c.walletConfig().MinStartBalance
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newWalletCore returns a core with a user holding one card and a wallet
+// with the given balance.
+func newWalletCore(t *testing.T, balance int) (*Core, *database_mock.MockDB, *holdGateway) {
+	t.Helper()
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	c := New(newTestLogger(), db)
+	c.SetAuth(authenticator.New(newTestLogger(), db))
+	g := &holdGateway{}
+	c.SetPaymentGateway(g)
+	c.SetWallet(&WalletConfig{MinStartBalance: 500, MinTopUp: 1000, MaxTopUp: 50000})
+
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1"})
+	require.NoError(t, db.AddUserTag(ctx, &entity.UserTag{UserId: "u1", Username: "driver", IdTag: "TAG1"}))
+	require.NoError(t, db.SavePaymentMethod(ctx, &entity.PaymentMethod{
+		Identifier: "tok-1", CofTid: "cof-1", ExpiryDate: "4012", IsDefault: true, UserId: "u1", UserName: "driver",
+	}))
+	if balance > 0 {
+		require.NoError(t, db.AddWalletEntry(ctx, &entity.WalletEntry{
+			UserId: "u1", Username: "driver", Type: entity.WalletEntryCredit, Amount: balance,
+		}))
+	}
+	return c, db, g
+}
+
+func TestPayTransaction_Wallet(t *testing.T) {
+	tests := []struct {
+		name    string
+		balance int
+		calls   []string
+		left    int
+	}{
+		{"balance covers the session", 2000, nil, 500},
+		{"balance falls short", 1000, []string{"pay 1200 1500"}, 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, g := newWalletCore(t, tt.balance)
+			db.SeedTransaction(&entity.Transaction{TransactionId: 1, IdTag: "TAG1", IsFinished: true, PaymentAmount: 1500})
+
+			require.NoError(t, c.PayTransaction(ctx, 1))
+			tx := waitBilled(t, db, 1500)
+			assert.Equal(t, tt.calls, g.Calls())
+			balance, _ := db.GetWalletBalance(ctx, "u1")
+			assert.Equal(t, tt.left, balance)
+
+			order, _ := db.GetPaymentOrder(ctx, tx.PaymentOrder)
+			require.NotNil(t, order)
+			assert.True(t, order.IsPaid())
+			assert.Equal(t, tt.calls == nil, order.Identifier == entity.WalletIdentifier)
+		})
+	}
+}
+
+func TestWalletTopUp(t *testing.T) {
+	ctx := context.Background()
+	c, db, _ := newWalletCore(t, 0)
+	user := &entity.User{Username: "driver", UserId: "u1"}
+
+	_, err := c.SetOrder(ctx, user, &entity.PaymentOrder{Amount: 500, Purpose: entity.OrderPurposeTopUp})
+	assert.Error(t, err, "top-ups below the minimum are rejected")
+
+	order, err := c.SetOrder(ctx, user, &entity.PaymentOrder{Amount: 2500, Purpose: entity.OrderPurposeTopUp})
+	require.NoError(t, err)
+	result := &entity.PaymentParameters{
+		Order:              normalizeOrderNumber(strconv.Itoa(order.Order)),
+		Amount:             "2500",
+		Response:           "0000",
+		MerchantIdentifier: "tok-2",
+	}
+	c.processNotifyResponse(ctx, result)
+	c.processNotifyResponse(ctx, result) // repeated by the gateway
+
+	info, err := c.GetUser(ctx, user, "0000")
+	require.NoError(t, err)
+	assert.Equal(t, 2500, info.WalletBalance)
+	wallet, err := c.GetWallet(ctx, user, "")
+	require.NoError(t, err)
+	require.Len(t, wallet.Entries, 1)
+	assert.Equal(t, entity.WalletEntryTopUp, wallet.Entries[0].Type)
+	assert.Equal(t, order.Order, wallet.Entries[0].Order)
+
+	methods, _ := db.GetPaymentMethods(ctx, "u1")
+	assert.Len(t, methods, 1, "a top-up does not enroll the card")
+}
+
+func TestCreditWallet(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newWalletCore(t, 0)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	driver := &entity.User{Username: "driver", UserId: "u1"}
+	req := &entity.WalletCreditRequest{Username: "driver", Amount: 1000, Note: "goodwill"}
+
+	_, err := c.CreditWallet(ctx, driver, req)
+	assert.Error(t, err, "users cannot credit wallets")
+
+	entry, err := c.CreditWallet(ctx, admin, req)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, entry.Balance)
+	assert.Equal(t, "admin", entry.Author)
+
+	_, err = c.CreditWallet(ctx, admin, &entity.WalletCreditRequest{Username: "nobody", Amount: 1000, Note: "x"})
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+
+	_, err = c.GetWallet(ctx, driver, "admin")
+	assert.Error(t, err, "users read only their own wallet")
+	wallet, err := c.GetWallet(ctx, admin, "driver")
+	require.NoError(t, err)
+	assert.Equal(t, 1000, wallet.Balance)
+}
+
+func TestRefund_WalletPayment(t *testing.T) {
+	ctx := context.Background()
+	c, db, g := newWalletCore(t, 2000)
+	admin := &entity.User{Username: "admin", Role: "admin"}
+	db.SeedTransaction(&entity.Transaction{TransactionId: 1, IdTag: "TAG1", IsFinished: true, PaymentAmount: 1500})
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	waitBilled(t, db, 1500)
+
+	_, err := c.IssueRefund(ctx, admin, 1, &entity.RefundOrderRequest{Amount: 400, Reason: entity.RefundReasonBillingError})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		refunds, _ := db.GetRefunds(ctx, 1)
+		return len(refunds) == 1 && refunds[0].Status == entity.RefundStatusCompleted
+	}, time.Second, 5*time.Millisecond)
+
+	balance, _ := db.GetWalletBalance(ctx, "u1")
+	assert.Equal(t, 900, balance)
+	assert.Empty(t, g.Calls(), "the gateway is not involved")
+}
+
+func TestValidateStartTransactionPaymentMethod_Wallet(t *testing.T) {
+	tests := []struct {
+		name     string
+		balance  int
+		failed   bool // the only card has failed before
+		methodId string
+		wantErr  bool
+	}{
+		{"card usable", 0, false, "", false},
+		{"no usable card, balance sufficient", 500, true, "", false},
+		{"no usable card, balance too low", 499, true, "", true},
+		{"wallet selected, balance sufficient", 800, false, entity.WalletIdentifier, false},
+		{"wallet selected, balance too low", 100, false, entity.WalletIdentifier, true},
+		{"failed card selected despite balance", 800, true, "tok-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, db, _ := newWalletCore(t, tt.balance)
+			if tt.failed {
+				require.NoError(t, db.UpdatePaymentMethodFailCount(ctx, "tok-1", 1))
+			}
+
+			err := c.validateStartTransactionPaymentMethod(ctx, &entity.UserRequest{Token: "TAG1", PaymentMethodId: tt.methodId})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -4,29 +4,52 @@ import (
 	"context"
 	"evsys-back/entity"
 	"fmt"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
 
 // MockDB provides an in-memory database implementation for testing
 type MockDB struct {
-	users              map[string]*entity.User              // key: username
-	usersById          map[string]*entity.User              // key: userId
-	tokens             map[string]*entity.User              // key: token
-	userTags           map[string][]entity.UserTag          // key: userId
-	allTags            map[string]bool                      // key: idTag (for uniqueness check)
-	invites            map[string]*entity.Invite            // key: code
-	transactions       map[int]*entity.Transaction          // key: transactionId
-	chargeStates       map[int]*entity.ChargeState          // key: transactionId
-	meterValues        map[int][]entity.TransactionMeter    // key: transactionId
-	paymentMethods     map[string][]*entity.PaymentMethod   // key: userId
-	paymentOrders      map[int]*entity.PaymentOrder         // key: orderId
-	ordersByTx         map[int]*entity.PaymentOrder         // key: transactionId
-	preauthorizations  map[string]*entity.Preauthorization  // key: orderNumber
-	preauthByTx        map[int]*entity.Preauthorization     // key: transactionId
-	paymentRetries     map[int]*entity.PaymentRetry         // key: transactionId
-	mailSubscriptions  map[string]*entity.MailSubscription  // key: id
-	webhookSubscribers map[string]*entity.WebhookSubscriber // key: id
+	users              map[string]*entity.User                // key: username
+	usersById          map[string]*entity.User                // key: userId
+	userTags           map[string][]entity.UserTag            // key: userId
+	allTags            map[string]bool                        // key: idTag (for uniqueness check)
+	invites            map[string]*entity.Invite              // key: code
+	transactions       map[int]*entity.Transaction            // key: transactionId
+	chargeStates       map[int]*entity.ChargeState            // key: transactionId
+	meterValues        map[int][]entity.TransactionMeter      // key: transactionId
+	paymentMethods     map[string][]*entity.PaymentMethod     // key: userId
+	paymentOrders      map[int]*entity.PaymentOrder           // key: orderId
+	ordersByTx         map[int]*entity.PaymentOrder           // key: transactionId
+	preauthorizations  map[string]*entity.Preauthorization    // key: orderNumber
+	preauthByTx        map[int]*entity.Preauthorization       // key: transactionId
+	paymentRetries     map[int]*entity.PaymentRetry           // key: transactionId
+	mailSubscriptions  map[string]*entity.MailSubscription    // key: id
+	webhookSubscribers map[string]*entity.WebhookSubscriber   // key: id
+	tariffs            map[string]*entity.Tariff              // key: tariffId
+	chargePoints       map[string]*entity.ChargePoint         // key: chargePointId
+	paymentPlans       map[string]*entity.PaymentPlan         // key: planId
+	refunds            []*entity.Refund                       // in insertion order
+	invoices           []*entity.Invoice                      // in insertion order
+	paymentResults     []*entity.PaymentParameters            // in insertion order
+	walletBalances     map[string]int                         // key: userId
+	walletEntries      []*entity.WalletEntry                  // in insertion order
+	idempotencyKeys    map[string]*entity.IdempotencyRecord   // key: idempotency key
+	usageLimits        map[string]*entity.UsageLimit          // key: scope/subject
+	usageCounters      map[string]*entity.UsageCounter        // key: scope/subject/period
+	userGroups         map[string]*entity.UserGroup           // key: name
+	groupStatements    map[string]*entity.GroupStatement      // key: id
+	promotions         map[string]*entity.Promotion           // key: code
+	redemptions        map[string]*entity.PromotionRedemption // key: code/userId
+	cardNotices        map[string]*entity.CardNotice          // key: identifier/kind
+	paymentEvents      []*entity.PaymentEvent                 // in insertion order
+	sequences          map[string]int                         // key: sequence name
+	sessions           map[string]*entity.Session             // key: session id
+	roles              map[string]*entity.Role                // key: name
+	apiKeys            map[string]*entity.ApiKey              // key: id
+	accountTokens      map[string]*entity.AccountToken        // key: hash
 	lastOrderId        int
 	mux                sync.RWMutex
 }
@@ -42,7 +65,6 @@ func NewMockDB() *MockDB {
 func (db *MockDB) clear() {
 	db.users = make(map[string]*entity.User)
 	db.usersById = make(map[string]*entity.User)
-	db.tokens = make(map[string]*entity.User)
 	db.userTags = make(map[string][]entity.UserTag)
 	db.allTags = make(map[string]bool)
 	db.invites = make(map[string]*entity.Invite)
@@ -57,6 +79,28 @@ func (db *MockDB) clear() {
 	db.paymentRetries = make(map[int]*entity.PaymentRetry)
 	db.mailSubscriptions = make(map[string]*entity.MailSubscription)
 	db.webhookSubscribers = make(map[string]*entity.WebhookSubscriber)
+	db.tariffs = make(map[string]*entity.Tariff)
+	db.chargePoints = make(map[string]*entity.ChargePoint)
+	db.paymentPlans = make(map[string]*entity.PaymentPlan)
+	db.refunds = nil
+	db.invoices = nil
+	db.paymentResults = nil
+	db.walletBalances = make(map[string]int)
+	db.walletEntries = nil
+	db.idempotencyKeys = make(map[string]*entity.IdempotencyRecord)
+	db.usageLimits = make(map[string]*entity.UsageLimit)
+	db.usageCounters = make(map[string]*entity.UsageCounter)
+	db.userGroups = make(map[string]*entity.UserGroup)
+	db.groupStatements = make(map[string]*entity.GroupStatement)
+	db.promotions = make(map[string]*entity.Promotion)
+	db.redemptions = make(map[string]*entity.PromotionRedemption)
+	db.cardNotices = make(map[string]*entity.CardNotice)
+	db.paymentEvents = nil
+	db.sequences = make(map[string]int)
+	db.sessions = make(map[string]*entity.Session)
+	db.roles = make(map[string]*entity.Role)
+	db.apiKeys = make(map[string]*entity.ApiKey)
+	db.accountTokens = make(map[string]*entity.AccountToken)
 	db.lastOrderId = 0
 }
 
@@ -75,9 +119,6 @@ func (db *MockDB) SeedUser(user *entity.User) {
 	if user.UserId != "" {
 		db.usersById[user.UserId] = user
 	}
-	if user.Token != "" {
-		db.tokens[user.Token] = user
-	}
 }
 
 // SeedInvite adds a test invite code to the mock database
@@ -114,6 +155,20 @@ func (db *MockDB) SeedPaymentOrder(order *entity.PaymentOrder) {
 	}
 }
 
+// SeedMeterValue appends a meter value to a transaction
+func (db *MockDB) SeedMeterValue(transactionId int, value entity.TransactionMeter) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.meterValues[transactionId] = append(db.meterValues[transactionId], value)
+}
+
+// SeedChargePoint adds a test charge point to the mock database
+func (db *MockDB) SeedChargePoint(cp *entity.ChargePoint) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.chargePoints[cp.Id] = cp
+}
+
 // --- User Methods ---
 
 func (db *MockDB) GetUser(_ context.Context, username string) (*entity.User, error) {
@@ -123,7 +178,8 @@ func (db *MockDB) GetUser(_ context.Context, username string) (*entity.User, err
 	if !ok {
 		return nil, fmt.Errorf("user %w", entity.ErrNotFound)
 	}
-	return user, nil
+	copied := *user
+	return &copied, nil
 }
 
 func (db *MockDB) GetUserById(_ context.Context, userId string) (*entity.User, error) {
@@ -133,7 +189,20 @@ func (db *MockDB) GetUserById(_ context.Context, userId string) (*entity.User, e
 	if !ok {
 		return nil, nil
 	}
-	return user, nil
+	copied := *user
+	return &copied, nil
+}
+
+func (db *MockDB) GetUserByEmail(_ context.Context, email string) (*entity.User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	for _, user := range db.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
 }
 
 func (db *MockDB) GetUsers(_ context.Context) ([]*entity.User, error) {
@@ -157,9 +226,16 @@ func (db *MockDB) GetUserInfo(_ context.Context, level int, userId string) (*ent
 		return nil, nil
 	}
 	return &entity.UserInfo{
-		Username: user.Username,
-		Name:     user.Name,
-		Role:     user.Role,
+		UserId:      user.UserId,
+		Username:    user.Username,
+		Name:        user.Name,
+		Role:        user.Role,
+		Email:       user.Email,
+		Group:       user.Group,
+		BillingMode: user.BillingMode,
+		Fiscal:      user.Fiscal,
+
+		EmailUnverified: user.EmailUnverified,
 	}, nil
 }
 
@@ -170,9 +246,6 @@ func (db *MockDB) AddUser(_ context.Context, user *entity.User) error {
 	if user.UserId != "" {
 		db.usersById[user.UserId] = user
 	}
-	if user.Token != "" {
-		db.tokens[user.Token] = user
-	}
 	return nil
 }
 
@@ -189,12 +262,41 @@ func (db *MockDB) UpdateUser(_ context.Context, user *entity.User) error {
 	existing.Role = user.Role
 	existing.AccessLevel = user.AccessLevel
 	existing.PaymentPlan = user.PaymentPlan
+	existing.Group = user.Group
+	existing.BillingMode = user.BillingMode
 	existing.Password = user.Password
+	existing.EmailUnverified = user.EmailUnverified
 	existing.WarningEmailsEnabled = user.WarningEmailsEnabled
 	existing.WarningEmail = user.WarningEmail
 	return nil
 }
 
+func (db *MockDB) UpdateUserTwoFactor(_ context.Context, user *entity.User) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	existing, ok := db.users[user.Username]
+	if !ok {
+		return fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	existing.TwoFactorEnabled = user.TwoFactorEnabled
+	existing.TotpSecret = user.TotpSecret
+	existing.TotpLastStep = user.TotpLastStep
+	existing.RecoveryCodes = slices.Clone(user.RecoveryCodes)
+	return nil
+}
+
+func (db *MockDB) GetUsersByPaymentPlan(_ context.Context, planId string) ([]*entity.User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var users []*entity.User
+	for _, u := range db.users {
+		if u.PaymentPlan == planId {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
 func (db *MockDB) GetWarningEmailRecipients(_ context.Context) ([]*entity.User, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
@@ -219,9 +321,6 @@ func (db *MockDB) DeleteUser(_ context.Context, username string) error {
 	if user.UserId != "" {
 		delete(db.usersById, user.UserId)
 	}
-	if user.Token != "" {
-		delete(db.tokens, user.Token)
-	}
 	return nil
 }
 
@@ -230,7 +329,6 @@ func (db *MockDB) UpdateLastSeen(_ context.Context, user *entity.User) error {
 	defer db.mux.Unlock()
 	if existing, ok := db.users[user.Username]; ok {
 		existing.LastSeen = user.LastSeen
-		existing.Token = user.Token
 	}
 	return nil
 }
@@ -244,16 +342,6 @@ func (db *MockDB) CheckUsername(_ context.Context, username string) error {
 	return nil
 }
 
-func (db *MockDB) CheckToken(_ context.Context, token string) (*entity.User, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
-	user, ok := db.tokens[token]
-	if !ok {
-		return nil, nil
-	}
-	return user, nil
-}
-
 // --- User Tags ---
 
 func (db *MockDB) GetUserTags(_ context.Context, userId string) ([]entity.UserTag, error) {
@@ -355,15 +443,52 @@ func (db *MockDB) DeleteUserTag(_ context.Context, idTag string) error {
 func (db *MockDB) AddInviteCode(_ context.Context, invite *entity.Invite) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
-	db.invites[invite.Code] = invite
+	copied := *invite
+	db.invites[invite.Code] = &copied
 	return nil
 }
 
-func (db *MockDB) CheckInviteCode(_ context.Context, code string) (bool, error) {
+func (db *MockDB) GetInvite(_ context.Context, code string) (*entity.Invite, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	invite, ok := db.invites[code]
+	if !ok {
+		return nil, nil
+	}
+	copied := *invite
+	return &copied, nil
+}
+
+func (db *MockDB) GetInvites(_ context.Context) ([]*entity.Invite, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
-	_, ok := db.invites[code]
-	return ok, nil
+	var result []*entity.Invite
+	for _, invite := range db.invites {
+		copied := *invite
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+func (db *MockDB) UseInviteCode(_ context.Context, code string, now time.Time) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	invite, ok := db.invites[code]
+	if !ok || invite.Usable(now) != nil {
+		return false, nil
+	}
+	invite.Uses++
+	return true, nil
+}
+
+func (db *MockDB) ReleaseInviteCode(_ context.Context, code string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if invite, ok := db.invites[code]; ok && invite.Uses > 0 {
+		invite.Uses--
+	}
+	return nil
 }
 
 func (db *MockDB) DeleteInviteCode(_ context.Context, code string) error {
@@ -382,7 +507,8 @@ func (db *MockDB) GetTransaction(_ context.Context, id int) (*entity.Transaction
 	if !ok {
 		return nil, nil
 	}
-	return tx, nil
+	txCopy := *tx
+	return &txCopy, nil
 }
 
 func (db *MockDB) GetTransactionByTag(_ context.Context, idTag string, timeStart time.Time) (*entity.Transaction, error) {
@@ -390,7 +516,8 @@ func (db *MockDB) GetTransactionByTag(_ context.Context, idTag string, timeStart
 	defer db.mux.RUnlock()
 	for _, tx := range db.transactions {
 		if tx.IdTag == idTag && tx.TimeStart.After(timeStart) {
-			return tx, nil
+			txCopy := *tx
+			return &txCopy, nil
 		}
 	}
 	return nil, nil
@@ -523,7 +650,8 @@ func (db *MockDB) GetTransactionsToBill(userId string) ([]*entity.Transaction, e
 func (db *MockDB) UpdateTransactionPayment(_ context.Context, transaction *entity.Transaction) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
-	db.transactions[transaction.TransactionId] = transaction
+	txCopy := *transaction
+	db.transactions[transaction.TransactionId] = &txCopy
 	return nil
 }
 
@@ -608,15 +736,29 @@ func (db *MockDB) GetLastOrder(_ context.Context) (*entity.PaymentOrder, error)
 	if db.lastOrderId == 0 {
 		return nil, nil
 	}
-	return db.paymentOrders[db.lastOrderId], nil
+	order, ok := db.paymentOrders[db.lastOrderId]
+	if !ok {
+		return nil, nil
+	}
+	orderCopy := *order
+	return &orderCopy, nil
+}
+
+func (db *MockDB) NextSequence(_ context.Context, name string, floor int) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	next := max(db.sequences[name], floor-1) + 1
+	db.sequences[name] = next
+	return next, nil
 }
 
 func (db *MockDB) SavePaymentOrder(_ context.Context, order *entity.PaymentOrder) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
-	db.paymentOrders[order.Order] = order
+	orderCopy := *order
+	db.paymentOrders[order.Order] = &orderCopy
 	if order.TransactionId > 0 {
-		db.ordersByTx[order.TransactionId] = order
+		db.ordersByTx[order.TransactionId] = &orderCopy
 	}
 	if order.Order > db.lastOrderId {
 		db.lastOrderId = order.Order
@@ -631,20 +773,26 @@ func (db *MockDB) GetPaymentOrder(_ context.Context, id int) (*entity.PaymentOrd
 	if !ok {
 		return nil, nil
 	}
-	return order, nil
+	orderCopy := *order
+	return &orderCopy, nil
 }
 
 func (db *MockDB) GetPaymentOrderByTransaction(_ context.Context, transactionId int) (*entity.PaymentOrder, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 	order, ok := db.ordersByTx[transactionId]
-	if !ok {
+	if !ok || order.IsCompleted {
 		return nil, nil
 	}
-	return order, nil
+	orderCopy := *order
+	return &orderCopy, nil
 }
 
 func (db *MockDB) SavePaymentResult(_ context.Context, paymentParameters *entity.PaymentParameters) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *paymentParameters
+	db.paymentResults = append(db.paymentResults, &copied)
 	return nil
 }
 
@@ -652,6 +800,40 @@ func (db *MockDB) WritePaymentLog(_ context.Context, _ *entity.LogMessage) error
 	return nil
 }
 
+func (db *MockDB) AddPaymentEvent(_ context.Context, event *entity.PaymentEvent) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *event
+	db.paymentEvents = append(db.paymentEvents, &copied)
+	return nil
+}
+
+func (db *MockDB) GetPaymentEvents(_ context.Context, filter *entity.PaymentEventFilter) ([]*entity.PaymentEvent, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var events []*entity.PaymentEvent
+	for i := len(db.paymentEvents) - 1; i >= 0; i-- {
+		e := db.paymentEvents[i]
+		if (filter.TransactionId > 0 && e.TransactionId != filter.TransactionId) ||
+			(filter.Order > 0 && e.Order != filter.Order) ||
+			(filter.UserId != "" && e.UserId != filter.UserId) ||
+			(filter.Username != "" && e.Username != filter.Username) ||
+			(filter.Type != "" && e.Type != filter.Type) ||
+			(filter.CardHash != "" && e.CardHash != filter.CardHash) ||
+			(filter.Code != "" && e.Code != filter.Code) ||
+			(filter.From != nil && e.Time.Before(*filter.From)) ||
+			(filter.To != nil && e.Time.After(*filter.To)) {
+			continue
+		}
+		copied := *e
+		events = append(events, &copied)
+		if filter.Limit > 0 && int64(len(events)) >= filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
 func (db *MockDB) GetUserTag(_ context.Context, idTag string) (*entity.UserTag, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
@@ -686,7 +868,8 @@ func (db *MockDB) GetPaymentMethodByIdentifier(_ context.Context, identifier str
 	for _, methods := range db.paymentMethods {
 		for _, m := range methods {
 			if m.Identifier == identifier {
-				return m, nil
+				methodCopy := *m
+				return &methodCopy, nil
 			}
 		}
 	}
@@ -734,6 +917,11 @@ func (db *MockDB) GetChargePoints(_ context.Context, level int, searchTerm strin
 }
 
 func (db *MockDB) GetChargePoint(_ context.Context, level int, id string) (*entity.ChargePoint, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if cp, ok := db.chargePoints[id]; ok && cp.AccessLevel <= level {
+		return cp, nil
+	}
 	return nil, nil
 }
 
@@ -780,9 +968,10 @@ func (db *MockDB) StationStatus(_ context.Context, chargePointId string) ([]*ent
 func (db *MockDB) SavePreauthorization(_ context.Context, preauth *entity.Preauthorization) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
-	db.preauthorizations[preauth.OrderNumber] = preauth
+	preauthCopy := *preauth
+	db.preauthorizations[preauth.OrderNumber] = &preauthCopy
 	if preauth.TransactionId > 0 {
-		db.preauthByTx[preauth.TransactionId] = preauth
+		db.preauthByTx[preauth.TransactionId] = &preauthCopy
 	}
 	return nil
 }
@@ -794,7 +983,8 @@ func (db *MockDB) GetPreauthorization(_ context.Context, orderNumber string) (*e
 	if !ok {
 		return nil, nil
 	}
-	return preauth, nil
+	preauthCopy := *preauth
+	return &preauthCopy, nil
 }
 
 func (db *MockDB) GetPreauthorizationByTransaction(_ context.Context, transactionId int) (*entity.Preauthorization, error) {
@@ -804,7 +994,8 @@ func (db *MockDB) GetPreauthorizationByTransaction(_ context.Context, transactio
 	if !ok {
 		return nil, nil
 	}
-	return preauth, nil
+	preauthCopy := *preauth
+	return &preauthCopy, nil
 }
 
 func (db *MockDB) UpdatePreauthorization(_ context.Context, preauth *entity.Preauthorization) error {
@@ -833,7 +1024,46 @@ func (db *MockDB) GetLastPreauthorizationOrder(_ context.Context) (*entity.Preau
 			latest = preauth
 		}
 	}
-	return latest, nil
+	if latest == nil {
+		return nil, nil
+	}
+	preauthCopy := *latest
+	return &preauthCopy, nil
+}
+
+func (db *MockDB) GetUserAuthorizedPreauthorization(_ context.Context, userId string) (*entity.Preauthorization, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var latest *entity.Preauthorization
+	for _, preauth := range db.preauthorizations {
+		if preauth.UserId != userId || preauth.Status != entity.PreauthorizationStatusAuthorized || preauth.TransactionId != 0 {
+			continue
+		}
+		if latest == nil || preauth.CreatedAt.After(latest.CreatedAt) {
+			latest = preauth
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	preauthCopy := *latest
+	return &preauthCopy, nil
+}
+
+func (db *MockDB) GetAuthorizedPreauthorizations(_ context.Context, createdBefore time.Time) ([]*entity.Preauthorization, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var result []*entity.Preauthorization
+	for _, preauth := range db.preauthorizations {
+		if preauth.Status == entity.PreauthorizationStatusAuthorized && preauth.CreatedAt.Before(createdBefore) {
+			preauthCopy := *preauth
+			result = append(result, &preauthCopy)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
 }
 
 // --- Payment Retries ---
@@ -843,13 +1073,26 @@ func (db *MockDB) GetUnbilledTransactions(_ context.Context) ([]*entity.Transact
 	defer db.mux.RUnlock()
 	var result []*entity.Transaction
 	for _, tx := range db.transactions {
-		if tx.IsFinished && tx.PaymentAmount > 0 && tx.PaymentBilled < tx.PaymentAmount {
+		if tx.IsFinished && tx.PaymentAmount > 0 && tx.PaymentBilled < tx.PaymentAmount && tx.GroupBilling == "" {
 			result = append(result, tx)
 		}
 	}
 	return result, nil
 }
 
+func (db *MockDB) GetUnfinishedTransactions(_ context.Context) ([]*entity.Transaction, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var result []*entity.Transaction
+	for _, tx := range db.transactions {
+		if !tx.IsFinished {
+			txCopy := *tx
+			result = append(result, &txCopy)
+		}
+	}
+	return result, nil
+}
+
 func (db *MockDB) SavePaymentRetry(_ context.Context, retry *entity.PaymentRetry) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
@@ -864,7 +1107,8 @@ func (db *MockDB) GetPaymentRetry(_ context.Context, transactionId int) (*entity
 	if !ok {
 		return nil, nil
 	}
-	return retry, nil
+	retryCopy := *retry
+	return &retryCopy, nil
 }
 
 func (db *MockDB) GetPendingRetries(_ context.Context, now time.Time) ([]*entity.PaymentRetry, error) {
@@ -954,6 +1198,235 @@ func (db *MockDB) DeleteMailSubscription(_ context.Context, id string) error {
 	return nil
 }
 
+func (db *MockDB) ListPaymentPlans(_ context.Context) ([]*entity.PaymentPlan, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	plans := make([]*entity.PaymentPlan, 0, len(db.paymentPlans))
+	for _, p := range db.paymentPlans {
+		plans = append(plans, p)
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].PlanId < plans[j].PlanId })
+	return plans, nil
+}
+
+func (db *MockDB) GetPaymentPlan(_ context.Context, planId string) (*entity.PaymentPlan, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if p, ok := db.paymentPlans[planId]; ok {
+		return p, nil
+	}
+	return nil, nil
+}
+
+func (db *MockDB) GetGroupDefaultPaymentPlan(_ context.Context, group string) (*entity.PaymentPlan, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	for _, p := range db.paymentPlans {
+		if !p.IsActive {
+			continue
+		}
+		for _, g := range p.UserGroups {
+			if g == group {
+				return p, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (db *MockDB) SavePaymentPlan(_ context.Context, plan *entity.PaymentPlan) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.paymentPlans[plan.PlanId] = plan
+	return nil
+}
+
+func (db *MockDB) ListTariffs(_ context.Context) ([]*entity.Tariff, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	tariffs := make([]*entity.Tariff, 0, len(db.tariffs))
+	for _, t := range db.tariffs {
+		tariffs = append(tariffs, t)
+	}
+	sort.Slice(tariffs, func(i, j int) bool { return tariffs[i].TariffId < tariffs[j].TariffId })
+	return tariffs, nil
+}
+
+func (db *MockDB) GetTariff(_ context.Context, id string) (*entity.Tariff, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if t, ok := db.tariffs[id]; ok {
+		return t, nil
+	}
+	return nil, nil
+}
+
+func (db *MockDB) InsertTariff(_ context.Context, tariff *entity.Tariff) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if _, ok := db.tariffs[tariff.TariffId]; ok {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	tariff.CreatedAt = now
+	tariff.UpdatedAt = now
+	db.tariffs[tariff.TariffId] = tariff
+	return true, nil
+}
+
+func (db *MockDB) SaveTariff(_ context.Context, tariff *entity.Tariff) (*entity.Tariff, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	now := time.Now().UTC()
+	tariff.UpdatedAt = now
+	if existing, ok := db.tariffs[tariff.TariffId]; ok {
+		tariff.CreatedAt = existing.CreatedAt
+	} else {
+		tariff.CreatedAt = now
+	}
+	db.tariffs[tariff.TariffId] = tariff
+	return tariff, nil
+}
+
+func (db *MockDB) DeleteTariff(_ context.Context, id string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if _, ok := db.tariffs[id]; !ok {
+		return fmt.Errorf("tariff %w", entity.ErrNotFound)
+	}
+	delete(db.tariffs, id)
+	return nil
+}
+
+func (db *MockDB) SaveRefund(_ context.Context, refund *entity.Refund) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if refund.Id == "" {
+		refund.Id = fmt.Sprintf("mock-%d", len(db.refunds)+1)
+		r := *refund
+		db.refunds = append(db.refunds, &r)
+		return nil
+	}
+	for i, r := range db.refunds {
+		if r.Id == refund.Id {
+			copied := *refund
+			db.refunds[i] = &copied
+			return nil
+		}
+	}
+	return fmt.Errorf("refund %w", entity.ErrNotFound)
+}
+
+func (db *MockDB) GetRefunds(_ context.Context, transactionId int) ([]*entity.Refund, error) {
+	return db.findRefunds(func(r *entity.Refund) bool { return r.TransactionId == transactionId }), nil
+}
+
+func (db *MockDB) GetRefundsByOrder(_ context.Context, order int) ([]*entity.Refund, error) {
+	return db.findRefunds(func(r *entity.Refund) bool { return r.Order == order }), nil
+}
+
+func (db *MockDB) findRefunds(match func(r *entity.Refund) bool) []*entity.Refund {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var refunds []*entity.Refund
+	for _, r := range db.refunds {
+		if match(r) {
+			copied := *r
+			refunds = append(refunds, &copied)
+		}
+	}
+	return refunds
+}
+
+func (db *MockDB) InsertInvoice(_ context.Context, invoice *entity.Invoice) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	for _, i := range db.invoices {
+		if (i.Series == invoice.Series && i.Year == invoice.Year && i.Number == invoice.Number) ||
+			(invoice.Type == entity.InvoiceTypeInvoice && i.Type == entity.InvoiceTypeInvoice && i.Order == invoice.Order) ||
+			(invoice.RefundId != "" && i.RefundId == invoice.RefundId) {
+			return false, nil
+		}
+	}
+	invoice.Id = fmt.Sprintf("mock-%d", len(db.invoices)+1)
+	copied := *invoice
+	db.invoices = append(db.invoices, &copied)
+	return true, nil
+}
+
+func (db *MockDB) GetInvoice(_ context.Context, id string) (*entity.Invoice, error) {
+	return db.findInvoice(func(i *entity.Invoice) bool { return i.Id == id }), nil
+}
+
+func (db *MockDB) GetLastInvoice(_ context.Context, series string, year int) (*entity.Invoice, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var last *entity.Invoice
+	for _, i := range db.invoices {
+		if i.Series == series && i.Year == year && (last == nil || i.Number > last.Number) {
+			last = i
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	copied := *last
+	return &copied, nil
+}
+
+func (db *MockDB) GetInvoiceByOrder(_ context.Context, order int) (*entity.Invoice, error) {
+	return db.findInvoice(func(i *entity.Invoice) bool {
+		return i.Order == order && i.Type == entity.InvoiceTypeInvoice
+	}), nil
+}
+
+func (db *MockDB) GetInvoiceByRefund(_ context.Context, refundId string) (*entity.Invoice, error) {
+	return db.findInvoice(func(i *entity.Invoice) bool { return i.RefundId == refundId }), nil
+}
+
+func (db *MockDB) GetUserInvoices(_ context.Context, userId string) ([]*entity.Invoice, error) {
+	return db.findInvoices(func(i *entity.Invoice) bool { return i.UserId == userId }), nil
+}
+
+func (db *MockDB) GetInvoices(_ context.Context, from, to time.Time) ([]*entity.Invoice, error) {
+	return db.findInvoices(func(i *entity.Invoice) bool {
+		return !i.IssuedAt.Before(from) && i.IssuedAt.Before(to)
+	}), nil
+}
+
+func (db *MockDB) findInvoice(match func(i *entity.Invoice) bool) *entity.Invoice {
+	invoices := db.findInvoices(match)
+	if len(invoices) == 0 {
+		return nil
+	}
+	return invoices[0]
+}
+
+func (db *MockDB) findInvoices(match func(i *entity.Invoice) bool) []*entity.Invoice {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var invoices []*entity.Invoice
+	for _, i := range db.invoices {
+		if match(i) {
+			copied := *i
+			invoices = append(invoices, &copied)
+		}
+	}
+	return invoices
+}
+
+func (db *MockDB) UpdateUserFiscal(_ context.Context, username string, fiscal *entity.FiscalDetails) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	user, ok := db.users[username]
+	if !ok {
+		return fmt.Errorf("user %w", entity.ErrNotFound)
+	}
+	copied := *fiscal
+	user.Fiscal = &copied
+	return nil
+}
+
 func (db *MockDB) ListWebhookSubscribers(_ context.Context) ([]*entity.WebhookSubscriber, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
@@ -998,3 +1471,701 @@ func (db *MockDB) GetWebhookOutboxStats(_ context.Context) ([]*entity.WebhookOut
 func (db *MockDB) ListWebhookProblemDeliveries(_ context.Context, _ int) ([]*entity.WebhookDeliveryView, error) {
 	return nil, nil
 }
+
+// --- Reconciliation ---
+
+func (db *MockDB) GetPaymentOrders(_ context.Context, from, to time.Time) ([]*entity.PaymentOrder, error) {
+	return db.findPaymentOrders(func(o *entity.PaymentOrder) bool {
+		return !o.TimeOpened.Before(from) && o.TimeOpened.Before(to)
+	}), nil
+}
+
+func (db *MockDB) GetTransactionPaymentOrders(_ context.Context, transactionId int) ([]*entity.PaymentOrder, error) {
+	return db.findPaymentOrders(func(o *entity.PaymentOrder) bool { return o.TransactionId == transactionId }), nil
+}
+
+func (db *MockDB) findPaymentOrders(match func(o *entity.PaymentOrder) bool) []*entity.PaymentOrder {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var orders []*entity.PaymentOrder
+	for _, o := range db.paymentOrders {
+		if match(o) {
+			copied := *o
+			orders = append(orders, &copied)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].TimeOpened.Equal(orders[j].TimeOpened) {
+			return orders[i].TimeOpened.Before(orders[j].TimeOpened)
+		}
+		return orders[i].Order < orders[j].Order
+	})
+	return orders
+}
+
+func (db *MockDB) GetPaymentResults(_ context.Context, from, to time.Time) ([]*entity.PaymentParameters, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var results []*entity.PaymentParameters
+	for _, r := range db.paymentResults {
+		if !r.ReceivedAt.Before(from) && r.ReceivedAt.Before(to) {
+			copied := *r
+			results = append(results, &copied)
+		}
+	}
+	return results, nil
+}
+
+// --- Wallets ---
+
+func (db *MockDB) GetWalletBalance(_ context.Context, userId string) (int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.walletBalances[userId], nil
+}
+
+func (db *MockDB) AddWalletEntry(_ context.Context, entry *entity.WalletEntry) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	balance := db.walletBalances[entry.UserId] + entry.Amount
+	if balance < 0 {
+		return entity.ErrInsufficientBalance
+	}
+	db.walletBalances[entry.UserId] = balance
+	entry.Balance = balance
+	entry.Id = fmt.Sprintf("mock-%d", len(db.walletEntries)+1)
+	copied := *entry
+	db.walletEntries = append(db.walletEntries, &copied)
+	return nil
+}
+
+func (db *MockDB) GetWalletEntries(_ context.Context, userId string, limit int) ([]*entity.WalletEntry, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var entries []*entity.WalletEntry
+	for i := len(db.walletEntries) - 1; i >= 0 && len(entries) < limit; i-- {
+		if db.walletEntries[i].UserId == userId {
+			copied := *db.walletEntries[i]
+			entries = append(entries, &copied)
+		}
+	}
+	return entries, nil
+}
+
+// --- Idempotency keys ---
+
+func (db *MockDB) InsertIdempotencyRecord(_ context.Context, record *entity.IdempotencyRecord) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if _, ok := db.idempotencyKeys[record.Key]; ok {
+		return false, nil
+	}
+	copied := *record
+	db.idempotencyKeys[record.Key] = &copied
+	return true, nil
+}
+
+func (db *MockDB) GetIdempotencyRecord(_ context.Context, key string) (*entity.IdempotencyRecord, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	record, ok := db.idempotencyKeys[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *record
+	return &copied, nil
+}
+
+func (db *MockDB) SaveIdempotencyRecord(_ context.Context, record *entity.IdempotencyRecord) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *record
+	db.idempotencyKeys[record.Key] = &copied
+	return nil
+}
+
+func (db *MockDB) ReplaceIdempotencyRecord(_ context.Context, record *entity.IdempotencyRecord, createdAt time.Time) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	existing, ok := db.idempotencyKeys[record.Key]
+	if !ok || !existing.CreatedAt.Equal(createdAt) {
+		return false, nil
+	}
+	copied := *record
+	db.idempotencyKeys[record.Key] = &copied
+	return true, nil
+}
+
+func (db *MockDB) DeleteIdempotencyRecord(_ context.Context, key string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	delete(db.idempotencyKeys, key)
+	return nil
+}
+
+// --- Usage limits ---
+
+func (db *MockDB) ListUsageLimits(_ context.Context) ([]*entity.UsageLimit, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	limits := make([]*entity.UsageLimit, 0, len(db.usageLimits))
+	for _, limit := range db.usageLimits {
+		copied := *limit
+		limits = append(limits, &copied)
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		if limits[i].Scope != limits[j].Scope {
+			return limits[i].Scope < limits[j].Scope
+		}
+		return limits[i].Subject < limits[j].Subject
+	})
+	return limits, nil
+}
+
+func (db *MockDB) GetUsageLimit(_ context.Context, scope, subject string) (*entity.UsageLimit, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	limit, ok := db.usageLimits[scope+"/"+subject]
+	if !ok {
+		return nil, nil
+	}
+	copied := *limit
+	return &copied, nil
+}
+
+func (db *MockDB) SaveUsageLimit(_ context.Context, limit *entity.UsageLimit) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *limit
+	db.usageLimits[limit.Scope+"/"+limit.Subject] = &copied
+	return nil
+}
+
+func (db *MockDB) DeleteUsageLimit(_ context.Context, scope, subject string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	key := scope + "/" + subject
+	if _, ok := db.usageLimits[key]; !ok {
+		return fmt.Errorf("usage limit %w", entity.ErrNotFound)
+	}
+	delete(db.usageLimits, key)
+	return nil
+}
+
+func (db *MockDB) AddUsage(_ context.Context, scope, subject, period string, amount, energy int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	key := scope + "/" + subject + "/" + period
+	counter, ok := db.usageCounters[key]
+	if !ok {
+		counter = &entity.UsageCounter{Scope: scope, Subject: subject, Period: period}
+		db.usageCounters[key] = counter
+	}
+	counter.Amount += amount
+	counter.Energy += energy
+	counter.Transactions++
+	return nil
+}
+
+func (db *MockDB) GetUsage(_ context.Context, scope, subject, period string) (*entity.UsageCounter, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	counter, ok := db.usageCounters[scope+"/"+subject+"/"+period]
+	if !ok {
+		return nil, nil
+	}
+	copied := *counter
+	return &copied, nil
+}
+
+// --- User groups ---
+
+func (db *MockDB) ListUserGroups(_ context.Context) ([]*entity.UserGroup, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	groups := make([]*entity.UserGroup, 0, len(db.userGroups))
+	for _, group := range db.userGroups {
+		copied := *group
+		groups = append(groups, &copied)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func (db *MockDB) GetUserGroup(_ context.Context, name string) (*entity.UserGroup, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	group, ok := db.userGroups[name]
+	if !ok {
+		return nil, nil
+	}
+	copied := *group
+	return &copied, nil
+}
+
+func (db *MockDB) SaveUserGroup(_ context.Context, group *entity.UserGroup) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *group
+	db.userGroups[group.Name] = &copied
+	return nil
+}
+
+func (db *MockDB) GetGroupBillingTransactions(_ context.Context, group string, before time.Time) ([]*entity.Transaction, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var result []*entity.Transaction
+	for _, tx := range db.transactions {
+		if tx.GroupBilling == group && tx.IsFinished && tx.TimeStart.Before(before) && tx.PaymentBilled < tx.PaymentAmount {
+			txCopy := *tx
+			result = append(result, &txCopy)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].TimeStart.Before(result[j].TimeStart) })
+	return result, nil
+}
+
+func (db *MockDB) SaveGroupStatement(_ context.Context, statement *entity.GroupStatement) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *statement
+	db.groupStatements[statement.Id] = &copied
+	return nil
+}
+
+func (db *MockDB) GetGroupStatement(_ context.Context, id string) (*entity.GroupStatement, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	statement, ok := db.groupStatements[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *statement
+	return &copied, nil
+}
+
+func (db *MockDB) GetGroupStatementByOrder(_ context.Context, order int) (*entity.GroupStatement, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	for _, statement := range db.groupStatements {
+		if statement.Order == order {
+			copied := *statement
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MockDB) GetGroupStatements(_ context.Context, group string) ([]*entity.GroupStatement, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var statements []*entity.GroupStatement
+	for _, statement := range db.groupStatements {
+		if statement.Group == group {
+			copied := *statement
+			statements = append(statements, &copied)
+		}
+	}
+	sort.Slice(statements, func(i, j int) bool { return statements[i].Period > statements[j].Period })
+	return statements, nil
+}
+
+// --- Promotions ---
+
+func (db *MockDB) ListPromotions(_ context.Context) ([]*entity.Promotion, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	promotions := make([]*entity.Promotion, 0, len(db.promotions))
+	for _, promotion := range db.promotions {
+		copied := *promotion
+		promotions = append(promotions, &copied)
+	}
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].CreatedAt.After(promotions[j].CreatedAt) })
+	return promotions, nil
+}
+
+func (db *MockDB) GetPromotion(_ context.Context, code string) (*entity.Promotion, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	promotion, ok := db.promotions[code]
+	if !ok {
+		return nil, nil
+	}
+	copied := *promotion
+	return &copied, nil
+}
+
+func (db *MockDB) SavePromotion(_ context.Context, promotion *entity.Promotion) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *promotion
+	db.promotions[promotion.Code] = &copied
+	return nil
+}
+
+func (db *MockDB) ClaimPromotion(_ context.Context, code string) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	promotion, ok := db.promotions[code]
+	if !ok || (promotion.MaxRedemptions > 0 && promotion.Redemptions >= promotion.MaxRedemptions) {
+		return false, nil
+	}
+	promotion.Redemptions++
+	return true, nil
+}
+
+func (db *MockDB) ReleasePromotion(_ context.Context, code string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if promotion, ok := db.promotions[code]; ok && promotion.Redemptions > 0 {
+		promotion.Redemptions--
+	}
+	return nil
+}
+
+func (db *MockDB) InsertPromotionRedemption(_ context.Context, redemption *entity.PromotionRedemption) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	key := redemption.Code + "/" + redemption.UserId
+	if _, ok := db.redemptions[key]; ok {
+		return false, nil
+	}
+	copied := *redemption
+	db.redemptions[key] = &copied
+	return true, nil
+}
+
+func (db *MockDB) DeletePromotionRedemption(_ context.Context, code, userId string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	delete(db.redemptions, code+"/"+userId)
+	return nil
+}
+
+func (db *MockDB) GetPromotionRedemption(_ context.Context, code, userId string) (*entity.PromotionRedemption, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	redemption, ok := db.redemptions[code+"/"+userId]
+	if !ok {
+		return nil, nil
+	}
+	copied := *redemption
+	return &copied, nil
+}
+
+func (db *MockDB) GetPromotionRedemptions(_ context.Context, userId string) ([]*entity.PromotionRedemption, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var redemptions []*entity.PromotionRedemption
+	for _, redemption := range db.redemptions {
+		if redemption.UserId == userId {
+			copied := *redemption
+			redemptions = append(redemptions, &copied)
+		}
+	}
+	sort.Slice(redemptions, func(i, j int) bool { return redemptions[i].RedeemedAt.Before(redemptions[j].RedeemedAt) })
+	return redemptions, nil
+}
+
+func (db *MockDB) UsePromotionRedemption(_ context.Context, code, userId string, discount int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	redemption, ok := db.redemptions[code+"/"+userId]
+	if !ok || redemption.Remaining <= 0 {
+		return false, nil
+	}
+	redemption.Remaining--
+	redemption.Discounted += discount
+	redemption.UsedAt = time.Now()
+	return true, nil
+}
+
+// --- Card notices ---
+
+func (db *MockDB) GetPaymentMethodsToNotice(_ context.Context, expiresBy string) ([]*entity.PaymentMethod, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var result []*entity.PaymentMethod
+	for _, methods := range db.paymentMethods {
+		for _, pm := range methods {
+			if pm.FailCount > 0 || (pm.ExpiryDate != "" && pm.ExpiryDate <= expiresBy) {
+				copied := *pm
+				result = append(result, &copied)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Identifier < result[j].Identifier })
+	return result, nil
+}
+
+func (db *MockDB) GetCardNotice(_ context.Context, identifier, kind string) (*entity.CardNotice, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	notice, ok := db.cardNotices[identifier+"/"+kind]
+	if !ok {
+		return nil, nil
+	}
+	copied := *notice
+	return &copied, nil
+}
+
+func (db *MockDB) SaveCardNotice(_ context.Context, notice *entity.CardNotice) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	notice.Id = notice.Identifier + "/" + notice.Kind
+	copied := *notice
+	db.cardNotices[notice.Id] = &copied
+	return nil
+}
+
+// --- Sessions ---
+
+func (db *MockDB) AddSession(_ context.Context, session *entity.Session) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *session
+	db.sessions[session.Id] = &copied
+	return nil
+}
+
+func (db *MockDB) findSession(match func(*entity.Session) bool) *entity.Session {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	for _, session := range db.sessions {
+		if match(session) {
+			copied := *session
+			return &copied
+		}
+	}
+	return nil
+}
+
+func (db *MockDB) GetSessionByToken(_ context.Context, tokenHash string) (*entity.Session, error) {
+	return db.findSession(func(s *entity.Session) bool { return s.TokenHash == tokenHash }), nil
+}
+
+func (db *MockDB) GetSessionByRefresh(_ context.Context, refreshHash string) (*entity.Session, error) {
+	return db.findSession(func(s *entity.Session) bool { return s.RefreshHash == refreshHash }), nil
+}
+
+func (db *MockDB) GetUserSessions(_ context.Context, userId string) ([]*entity.Session, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var result []*entity.Session
+	for _, session := range db.sessions {
+		if session.UserId == userId {
+			copied := *session
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastSeen.After(result[j].LastSeen) })
+	return result, nil
+}
+
+func (db *MockDB) UpdateSession(_ context.Context, session *entity.Session) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	existing, ok := db.sessions[session.Id]
+	if !ok {
+		return fmt.Errorf("session %w", entity.ErrNotFound)
+	}
+	existing.TokenHash = session.TokenHash
+	existing.RefreshHash = session.RefreshHash
+	existing.LastSeen = session.LastSeen
+	existing.ExpiresAt = session.ExpiresAt
+	existing.RefreshExpiresAt = session.RefreshExpiresAt
+	return nil
+}
+
+func (db *MockDB) DeleteSession(_ context.Context, userId, id string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	session, ok := db.sessions[id]
+	if !ok || session.UserId != userId {
+		return fmt.Errorf("session %w", entity.ErrNotFound)
+	}
+	delete(db.sessions, id)
+	return nil
+}
+
+func (db *MockDB) DeleteUserSessions(_ context.Context, userId string) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	count := 0
+	for id, session := range db.sessions {
+		if session.UserId == userId {
+			delete(db.sessions, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (db *MockDB) DeleteExpiredSessions(_ context.Context, before time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	for id, session := range db.sessions {
+		if session.RefreshExpiresAt.Before(before) {
+			delete(db.sessions, id)
+		}
+	}
+	return nil
+}
+
+// --- Roles ---
+
+func (db *MockDB) GetRole(_ context.Context, name string) (*entity.Role, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	role, ok := db.roles[name]
+	if !ok {
+		return nil, nil
+	}
+	copied := *role
+	return &copied, nil
+}
+
+func (db *MockDB) GetRoles(_ context.Context) ([]*entity.Role, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var result []*entity.Role
+	for _, role := range db.roles {
+		copied := *role
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (db *MockDB) SaveRole(_ context.Context, role *entity.Role) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *role
+	db.roles[role.Name] = &copied
+	return nil
+}
+
+func (db *MockDB) DeleteRole(_ context.Context, name string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if _, ok := db.roles[name]; !ok {
+		return fmt.Errorf("role %w", entity.ErrNotFound)
+	}
+	delete(db.roles, name)
+	return nil
+}
+
+// --- API Keys ---
+
+func (db *MockDB) AddApiKey(_ context.Context, key *entity.ApiKey) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *key
+	copied.Key = ""
+	db.apiKeys[key.Id] = &copied
+	return nil
+}
+
+func (db *MockDB) GetApiKey(_ context.Context, id string) (*entity.ApiKey, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	key, ok := db.apiKeys[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (db *MockDB) GetApiKeyByHash(_ context.Context, keyHash string) (*entity.ApiKey, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	for _, key := range db.apiKeys {
+		if key.KeyHash == keyHash {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MockDB) GetApiKeys(_ context.Context) ([]*entity.ApiKey, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var result []*entity.ApiKey
+	for _, key := range db.apiKeys {
+		copied := *key
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+func (db *MockDB) UpdateApiKey(_ context.Context, key *entity.ApiKey) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	existing, ok := db.apiKeys[key.Id]
+	if !ok {
+		return fmt.Errorf("api key %w", entity.ErrNotFound)
+	}
+	existing.KeyHash = key.KeyHash
+	existing.Prefix = key.Prefix
+	existing.RotatedAt = key.RotatedAt
+	existing.RevokedAt = key.RevokedAt
+	return nil
+}
+
+func (db *MockDB) SetApiKeyUsed(_ context.Context, id string, at time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	existing, ok := db.apiKeys[id]
+	if !ok {
+		return fmt.Errorf("api key %w", entity.ErrNotFound)
+	}
+	existing.LastUsed = &at
+	return nil
+}
+
+// --- Account Tokens ---
+
+func (db *MockDB) AddAccountToken(_ context.Context, token *entity.AccountToken) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	copied := *token
+	copied.Token = ""
+	db.accountTokens[token.Hash] = &copied
+	return nil
+}
+
+func (db *MockDB) GetAccountToken(_ context.Context, hash string) (*entity.AccountToken, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	token, ok := db.accountTokens[hash]
+	if !ok {
+		return nil, nil
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (db *MockDB) DeleteAccountToken(_ context.Context, hash string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if _, ok := db.accountTokens[hash]; !ok {
+		return fmt.Errorf("token %w", entity.ErrNotFound)
+	}
+	delete(db.accountTokens, hash)
+	return nil
+}
+
+func (db *MockDB) DeleteAccountTokens(_ context.Context, userId, purpose string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	now := time.Now()
+	for hash, token := range db.accountTokens {
+		if (token.UserId == userId && token.Purpose == purpose) || token.ExpiresAt.Before(now) {
+			delete(db.accountTokens, hash)
+		}
+	}
+	return nil
+}
@@ -20,6 +20,8 @@ const (
 	collectionSysLog            = "sys_log"
 	collectionBackLog           = "back_log"
 	collectionPaymentLog        = "payment_log"
+	collectionPaymentEvents     = "payment_events"
+	collectionCounters          = "counters"
 	collectionConfig            = "config"
 	collectionUsers             = "users"
 	collectionUserTags          = "user_tags"
@@ -36,6 +38,23 @@ const (
 	collectionPreauthorizations = "preauthorizations"
 	collectionPaymentRetries    = "payment_retries"
 	collectionMailSubscriptions = "mail_subscriptions"
+	collectionTariffs           = "tariffs"
+	collectionRefunds           = "refunds"
+	collectionInvoices          = "invoices"
+	collectionWallets           = "wallets"
+	collectionWalletEntries     = "wallet_entries"
+	collectionIdempotencyKeys   = "idempotency_keys"
+	collectionUsageLimits       = "usage_limits"
+	collectionUsageCounters     = "usage_counters"
+	collectionUserGroups        = "user_groups"
+	collectionGroupStatements   = "group_statements"
+	collectionPromotions        = "promotions"
+	collectionRedemptions       = "promotion_redemptions"
+	collectionCardNotices       = "card_notices"
+	collectionSessions          = "sessions"
+	collectionRoles             = "roles"
+	collectionApiKeys           = "api_keys"
+	collectionAccountTokens     = "account_tokens"
 
 	// written by evsys; see evsys docs/WEBHOOKS.md for the schema contract
 	collectionWebhookSubscribers = "webhook_subscribers"
@@ -141,11 +160,62 @@ func NewMongoClient(conf *config.Config) (*MongoDB, error) {
 		return nil, fmt.Errorf("pinging mongodb: %w", err)
 	}
 
-	return &MongoDB{
+	m := &MongoDB{
 		client:           client,
 		database:         conf.Mongo.Database,
 		logRecordsNumber: conf.LogRecords,
-	}, nil
+	}
+	if err := m.createIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("creating indexes: %w", err)
+	}
+	return m, nil
+}
+
+// createIndexes creates the unique indexes that keep documents from being
+// duplicated by concurrent backend instances; existing indexes are kept.
+func (m *MongoDB) createIndexes(ctx context.Context) error {
+	indexes := map[string][]mongo.IndexModel{
+		collectionInvoices: {
+			{
+				Keys:    bson.D{{Key: "series", Value: 1}, {Key: "year", Value: 1}, {Key: "number", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "order", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.D{{Key: "type", Value: entity.InvoiceTypeInvoice}}),
+			},
+			{
+				Keys: bson.D{{Key: "refund_id", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.D{{Key: "refund_id", Value: bson.D{{Key: "$type", Value: "string"}}}}),
+			},
+		},
+		collectionInvites: {
+			{
+				Keys:    bson.D{{Key: "code", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		collectionTariffs: {
+			{
+				Keys:    bson.D{{Key: "tariff_id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		collectionRedemptions: {
+			{
+				Keys:    bson.D{{Key: "code", Value: 1}, {Key: "user_id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+	}
+	for name, models := range indexes {
+		if _, err := m.col(name).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
 }
 
 func (m *MongoDB) Close() error {
@@ -305,7 +375,7 @@ func (m *MongoDB) GetUserInfo(ctx context.Context, _ int, username string) (*ent
 }
 
 func (m *MongoDB) GetUsers(ctx context.Context) ([]*entity.User, error) {
-	projection := bson.M{"password": 0, "token": 0}
+	projection := bson.M{"password": 0, "token": 0, "totp_secret": 0, "recovery_codes": 0}
 	return findMany[*entity.User](m, ctx, collectionUsers, bson.D{}, options.Find().SetProjection(projection))
 }
 
@@ -313,6 +383,17 @@ func (m *MongoDB) GetUserById(ctx context.Context, userId string) (*entity.User,
 	return findOne[entity.User](m, ctx, collectionUsers, bson.D{{Key: "user_id", Value: userId}})
 }
 
+func (m *MongoDB) GetUserByEmail(ctx context.Context, email string) (*entity.User, error) {
+	return findOne[entity.User](m, ctx, collectionUsers, bson.D{{Key: "email", Value: email}})
+}
+
+// GetUsersByPaymentPlan returns users assigned to the given payment plan.
+func (m *MongoDB) GetUsersByPaymentPlan(ctx context.Context, planId string) ([]*entity.User, error) {
+	filter := bson.D{{Key: "payment_plan", Value: planId}}
+	projection := bson.M{"password": 0, "token": 0}
+	return findMany[*entity.User](m, ctx, collectionUsers, filter, options.Find().SetProjection(projection))
+}
+
 // GetWarningEmailRecipients returns users who opted in to payment warning emails.
 func (m *MongoDB) GetWarningEmailRecipients(ctx context.Context) ([]*entity.User, error) {
 	filter := bson.D{{Key: "warning_emails_enabled", Value: true}}
@@ -389,10 +470,11 @@ func (m *MongoDB) DeleteUserTag(ctx context.Context, idTag string) error {
 func (m *MongoDB) UpdateLastSeen(ctx context.Context, user *entity.User) error {
 	collection := m.col(collectionUsers)
 	filter := bson.D{{Key: "username", Value: user.Username}}
-	update := bson.M{"$set": bson.D{
-		{Key: "last_seen", Value: time.Now()},
-		{Key: "token", Value: user.Token},
-	}}
+	// tokens live in sessions; the legacy user token is dropped
+	update := bson.M{
+		"$set":   bson.D{{Key: "last_seen", Value: time.Now()}},
+		"$unset": bson.D{{Key: "token", Value: ""}},
+	}
 	_, err := collection.UpdateOne(ctx, filter, update)
 	return err
 }
@@ -411,13 +493,28 @@ func (m *MongoDB) UpdateUser(ctx context.Context, user *entity.User) error {
 		{Key: "role", Value: user.Role},
 		{Key: "access_level", Value: user.AccessLevel},
 		{Key: "payment_plan", Value: user.PaymentPlan},
+		{Key: "group", Value: user.Group},
+		{Key: "billing_mode", Value: user.BillingMode},
 		{Key: "password", Value: user.Password},
+		{Key: "email_unverified", Value: user.EmailUnverified},
 		{Key: "warning_emails_enabled", Value: user.WarningEmailsEnabled},
 		{Key: "warning_email", Value: user.WarningEmail},
 	}}
 	return m.updateOne(ctx, collectionUsers, filter, update, "user")
 }
 
+// UpdateUserTwoFactor writes the second factor settings of a user.
+func (m *MongoDB) UpdateUserTwoFactor(ctx context.Context, user *entity.User) error {
+	filter := bson.D{{Key: "username", Value: user.Username}}
+	update := bson.M{"$set": bson.D{
+		{Key: "two_factor_enabled", Value: user.TwoFactorEnabled},
+		{Key: "totp_secret", Value: user.TotpSecret},
+		{Key: "totp_last_step", Value: user.TotpLastStep},
+		{Key: "recovery_codes", Value: user.RecoveryCodes},
+	}}
+	return m.updateOne(ctx, collectionUsers, filter, update, "user")
+}
+
 func (m *MongoDB) DeleteUser(ctx context.Context, username string) error {
 	return m.deleteOne(ctx, collectionUsers, bson.D{{Key: "username", Value: username}}, "user")
 }
@@ -434,17 +531,6 @@ func (m *MongoDB) CheckUsername(ctx context.Context, username string) error {
 	return nil
 }
 
-func (m *MongoDB) CheckToken(ctx context.Context, token string) (*entity.User, error) {
-	collection := m.col(collectionUsers)
-	filter := bson.D{{Key: "token", Value: token}}
-	var userData entity.User
-	err := collection.FindOne(ctx, filter).Decode(&userData)
-	if err != nil {
-		return nil, err
-	}
-	return &userData, nil
-}
-
 func (m *MongoDB) GetChargePoints(ctx context.Context, level int, searchTerm string) ([]*entity.ChargePoint, error) {
 	var searchRegex = bson.M{"$regex": primitive.Regex{Pattern: ".*" + searchTerm + ".*", Options: "i"}}
 
@@ -670,6 +756,9 @@ func (m *MongoDB) UpdateTransactionPayment(ctx context.Context, transaction *ent
 			{Key: "payment_order", Value: transaction.PaymentOrder},
 			{Key: "payment_billed", Value: transaction.PaymentBilled},
 			{Key: "payment_error", Value: transaction.PaymentError},
+			{Key: "group_billing", Value: transaction.GroupBilling},
+			{Key: "discount", Value: transaction.Discount},
+			{Key: "promo_code", Value: transaction.PromoCode},
 			{Key: "payment_orders", Value: transaction.PaymentOrders},
 			{Key: "payment_method", Value: transaction.PaymentMethod},
 		}},
@@ -928,14 +1017,43 @@ func (m *MongoDB) AddInviteCode(ctx context.Context, invite *entity.Invite) erro
 	return nil
 }
 
-func (m *MongoDB) CheckInviteCode(ctx context.Context, code string) (bool, error) {
-	collection := m.col(collectionInvites)
-	filter := bson.D{{Key: "code", Value: code}}
-	var inviteCode entity.Invite
-	if err := collection.FindOne(ctx, filter).Decode(&inviteCode); err != nil {
+func (m *MongoDB) GetInvite(ctx context.Context, code string) (*entity.Invite, error) {
+	return findOne[entity.Invite](m, ctx, collectionInvites, bson.D{{Key: "code", Value: code}})
+}
+
+// GetInvites returns all invites, newest first.
+func (m *MongoDB) GetInvites(ctx context.Context) ([]*entity.Invite, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	return findMany[*entity.Invite](m, ctx, collectionInvites, bson.M{}, opts)
+}
+
+// UseInviteCode counts a registration with the invite code if it is neither
+// expired nor used up at the given time, reporting whether it was counted.
+func (m *MongoDB) UseInviteCode(ctx context.Context, code string, now time.Time) (bool, error) {
+	filter := bson.D{
+		{Key: "code", Value: code},
+		{Key: "expires_at", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$lte", Value: now}}}}},
+		{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$uses", 0}}},
+			bson.D{{Key: "$max", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$max_uses", 0}}}, 1}}},
+		}}}},
+	}
+	update := bson.M{"$inc": bson.D{{Key: "uses", Value: 1}}}
+	result, err := m.col(collectionInvites).UpdateOne(ctx, filter, update)
+	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return result.ModifiedCount > 0, nil
+}
+
+// ReleaseInviteCode gives back a use counted by UseInviteCode.
+func (m *MongoDB) ReleaseInviteCode(ctx context.Context, code string) error {
+	filter := bson.D{
+		{Key: "code", Value: code},
+		{Key: "uses", Value: bson.D{{Key: "$gt", Value: 0}}},
+	}
+	_, err := m.col(collectionInvites).UpdateOne(ctx, filter, bson.M{"$inc": bson.D{{Key: "uses", Value: -1}}})
+	return err
 }
 
 func (m *MongoDB) DeleteInviteCode(ctx context.Context, code string) error {
@@ -958,6 +1076,55 @@ func (m *MongoDB) WritePaymentLog(ctx context.Context, msg *entity.LogMessage) e
 	return err
 }
 
+// AddPaymentEvent inserts a typed payment event.
+func (m *MongoDB) AddPaymentEvent(ctx context.Context, event *entity.PaymentEvent) error {
+	_, err := m.col(collectionPaymentEvents).InsertOne(ctx, event)
+	return err
+}
+
+// GetPaymentEvents returns the payment events matching the filter, newest
+// first, capped like the logs.
+func (m *MongoDB) GetPaymentEvents(ctx context.Context, filter *entity.PaymentEventFilter) ([]*entity.PaymentEvent, error) {
+	query := bson.D{}
+	if filter.TransactionId > 0 {
+		query = append(query, bson.E{Key: "transaction_id", Value: filter.TransactionId})
+	}
+	if filter.Order > 0 {
+		query = append(query, bson.E{Key: "order", Value: filter.Order})
+	}
+	if filter.UserId != "" {
+		query = append(query, bson.E{Key: "user_id", Value: filter.UserId})
+	}
+	if filter.Username != "" {
+		query = append(query, bson.E{Key: "username", Value: filter.Username})
+	}
+	if filter.Type != "" {
+		query = append(query, bson.E{Key: "type", Value: filter.Type})
+	}
+	if filter.CardHash != "" {
+		query = append(query, bson.E{Key: "card_hash", Value: filter.CardHash})
+	}
+	if filter.Code != "" {
+		query = append(query, bson.E{Key: "code", Value: filter.Code})
+	}
+	period := bson.D{}
+	if filter.From != nil {
+		period = append(period, bson.E{Key: "$gte", Value: *filter.From})
+	}
+	if filter.To != nil {
+		period = append(period, bson.E{Key: "$lte", Value: *filter.To})
+	}
+	if len(period) > 0 {
+		query = append(query, bson.E{Key: "time", Value: period})
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > maxLogRecords {
+		limit = maxLogRecords
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: -1}}).SetLimit(limit)
+	return findMany[*entity.PaymentEvent](m, ctx, collectionPaymentEvents, query, opts)
+}
+
 // GetPaymentParameters get payment parameters by order id
 func (m *MongoDB) GetPaymentParameters(orderId string) (*entity.PaymentParameters, error) {
 	return findOne[entity.PaymentParameters](m, context.Background(), collectionPayment, bson.D{{Key: "order", Value: orderId}})
@@ -1061,6 +1228,32 @@ func (m *MongoDB) GetLastOrder(ctx context.Context) (*entity.PaymentOrder, error
 	return &order, nil
 }
 
+// NextSequence atomically increments the named counter and returns its new
+// value, raised to floor when it is lower. The counter is created on first
+// use; a concurrent first use that loses the insert race is retried.
+func (m *MongoDB) NextSequence(ctx context.Context, name string, floor int) (int, error) {
+	// seq = max(seq, floor-1) + 1, in one server-side update
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "seq", Value: bson.D{{Key: "$add", Value: bson.A{
+		bson.D{{Key: "$max", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$seq", 0}}}, floor - 1}}},
+		1,
+	}}}}}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		err = m.col(collectionCounters).FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: name}}, update, opts).Decode(&counter)
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
 // GetPaymentOrderByTransaction get payment order by transaction id
 func (m *MongoDB) GetPaymentOrderByTransaction(ctx context.Context, transactionId int) (*entity.PaymentOrder, error) {
 	collection := m.col(collectionPaymentOrders)
@@ -1261,16 +1454,49 @@ func (m *MongoDB) GetLastPreauthorizationOrder(ctx context.Context) (*entity.Pre
 	return &preauth, nil
 }
 
+// GetUserAuthorizedPreauthorization returns the latest authorized preauthorization
+// of a user that is not linked to a transaction yet
+func (m *MongoDB) GetUserAuthorizedPreauthorization(ctx context.Context, userId string) (*entity.Preauthorization, error) {
+	collection := m.col(collectionPreauthorizations)
+	filter := bson.D{
+		{Key: "user_id", Value: userId},
+		{Key: "status", Value: entity.PreauthorizationStatusAuthorized},
+		{Key: "transaction_id", Value: 0},
+	}
+	var preauth entity.Preauthorization
+	if err := collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})).Decode(&preauth); err != nil {
+		return nil, m.findError(err)
+	}
+	return &preauth, nil
+}
+
+// GetAuthorizedPreauthorizations returns authorized preauthorizations created before the given time
+func (m *MongoDB) GetAuthorizedPreauthorizations(ctx context.Context, createdBefore time.Time) ([]*entity.Preauthorization, error) {
+	filter := bson.D{
+		{Key: "status", Value: entity.PreauthorizationStatusAuthorized},
+		{Key: "created_at", Value: bson.D{{Key: "$lt", Value: createdBefore}}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return findMany[*entity.Preauthorization](m, ctx, collectionPreauthorizations, filter, opts)
+}
+
 // GetUnbilledTransactions returns all finished transactions where payment_billed < payment_amount
 func (m *MongoDB) GetUnbilledTransactions(ctx context.Context) ([]*entity.Transaction, error) {
 	filter := bson.D{
 		{Key: "is_finished", Value: true},
 		{Key: "payment_amount", Value: bson.D{{Key: "$gt", Value: 0}}},
 		{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$payment_billed", "$payment_amount"}}}},
+		// sessions of groups billed monthly are paid with the group's order
+		{Key: "group_billing", Value: bson.D{{Key: "$in", Value: bson.A{nil, ""}}}},
 	}
 	return findMany[*entity.Transaction](m, ctx, collectionTransactions, filter)
 }
 
+// GetUnfinishedTransactions returns all running sessions.
+func (m *MongoDB) GetUnfinishedTransactions(ctx context.Context) ([]*entity.Transaction, error) {
+	return findMany[*entity.Transaction](m, ctx, collectionTransactions, bson.D{{Key: "is_finished", Value: false}})
+}
+
 // SavePaymentRetry upserts a payment retry record by transaction_id
 func (m *MongoDB) SavePaymentRetry(ctx context.Context, retry *entity.PaymentRetry) error {
 	collection := m.col(collectionPaymentRetries)
@@ -1356,6 +1582,633 @@ func (m *MongoDB) DeleteMailSubscription(ctx context.Context, id string) error {
 	return m.deleteOne(ctx, collectionMailSubscriptions, bson.D{{Key: "_id", Value: id}}, "subscription")
 }
 
+// ListPaymentPlans returns all payment plans ordered by id.
+func (m *MongoDB) ListPaymentPlans(ctx context.Context) ([]*entity.PaymentPlan, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "plan_id", Value: 1}})
+	return findMany[*entity.PaymentPlan](m, ctx, collectionPaymentPlans, bson.M{}, opts)
+}
+
+// GetPaymentPlan returns one payment plan by plan_id.
+func (m *MongoDB) GetPaymentPlan(ctx context.Context, planId string) (*entity.PaymentPlan, error) {
+	return findOne[entity.PaymentPlan](m, ctx, collectionPaymentPlans, bson.D{{Key: "plan_id", Value: planId}})
+}
+
+// GetGroupDefaultPaymentPlan returns the active plan that is the default for a user group.
+func (m *MongoDB) GetGroupDefaultPaymentPlan(ctx context.Context, group string) (*entity.PaymentPlan, error) {
+	filter := bson.D{
+		{Key: "user_groups", Value: group},
+		{Key: "is_active", Value: true},
+	}
+	return findOne[entity.PaymentPlan](m, ctx, collectionPaymentPlans, filter)
+}
+
+// SavePaymentPlan inserts or replaces a payment plan by plan_id.
+func (m *MongoDB) SavePaymentPlan(ctx context.Context, plan *entity.PaymentPlan) error {
+	filter := bson.D{{Key: "plan_id", Value: plan.PlanId}}
+	_, err := m.col(collectionPaymentPlans).ReplaceOne(ctx, filter, plan, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ListTariffs returns all tariffs ordered by id.
+func (m *MongoDB) ListTariffs(ctx context.Context) ([]*entity.Tariff, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "tariff_id", Value: 1}})
+	return findMany[*entity.Tariff](m, ctx, collectionTariffs, bson.M{}, opts)
+}
+
+// GetTariff returns one tariff by tariff_id.
+func (m *MongoDB) GetTariff(ctx context.Context, id string) (*entity.Tariff, error) {
+	return findOne[entity.Tariff](m, ctx, collectionTariffs, bson.D{{Key: "tariff_id", Value: id}})
+}
+
+// InsertTariff stores a new tariff. It reports false, without error, when a
+// tariff with the same tariff_id exists; tariff_id is a unique index.
+func (m *MongoDB) InsertTariff(ctx context.Context, tariff *entity.Tariff) (bool, error) {
+	now := time.Now().UTC()
+	tariff.CreatedAt = now
+	tariff.UpdatedAt = now
+	_, err := m.col(collectionTariffs).InsertOne(ctx, tariff)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SaveTariff inserts or replaces a tariff by tariff_id, keeping the original
+// creation time.
+func (m *MongoDB) SaveTariff(ctx context.Context, tariff *entity.Tariff) (*entity.Tariff, error) {
+	collection := m.col(collectionTariffs)
+	now := time.Now().UTC()
+	tariff.UpdatedAt = now
+	existing, err := m.GetTariff(ctx, tariff.TariffId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && !existing.CreatedAt.IsZero() {
+		tariff.CreatedAt = existing.CreatedAt
+	} else {
+		tariff.CreatedAt = now
+	}
+	filter := bson.D{{Key: "tariff_id", Value: tariff.TariffId}}
+	if _, err = collection.ReplaceOne(ctx, filter, tariff, options.Replace().SetUpsert(true)); err != nil {
+		return nil, err
+	}
+	return tariff, nil
+}
+
+// DeleteTariff removes a tariff by tariff_id.
+func (m *MongoDB) DeleteTariff(ctx context.Context, id string) error {
+	return m.deleteOne(ctx, collectionTariffs, bson.D{{Key: "tariff_id", Value: id}}, "tariff")
+}
+
+// SaveRefund inserts a new refund ledger entry or replaces an existing one by id.
+func (m *MongoDB) SaveRefund(ctx context.Context, refund *entity.Refund) error {
+	collection := m.col(collectionRefunds)
+	if refund.Id == "" {
+		refund.Id = primitive.NewObjectID().Hex()
+		_, err := collection.InsertOne(ctx, refund)
+		return err
+	}
+	filter := bson.D{{Key: "_id", Value: refund.Id}}
+	_, err := collection.ReplaceOne(ctx, filter, refund)
+	return err
+}
+
+// GetRefunds returns the refund ledger of a transaction, oldest first.
+func (m *MongoDB) GetRefunds(ctx context.Context, transactionId int) ([]*entity.Refund, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return findMany[*entity.Refund](m, ctx, collectionRefunds, bson.D{{Key: "transaction_id", Value: transactionId}}, opts)
+}
+
+// GetRefundsByOrder returns the refund ledger of a payment order, oldest first.
+func (m *MongoDB) GetRefundsByOrder(ctx context.Context, order int) ([]*entity.Refund, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return findMany[*entity.Refund](m, ctx, collectionRefunds, bson.D{{Key: "order", Value: order}}, opts)
+}
+
+// InsertInvoice inserts a newly issued invoice. Issued invoices are never
+// modified. The unique indexes of the collection reject a duplicate number,
+// a second invoice of an order and a second credit note of a refund, which is
+// reported as false without error.
+func (m *MongoDB) InsertInvoice(ctx context.Context, invoice *entity.Invoice) (bool, error) {
+	invoice.Id = primitive.NewObjectID().Hex()
+	_, err := m.col(collectionInvoices).InsertOne(ctx, invoice)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *MongoDB) GetInvoice(ctx context.Context, id string) (*entity.Invoice, error) {
+	return findOne[entity.Invoice](m, ctx, collectionInvoices, bson.D{{Key: "_id", Value: id}})
+}
+
+// GetLastInvoice returns the highest numbered invoice of a series and year.
+func (m *MongoDB) GetLastInvoice(ctx context.Context, series string, year int) (*entity.Invoice, error) {
+	filter := bson.D{{Key: "series", Value: series}, {Key: "year", Value: year}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}})
+	var invoice entity.Invoice
+	if err := m.col(collectionInvoices).FindOne(ctx, filter, opts).Decode(&invoice); err != nil {
+		return nil, m.findError(err)
+	}
+	return &invoice, nil
+}
+
+// GetInvoiceByOrder returns the invoice issued for a payment order; credit
+// notes referring to the same order are not matched.
+func (m *MongoDB) GetInvoiceByOrder(ctx context.Context, order int) (*entity.Invoice, error) {
+	filter := bson.D{{Key: "order", Value: order}, {Key: "type", Value: entity.InvoiceTypeInvoice}}
+	return findOne[entity.Invoice](m, ctx, collectionInvoices, filter)
+}
+
+func (m *MongoDB) GetInvoiceByRefund(ctx context.Context, refundId string) (*entity.Invoice, error) {
+	return findOne[entity.Invoice](m, ctx, collectionInvoices, bson.D{{Key: "refund_id", Value: refundId}})
+}
+
+// GetUserInvoices returns the invoices of a user, newest first, without their
+// rendered documents.
+func (m *MongoDB) GetUserInvoices(ctx context.Context, userId string) ([]*entity.Invoice, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "issued_at", Value: -1}}).
+		SetProjection(bson.M{"html": 0})
+	return findMany[*entity.Invoice](m, ctx, collectionInvoices, bson.D{{Key: "user_id", Value: userId}}, opts)
+}
+
+// GetInvoices returns the invoices issued within [from, to) in numbering
+// order, without their rendered documents.
+func (m *MongoDB) GetInvoices(ctx context.Context, from, to time.Time) ([]*entity.Invoice, error) {
+	filter := bson.D{{Key: "issued_at", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "series", Value: 1}, {Key: "year", Value: 1}, {Key: "number", Value: 1}}).
+		SetProjection(bson.M{"html": 0})
+	return findMany[*entity.Invoice](m, ctx, collectionInvoices, filter, opts)
+}
+
+// GetPaymentOrders returns the payment orders opened within [from, to), oldest first.
+func (m *MongoDB) GetPaymentOrders(ctx context.Context, from, to time.Time) ([]*entity.PaymentOrder, error) {
+	filter := bson.D{{Key: "time_opened", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}}}
+	opts := options.Find().SetSort(bson.D{{Key: "time_opened", Value: 1}})
+	return findMany[*entity.PaymentOrder](m, ctx, collectionPaymentOrders, filter, opts)
+}
+
+// GetTransactionPaymentOrders returns every payment order of a transaction,
+// open or closed, oldest first.
+func (m *MongoDB) GetTransactionPaymentOrders(ctx context.Context, transactionId int) ([]*entity.PaymentOrder, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "time_opened", Value: 1}})
+	return findMany[*entity.PaymentOrder](m, ctx, collectionPaymentOrders, bson.D{{Key: "transaction_id", Value: transactionId}}, opts)
+}
+
+// GetPaymentResults returns the gateway notifications received within [from, to).
+// Notifications stored before received_at was recorded are not returned.
+func (m *MongoDB) GetPaymentResults(ctx context.Context, from, to time.Time) ([]*entity.PaymentParameters, error) {
+	filter := bson.D{{Key: "received_at", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}}}
+	opts := options.Find().SetSort(bson.D{{Key: "received_at", Value: 1}})
+	return findMany[*entity.PaymentParameters](m, ctx, collectionPayment, filter, opts)
+}
+
+// GetWalletBalance returns the prepaid balance of a user; zero when the user
+// has no wallet yet.
+func (m *MongoDB) GetWalletBalance(ctx context.Context, userId string) (int, error) {
+	var wallet struct {
+		Balance int `bson:"balance"`
+	}
+	err := m.col(collectionWallets).FindOne(ctx, bson.D{{Key: "user_id", Value: userId}}).Decode(&wallet)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return wallet.Balance, nil
+}
+
+// AddWalletEntry applies the entry's amount to the user's balance and records
+// it in the ledger with the resulting balance. The balance is changed with a
+// single conditional update, so concurrent debits cannot take it below zero;
+// such a debit fails with entity.ErrInsufficientBalance.
+func (m *MongoDB) AddWalletEntry(ctx context.Context, entry *entity.WalletEntry) error {
+	filter := bson.D{{Key: "user_id", Value: entry.UserId}}
+	if entry.Amount < 0 {
+		filter = append(filter, bson.E{Key: "balance", Value: bson.D{{Key: "$gte", Value: -entry.Amount}}})
+	}
+	update := bson.M{
+		"$inc": bson.M{"balance": entry.Amount},
+		"$set": bson.M{"username": entry.Username, "updated_at": entry.CreatedAt},
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetUpsert(entry.Amount >= 0)
+	var wallet struct {
+		Balance int `bson:"balance"`
+	}
+	err := m.col(collectionWallets).FindOneAndUpdate(ctx, filter, update, opts).Decode(&wallet)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return entity.ErrInsufficientBalance
+	}
+	if err != nil {
+		return err
+	}
+	entry.Balance = wallet.Balance
+	entry.Id = primitive.NewObjectID().Hex()
+	_, err = m.col(collectionWalletEntries).InsertOne(ctx, entry)
+	return err
+}
+
+// GetWalletEntries returns the latest ledger entries of a user, newest first.
+func (m *MongoDB) GetWalletEntries(ctx context.Context, userId string, limit int) ([]*entity.WalletEntry, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit))
+	return findMany[*entity.WalletEntry](m, ctx, collectionWalletEntries, bson.D{{Key: "user_id", Value: userId}}, opts)
+}
+
+// InsertIdempotencyRecord stores a new idempotency record. It reports false,
+// without error, when a record with the same key already exists; the key is
+// the document id, so concurrent inserts cannot both succeed.
+func (m *MongoDB) InsertIdempotencyRecord(ctx context.Context, record *entity.IdempotencyRecord) (bool, error) {
+	_, err := m.col(collectionIdempotencyKeys).InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *MongoDB) GetIdempotencyRecord(ctx context.Context, key string) (*entity.IdempotencyRecord, error) {
+	return findOne[entity.IdempotencyRecord](m, ctx, collectionIdempotencyKeys, bson.D{{Key: "_id", Value: key}})
+}
+
+func (m *MongoDB) SaveIdempotencyRecord(ctx context.Context, record *entity.IdempotencyRecord) error {
+	filter := bson.D{{Key: "_id", Value: record.Key}}
+	_, err := m.col(collectionIdempotencyKeys).ReplaceOne(ctx, filter, record, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ReplaceIdempotencyRecord replaces the record of a key only if it is still
+// the one created at the given time, reporting whether it was replaced.
+func (m *MongoDB) ReplaceIdempotencyRecord(ctx context.Context, record *entity.IdempotencyRecord, createdAt time.Time) (bool, error) {
+	filter := bson.D{{Key: "_id", Value: record.Key}, {Key: "created_at", Value: createdAt}}
+	result, err := m.col(collectionIdempotencyKeys).ReplaceOne(ctx, filter, record)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (m *MongoDB) DeleteIdempotencyRecord(ctx context.Context, key string) error {
+	_, err := m.col(collectionIdempotencyKeys).DeleteOne(ctx, bson.D{{Key: "_id", Value: key}})
+	return err
+}
+
+// ListUsageLimits returns all usage limits ordered by scope and subject.
+func (m *MongoDB) ListUsageLimits(ctx context.Context) ([]*entity.UsageLimit, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "scope", Value: 1}, {Key: "subject", Value: 1}})
+	return findMany[*entity.UsageLimit](m, ctx, collectionUsageLimits, bson.M{}, opts)
+}
+
+func (m *MongoDB) GetUsageLimit(ctx context.Context, scope, subject string) (*entity.UsageLimit, error) {
+	filter := bson.D{{Key: "scope", Value: scope}, {Key: "subject", Value: subject}}
+	return findOne[entity.UsageLimit](m, ctx, collectionUsageLimits, filter)
+}
+
+// SaveUsageLimit inserts or replaces the limit of a user or group.
+func (m *MongoDB) SaveUsageLimit(ctx context.Context, limit *entity.UsageLimit) error {
+	filter := bson.D{{Key: "scope", Value: limit.Scope}, {Key: "subject", Value: limit.Subject}}
+	_, err := m.col(collectionUsageLimits).ReplaceOne(ctx, filter, limit, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *MongoDB) DeleteUsageLimit(ctx context.Context, scope, subject string) error {
+	filter := bson.D{{Key: "scope", Value: scope}, {Key: "subject", Value: subject}}
+	return m.deleteOne(ctx, collectionUsageLimits, filter, "usage limit")
+}
+
+// AddUsage increments the running totals of a user or group in a period,
+// creating the counter on first use.
+func (m *MongoDB) AddUsage(ctx context.Context, scope, subject, period string, amount, energy int) error {
+	filter := bson.D{
+		{Key: "scope", Value: scope},
+		{Key: "subject", Value: subject},
+		{Key: "period", Value: period},
+	}
+	update := bson.M{"$inc": bson.M{"amount": amount, "energy": energy, "transactions": 1}}
+	_, err := m.col(collectionUsageCounters).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (m *MongoDB) GetUsage(ctx context.Context, scope, subject, period string) (*entity.UsageCounter, error) {
+	filter := bson.D{
+		{Key: "scope", Value: scope},
+		{Key: "subject", Value: subject},
+		{Key: "period", Value: period},
+	}
+	return findOne[entity.UsageCounter](m, ctx, collectionUsageCounters, filter)
+}
+
+// ListUserGroups returns all user groups ordered by name.
+func (m *MongoDB) ListUserGroups(ctx context.Context) ([]*entity.UserGroup, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	return findMany[*entity.UserGroup](m, ctx, collectionUserGroups, bson.M{}, opts)
+}
+
+func (m *MongoDB) GetUserGroup(ctx context.Context, name string) (*entity.UserGroup, error) {
+	return findOne[entity.UserGroup](m, ctx, collectionUserGroups, bson.D{{Key: "name", Value: name}})
+}
+
+func (m *MongoDB) SaveUserGroup(ctx context.Context, group *entity.UserGroup) error {
+	filter := bson.D{{Key: "name", Value: group.Name}}
+	_, err := m.col(collectionUserGroups).ReplaceOne(ctx, filter, group, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetGroupBillingTransactions returns the finished, unpaid sessions waiting
+// for a group's monthly order that started before the given time.
+func (m *MongoDB) GetGroupBillingTransactions(ctx context.Context, group string, before time.Time) ([]*entity.Transaction, error) {
+	filter := bson.D{
+		{Key: "group_billing", Value: group},
+		{Key: "is_finished", Value: true},
+		{Key: "time_start", Value: bson.D{{Key: "$lt", Value: before}}},
+		{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$payment_billed", "$payment_amount"}}}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "time_start", Value: 1}})
+	return findMany[*entity.Transaction](m, ctx, collectionTransactions, filter, opts)
+}
+
+func (m *MongoDB) SaveGroupStatement(ctx context.Context, statement *entity.GroupStatement) error {
+	filter := bson.D{{Key: "_id", Value: statement.Id}}
+	_, err := m.col(collectionGroupStatements).ReplaceOne(ctx, filter, statement, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *MongoDB) GetGroupStatement(ctx context.Context, id string) (*entity.GroupStatement, error) {
+	return findOne[entity.GroupStatement](m, ctx, collectionGroupStatements, bson.D{{Key: "_id", Value: id}})
+}
+
+func (m *MongoDB) GetGroupStatementByOrder(ctx context.Context, order int) (*entity.GroupStatement, error) {
+	return findOne[entity.GroupStatement](m, ctx, collectionGroupStatements, bson.D{{Key: "order", Value: order}})
+}
+
+// GetGroupStatements returns the statements of a group, newest first.
+func (m *MongoDB) GetGroupStatements(ctx context.Context, group string) ([]*entity.GroupStatement, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "period", Value: -1}})
+	return findMany[*entity.GroupStatement](m, ctx, collectionGroupStatements, bson.D{{Key: "group", Value: group}}, opts)
+}
+
+// ListPromotions returns all promotions, newest first.
+func (m *MongoDB) ListPromotions(ctx context.Context) ([]*entity.Promotion, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	return findMany[*entity.Promotion](m, ctx, collectionPromotions, bson.M{}, opts)
+}
+
+func (m *MongoDB) GetPromotion(ctx context.Context, code string) (*entity.Promotion, error) {
+	return findOne[entity.Promotion](m, ctx, collectionPromotions, bson.D{{Key: "code", Value: code}})
+}
+
+func (m *MongoDB) SavePromotion(ctx context.Context, promotion *entity.Promotion) error {
+	filter := bson.D{{Key: "code", Value: promotion.Code}}
+	_, err := m.col(collectionPromotions).ReplaceOne(ctx, filter, promotion, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ClaimPromotion counts one more redemption of a promotion unless it has
+// reached its maximum, reporting whether the redemption was counted.
+func (m *MongoDB) ClaimPromotion(ctx context.Context, code string) (bool, error) {
+	filter := bson.D{
+		{Key: "code", Value: code},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "max_redemptions", Value: 0}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$redemptions", "$max_redemptions"}}}}},
+		}},
+	}
+	update := bson.M{"$inc": bson.M{"redemptions": 1}}
+	result, err := m.col(collectionPromotions).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ReleasePromotion gives back a redemption counted by ClaimPromotion.
+func (m *MongoDB) ReleasePromotion(ctx context.Context, code string) error {
+	filter := bson.D{
+		{Key: "code", Value: code},
+		{Key: "redemptions", Value: bson.D{{Key: "$gt", Value: 0}}},
+	}
+	_, err := m.col(collectionPromotions).UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"redemptions": -1}})
+	return err
+}
+
+// InsertPromotionRedemption stores a new redemption. It reports false, without
+// error, when the user has already redeemed the code; (code, user_id) is a
+// unique index, so concurrent inserts cannot both succeed.
+func (m *MongoDB) InsertPromotionRedemption(ctx context.Context, redemption *entity.PromotionRedemption) (bool, error) {
+	_, err := m.col(collectionRedemptions).InsertOne(ctx, redemption)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *MongoDB) DeletePromotionRedemption(ctx context.Context, code, userId string) error {
+	filter := bson.D{
+		{Key: "code", Value: code},
+		{Key: "user_id", Value: userId},
+	}
+	_, err := m.col(collectionRedemptions).DeleteOne(ctx, filter)
+	return err
+}
+
+func (m *MongoDB) GetPromotionRedemption(ctx context.Context, code, userId string) (*entity.PromotionRedemption, error) {
+	filter := bson.D{
+		{Key: "code", Value: code},
+		{Key: "user_id", Value: userId},
+	}
+	return findOne[entity.PromotionRedemption](m, ctx, collectionRedemptions, filter)
+}
+
+// GetPromotionRedemptions returns the redemptions of a user, oldest first.
+func (m *MongoDB) GetPromotionRedemptions(ctx context.Context, userId string) ([]*entity.PromotionRedemption, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "redeemed_at", Value: 1}})
+	return findMany[*entity.PromotionRedemption](m, ctx, collectionRedemptions, bson.D{{Key: "user_id", Value: userId}}, opts)
+}
+
+// UsePromotionRedemption takes one session off a redemption that has any
+// left and adds the discount given, reporting whether a session was left.
+func (m *MongoDB) UsePromotionRedemption(ctx context.Context, code, userId string, discount int) (bool, error) {
+	filter := bson.D{
+		{Key: "code", Value: code},
+		{Key: "user_id", Value: userId},
+		{Key: "remaining", Value: bson.D{{Key: "$gt", Value: 0}}},
+	}
+	update := bson.M{
+		"$inc": bson.M{"remaining": -1, "discounted": discount},
+		"$set": bson.M{"used_at": time.Now()},
+	}
+	result, err := m.col(collectionRedemptions).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// GetPaymentMethodsToNotice returns the payment methods that failed their last
+// payment or expire by the given month (YYMM).
+func (m *MongoDB) GetPaymentMethodsToNotice(ctx context.Context, expiresBy string) ([]*entity.PaymentMethod, error) {
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "fail_count", Value: bson.D{{Key: "$gt", Value: 0}}}},
+		bson.D{{Key: "expiry_date", Value: bson.D{{Key: "$ne", Value: ""}, {Key: "$lte", Value: expiresBy}}}},
+	}}}
+	return findMany[*entity.PaymentMethod](m, ctx, collectionPaymentMethods, filter)
+}
+
+func (m *MongoDB) GetCardNotice(ctx context.Context, identifier, kind string) (*entity.CardNotice, error) {
+	return findOne[entity.CardNotice](m, ctx, collectionCardNotices, bson.D{{Key: "_id", Value: identifier + "/" + kind}})
+}
+
+func (m *MongoDB) SaveCardNotice(ctx context.Context, notice *entity.CardNotice) error {
+	notice.Id = notice.Identifier + "/" + notice.Kind
+	filter := bson.D{{Key: "_id", Value: notice.Id}}
+	_, err := m.col(collectionCardNotices).ReplaceOne(ctx, filter, notice, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *MongoDB) AddSession(ctx context.Context, session *entity.Session) error {
+	_, err := m.col(collectionSessions).InsertOne(ctx, session)
+	return err
+}
+
+func (m *MongoDB) GetSessionByToken(ctx context.Context, tokenHash string) (*entity.Session, error) {
+	return findOne[entity.Session](m, ctx, collectionSessions, bson.D{{Key: "token_hash", Value: tokenHash}})
+}
+
+func (m *MongoDB) GetSessionByRefresh(ctx context.Context, refreshHash string) (*entity.Session, error) {
+	return findOne[entity.Session](m, ctx, collectionSessions, bson.D{{Key: "refresh_hash", Value: refreshHash}})
+}
+
+// GetUserSessions returns the sessions of a user, most recently used first.
+func (m *MongoDB) GetUserSessions(ctx context.Context, userId string) ([]*entity.Session, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen", Value: -1}})
+	return findMany[*entity.Session](m, ctx, collectionSessions, bson.D{{Key: "user_id", Value: userId}}, opts)
+}
+
+func (m *MongoDB) UpdateSession(ctx context.Context, session *entity.Session) error {
+	filter := bson.D{{Key: "_id", Value: session.Id}}
+	update := bson.M{"$set": bson.D{
+		{Key: "token_hash", Value: session.TokenHash},
+		{Key: "refresh_hash", Value: session.RefreshHash},
+		{Key: "last_seen", Value: session.LastSeen},
+		{Key: "expires_at", Value: session.ExpiresAt},
+		{Key: "refresh_expires_at", Value: session.RefreshExpiresAt},
+	}}
+	return m.updateOne(ctx, collectionSessions, filter, update, "session")
+}
+
+func (m *MongoDB) DeleteSession(ctx context.Context, userId, id string) error {
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "user_id", Value: userId}}
+	return m.deleteOne(ctx, collectionSessions, filter, "session")
+}
+
+func (m *MongoDB) DeleteUserSessions(ctx context.Context, userId string) (int, error) {
+	result, err := m.col(collectionSessions).DeleteMany(ctx, bson.D{{Key: "user_id", Value: userId}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// DeleteExpiredSessions removes the sessions whose refresh token expired.
+func (m *MongoDB) DeleteExpiredSessions(ctx context.Context, before time.Time) error {
+	filter := bson.D{{Key: "refresh_expires_at", Value: bson.D{{Key: "$lt", Value: before}}}}
+	_, err := m.col(collectionSessions).DeleteMany(ctx, filter)
+	return err
+}
+
+func (m *MongoDB) GetRole(ctx context.Context, name string) (*entity.Role, error) {
+	return findOne[entity.Role](m, ctx, collectionRoles, bson.D{{Key: "_id", Value: name}})
+}
+
+func (m *MongoDB) GetRoles(ctx context.Context) ([]*entity.Role, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	return findMany[*entity.Role](m, ctx, collectionRoles, bson.M{}, opts)
+}
+
+func (m *MongoDB) SaveRole(ctx context.Context, role *entity.Role) error {
+	filter := bson.D{{Key: "_id", Value: role.Name}}
+	_, err := m.col(collectionRoles).ReplaceOne(ctx, filter, role, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *MongoDB) DeleteRole(ctx context.Context, name string) error {
+	return m.deleteOne(ctx, collectionRoles, bson.D{{Key: "_id", Value: name}}, "role")
+}
+
+func (m *MongoDB) AddApiKey(ctx context.Context, key *entity.ApiKey) error {
+	_, err := m.col(collectionApiKeys).InsertOne(ctx, key)
+	return err
+}
+
+func (m *MongoDB) GetApiKey(ctx context.Context, id string) (*entity.ApiKey, error) {
+	return findOne[entity.ApiKey](m, ctx, collectionApiKeys, bson.D{{Key: "_id", Value: id}})
+}
+
+func (m *MongoDB) GetApiKeyByHash(ctx context.Context, keyHash string) (*entity.ApiKey, error) {
+	return findOne[entity.ApiKey](m, ctx, collectionApiKeys, bson.D{{Key: "key_hash", Value: keyHash}})
+}
+
+// GetApiKeys returns all API keys, newest first.
+func (m *MongoDB) GetApiKeys(ctx context.Context) ([]*entity.ApiKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	return findMany[*entity.ApiKey](m, ctx, collectionApiKeys, bson.M{}, opts)
+}
+
+// UpdateApiKey writes the secret, rotation and revocation of a key; the
+// last-used time is written by SetApiKeyUsed.
+func (m *MongoDB) UpdateApiKey(ctx context.Context, key *entity.ApiKey) error {
+	filter := bson.D{{Key: "_id", Value: key.Id}}
+	update := bson.M{"$set": bson.D{
+		{Key: "key_hash", Value: key.KeyHash},
+		{Key: "prefix", Value: key.Prefix},
+		{Key: "rotated_at", Value: key.RotatedAt},
+		{Key: "revoked_at", Value: key.RevokedAt},
+	}}
+	return m.updateOne(ctx, collectionApiKeys, filter, update, "api key")
+}
+
+func (m *MongoDB) SetApiKeyUsed(ctx context.Context, id string, at time.Time) error {
+	filter := bson.D{{Key: "_id", Value: id}}
+	update := bson.M{"$set": bson.D{{Key: "last_used", Value: at}}}
+	return m.updateOne(ctx, collectionApiKeys, filter, update, "api key")
+}
+
+func (m *MongoDB) AddAccountToken(ctx context.Context, token *entity.AccountToken) error {
+	_, err := m.col(collectionAccountTokens).InsertOne(ctx, token)
+	return err
+}
+
+func (m *MongoDB) GetAccountToken(ctx context.Context, hash string) (*entity.AccountToken, error) {
+	return findOne[entity.AccountToken](m, ctx, collectionAccountTokens, bson.D{{Key: "_id", Value: hash}})
+}
+
+func (m *MongoDB) DeleteAccountToken(ctx context.Context, hash string) error {
+	return m.deleteOne(ctx, collectionAccountTokens, bson.D{{Key: "_id", Value: hash}}, "token")
+}
+
+// DeleteAccountTokens removes the tokens of a purpose issued to a user,
+// along with any expired token.
+func (m *MongoDB) DeleteAccountTokens(ctx context.Context, userId, purpose string) error {
+	filter := bson.M{"$or": bson.A{
+		bson.D{{Key: "user_id", Value: userId}, {Key: "purpose", Value: purpose}},
+		bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lt", Value: time.Now()}}}},
+	}}
+	_, err := m.col(collectionAccountTokens).DeleteMany(ctx, filter)
+	return err
+}
+
+// UpdateUserFiscal sets the fiscal details of a user.
+func (m *MongoDB) UpdateUserFiscal(ctx context.Context, username string, fiscal *entity.FiscalDetails) error {
+	filter := bson.D{{Key: "username", Value: username}}
+	update := bson.M{"$set": bson.D{{Key: "fiscal", Value: fiscal}}}
+	return m.updateOne(ctx, collectionUsers, filter, update, "user")
+}
+
 // ListWebhookSubscribers returns all webhook subscribers ordered by creation time.
 func (m *MongoDB) ListWebhookSubscribers(ctx context.Context) ([]*entity.WebhookSubscriber, error) {
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
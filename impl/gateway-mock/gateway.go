@@ -0,0 +1,324 @@
+package gateway_mock
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"evsys-back/entity"
+	"evsys-back/impl/core"
+	"evsys-back/internal/lib/sl"
+)
+
+// Outcomes the fake gateway can answer an operation with.
+const (
+	OutcomeApprove = "approve"
+	OutcomeDecline = "decline"
+	OutcomeTimeout = "timeout"
+)
+
+// Operations an outcome can be configured for.
+const (
+	OpEnroll       = "enroll"
+	OpPay          = "pay"
+	OpPreauthorize = "preauthorize"
+	OpCapture      = "capture"
+	OpCancel       = "cancel"
+	OpRefund       = "refund"
+)
+
+const signatureVersion = "HMAC_SHA256_V1"
+
+// Config holds the fake gateway behaviour
+type Config struct {
+	// Outcome applies to every operation without an entry in Outcomes.
+	Outcome  string
+	Outcomes map[string]string // key: operation
+	// DeclineCode is the response code of declined payments, preauthorizations
+	// and enrollments.
+	DeclineCode string
+	// Timeout is how long an operation configured to time out blocks before
+	// failing, unless its context ends first.
+	Timeout time.Duration
+	// NotifyUrl is the public URL of our /payment/notify endpoint. The entry
+	// form posts the enrollment result straight to it.
+	NotifyUrl string
+	Currency  string
+	// SecretKey signs notifications; a random key is used when empty.
+	SecretKey string
+}
+
+// Gateway is an offline payment gateway for local environments. It answers
+// every operation according to its configuration, using the same response
+// codes as the real acquirer, and never moves money.
+type Gateway struct {
+	config   Config
+	secret   []byte
+	outcomes map[string]string
+	mux      sync.RWMutex
+	log      *slog.Logger
+}
+
+// New creates a fake gateway
+func New(config Config, log *slog.Logger) *Gateway {
+	if config.Outcome == "" {
+		config.Outcome = OutcomeApprove
+	}
+	if config.DeclineCode == "" {
+		config.DeclineCode = "0190"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	secret := []byte(config.SecretKey)
+	if len(secret) == 0 {
+		secret = []byte(randomHex(32))
+	}
+	outcomes := make(map[string]string, len(config.Outcomes))
+	for op, outcome := range config.Outcomes {
+		outcomes[op] = outcome
+	}
+	return &Gateway{
+		config:   config,
+		secret:   secret,
+		outcomes: outcomes,
+		log:      log.With(sl.Module("gateway.mock")),
+	}
+}
+
+// SetOutcome changes the outcome of an operation at runtime.
+func (g *Gateway) SetOutcome(operation, outcome string) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.outcomes[operation] = outcome
+}
+
+func (g *Gateway) outcome(operation string) string {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	if outcome, ok := g.outcomes[operation]; ok {
+		return outcome
+	}
+	return g.config.Outcome
+}
+
+// Capture confirms a preauthorized amount
+func (g *Gateway) Capture(ctx context.Context, req core.CaptureRequest) (*core.CaptureResponse, error) {
+	return g.respond(ctx, OpCapture, entity.RedsysTxCapture, req.OrderNumber, req.Amount, "0900")
+}
+
+// Cancel releases a preauthorization
+func (g *Gateway) Cancel(ctx context.Context, req core.CaptureRequest) (*core.CaptureResponse, error) {
+	return g.respond(ctx, OpCancel, entity.RedsysTxCancel, req.OrderNumber, req.Amount, "0400")
+}
+
+// Preauthorize holds an amount on a stored card
+func (g *Gateway) Preauthorize(ctx context.Context, req core.PreauthorizeRequest) (*core.CaptureResponse, error) {
+	resp, err := g.respond(ctx, OpPreauthorize, entity.RedsysTxPreauthorize, req.OrderNumber, req.Amount, "0000")
+	if resp != nil && resp.Success {
+		resp.CardToken = req.CardToken
+		resp.NetworkTxId = req.NetworkTxId
+	}
+	return resp, err
+}
+
+// Pay charges a stored card
+func (g *Gateway) Pay(ctx context.Context, req core.PayRequest) (*core.CaptureResponse, error) {
+	resp, err := g.respond(ctx, OpPay, entity.RedsysTxPay, req.OrderNumber, req.Amount, "0000")
+	if resp != nil && resp.Success {
+		resp.CardToken = req.CardToken
+		resp.NetworkTxId = req.NetworkTxId
+	}
+	return resp, err
+}
+
+// Refund returns a captured amount
+func (g *Gateway) Refund(ctx context.Context, req core.RefundRequest) (*core.CaptureResponse, error) {
+	return g.respond(ctx, OpRefund, entity.RedsysTxRefund, req.OrderNumber, req.Amount, "0900")
+}
+
+// BuildEntryForm returns a form that, once posted by the browser, delivers a
+// signed enrollment result to the notify endpoint, as if the user had entered
+// a card on a hosted page. An approved enrollment carries a fresh card token;
+// a timed out one points the form to UrlKo and never notifies, leaving the
+// order open.
+func (g *Gateway) BuildEntryForm(req core.EntryFormRequest) (*core.EntryFormResponse, error) {
+	if g.config.NotifyUrl == "" {
+		return nil, fmt.Errorf("fake gateway notify url not configured")
+	}
+	if req.OrderNumber == "" {
+		return nil, fmt.Errorf("order number is required")
+	}
+	if req.Amount < 0 {
+		return nil, fmt.Errorf("amount must not be negative")
+	}
+	if req.UrlOk == "" || req.UrlKo == "" {
+		return nil, fmt.Errorf("both UrlOk and UrlKo are required")
+	}
+
+	outcome := g.outcome(OpEnroll)
+	g.log.With(
+		slog.String("order", req.OrderNumber),
+		slog.Int("amount", req.Amount),
+		slog.String("outcome", outcome),
+	).Info("entry form built")
+
+	if outcome == OutcomeTimeout {
+		return &core.EntryFormResponse{FormUrl: req.UrlKo, SignatureVersion: signatureVersion}, nil
+	}
+
+	now := time.Now()
+	result := &entity.PaymentParameters{
+		Order:           req.OrderNumber,
+		Amount:          strconv.Itoa(req.Amount),
+		Currency:        g.config.Currency,
+		Date:            now.Format("02/01/2006"),
+		Hour:            now.Format("15:04"),
+		TransactionType: entity.RedsysTxPay,
+		Response:        g.config.DeclineCode,
+	}
+	if outcome == OutcomeApprove {
+		result.Response = "0000"
+		result.AuthorisationCode = authorizationCode()
+		result.MerchantIdentifier = randomHex(20)
+		result.MerchantCofTxnid = randomHex(8)
+		result.CardBrand = "1"
+		result.CardCountry = "724"
+		result.ExpiryDate = now.AddDate(3, 0, 0).Format("0601")
+	}
+
+	encoded, signature, err := g.sign(result)
+	if err != nil {
+		return nil, err
+	}
+	return &core.EntryFormResponse{
+		FormUrl:            g.config.NotifyUrl,
+		SignatureVersion:   signatureVersion,
+		MerchantParameters: encoded,
+		Signature:          signature,
+	}, nil
+}
+
+// ParseNotification decodes a notification signed by this gateway
+func (g *Gateway) ParseNotification(data []byte) (*entity.PaymentParameters, error) {
+	params, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse query: %w", err)
+	}
+	encoded := params.Get("Ds_MerchantParameters")
+	if encoded == "" {
+		return nil, fmt.Errorf("empty merchant parameters")
+	}
+	received, err := base64.StdEncoding.DecodeString(params.Get("Ds_Signature"))
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+	if !hmac.Equal(received, g.mac(encoded)) {
+		return nil, fmt.Errorf("signature mismatch")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode parameters: %w", err)
+	}
+	var result entity.PaymentParameters
+	if err = json.Unmarshal(decoded, &result); err != nil {
+		return nil, fmt.Errorf("parse parameters: %w", err)
+	}
+	return &result, nil
+}
+
+// Notification returns a signed notification body for the given result, in
+// the form the notify endpoint receives it.
+func (g *Gateway) Notification(result *entity.PaymentParameters) ([]byte, error) {
+	encoded, signature, err := g.sign(result)
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	values.Set("Ds_SignatureVersion", signatureVersion)
+	values.Set("Ds_MerchantParameters", encoded)
+	values.Set("Ds_Signature", signature)
+	return []byte(values.Encode()), nil
+}
+
+// respond answers an operation according to its configured outcome.
+// approvedCode is the response code of an approved operation of that type.
+func (g *Gateway) respond(ctx context.Context, operation, txType, orderNumber string, amount int, approvedCode string) (*core.CaptureResponse, error) {
+	outcome := g.outcome(operation)
+	log := g.log.With(
+		slog.String("operation", operation),
+		slog.String("order", orderNumber),
+		slog.Int("amount", amount),
+		slog.String("outcome", outcome),
+	)
+
+	if outcome == OutcomeTimeout {
+		log.Warn("simulating gateway timeout")
+		timer := time.NewTimer(g.config.Timeout)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to send request: %w", ctx.Err())
+		case <-timer.C:
+			return nil, fmt.Errorf("failed to send request: gateway timeout")
+		}
+	}
+
+	now := time.Now()
+	resp := &core.CaptureResponse{
+		Order:           orderNumber,
+		Amount:          strconv.Itoa(amount),
+		Currency:        g.config.Currency,
+		TransactionType: txType,
+		Date:            now.Format("02/01/2006"),
+		Hour:            now.Format("15:04"),
+	}
+	if outcome == OutcomeApprove {
+		resp.Success = true
+		resp.ResponseCode = approvedCode
+		resp.AuthorizationCode = authorizationCode()
+	} else {
+		resp.ResponseCode = g.config.DeclineCode
+		resp.ErrorMessage = fmt.Sprintf("declined by fake gateway: %s", g.config.DeclineCode)
+	}
+	log.With(slog.String("response_code", resp.ResponseCode)).Info("fake gateway transaction completed")
+	return resp, nil
+}
+
+func (g *Gateway) sign(result *entity.PaymentParameters) (string, string, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", "", fmt.Errorf("marshal parameters: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return encoded, base64.StdEncoding.EncodeToString(g.mac(encoded)), nil
+}
+
+func (g *Gateway) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, g.secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
+
+func authorizationCode() string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%06d", (int(b[0])<<16|int(b[1])<<8|int(b[2]))%1000000)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,186 @@
+package gateway_mock
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/core"
+	database_mock "evsys-back/impl/database-mock"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func newTestGateway(outcome string) *Gateway {
+	return New(Config{
+		Outcome:   outcome,
+		Timeout:   20 * time.Millisecond,
+		NotifyUrl: "http://localhost:5500/api/v1/payment/notify",
+		Currency:  "978",
+	}, newTestLogger())
+}
+
+// formBody is what the browser posts when it submits the entry form.
+func formBody(form *core.EntryFormResponse) []byte {
+	return []byte(url.Values{
+		"Ds_SignatureVersion":   {form.SignatureVersion},
+		"Ds_MerchantParameters": {form.MerchantParameters},
+		"Ds_Signature":          {form.Signature},
+	}.Encode())
+}
+
+func TestGateway_Outcomes(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		outcome string
+		call    func(g *Gateway) (*core.CaptureResponse, error)
+		code    string
+	}{
+		{"pay approved", OutcomeApprove, func(g *Gateway) (*core.CaptureResponse, error) {
+			return g.Pay(ctx, core.PayRequest{OrderNumber: "1200", Amount: 500, CardToken: "tok"})
+		}, "0000"},
+		{"pay declined", OutcomeDecline, func(g *Gateway) (*core.CaptureResponse, error) {
+			return g.Pay(ctx, core.PayRequest{OrderNumber: "1200", Amount: 500, CardToken: "tok"})
+		}, "0190"},
+		{"capture approved", OutcomeApprove, func(g *Gateway) (*core.CaptureResponse, error) {
+			return g.Capture(ctx, core.CaptureRequest{OrderNumber: "3000", Amount: 500})
+		}, "0900"},
+		{"cancel approved", OutcomeApprove, func(g *Gateway) (*core.CaptureResponse, error) {
+			return g.Cancel(ctx, core.CaptureRequest{OrderNumber: "3000", Amount: 500})
+		}, "0400"},
+		{"refund approved", OutcomeApprove, func(g *Gateway) (*core.CaptureResponse, error) {
+			return g.Refund(ctx, core.RefundRequest{OrderNumber: "1200", Amount: 500})
+		}, "0900"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.call(newTestGateway(tt.outcome))
+			require.NoError(t, err)
+			assert.Equal(t, tt.code, resp.ResponseCode)
+			assert.Equal(t, entity.IsRedsysApproved(resp.TransactionType, resp.ResponseCode), resp.Success)
+			assert.Equal(t, tt.outcome == OutcomeApprove, resp.Success)
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		g := newTestGateway(OutcomeApprove)
+		g.SetOutcome(OpRefund, OutcomeTimeout)
+		_, err := g.Refund(ctx, core.RefundRequest{OrderNumber: "1200", Amount: 500})
+		assert.ErrorContains(t, err, "gateway timeout")
+
+		resp, err := g.Pay(ctx, core.PayRequest{OrderNumber: "1200", Amount: 500})
+		require.NoError(t, err)
+		assert.True(t, resp.Success, "other operations keep the default outcome")
+	})
+}
+
+func TestGateway_ParseNotification(t *testing.T) {
+	g := newTestGateway(OutcomeApprove)
+	form, err := g.BuildEntryForm(core.EntryFormRequest{OrderNumber: "000000001200", Amount: 100, UrlOk: "ok", UrlKo: "ko"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:5500/api/v1/payment/notify", form.FormUrl)
+
+	result, err := g.ParseNotification(formBody(form))
+	require.NoError(t, err)
+	assert.True(t, result.IsApproved())
+	assert.Equal(t, "000000001200", result.Order)
+	assert.NotEmpty(t, result.MerchantIdentifier)
+
+	other := newTestGateway(OutcomeApprove)
+	_, err = other.ParseNotification(formBody(form))
+	assert.ErrorContains(t, err, "signature mismatch", "notifications signed by another gateway are rejected")
+
+	g.SetOutcome(OpEnroll, OutcomeTimeout)
+	form, err = g.BuildEntryForm(core.EntryFormRequest{OrderNumber: "000000001201", UrlOk: "ok", UrlKo: "ko"})
+	require.NoError(t, err)
+	assert.Equal(t, "ko", form.FormUrl)
+	assert.Empty(t, form.MerchantParameters)
+}
+
+// TestGateway_EndToEnd runs the local payment flow against the mock database:
+// card enrollment, payment, decline, preauthorization and capture.
+func TestGateway_EndToEnd(t *testing.T) {
+	ctx := context.Background()
+	db := database_mock.NewMockDB()
+	c := core.New(newTestLogger(), db)
+	g := newTestGateway(OutcomeApprove)
+	c.SetPaymentGateway(g)
+
+	user := &entity.User{Username: "driver", UserId: "u1"}
+	db.SeedUser(user)
+	require.NoError(t, db.AddUserTag(ctx, &entity.UserTag{UserId: "u1", Username: "driver", IdTag: "TAG1"}))
+
+	// enrollment: the hosted form notifies the card, the verification charge is refunded
+	order, form, err := c.CreateWebOrder(ctx, user, &entity.PaymentOrder{Amount: 100, Description: "card"},
+		&core.WebOrderRequest{UrlOk: "ok", UrlKo: "ko"})
+	require.NoError(t, err)
+	require.NoError(t, c.Notify(ctx, formBody(form)))
+
+	var card *entity.PaymentMethod
+	require.Eventually(t, func() bool {
+		methods, _ := db.GetPaymentMethods(ctx, "u1")
+		if len(methods) == 0 {
+			return false
+		}
+		card = methods[0]
+		return true
+	}, time.Second, 5*time.Millisecond)
+	assert.NotEmpty(t, card.CofTid)
+	require.Eventually(t, func() bool {
+		enrolled, _ := db.GetPaymentOrder(ctx, order.Order)
+		return enrolled != nil && enrolled.RefundAmount == 100
+	}, time.Second, 5*time.Millisecond)
+
+	// payment of a finished session
+	db.SeedTransaction(&entity.Transaction{TransactionId: 1, IdTag: "TAG1", IsFinished: true, PaymentAmount: 500})
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	require.Eventually(t, func() bool {
+		tx, _ := db.GetTransaction(ctx, 1)
+		return tx.PaymentBilled == 500
+	}, time.Second, 5*time.Millisecond)
+
+	// declined payment
+	g.SetOutcome(OpPay, OutcomeDecline)
+	db.SeedTransaction(&entity.Transaction{TransactionId: 2, IdTag: "TAG1", IsFinished: true, PaymentAmount: 300})
+	require.NoError(t, c.PayTransaction(ctx, 2))
+	require.Eventually(t, func() bool {
+		tx, _ := db.GetTransaction(ctx, 2)
+		return tx.PaymentError != ""
+	}, time.Second, 5*time.Millisecond)
+	retry, _ := db.GetPaymentRetry(ctx, 2)
+	require.NotNil(t, retry, "a declined payment is queued for retry")
+
+	// preauthorization and capture
+	preauth, err := c.CreatePreauthorizationOrder(ctx, user, &entity.PreauthorizationOrderRequest{
+		Amount: 2000, PaymentMethodId: card.Identifier,
+	})
+	require.NoError(t, err)
+	require.Empty(t, preauth.Error)
+	assert.NotEmpty(t, preauth.AuthorizationCode)
+
+	captured, err := c.CapturePreauthorization(ctx, user, &entity.CaptureOrderRequest{
+		Amount: 1500, OriginalOrder: strconv.Itoa(preauth.Order),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, entity.PreauthorizationStatusCaptured, captured.Status)
+
+	// a gateway that never answers fails the preauthorization
+	g.SetOutcome(OpPreauthorize, OutcomeTimeout)
+	preauth, err = c.CreatePreauthorizationOrder(ctx, user, &entity.PreauthorizationOrderRequest{
+		Amount: 2000, PaymentMethodId: card.Identifier,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, preauth.Error)
+}
@@ -0,0 +1,68 @@
+package mail
+
+import (
+	"context"
+	"evsys-back/entity"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// SendPasswordReset emails a user the link to set a new password.
+func (s *Service) SendPasswordReset(ctx context.Context, to string, m entity.AccountMail) error {
+	if m.Link == "" {
+		return fmt.Errorf("no reset link")
+	}
+	return s.sender.Send(ctx, to, "Reset your password", renderAccountMail(m,
+		"Reset your password",
+		"We received a request to reset the password of your account. "+
+			"If it was you, set a new password with the link below; otherwise you can ignore this email.",
+		"Set a new password"))
+}
+
+// SendEmailVerification emails a registered user the link to confirm the
+// email address.
+func (s *Service) SendEmailVerification(ctx context.Context, to string, m entity.AccountMail) error {
+	if m.Link == "" {
+		return fmt.Errorf("no verification link")
+	}
+	return s.sender.Send(ctx, to, "Confirm your email address", renderAccountMail(m,
+		"Confirm your email address",
+		"Thank you for registering. Confirm this is your email address to start charging.",
+		"Confirm email address"))
+}
+
+// linkValidity describes how long a link stays valid, from now until it expires.
+func linkValidity(now, expires time.Time) string {
+	d := expires.Sub(now).Round(time.Minute)
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	case d > time.Minute:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	}
+	return "a minute"
+}
+
+func renderAccountMail(m entity.AccountMail, title, text, action string) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html><html><body style="font-family:Arial,sans-serif;color:#222;">`)
+	fmt.Fprintf(&b, `<h2 style="margin-bottom:4px;">%s</h2>`, html.EscapeString(title))
+	if m.Username != "" {
+		fmt.Fprintf(&b, `<p style="color:#666;margin-top:0;">Hello %s,</p>`, html.EscapeString(m.Username))
+	}
+	fmt.Fprintf(&b, `<p>%s</p>`, html.EscapeString(text))
+	fmt.Fprintf(&b,
+		`<p style="margin-top:20px;"><a href="%s" style="display:inline-block;background-color:#0b5cad;color:#ffffff;`+
+			`padding:10px 18px;border-radius:6px;text-decoration:none;font-weight:bold;">%s</a></p>`,
+		html.EscapeString(m.Link), html.EscapeString(action))
+	if !m.ExpiresAt.IsZero() {
+		fmt.Fprintf(&b, `<p style="color:#666;">The link can be used once and is valid for %s.</p>`,
+			linkValidity(time.Now(), m.ExpiresAt))
+	}
+	b.WriteString(`</body></html>`)
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"evsys-back/entity"
+)
+
+func TestLinkValidity(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{48 * time.Hour, "2 days"},
+		{time.Hour, "60 minutes"},
+		{3 * time.Hour, "3 hours"},
+		{30 * time.Second, "a minute"},
+	}
+	for _, tt := range tests {
+		if got := linkValidity(now, now.Add(tt.in)); got != tt.want {
+			t.Errorf("linkValidity(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderAccountMail(t *testing.T) {
+	body := renderAccountMail(entity.AccountMail{
+		Username:  "driver",
+		Link:      "https://app.example.com/reset?token=abc&x=1",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}, "Reset your password", "Set a new password <now>.", "Set a new password")
+	for _, want := range []string{
+		"Hello driver",
+		"Set a new password &lt;now&gt;.",
+		`href="https://app.example.com/reset?token=abc&amp;x=1"`,
+		"valid for 60 minutes",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q", want)
+		}
+	}
+}
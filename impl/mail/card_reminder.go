@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"context"
+	"evsys-back/entity"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// SendCardReminder emails a user about saved cards that expire soon or were
+// declined, with a link to add a new card.
+func (s *Service) SendCardReminder(ctx context.Context, to string, r entity.CardReminder) error {
+	if len(r.Cards) == 0 {
+		return fmt.Errorf("no cards to remind of")
+	}
+	return s.sender.Send(ctx, to, buildCardReminderSubject(r), renderCardReminder(r))
+}
+
+func buildCardReminderSubject(r entity.CardReminder) string {
+	for _, card := range r.Cards {
+		if card.Kind == entity.CardNoticeFailed {
+			return "Your card was declined — add a new card to keep charging"
+		}
+	}
+	return "Your card expires soon — add a new card to keep charging"
+}
+
+func cardIssueText(card entity.CardIssue) string {
+	if card.Kind == entity.CardNoticeFailed {
+		return "declined on the last payment"
+	}
+	return "expires " + entity.FormatCardExpiry(card.ExpiryDate)
+}
+
+func renderCardReminder(r entity.CardReminder) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html><html><body style="font-family:Arial,sans-serif;color:#222;">`)
+	b.WriteString(`<h2 style="margin-bottom:4px;">Your payment card needs attention</h2>`)
+	if r.Username != "" {
+		fmt.Fprintf(&b, `<p style="color:#666;margin-top:0;">Hello %s,</p>`, html.EscapeString(r.Username))
+	}
+	b.WriteString(`<p>Charging sessions are paid with your saved card. ` +
+		`To avoid being stopped at the charger, please add a new card:</p>`)
+
+	b.WriteString(`<table cellpadding="6" cellspacing="0" border="0" style="border-collapse:collapse;min-width:380px;">`)
+	for _, card := range r.Cards {
+		fmt.Fprintf(&b,
+			`<tr><td style="border-bottom:1px solid #eee;"><strong>%s</strong></td>`+
+				`<td style="border-bottom:1px solid #eee;color:#b00020;">%s</td></tr>`,
+			html.EscapeString(card.Card), html.EscapeString(cardIssueText(card)))
+	}
+	b.WriteString(`</table>`)
+
+	if r.AddCardUrl != "" {
+		fmt.Fprintf(&b,
+			`<p style="margin-top:20px;"><a href="%s" style="display:inline-block;background-color:#0b5cad;color:#ffffff;`+
+				`padding:10px 18px;border-radius:6px;text-decoration:none;font-weight:bold;">Add a new card</a></p>`,
+			html.EscapeString(r.AddCardUrl))
+	}
+	b.WriteString(`</body></html>`)
+	return b.String()
+}
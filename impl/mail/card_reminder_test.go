@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"evsys-back/entity"
+)
+
+func TestBuildCardReminderSubject(t *testing.T) {
+	expiring := entity.CardIssue{Card: "**** 0004", ExpiryDate: "2612", Kind: entity.CardNoticeExpiring}
+	failed := entity.CardIssue{Card: "**** 1111", Kind: entity.CardNoticeFailed}
+
+	if got := buildCardReminderSubject(entity.CardReminder{Cards: []entity.CardIssue{expiring}}); !strings.Contains(got, "expires soon") {
+		t.Errorf("subject = %q, want the expiry wording", got)
+	}
+	if got := buildCardReminderSubject(entity.CardReminder{Cards: []entity.CardIssue{expiring, failed}}); !strings.Contains(got, "declined") {
+		t.Errorf("subject = %q, want a decline to take precedence", got)
+	}
+}
+
+func TestRenderCardReminder(t *testing.T) {
+	body := renderCardReminder(entity.CardReminder{
+		Username: "driver",
+		Cards: []entity.CardIssue{
+			{Card: "**** 0004", ExpiryDate: "2612", Kind: entity.CardNoticeExpiring},
+			{Card: "**** 1111", Kind: entity.CardNoticeFailed},
+		},
+		AddCardUrl: "https://app.example.com/cards/add?a=1&b=2",
+	})
+	for _, want := range []string{
+		"Hello driver",
+		"**** 0004",
+		"expires 12/26",
+		"**** 1111",
+		"declined on the last payment",
+		`href="https://app.example.com/cards/add?a=1&amp;b=2"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q", want)
+		}
+	}
+
+	if body = renderCardReminder(entity.CardReminder{Cards: []entity.CardIssue{{Card: "x"}}}); strings.Contains(body, "<a ") {
+		t.Error("a link is rendered without an url")
+	}
+}
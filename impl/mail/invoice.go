@@ -0,0 +1,114 @@
+package mail
+
+import (
+	"evsys-back/entity"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// RenderInvoice returns the standalone HTML document of an invoice or credit
+// note. It is rendered once, when the invoice is issued, and stored with it.
+func RenderInvoice(inv *entity.Invoice) string {
+	if inv == nil {
+		return ""
+	}
+	currency := currencyLabel(inv.Currency)
+	title := "Invoice"
+	if inv.Type == entity.InvoiceTypeCreditNote {
+		title = "Credit note"
+	}
+
+	issuer := fiscalSection("Issuer", inv.Issuer)
+	customer := fiscalSection("Customer", inv.Customer)
+
+	details := section{title: "Details"}
+	details.add("Number", inv.InvoiceNumber)
+	details.add("Date", inv.IssuedAt.UTC().Format("2006-01-02"))
+	details.add("Rectifies", inv.Rectifies)
+	if inv.TransactionId > 0 {
+		details.add("Transaction", fmt.Sprintf("%d", inv.TransactionId))
+	}
+	if inv.Order > 0 {
+		details.add("Order", fmt.Sprintf("#%d", inv.Order))
+	}
+
+	totals := section{title: "Amount"}
+	totals.add("Taxable base", formatAmount(inv.TaxableBase, currency))
+	totals.add(fmt.Sprintf("VAT %s%%", formatRate(inv.VatRate)), formatAmount(inv.VatAmount, currency))
+	totals.add("Total", formatAmount(inv.Total, currency))
+
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html>` + "\n")
+	b.WriteString(`<html lang="en" xmlns="http://www.w3.org/1999/xhtml">` + "\n<head>\n")
+	b.WriteString(`<meta charset="utf-8">` + "\n")
+	b.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1.0">` + "\n")
+	fmt.Fprintf(&b, "<title>%s %s</title>\n", title, html.EscapeString(inv.InvoiceNumber))
+	fmt.Fprintf(&b, "</head>\n<body style=\"margin:0;padding:0;background-color:%s;\">\n", colPage)
+
+	fmt.Fprintf(&b, `<table role="presentation" width="100%%" cellpadding="0" cellspacing="0" border="0" style="background-color:%s;">`, colPage)
+	b.WriteString(`<tr><td align="center" style="padding:24px 12px;">`)
+	fmt.Fprintf(&b,
+		`<table role="presentation" width="600" cellpadding="0" cellspacing="0" border="0" `+
+			`style="width:600px;max-width:600px;background-color:#ffffff;border:1px solid %s;border-radius:12px;overflow:hidden;font-family:%s;">`,
+		colBorder, fontStack)
+
+	// Header: document type and number.
+	fmt.Fprintf(&b, `<tr><td style="padding:20px 24px;border-bottom:1px solid %s;">`, colBorder)
+	fmt.Fprintf(&b, `<div style="font-size:18px;font-weight:bold;color:%s;line-height:1.3;">%s %s</div>`,
+		colText, title, html.EscapeString(inv.InvoiceNumber))
+	fmt.Fprintf(&b, `<div style="font-size:13px;color:%s;line-height:1.5;margin-top:2px;">%s</div>`,
+		colMuted, html.EscapeString(inv.Description))
+	b.WriteString(`</td></tr>`)
+
+	// Parties side by side.
+	b.WriteString(`<tr><td style="padding:16px 24px 4px 24px;">`)
+	b.WriteString(`<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0"><tr>`)
+	b.WriteString(`<td width="50%" valign="top" style="padding-right:14px;">`)
+	renderColumn(&b, []section{issuer})
+	b.WriteString(`</td><td width="50%" valign="top" style="padding-left:14px;">`)
+	renderColumn(&b, []section{customer})
+	b.WriteString(`</td></tr></table></td></tr>`)
+
+	b.WriteString(`<tr><td style="padding:4px 24px 20px 24px;">`)
+	b.WriteString(`<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0"><tr>`)
+	b.WriteString(`<td width="50%" valign="top" style="padding-right:14px;">`)
+	renderColumn(&b, []section{details})
+	b.WriteString(`</td><td width="50%" valign="top" style="padding-left:14px;">`)
+	renderColumn(&b, []section{totals})
+	b.WriteString(`</td></tr></table></td></tr>`)
+
+	fmt.Fprintf(&b, `<tr><td style="padding:14px 24px;border-top:1px solid %s;background-color:%s;">`, colBorder, colFooterBg)
+	fmt.Fprintf(&b, `<div style="font-size:11px;color:%s;line-height:1.5;">%s %s · %s</div>`,
+		colLabel, title, html.EscapeString(inv.InvoiceNumber), html.EscapeString(issuerName(inv.Issuer)))
+	b.WriteString(`</td></tr>`)
+
+	b.WriteString(`</table></td></tr></table>` + "\n</body>\n</html>\n")
+	return b.String()
+}
+
+func fiscalSection(title string, f *entity.FiscalDetails) section {
+	s := section{title: title}
+	if f == nil {
+		return s
+	}
+	s.add("Name", f.Name)
+	s.addMono("Tax ID", f.TaxId)
+	s.add("Address", f.Address)
+	s.add("Postal code", f.PostalCode)
+	s.add("City", f.City)
+	s.add("Country", f.Country)
+	return s
+}
+
+func issuerName(f *entity.FiscalDetails) string {
+	if f == nil {
+		return ""
+	}
+	return f.Name
+}
+
+// formatRate prints a VAT rate without trailing zeros: 21, 10, 5.5.
+func formatRate(rate float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", rate), "0"), ".")
+}
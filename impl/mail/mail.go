@@ -209,6 +209,8 @@ func (s *Service) SendPaymentWarning(ctx context.Context, to string, w entity.Pa
 func buildWarningSubject(w entity.PaymentWarning) string {
 	state := "failed"
 	switch {
+	case w.DeclineClass == entity.DeclineClassHard:
+		state = "hard decline, not retried"
 	case w.Exhausted:
 		state = "failed — retries exhausted"
 	case w.Attempt > 0:
@@ -257,6 +259,8 @@ func renderPaymentWarning(w entity.PaymentWarning) string {
 
 	b.WriteString(`<p style="margin-top:16px;">`)
 	switch {
+	case w.DeclineClass == entity.DeclineClassHard:
+		b.WriteString(`<strong style="color:#b00020;">Hard decline — the card will not be charged again automatically.</strong>`)
 	case w.Exhausted:
 		fmt.Fprintf(&b,
 			`<strong style="color:#b00020;">All %d retry attempts exhausted — payment permanently failed.</strong>`,
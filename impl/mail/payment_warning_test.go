@@ -24,6 +24,11 @@ func TestBuildWarningSubject(t *testing.T) {
 			w:    entity.PaymentWarning{TransactionId: 4207, ChargePointId: "PE00003", MaxAttempts: 4, Exhausted: true},
 			want: "Payment failed — retries exhausted — PE00003 tx 4207",
 		},
+		{
+			name: "hard decline",
+			w:    entity.PaymentWarning{TransactionId: 4207, ChargePointId: "PE00003", DeclineClass: entity.DeclineClassHard},
+			want: "Payment hard decline, not retried — PE00003 tx 4207",
+		},
 		{
 			name: "no charge point",
 			w:    entity.PaymentWarning{TransactionId: 99},
@@ -79,6 +84,15 @@ func TestRenderPaymentWarning(t *testing.T) {
 		}
 	})
 
+	t.Run("hard decline wording", func(t *testing.T) {
+		body := renderPaymentWarning(entity.PaymentWarning{
+			TransactionId: 4207, Result: "0208", DeclineClass: entity.DeclineClassHard, OccurredAt: now,
+		})
+		if !strings.Contains(body, "will not be charged again automatically") {
+			t.Errorf("body missing hard decline wording\n%s", body)
+		}
+	})
+
 	t.Run("escapes html in result", func(t *testing.T) {
 		body := renderPaymentWarning(entity.PaymentWarning{
 			TransactionId: 1, Result: "<script>x</script>", OccurredAt: now,
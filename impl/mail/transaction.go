@@ -116,12 +116,19 @@ func energyKnown(tx *entity.Transaction) bool {
 }
 
 // billedAmount is what the session actually cost: the billed figure once
-// settled, otherwise the amount computed at stop. Zero means "nothing to show".
+// settled, otherwise the amount computed at stop, both net of a promotion's
+// discount. Zero means "nothing to show".
 func billedAmount(tx *entity.Transaction) int {
 	if tx.PaymentBilled > 0 {
-		return tx.PaymentBilled
+		return tx.PaymentBilled - tx.Discount
 	}
-	return tx.PaymentAmount
+	return tx.PaymentAmount - tx.Discount
+}
+
+// amountKnown reports whether the KPI and preheader show an amount. A session
+// a promotion paid in full costs zero, which is worth showing.
+func amountKnown(tx *entity.Transaction) bool {
+	return billedAmount(tx) > 0 || tx.Discount > 0
 }
 
 // numericCurrencies maps the ISO 4217 numeric codes Redsys returns onto their
@@ -328,8 +335,16 @@ func renderTransaction(t entity.TransactionMail) string {
 	if tx.PaymentAmount > 0 {
 		pay.add("Amount", formatAmount(tx.PaymentAmount, currency))
 	}
-	if tx.PaymentBilled > 0 {
-		pay.add("Billed", formatAmount(tx.PaymentBilled, currency))
+	if tx.Discount > 0 {
+		promotion := tx.PromoCode
+		if t.Promotion != "" {
+			promotion += " · " + t.Promotion
+		}
+		pay.add("Promotion", promotion)
+		pay.add("Discount", "-"+formatAmount(tx.Discount, currency))
+	}
+	if tx.PaymentBilled > tx.Discount {
+		pay.add("Billed", formatAmount(tx.PaymentBilled-tx.Discount, currency))
 	}
 	pay.add("Stop reason", tx.Reason)
 	pay.add("Error", tx.PaymentError)
@@ -360,8 +375,8 @@ func renderTransaction(t entity.TransactionMail) string {
 	if energyKnown(tx) {
 		preheader += " · " + formatEnergy(consumed)
 	}
-	if a := billedAmount(tx); a > 0 {
-		preheader += " · " + formatAmount(a, currency)
+	if amountKnown(tx) {
+		preheader += " · " + formatAmount(billedAmount(tx), currency)
 	}
 	preheader += " · " + stripTags(status.text)
 	fmt.Fprintf(&b,
@@ -398,8 +413,8 @@ func renderTransaction(t entity.TransactionMail) string {
 		energyValue, energyUnit = formatEnergyHeadline(consumed), "kWh"
 	}
 	amountValue, amountUnit := "—", ""
-	if a := billedAmount(tx); a > 0 {
-		amountValue, amountUnit = fmt.Sprintf("%.2f", float64(a)/100.0), currency
+	if amountKnown(tx) {
+		amountValue, amountUnit = fmt.Sprintf("%.2f", float64(billedAmount(tx))/100.0), currency
 	}
 
 	b.WriteString(`<tr><td style="padding:0;"><table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0"><tr>`)
@@ -127,6 +127,28 @@ func TestRenderTransaction(t *testing.T) {
 		}
 	})
 
+	t.Run("renders a promotion discount", func(t *testing.T) {
+		tx := finishedTransaction()
+		tx.Discount = 234
+		tx.PromoCode = "SPRING"
+		body := renderTransaction(entity.TransactionMail{Transaction: tx, Promotion: "Spring campaign"})
+		for _, want := range []string{"SPRING · Spring campaign", "-2.34", "10.00"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("body missing %q", want)
+			}
+		}
+
+		// a session the promotion paid in full shows a zero amount, not a dash
+		tx.Discount = tx.PaymentAmount
+		body = renderTransaction(entity.TransactionMail{Transaction: tx})
+		if !strings.Contains(body, "Session #4207 · 15.500 kWh · 0.00") {
+			t.Error("preheader does not show the zero amount")
+		}
+		if strings.Contains(body, ">Billed<") {
+			t.Error("nothing was charged, yet a billed row is shown")
+		}
+	})
+
 	t.Run("maps redsys numeric currency onto the payment section", func(t *testing.T) {
 		tx := finishedTransaction()
 		tx.PaymentOrders = []entity.PaymentOrder{{Order: 1, Amount: 1234, Currency: "978"}}
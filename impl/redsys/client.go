@@ -9,6 +9,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"evsys-back/entity"
@@ -38,6 +39,8 @@ type Config struct {
 	// Redsys calls server-to-server with the signed response.
 	NotifyUrl string
 	Currency  string
+	// Timeout bounds each REST request; 30 seconds when zero.
+	Timeout time.Duration
 }
 
 // Client is the Redsys REST API client
@@ -49,9 +52,12 @@ type Client struct {
 
 // NewClient creates a new Redsys client
 func NewClient(config Config, log *slog.Logger) *Client {
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
 	return &Client{
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: config.Timeout,
 		},
 		config: config,
 		log:    log.With(sl.Module("redsys.client")),
@@ -122,7 +128,7 @@ type DecodedResponse struct {
 // The request/response types for these operations (core.CaptureRequest,
 // core.CaptureResponse, core.PreauthorizeRequest, core.PayRequest, core.RefundRequest,
 // core.EntryFormRequest, core.EntryFormResponse) are defined in the core package;
-// Client uses them directly so it satisfies core.RedsysClient without an
+// Client uses them directly so it satisfies core.PaymentGateway without an
 // adapter.
 
 // Capture performs a capture operation on a preauthorized amount
@@ -137,12 +143,12 @@ func (c *Client) Cancel(ctx context.Context, req core.CaptureRequest) (*core.Cap
 
 // Preauthorize performs a MIT preauthorization with a saved card token
 func (c *Client) Preauthorize(ctx context.Context, req core.PreauthorizeRequest) (*core.CaptureResponse, error) {
-	return c.performMITTransaction(ctx, req.OrderNumber, req.Amount, req.CardToken, req.CofTid, TransactionTypePreauthorize)
+	return c.performMITTransaction(ctx, req.OrderNumber, req.Amount, req.CardToken, req.NetworkTxId, TransactionTypePreauthorize)
 }
 
 // Pay performs a direct MIT payment with a saved card token (transaction type "0")
 func (c *Client) Pay(ctx context.Context, req core.PayRequest) (*core.CaptureResponse, error) {
-	return c.performMITTransaction(ctx, req.OrderNumber, req.Amount, req.CardToken, req.CofTid, TransactionTypePay)
+	return c.performMITTransaction(ctx, req.OrderNumber, req.Amount, req.CardToken, req.NetworkTxId, TransactionTypePay)
 }
 
 // BuildEntryForm signs a Redsys TPV Virtual "realizarPago" payload for
@@ -234,6 +240,34 @@ func (c *Client) VerifyNotification(merchantParams, signature, orderNumber strin
 	return VerifySignature(merchantParams, signature, c.config.SecretKey, orderNumber)
 }
 
+// ParseNotification decodes the form-encoded body Redsys posts to
+// /payment/notify and verifies its signature before returning the result.
+func (c *Client) ParseNotification(data []byte) (*entity.PaymentParameters, error) {
+	params, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse query: %w", err)
+	}
+
+	merchantParams := params.Get("Ds_MerchantParameters")
+	if merchantParams == "" {
+		return nil, fmt.Errorf("empty merchant parameters")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(merchantParams)
+	if err != nil {
+		return nil, fmt.Errorf("decode parameters: %w", err)
+	}
+	var result entity.PaymentParameters
+	if err = json.Unmarshal(decoded, &result); err != nil {
+		return nil, fmt.Errorf("parse parameters: %w", err)
+	}
+
+	if err = c.VerifyNotification(merchantParams, params.Get("Ds_Signature"), result.Order); err != nil {
+		return nil, fmt.Errorf("verify signature: %w", err)
+	}
+	return &result, nil
+}
+
 // performMITTransaction executes a Merchant Initiated Transaction with stored credentials.
 // Used by both Preauthorize (type "1") and Pay (type "0").
 func (c *Client) performMITTransaction(ctx context.Context, orderNumber string, amount int, cardToken, cofTid, txType string) (*core.CaptureResponse, error) {
@@ -399,21 +433,21 @@ func (c *Client) decodeResponse(log *slog.Logger, body []byte, transactionType s
 	).Info("Redsys transaction completed")
 
 	return &core.CaptureResponse{
-		Success:            success,
-		ResponseCode:       decoded.ResponseCode,
-		AuthorizationCode:  decoded.AuthorisationCode,
-		ErrorCode:          decoded.ErrorCode,
-		MerchantIdentifier: decoded.MerchantIdentifier,
-		CofTxnid:           decoded.MerchantCofTxnid,
-		CardBrand:          decoded.CardBrand,
-		CardCountry:        decoded.CardCountry,
-		ExpiryDate:         decoded.ExpiryDate,
-		Order:              decoded.Order,
-		Amount:             decoded.Amount,
-		Currency:           decoded.Currency,
-		TransactionType:    decoded.TransactionType,
-		Date:               decoded.Date,
-		Hour:               decoded.Hour,
+		Success:           success,
+		ResponseCode:      decoded.ResponseCode,
+		AuthorizationCode: decoded.AuthorisationCode,
+		ErrorCode:         decoded.ErrorCode,
+		CardToken:         decoded.MerchantIdentifier,
+		NetworkTxId:       decoded.MerchantCofTxnid,
+		CardBrand:         decoded.CardBrand,
+		CardCountry:       decoded.CardCountry,
+		ExpiryDate:        decoded.ExpiryDate,
+		Order:             decoded.Order,
+		Amount:            decoded.Amount,
+		Currency:          decoded.Currency,
+		TransactionType:   decoded.TransactionType,
+		Date:              decoded.Date,
+		Hour:              decoded.Hour,
 	}, nil
 }
 
@@ -0,0 +1,283 @@
+// Package redsystest provides a local stand-in for the Redsys REST API and
+// its notification callbacks, for integration tests of the payment flow.
+package redsystest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"evsys-back/entity"
+	"evsys-back/impl/redsys"
+)
+
+// SecretKey is the sandbox merchant key published in the Redsys integration
+// guide; servers created with an empty key use it.
+const SecretKey = "sq7HjrUOBfKmC576ILgskD5srU870gJ7"
+
+// Path is the REST endpoint served by the simulator.
+const Path = "/sis/rest/trataPeticionREST"
+
+// Reply scripts the answer to one REST request. The zero value approves the
+// operation with the approval code of its transaction type.
+type Reply struct {
+	// Code is the Ds_Response returned in the signed parameters.
+	Code string
+	// ErrorCode makes Redsys reject the request outright with an
+	// {"errorCode": ...} body, e.g. SIS0051 for a duplicated order.
+	ErrorCode string
+	// Status answers with a non-200 HTTP status and a plain body.
+	Status int
+	// Body answers with this raw body instead, e.g. a malformed one.
+	Body string
+	// Delay holds the answer back, to run the client into its timeout.
+	Delay time.Duration
+}
+
+// Received is a REST request accepted by the simulator
+type Received struct {
+	Params    redsys.MerchantParameters
+	Signature string
+}
+
+// Server is an httptest-based Redsys stand-in. It verifies the signature of
+// every REST request, answers it as scripted and can post signed
+// notification callbacks.
+type Server struct {
+	server   *httptest.Server
+	secret   string
+	replies  map[string][]Reply // key: transaction type
+	fallback Reply
+	received []Received
+	mux      sync.Mutex
+}
+
+// New starts a simulator signing with secretKey.
+func New(secretKey string) *Server {
+	if secretKey == "" {
+		secretKey = SecretKey
+	}
+	s := &Server{
+		secret:  secretKey,
+		replies: make(map[string][]Reply),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Close shuts the simulator down
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL is the REST API URL the client must be configured with
+func (s *Server) URL() string {
+	return s.server.URL + Path
+}
+
+// SecretKey is the merchant key the client must be configured with
+func (s *Server) SecretKey() string {
+	return s.secret
+}
+
+// Config returns a client configuration pointing at the simulator
+func (s *Server) Config() redsys.Config {
+	return redsys.Config{
+		MerchantCode: "999008881",
+		Terminal:     "001",
+		SecretKey:    s.secret,
+		RestApiUrl:   s.URL(),
+		Currency:     "978",
+	}
+}
+
+// Reply queues answers for the next requests of a transaction type
+// (redsys.TransactionTypePay, ...). Each reply is used once, in order.
+func (s *Server) Reply(transactionType string, replies ...Reply) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.replies[transactionType] = append(s.replies[transactionType], replies...)
+}
+
+// SetDefault sets the answer used when no scripted reply is queued.
+func (s *Server) SetDefault(reply Reply) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.fallback = reply
+}
+
+// Requests returns the REST requests received so far
+func (s *Server) Requests() []Received {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]Received(nil), s.received...)
+}
+
+func (s *Server) next(transactionType string) Reply {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	queue := s.replies[transactionType]
+	if len(queue) == 0 {
+		return s.fallback
+	}
+	s.replies[transactionType] = queue[1:]
+	return queue[0]
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req redsys.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, redsys.ErrorCodeResponse{Code: "SIS0218"})
+		return
+	}
+	decoded, err := base64.StdEncoding.DecodeString(req.MerchantParameters)
+	if err != nil {
+		writeJSON(w, redsys.ErrorCodeResponse{Code: "SIS0218"})
+		return
+	}
+	var params redsys.MerchantParameters
+	if err = json.Unmarshal(decoded, &params); err != nil {
+		writeJSON(w, redsys.ErrorCodeResponse{Code: "SIS0218"})
+		return
+	}
+	if err = redsys.VerifySignature(req.MerchantParameters, req.Signature, s.secret, params.Order); err != nil {
+		writeJSON(w, redsys.ErrorCodeResponse{Code: "SIS0042"})
+		return
+	}
+
+	s.mux.Lock()
+	s.received = append(s.received, Received{Params: params, Signature: req.Signature})
+	s.mux.Unlock()
+
+	reply := s.next(params.TransactionType)
+	if reply.Delay > 0 {
+		select {
+		case <-time.After(reply.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case reply.Body != "":
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(reply.Body))
+	case reply.Status != 0:
+		http.Error(w, http.StatusText(reply.Status), reply.Status)
+	case reply.ErrorCode != "":
+		writeJSON(w, redsys.ErrorCodeResponse{Code: reply.ErrorCode})
+	default:
+		s.writeResult(w, params, reply.Code)
+	}
+}
+
+// writeResult answers with signed parameters carrying the response code.
+func (s *Server) writeResult(w http.ResponseWriter, params redsys.MerchantParameters, code string) {
+	if code == "" {
+		code = approvalCode(params.TransactionType)
+	}
+	now := time.Now()
+	result := redsys.DecodedResponse{
+		ResponseCode:    code,
+		Order:           params.Order,
+		Amount:          params.Amount,
+		Currency:        params.Currency,
+		TransactionType: params.TransactionType,
+		Date:            now.Format("02/01/2006"),
+		Hour:            now.Format("15:04"),
+	}
+	if entity.IsRedsysApproved(params.TransactionType, code) {
+		result.AuthorisationCode = "123456"
+		result.MerchantIdentifier = params.Identifier
+		result.MerchantCofTxnid = params.CofTid
+	}
+
+	encoded, signature, err := s.sign(result, params.Order)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{
+		"Ds_SignatureVersion":   "HMAC_SHA256_V1",
+		"Ds_MerchantParameters": encoded,
+		"Ds_Signature":          signature,
+	})
+}
+
+// Notification returns the signed form body Redsys posts to the merchant's
+// notification URL for the given result.
+func (s *Server) Notification(result *entity.PaymentParameters) ([]byte, error) {
+	encoded, signature, err := s.sign(result, result.Order)
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	values.Set("Ds_SignatureVersion", "HMAC_SHA256_V1")
+	values.Set("Ds_MerchantParameters", encoded)
+	values.Set("Ds_Signature", signature)
+	return []byte(values.Encode()), nil
+}
+
+// SendNotification posts a signed notification callback to notifyUrl, as
+// Redsys does after an operation, and returns the HTTP status of the answer.
+func (s *Server) SendNotification(ctx context.Context, notifyUrl string, result *entity.PaymentParameters) (int, error) {
+	body, err := s.Notification(result)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notifyUrl, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func (s *Server) sign(result any, order string) (string, string, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", "", fmt.Errorf("marshal parameters: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	signature, err := redsys.GenerateSignature(encoded, s.secret, order)
+	if err != nil {
+		return "", "", err
+	}
+	return encoded, signature, nil
+}
+
+// approvalCode is the response code of an approved operation of the type.
+func approvalCode(transactionType string) string {
+	switch transactionType {
+	case redsys.TransactionTypeCapture, redsys.TransactionTypeRefund:
+		return "0900"
+	case redsys.TransactionTypeCancel:
+		return "0400"
+	default:
+		return "0000"
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
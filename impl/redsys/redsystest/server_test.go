@@ -0,0 +1,240 @@
+package redsystest
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	"evsys-back/impl/core"
+	database_mock "evsys-back/impl/database-mock"
+	"evsys-back/impl/redsys"
+	"evsys-back/internal/api/handlers/payments"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var admin = &entity.User{Username: "admin", Role: "admin"}
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// newSuite wires the core payment flow to a Redsys client talking to a fresh
+// simulator, with a user holding one stored card.
+func newSuite(t *testing.T) (*Server, *core.Core, *database_mock.MockDB) {
+	t.Helper()
+	sim := New("")
+	t.Cleanup(sim.Close)
+
+	conf := sim.Config()
+	conf.Timeout = 100 * time.Millisecond
+	db := database_mock.NewMockDB()
+	c := core.New(newTestLogger(), db)
+	c.SetAuth(authenticator.New(newTestLogger(), db))
+	c.SetPaymentGateway(redsys.NewClient(conf, newTestLogger()))
+
+	ctx := context.Background()
+	db.SeedUser(&entity.User{Username: "driver", UserId: "u1"})
+	require.NoError(t, db.AddUserTag(ctx, &entity.UserTag{UserId: "u1", Username: "driver", IdTag: "TAG1"}))
+	require.NoError(t, db.SavePaymentMethod(ctx, &entity.PaymentMethod{
+		Identifier: "tok-1", CofTid: "cof-1", ExpiryDate: "4012", IsDefault: true, UserId: "u1", UserName: "driver",
+	}))
+	return sim, c, db
+}
+
+func seedFinished(db *database_mock.MockDB, transactionId, amount int) {
+	db.SeedTransaction(&entity.Transaction{TransactionId: transactionId, IdTag: "TAG1", IsFinished: true, PaymentAmount: amount})
+}
+
+// waitOrder waits until the last payment order of a transaction is closed.
+func waitOrder(t *testing.T, db *database_mock.MockDB, transactionId int) *entity.PaymentOrder {
+	t.Helper()
+	var order *entity.PaymentOrder
+	require.Eventually(t, func() bool {
+		tx, _ := db.GetTransaction(context.Background(), transactionId)
+		if tx == nil || tx.PaymentOrder == 0 && tx.PaymentError == "" {
+			return false
+		}
+		order, _ = db.GetPaymentOrder(context.Background(), tx.PaymentOrder)
+		if order == nil {
+			order, _ = db.GetLastOrder(context.Background())
+		}
+		return order != nil && order.IsCompleted
+	}, 2*time.Second, 5*time.Millisecond)
+	return order
+}
+
+func TestPayTransaction_Approved(t *testing.T) {
+	ctx := context.Background()
+	sim, c, db := newSuite(t)
+	seedFinished(db, 1, 750)
+
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	order := waitOrder(t, db, 1)
+	assert.Equal(t, "0000", order.Result)
+	assert.Equal(t, 750, order.Amount)
+
+	tx, _ := db.GetTransaction(ctx, 1)
+	assert.Equal(t, 750, tx.PaymentBilled)
+	assert.Empty(t, tx.PaymentError)
+
+	requests := sim.Requests()
+	require.Len(t, requests, 1)
+	params := requests[0].Params
+	assert.Equal(t, redsys.TransactionTypePay, params.TransactionType)
+	assert.Equal(t, "750", params.Amount)
+	assert.Equal(t, "tok-1", params.Identifier)
+	assert.Equal(t, "cof-1", params.CofTid)
+	assert.Equal(t, "MIT", params.Exception)
+}
+
+func TestPayTransaction_Failures(t *testing.T) {
+	tests := []struct {
+		name   string
+		reply  Reply
+		result string
+	}{
+		{"declined", Reply{Code: "0190"}, "0190"},
+		{"cancelled by holder", Reply{Code: "9915"}, "9915"},
+		{"gateway error code", Reply{ErrorCode: "SIS0051"}, "SIS0051"},
+		{"http error", Reply{Status: http.StatusServiceUnavailable}, "503"},
+		{"empty parameters", Reply{Body: `{"Ds_MerchantParameters":""}`}, "EMPTY_RESPONSE"},
+		{"malformed body", Reply{Body: `<html>maintenance</html>`}, "failed to unmarshal response"},
+		{"timeout", Reply{Delay: time.Second}, "failed to send request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			sim, c, db := newSuite(t)
+			sim.Reply(redsys.TransactionTypePay, tt.reply)
+			seedFinished(db, 1, 500)
+
+			require.NoError(t, c.PayTransaction(ctx, 1))
+			order := waitOrder(t, db, 1)
+			assert.Contains(t, order.Result, tt.result)
+
+			tx, _ := db.GetTransaction(ctx, 1)
+			assert.Contains(t, tx.PaymentError, tt.result)
+			retry, _ := db.GetPaymentRetry(ctx, 1)
+			require.NotNil(t, retry, "a failed payment is queued for retry")
+			assert.Equal(t, 1, retry.Attempt)
+			method, _ := db.GetPaymentMethodByIdentifier(ctx, "tok-1")
+			assert.Equal(t, 1, method.FailCount)
+		})
+	}
+}
+
+func TestPaymentRetry_SucceedsAfterDecline(t *testing.T) {
+	ctx := context.Background()
+	sim, c, db := newSuite(t)
+	sim.Reply(redsys.TransactionTypePay, Reply{Code: "0190"}, Reply{Code: "0000"})
+	seedFinished(db, 1, 500)
+
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	waitOrder(t, db, 1)
+	retry, _ := db.GetPaymentRetry(ctx, 1)
+	require.NotNil(t, retry)
+
+	require.NoError(t, c.ForcePaymentRetry(ctx, admin, 1))
+	require.Eventually(t, func() bool {
+		tx, _ := db.GetTransaction(ctx, 1)
+		return tx.PaymentBilled == 500
+	}, 2*time.Second, 5*time.Millisecond)
+
+	tx, _ := db.GetTransaction(ctx, 1)
+	assert.Empty(t, tx.PaymentError)
+	retry, _ = db.GetPaymentRetry(ctx, 1)
+	assert.Nil(t, retry, "a paid transaction leaves the retry queue")
+	method, _ := db.GetPaymentMethodByIdentifier(ctx, "tok-1")
+	assert.Equal(t, 0, method.FailCount)
+	assert.Len(t, sim.Requests(), 2)
+}
+
+func TestRefund(t *testing.T) {
+	ctx := context.Background()
+	sim, c, db := newSuite(t)
+	seedFinished(db, 1, 1000)
+	require.NoError(t, c.PayTransaction(ctx, 1))
+	order := waitOrder(t, db, 1)
+
+	// answered refund
+	sim.Reply(redsys.TransactionTypeRefund, Reply{})
+	_, err := c.IssueRefund(ctx, admin, 1, &entity.RefundOrderRequest{Amount: 300, Reason: entity.RefundReasonBillingError})
+	require.NoError(t, err)
+
+	// unanswered refund, settled by the notification that follows
+	sim.Reply(redsys.TransactionTypeRefund, Reply{Delay: time.Second})
+	_, err = c.IssueRefund(ctx, admin, 1, &entity.RefundOrderRequest{Amount: 200, Reason: entity.RefundReasonOther})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		refunds, _ := db.GetRefunds(ctx, 1)
+		return len(refunds) == 2 && refunds[0].Status == entity.RefundStatusCompleted &&
+			len(sim.Requests()) == 3
+	}, 2*time.Second, 5*time.Millisecond)
+	time.Sleep(200 * time.Millisecond) // let the client time out
+	refunds, _ := db.GetRefunds(ctx, 1)
+	assert.Equal(t, entity.RefundStatusPending, refunds[1].Status)
+
+	body, err := sim.Notification(&entity.PaymentParameters{
+		Order:           strconv.Itoa(order.Order),
+		Amount:          "200",
+		Response:        "0900",
+		TransactionType: entity.RedsysTxRefund,
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.Notify(ctx, body))
+	require.Eventually(t, func() bool {
+		refunds, _ = db.GetRefunds(ctx, 1)
+		return refunds[1].Status == entity.RefundStatusCompleted
+	}, 2*time.Second, 5*time.Millisecond)
+
+	paid, _ := db.GetPaymentOrder(ctx, order.Order)
+	assert.Equal(t, 500, paid.RefundAmount)
+}
+
+func TestNotify_Enrollment(t *testing.T) {
+	ctx := context.Background()
+	sim, c, db := newSuite(t)
+	order, err := c.SetOrder(ctx, &entity.User{Username: "driver", UserId: "u1"}, &entity.PaymentOrder{Description: "card"})
+	require.NoError(t, err)
+
+	notify := httptest.NewServer(payments.Notify(newTestLogger(), c))
+	defer notify.Close()
+
+	result := &entity.PaymentParameters{
+		Order:              strconv.Itoa(order.Order),
+		Amount:             "0",
+		Response:           "0000",
+		MerchantIdentifier: "tok-2",
+		MerchantCofTxnid:   "cof-2",
+		ExpiryDate:         "4012",
+	}
+
+	// a callback signed with another merchant key is rejected
+	forger := New("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+	defer forger.Close()
+	status, err := forger.SendNotification(ctx, notify.URL, result)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	status, err = sim.SendNotification(ctx, notify.URL, result)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+
+	require.Eventually(t, func() bool {
+		method, _ := db.GetPaymentMethodByIdentifier(ctx, "tok-2")
+		return method != nil && method.CofTid == "cof-2"
+	}, 2*time.Second, 5*time.Millisecond)
+	closed, _ := db.GetPaymentOrder(ctx, order.Order)
+	assert.True(t, closed.IsCompleted)
+	assert.Equal(t, "0000", closed.Result)
+}
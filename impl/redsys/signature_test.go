@@ -2,6 +2,9 @@ package redsys
 
 import (
 	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/url"
 	"strings"
 	"testing"
 )
@@ -69,3 +72,30 @@ func TestVerifySignatureRejects(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNotification(t *testing.T) {
+	client := NewClient(Config{SecretKey: testSecret}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	params := base64.StdEncoding.EncodeToString([]byte(
+		`{"Ds_Order":"3018","Ds_Response":"0000","Ds_Merchant_Identifier":"52680a44e"}`))
+	signature, err := GenerateSignature(params, testSecret, "3018")
+	if err != nil {
+		t.Fatalf("GenerateSignature: %v", err)
+	}
+
+	body := url.Values{"Ds_MerchantParameters": {params}, "Ds_Signature": {signature}}.Encode()
+	result, err := client.ParseNotification([]byte(body))
+	if err != nil {
+		t.Fatalf("valid notification rejected: %v", err)
+	}
+	if result.Order != "3018" || result.MerchantIdentifier != "52680a44e" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	forged := url.Values{"Ds_MerchantParameters": {params}, "Ds_Signature": {"AAAA"}}.Encode()
+	if _, err = client.ParseNotification([]byte(forged)); err == nil {
+		t.Error("expected forged notification to be rejected")
+	}
+	if _, err = client.ParseNotification([]byte("Ds_Signature=AAAA")); err == nil {
+		t.Error("expected notification without parameters to be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+package apikeys
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Handler is the handler dependency for the API keys of service integrations.
+type Handler interface {
+	ListApiKeys(ctx context.Context, author *entity.User) ([]*entity.ApiKey, error)
+	CreateApiKey(ctx context.Context, author *entity.User, req *entity.ApiKeyRequest) (*entity.ApiKey, error)
+	RotateApiKey(ctx context.Context, author *entity.User, id string) (*entity.ApiKey, error)
+	RevokeApiKey(ctx context.Context, author *entity.User, id string) error
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.apikeys",
+		slog.String("author", author.Username),
+		slog.String("role", author.Role),
+	)
+}
+
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListApiKeys(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to list api keys", err)
+			return
+		}
+		web.OK(w, r, log, "api keys list", data)
+	}
+}
+
+// Create creates an API key; the response is the only one holding its secret.
+func Create(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var req entity.ApiKeyRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode api key", err)
+			return
+		}
+		log = log.With(slog.String("name", req.Name))
+
+		data, err := h.CreateApiKey(ctx, author, &req)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to create api key", err)
+			return
+		}
+		web.OK(w, r, log, "api key created", data)
+	}
+}
+
+// Rotate replaces the secret of an API key and returns the new one.
+func Rotate(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := loggerWith(logger, r, author).With(slog.String("id", id))
+
+		data, err := h.RotateApiKey(ctx, author, id)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to rotate api key", err)
+			return
+		}
+		web.OK(w, r, log, "api key rotated", data)
+	}
+}
+
+// Revoke disables an API key.
+func Revoke(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := loggerWith(logger, r, author).With(slog.String("id", id))
+
+		if err := h.RevokeApiKey(ctx, author, id); err != nil {
+			web.Fail(w, r, log, 0, "Failed to revoke api key", err)
+			return
+		}
+		web.OK(w, r, log, "api key revoked", map[string]any{
+			"success": true,
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package groups
+
+import (
+	"context"
+	"encoding/csv"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Handler is the handler dependency for user groups and the statements of
+// groups billed monthly.
+type Handler interface {
+	ListUserGroups(ctx context.Context, author *entity.User) ([]*entity.UserGroup, error)
+	SaveUserGroup(ctx context.Context, author *entity.User, group *entity.UserGroup) (*entity.UserGroup, error)
+	ListGroupStatements(ctx context.Context, author *entity.User, group string) ([]*entity.GroupStatement, error)
+	GetGroupStatement(ctx context.Context, author *entity.User, id string) (*entity.GroupStatement, error)
+	BillGroup(ctx context.Context, author *entity.User, name, period string) (*entity.GroupStatement, error)
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.groups",
+		slog.String("author", author.Username),
+		slog.String("role", author.Role),
+	)
+}
+
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListUserGroups(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to list user groups", err)
+			return
+		}
+		web.OK(w, r, log, "user groups list", data)
+	}
+}
+
+func Save(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var group entity.UserGroup
+		if err := render.Bind(r, &group); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode user group", err)
+			return
+		}
+		log = log.With(slog.String("group", group.Name), slog.String("billing_mode", group.BillingMode))
+
+		data, err := h.SaveUserGroup(ctx, author, &group)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to save user group", err)
+			return
+		}
+		web.OK(w, r, log, "user group saved", data)
+	}
+}
+
+func Statements(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		name := chi.URLParam(r, "name")
+		log := loggerWith(logger, r, author).With(slog.String("group", name))
+
+		data, err := h.ListGroupStatements(ctx, author, name)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to list group statements", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.Int("count", len(data))), "group statements list", data)
+	}
+}
+
+// Statement serves one statement as JSON or, with format=csv, as a CSV
+// download listing every session by driver.
+func Statement(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := loggerWith(logger, r, author).With(slog.String("id", id))
+
+		data, err := h.GetGroupStatement(ctx, author, id)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to get group statement", err)
+			return
+		}
+
+		if r.URL.Query().Get("format") != "csv" {
+			web.OK(w, r, log, "group statement", data)
+			return
+		}
+		log.Info("group statement csv")
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"statement-%s.csv\"", data.Id))
+		if err = writeStatementCSV(w, data); err != nil {
+			log.With(sl.Err(err)).Error("write statement csv")
+		}
+	}
+}
+
+// Bill bills the month given as ?period=2006-01 of a group right away.
+func Bill(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		name := chi.URLParam(r, "name")
+		period := r.URL.Query().Get("period")
+		log := loggerWith(logger, r, author).With(slog.String("group", name), slog.String("period", period))
+
+		data, err := h.BillGroup(ctx, author, name, period)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to bill group", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.Int("order", data.Order), slog.Int("amount", data.Amount)), "group billed", data)
+	}
+}
+
+func writeStatementCSV(w io.Writer, statement *entity.GroupStatement) error {
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"username", "transaction_id", "charge_point_id", "time_start", "time_stop", "energy_wh", "amount"})
+	for _, driver := range statement.Drivers {
+		for _, s := range driver.Sessions {
+			_ = out.Write([]string{
+				driver.Username,
+				strconv.Itoa(s.TransactionId),
+				s.ChargePointId,
+				s.TimeStart.Format(time.RFC3339),
+				s.TimeStop.Format(time.RFC3339),
+				strconv.Itoa(s.Energy),
+				strconv.Itoa(s.Amount),
+			})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,82 @@
+package invites
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Handler is the handler dependency for the invite codes of registration.
+type Handler interface {
+	ListInvites(ctx context.Context, author *entity.User) ([]*entity.Invite, error)
+	GenerateInvites(ctx context.Context, author *entity.User, req *entity.InviteRequest) ([]*entity.Invite, error)
+	RevokeInvite(ctx context.Context, author *entity.User, code string) error
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.invites",
+		slog.String("author", author.Username),
+		slog.String("role", author.Role),
+	)
+}
+
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListInvites(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to list invites", err)
+			return
+		}
+		web.OK(w, r, log, "invites list", data)
+	}
+}
+
+// Generate creates invite codes with the requested limits and targets.
+func Generate(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var req entity.InviteRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode invite request", err)
+			return
+		}
+
+		data, err := h.GenerateInvites(ctx, author, &req)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to generate invites", err)
+			return
+		}
+		web.Created(w, r, log, "invites generated", data)
+	}
+}
+
+// Revoke deletes an invite code.
+func Revoke(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		code := chi.URLParam(r, "code")
+		log := loggerWith(logger, r, author).With(slog.String("code", code))
+
+		if err := h.RevokeInvite(ctx, author, code); err != nil {
+			web.Fail(w, r, log, 0, "Failed to revoke invite", err)
+			return
+		}
+		web.OK(w, r, log, "invite revoked", map[string]any{
+			"success": true,
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package invoices
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/request"
+	"evsys-back/internal/lib/api/web"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+type Handler interface {
+	SaveFiscalDetails(ctx context.Context, author *entity.User, fiscal *entity.FiscalDetails) (*entity.FiscalDetails, error)
+	ListUserInvoices(ctx context.Context, author *entity.User) ([]*entity.Invoice, error)
+	ListInvoices(ctx context.Context, author *entity.User, from, to time.Time) ([]*entity.Invoice, error)
+	GetInvoice(ctx context.Context, author *entity.User, id string) (*entity.Invoice, error)
+	IssueTransactionInvoice(ctx context.Context, author *entity.User, transactionId int) (*entity.Invoice, error)
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.invoices",
+		slog.String("user", author.Username),
+		sl.Secret("user_id", author.UserId),
+	)
+}
+
+// Fiscal stores the fiscal details printed on the user's invoices.
+func Fiscal(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var fiscal entity.FiscalDetails
+		if err := render.Bind(r, &fiscal); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode fiscal details", err)
+			return
+		}
+
+		data, err := h.SaveFiscalDetails(ctx, author, &fiscal)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to save fiscal details", err)
+			return
+		}
+		web.OK(w, r, log, "fiscal details saved", data)
+	}
+}
+
+// List returns the invoices and credit notes of the current user.
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListUserInvoices(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to list invoices", err)
+			return
+		}
+		web.OK(w, r, log, "invoices list", data)
+	}
+}
+
+// Period lists every invoice issued between the from and to query dates.
+func Period(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		from, err := request.GetDate(r, "from")
+		if err != nil {
+			web.FailCode(w, r, log, 400, 400, "Invalid parameter", err)
+			return
+		}
+		to, err := request.GetDate(r, "to")
+		if err != nil {
+			web.FailCode(w, r, log, 400, 400, "Invalid parameter", err)
+			return
+		}
+		log = log.With(slog.Time("from", from), slog.Time("to", to))
+
+		data, err := h.ListInvoices(ctx, author, from, to)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to list invoices", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.Int("count", len(data))), "invoices by period", data)
+	}
+}
+
+// Download returns the frozen HTML document of an invoice.
+func Download(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := loggerWith(logger, r, author).With(slog.String("id", id))
+
+		invoice, err := h.GetInvoice(ctx, author, id)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to get invoice", err)
+			return
+		}
+
+		log.With(slog.String("number", invoice.InvoiceNumber)).Info("invoice download")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=\"%s.html\"", invoice.InvoiceNumber))
+		_, _ = w.Write([]byte(invoice.Html))
+	}
+}
+
+// IssueForTransaction issues the invoice of a transaction's payment on demand.
+func IssueForTransaction(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		transactionId, err := strconv.Atoi(chi.URLParam(r, "transactionId"))
+		if err != nil || transactionId <= 0 {
+			web.FailCode(w, r, log, 400, 2002, "Invalid transaction id", err)
+			return
+		}
+		log = log.With(slog.Int("transaction_id", transactionId))
+
+		data, err := h.IssueTransactionInvoice(ctx, author, transactionId)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to issue invoice", err)
+			return
+		}
+		web.Created(w, r, log.With(slog.String("number", data.InvoiceNumber)), "invoice issued", data)
+	}
+}
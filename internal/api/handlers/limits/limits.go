@@ -0,0 +1,83 @@
+package limits
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Handler is the handler dependency for user and group usage limits. The
+// consumption against them is reported with the user info.
+type Handler interface {
+	ListUsageLimits(ctx context.Context, author *entity.User) ([]*entity.UsageLimit, error)
+	SaveUsageLimit(ctx context.Context, author *entity.User, limit *entity.UsageLimit) (*entity.UsageLimit, error)
+	DeleteUsageLimit(ctx context.Context, author *entity.User, scope, subject string) error
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.limits",
+		slog.String("author", author.Username),
+		slog.String("role", author.Role),
+	)
+}
+
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListUsageLimits(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to list usage limits", err)
+			return
+		}
+		web.OK(w, r, log, "usage limits list", data)
+	}
+}
+
+// Save sets the limits of the user or group given in the body, replacing
+// earlier ones.
+func Save(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var limit entity.UsageLimit
+		if err := render.Bind(r, &limit); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode usage limit", err)
+			return
+		}
+		log = log.With(slog.String("scope", limit.Scope), slog.String("subject", limit.Subject))
+
+		data, err := h.SaveUsageLimit(ctx, author, &limit)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to save usage limit", err)
+			return
+		}
+		web.OK(w, r, log, "usage limit saved", data)
+	}
+}
+
+func Delete(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		scope := chi.URLParam(r, "scope")
+		subject := chi.URLParam(r, "subject")
+		log := loggerWith(logger, r, author).With(slog.String("scope", scope), slog.String("subject", subject))
+
+		if err := h.DeleteUsageLimit(ctx, author, scope, subject); err != nil {
+			web.Fail(w, r, log, 0, "Failed to delete usage limit", err)
+			return
+		}
+		web.OK(w, r, log, "usage limit deleted", map[string]any{"success": true})
+	}
+}
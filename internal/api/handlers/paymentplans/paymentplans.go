@@ -0,0 +1,139 @@
+package paymentplans
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+type Handler interface {
+	ListPaymentPlans(ctx context.Context, author *entity.User) ([]*entity.PaymentPlan, error)
+	GetPaymentPlan(ctx context.Context, author *entity.User, planId string) (*entity.PaymentPlan, error)
+	CreatePaymentPlan(ctx context.Context, author *entity.User, plan *entity.PaymentPlan) (*entity.PaymentPlan, error)
+	UpdatePaymentPlan(ctx context.Context, author *entity.User, plan *entity.PaymentPlan) (*entity.PaymentPlan, error)
+	ActivatePaymentPlan(ctx context.Context, author *entity.User, planId string) (*entity.PaymentPlan, error)
+	DeactivatePaymentPlan(ctx context.Context, author *entity.User, planId string, confirm bool) (*entity.PaymentPlanDeactivation, error)
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.paymentplans",
+		slog.String("author", author.Username),
+		slog.String("role", author.Role),
+	)
+}
+
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListPaymentPlans(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to list payment plans", err)
+			return
+		}
+		web.OK(w, r, log, "payment plans list", data)
+	}
+}
+
+func Get(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := loggerWith(logger, r, author).With(slog.String("id", id))
+
+		data, err := h.GetPaymentPlan(ctx, author, id)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to get payment plan", err)
+			return
+		}
+		web.OK(w, r, log, "payment plan info", data)
+	}
+}
+
+func Create(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var plan entity.PaymentPlan
+		if err := render.Bind(r, &plan); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode payment plan", err)
+			return
+		}
+
+		data, err := h.CreatePaymentPlan(ctx, author, &plan)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to create payment plan", err)
+			return
+		}
+		web.Created(w, r, log.With(slog.String("id", data.PlanId)), "payment plan created", data)
+	}
+}
+
+func Update(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		id := chi.URLParam(r, "id")
+		var plan entity.PaymentPlan
+		if err := render.Bind(r, &plan); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode payment plan", err)
+			return
+		}
+		plan.PlanId = id
+
+		data, err := h.UpdatePaymentPlan(ctx, author, &plan)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to update payment plan", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.String("id", id)), "payment plan updated", data)
+	}
+}
+
+func Activate(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := loggerWith(logger, r, author).With(slog.String("id", id))
+
+		data, err := h.ActivatePaymentPlan(ctx, author, id)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to activate payment plan", err)
+			return
+		}
+		web.OK(w, r, log, "payment plan activated", data)
+	}
+}
+
+// Deactivate lists the users assigned to the plan; with ?confirm=true it also
+// deactivates the plan.
+func Deactivate(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		confirm := r.URL.Query().Get("confirm") == "true"
+		log := loggerWith(logger, r, author).With(slog.String("id", id), slog.Bool("confirm", confirm))
+
+		data, err := h.DeactivatePaymentPlan(ctx, author, id, confirm)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to deactivate payment plan", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.Int("affected_users", len(data.AffectedUsers))), "payment plan deactivation", data)
+	}
+}
@@ -0,0 +1,125 @@
+package payments
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/request"
+	"evsys-back/internal/lib/api/web"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PaymentEvents is the handler dependency for the payment event log.
+type PaymentEvents interface {
+	GetPaymentEvents(ctx context.Context, author *entity.User, filter *entity.PaymentEventFilter) ([]*entity.PaymentEvent, error)
+	GetPaymentTimeline(ctx context.Context, author *entity.User, transactionId int) ([]*entity.PaymentEvent, error)
+}
+
+// EventList serves the payment events matching the query parameters for
+// power users: transaction_id, order, user_id, username, type, card_hash,
+// code, from, to and limit.
+func EventList(logger *slog.Logger, handler PaymentEvents) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.payments",
+			slog.String("user", author.Username),
+			sl.Secret("user_id", author.UserId),
+		)
+
+		filter, err := parseEventFilter(r)
+		if err != nil {
+			web.FailCode(w, r, log, 400, 400, "Invalid parameter", err)
+			return
+		}
+
+		data, err := handler.GetPaymentEvents(ctx, author, filter)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to read payment events", err)
+			return
+		}
+		web.OK(w, r, log, "payment events", data)
+	}
+}
+
+// EventTimeline serves the payment events of one transaction, oldest first,
+// for power users.
+func EventTimeline(logger *slog.Logger, handler PaymentEvents) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.payments",
+			slog.String("user", author.Username),
+			sl.Secret("user_id", author.UserId),
+		)
+
+		transactionId, err := strconv.Atoi(chi.URLParam(r, "transactionId"))
+		if err != nil || transactionId <= 0 {
+			web.FailCode(w, r, log, 400, 2002, "Invalid transaction id", err)
+			return
+		}
+		log = log.With(slog.Int("transaction_id", transactionId))
+
+		data, err := handler.GetPaymentTimeline(ctx, author, transactionId)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to read payment timeline", err)
+			return
+		}
+		web.OK(w, r, log, "payment timeline", data)
+	}
+}
+
+func parseEventFilter(r *http.Request) (*entity.PaymentEventFilter, error) {
+	query := r.URL.Query()
+	filter := &entity.PaymentEventFilter{
+		UserId:   query.Get("user_id"),
+		Username: query.Get("username"),
+		Type:     query.Get("type"),
+		CardHash: query.Get("card_hash"),
+		Code:     query.Get("code"),
+	}
+	numbers := []struct {
+		key   string
+		value *int
+	}{
+		{"transaction_id", &filter.TransactionId},
+		{"order", &filter.Order},
+	}
+	for _, n := range numbers {
+		if par := query.Get(n.key); par != "" {
+			v, err := strconv.Atoi(par)
+			if err != nil {
+				return nil, fmt.Errorf("%s=%s: cannot parse as number", n.key, par)
+			}
+			*n.value = v
+		}
+	}
+	if par := query.Get("limit"); par != "" {
+		limit, err := strconv.ParseInt(par, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("limit=%s: cannot parse as number", par)
+		}
+		filter.Limit = limit
+	}
+	if query.Get("from") != "" {
+		from, err := request.GetDate(r, "from")
+		if err != nil {
+			return nil, err
+		}
+		filter.From = &from
+	}
+	if query.Get("to") != "" {
+		to, err := request.GetDate(r, "to")
+		if err != nil {
+			return nil, err
+		}
+		filter.To = &to
+	}
+	return filter, nil
+}
@@ -0,0 +1,85 @@
+package payments
+
+import (
+	"context"
+	"encoding/csv"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/request"
+	"evsys-back/internal/lib/api/web"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Reconciliation is the handler dependency for the payment reconciliation report.
+type Reconciliation interface {
+	GetReconciliationReport(ctx context.Context, author *entity.User, from, to time.Time) (*entity.ReconciliationReport, error)
+}
+
+// ReconciliationReport serves the reconciliation report of the from-to period
+// for power users, as JSON or, with format=csv, as a CSV download of the
+// discrepancies.
+func ReconciliationReport(logger *slog.Logger, handler Reconciliation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.payments",
+			slog.String("user", author.Username),
+			sl.Secret("user_id", author.UserId),
+		)
+
+		from, err := request.GetDate(r, "from")
+		if err != nil {
+			web.FailCode(w, r, log, 400, 400, "Invalid parameter", err)
+			return
+		}
+		to, err := request.GetDate(r, "to")
+		if err != nil {
+			web.FailCode(w, r, log, 400, 400, "Invalid parameter", err)
+			return
+		}
+		log = log.With(slog.Time("from", from), slog.Time("to", to))
+
+		report, err := handler.GetReconciliationReport(ctx, author, from, to)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to reconcile payments", err)
+			return
+		}
+		log = log.With(slog.Int("discrepancies", len(report.Discrepancies)))
+
+		if r.URL.Query().Get("format") != "csv" {
+			web.OK(w, r, log, "payment reconciliation", report)
+			return
+		}
+		log.Info("payment reconciliation csv")
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"reconciliation-%s-%s.csv\"",
+			from.Format(time.DateOnly), to.Format(time.DateOnly)))
+		if err = writeReconciliationCSV(w, report); err != nil {
+			log.With(sl.Err(err)).Error("write reconciliation csv")
+		}
+	}
+}
+
+func writeReconciliationCSV(w io.Writer, report *entity.ReconciliationReport) error {
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"category", "transaction_id", "order", "expected", "actual", "detail", "action"})
+	for _, d := range report.Discrepancies {
+		_ = out.Write([]string{
+			d.Category,
+			strconv.Itoa(d.TransactionId),
+			strconv.Itoa(d.Order),
+			strconv.Itoa(d.Expected),
+			strconv.Itoa(d.Actual),
+			d.Detail,
+			d.Action,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
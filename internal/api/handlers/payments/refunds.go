@@ -0,0 +1,82 @@
+package payments
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"evsys-back/internal/lib/sl"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Refunds is the handler dependency for the refund ledger.
+type Refunds interface {
+	ListRefunds(ctx context.Context, author *entity.User, transactionId int) ([]*entity.Refund, error)
+	IssueRefund(ctx context.Context, author *entity.User, transactionId int, req *entity.RefundOrderRequest) (*entity.Refund, error)
+}
+
+// RefundList serves the refund ledger of a transaction for power users.
+func RefundList(logger *slog.Logger, handler Refunds) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.payments",
+			slog.String("user", author.Username),
+			sl.Secret("user_id", author.UserId),
+		)
+
+		idStr := chi.URLParam(r, "transactionId")
+		transactionId, err := strconv.Atoi(idStr)
+		if err != nil || transactionId <= 0 {
+			web.FailCode(w, r, log, 400, 2002, "Invalid transaction id", err)
+			return
+		}
+		log = log.With(slog.Int("transaction_id", transactionId))
+
+		data, err := handler.ListRefunds(ctx, author, transactionId)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to read refunds", err)
+			return
+		}
+		web.OK(w, r, log, "refunds list", data)
+	}
+}
+
+// RefundIssue refunds part of a transaction's payment for power users.
+// Expects JSON body: {"amount": 500, "reason": "billing_error", "note": "...", "order": 1234}
+func RefundIssue(logger *slog.Logger, handler Refunds) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.payments",
+			slog.String("user", author.Username),
+			sl.Secret("user_id", author.UserId),
+		)
+
+		idStr := chi.URLParam(r, "transactionId")
+		transactionId, err := strconv.Atoi(idStr)
+		if err != nil || transactionId <= 0 {
+			web.FailCode(w, r, log, 400, 2002, "Invalid transaction id", err)
+			return
+		}
+		log = log.With(slog.Int("transaction_id", transactionId))
+
+		var req entity.RefundOrderRequest
+		if err = render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode refund request", err)
+			return
+		}
+
+		data, err := handler.IssueRefund(ctx, author, transactionId, &req)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to issue refund", err)
+			return
+		}
+		web.Created(w, r, log.With(slog.Int("order", data.Order), slog.Int("amount", data.Amount)), "refund issued", data)
+	}
+}
@@ -0,0 +1,105 @@
+package promotions
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// Handler is the handler dependency for promotion codes. Discounts of
+// redeemed codes are taken off sessions at billing.
+type Handler interface {
+	ListPromotions(ctx context.Context, author *entity.User) ([]*entity.Promotion, error)
+	SavePromotion(ctx context.Context, author *entity.User, promotion *entity.Promotion) (*entity.Promotion, error)
+	RedeemPromotion(ctx context.Context, author *entity.User, code string) (*entity.PromotionRedemption, error)
+	GetPromotionRedemptions(ctx context.Context, author *entity.User) ([]*entity.PromotionRedemption, error)
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.promotions",
+		slog.String("author", author.Username),
+		slog.String("role", author.Role),
+	)
+}
+
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListPromotions(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to list promotions", err)
+			return
+		}
+		web.OK(w, r, log, "promotions list", data)
+	}
+}
+
+// Save creates a promotion or updates the one with the same code.
+func Save(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var promotion entity.Promotion
+		if err := render.Bind(r, &promotion); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode promotion", err)
+			return
+		}
+		log = log.With(slog.String("code", promotion.Code))
+
+		data, err := h.SavePromotion(ctx, author, &promotion)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to save promotion", err)
+			return
+		}
+		web.OK(w, r, log, "promotion saved", data)
+	}
+}
+
+// Redeem redeems a promotion code for the current user.
+func Redeem(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var req entity.PromotionCode
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode promotion code", err)
+			return
+		}
+		log = log.With(slog.String("code", req.Code))
+
+		data, err := h.RedeemPromotion(ctx, author, req.Code)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to redeem promotion", err)
+			return
+		}
+		web.Created(w, r, log, "promotion redeemed", data)
+	}
+}
+
+// Redemptions lists the promotion codes redeemed by the current user.
+func Redemptions(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.GetPromotionRedemptions(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to get promotion redemptions", err)
+			return
+		}
+		web.OK(w, r, log, "promotion redemptions", data)
+	}
+}
@@ -0,0 +1,101 @@
+package roles
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Handler is the handler dependency for roles, the named sets of permissions
+// users are assigned.
+type Handler interface {
+	ListRoles(ctx context.Context, author *entity.User) ([]*entity.Role, error)
+	ListPermissions(ctx context.Context, author *entity.User) ([]string, error)
+	SaveRole(ctx context.Context, author *entity.User, role *entity.Role) (*entity.Role, error)
+	DeleteRole(ctx context.Context, author *entity.User, name string) error
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.roles",
+		slog.String("author", author.Username),
+		slog.String("role", author.Role),
+	)
+}
+
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListRoles(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to list roles", err)
+			return
+		}
+		web.OK(w, r, log, "roles list", data)
+	}
+}
+
+// Permissions serves the permissions a role can grant.
+func Permissions(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListPermissions(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to list permissions", err)
+			return
+		}
+		web.OK(w, r, log, "permissions list", data)
+	}
+}
+
+// Save creates a role or replaces the one with the same name.
+func Save(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var role entity.Role
+		if err := render.Bind(r, &role); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode role", err)
+			return
+		}
+		log = log.With(slog.String("name", role.Name))
+
+		data, err := h.SaveRole(ctx, author, &role)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to save role", err)
+			return
+		}
+		web.OK(w, r, log, "role saved", data)
+	}
+}
+
+// Delete removes a stored role; a built-in role returns to its defaults.
+func Delete(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		name := chi.URLParam(r, "name")
+		log := loggerWith(logger, r, author).With(slog.String("name", name))
+
+		if err := h.DeleteRole(ctx, author, name); err != nil {
+			web.Fail(w, r, log, 0, "Failed to delete role", err)
+			return
+		}
+		web.OK(w, r, log, "role deleted", map[string]any{
+			"success": true,
+		})
+	}
+}
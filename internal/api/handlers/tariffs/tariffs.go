@@ -0,0 +1,160 @@
+package tariffs
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+type Handler interface {
+	ListTariffs(ctx context.Context, author *entity.User) ([]*entity.Tariff, error)
+	GetTariff(ctx context.Context, author *entity.User, id string) (*entity.Tariff, error)
+	CreateTariff(ctx context.Context, author *entity.User, tariff *entity.Tariff) (*entity.Tariff, error)
+	SaveTariff(ctx context.Context, author *entity.User, tariff *entity.Tariff) (*entity.Tariff, error)
+	DeleteTariff(ctx context.Context, author *entity.User, id string) error
+	GetChargePointTariff(ctx context.Context, user *entity.User, chargePointId string) (*entity.Tariff, error)
+	SimulatePrice(ctx context.Context, author *entity.User, req *entity.PriceSimulationRequest) (*entity.PriceBreakdown, error)
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.tariffs",
+		slog.String("author", author.Username),
+		slog.String("role", author.Role),
+	)
+}
+
+func List(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		data, err := h.ListTariffs(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to list tariffs", err)
+			return
+		}
+		web.OK(w, r, log, "tariffs list", data)
+	}
+}
+
+func Get(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := loggerWith(logger, r, author).With(slog.String("id", id))
+
+		data, err := h.GetTariff(ctx, author, id)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to get tariff", err)
+			return
+		}
+		web.OK(w, r, log, "tariff info", data)
+	}
+}
+
+func Create(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var tariff entity.Tariff
+		if err := render.Bind(r, &tariff); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode tariff", err)
+			return
+		}
+
+		data, err := h.CreateTariff(ctx, author, &tariff)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to create tariff", err)
+			return
+		}
+		web.Created(w, r, log.With(slog.String("id", data.TariffId)), "tariff created", data)
+	}
+}
+
+func Update(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		id := chi.URLParam(r, "id")
+		var tariff entity.Tariff
+		if err := render.Bind(r, &tariff); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode tariff", err)
+			return
+		}
+		tariff.TariffId = id
+
+		data, err := h.SaveTariff(ctx, author, &tariff)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to update tariff", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.String("id", id)), "tariff updated", data)
+	}
+}
+
+func Delete(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		id := chi.URLParam(r, "id")
+		if err := h.DeleteTariff(ctx, author, id); err != nil {
+			web.Fail(w, r, log, 0, "Failed to delete tariff", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.String("id", id)), "tariff deleted", map[string]any{"success": true})
+	}
+}
+
+// ChargePointTariff returns the tariff in effect at a charge point; available
+// to every authenticated user.
+func ChargePointTariff(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := loggerWith(logger, r, user).With(slog.String("charge_point_id", id))
+
+		data, err := h.GetChargePointTariff(ctx, user, id)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to get charge point tariff", err)
+			return
+		}
+		web.OK(w, r, log, "charge point tariff", data)
+	}
+}
+
+// Simulate prices a hypothetical session, returning the itemized breakdown.
+func Simulate(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var req entity.PriceSimulationRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode simulation request", err)
+			return
+		}
+
+		data, err := h.SimulatePrice(ctx, author, &req)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to simulate price", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.String("tariff_id", data.TariffId), slog.Int("total", data.Total)), "price simulated", data)
+	}
+}
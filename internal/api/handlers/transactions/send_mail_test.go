@@ -65,6 +65,10 @@ func (s *stubTransactions) GetTransactionReceipt(_ context.Context, author *enti
 	return s.receipt, s.receiptErr
 }
 
+func (s *stubTransactions) PriceTransaction(context.Context, *entity.User, int) (*entity.PriceBreakdown, error) {
+	return nil, nil
+}
+
 // router mounts both handlers on the real chi routes so {id} is populated the
 // same way it is in production.
 func router(h *stubTransactions) *chi.Mux {
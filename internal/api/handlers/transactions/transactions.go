@@ -24,6 +24,7 @@ type Transactions interface {
 	GetRecentChargePoints(ctx context.Context, userId string) (any, error)
 	SendTransactionMail(ctx context.Context, author *entity.User, transactionId int, to string) error
 	GetTransactionReceipt(ctx context.Context, author *entity.User, transactionId int) (string, error)
+	PriceTransaction(ctx context.Context, author *entity.User, transactionId int) (*entity.PriceBreakdown, error)
 }
 
 type sendMailRequest struct {
@@ -61,7 +62,7 @@ func List(logger *slog.Logger, handler Transactions) http.HandlerFunc {
 
 		// Check for query parameters (new filtering for power users)
 		filter := parseTransactionFilter(r)
-		if user.IsPowerUser() && filter.HasFilters() {
+		if user.Can(entity.PermissionTransactionsRead) && filter.HasFilters() {
 			data, err := handler.GetFilteredTransactions(ctx, user, filter)
 			if err != nil {
 				web.Fail(w, r, log, 400, "Failed to read transactions", err)
@@ -216,6 +217,33 @@ func Receipt(logger *slog.Logger, handler Transactions) http.HandlerFunc {
 	}
 }
 
+// Price returns the backend's itemized price calculation for a transaction.
+func Price(logger *slog.Logger, handler Transactions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := web.Log(ctx, logger, "handlers.transactions",
+			slog.String("user", user.Username),
+			sl.Secret("user_id", user.UserId),
+			slog.String("id", id),
+		)
+
+		transactionId, err := strconv.Atoi(id)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to parse transaction id", err)
+			return
+		}
+
+		data, err := handler.PriceTransaction(ctx, user, transactionId)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to calculate transaction price", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.Bool("mismatch", data.Mismatch)), "transaction price", data)
+	}
+}
+
 func RecentUserChargePoints(logger *slog.Logger, handler Transactions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
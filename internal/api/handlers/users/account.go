@@ -0,0 +1,106 @@
+package users
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// Account is the handler dependency for password resets and email verification.
+type Account interface {
+	ForgotPassword(ctx context.Context, login string) error
+	ResetPassword(ctx context.Context, req *entity.PasswordResetRequest) error
+	VerifyEmail(ctx context.Context, req *entity.EmailVerifyRequest) error
+	ResendEmailVerification(ctx context.Context, author *entity.User) error
+}
+
+// PasswordForgot emails a password reset link; the response does not tell
+// whether the account exists.
+func PasswordForgot(logger *slog.Logger, handler Account) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := web.Log(ctx, logger, "handlers.users")
+
+		var req entity.PasswordForgotRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode request", err)
+			return
+		}
+
+		if err := handler.ForgotPassword(ctx, req.Login); err != nil {
+			web.Fail(w, r, log, 500, "Failed to request password reset", err)
+			return
+		}
+		web.OK(w, r, log, "password reset requested", map[string]any{
+			"success": true,
+		})
+	}
+}
+
+// PasswordReset sets a new password with the token of a reset link.
+func PasswordReset(logger *slog.Logger, handler Account) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := web.Log(ctx, logger, "handlers.users")
+
+		var req entity.PasswordResetRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode request", err)
+			return
+		}
+
+		if err := handler.ResetPassword(ctx, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to reset password", err)
+			return
+		}
+		web.OK(w, r, log, "password reset", map[string]any{
+			"success": true,
+		})
+	}
+}
+
+// EmailVerify confirms an email address with the token of a verification link.
+func EmailVerify(logger *slog.Logger, handler Account) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := web.Log(ctx, logger, "handlers.users")
+
+		var req entity.EmailVerifyRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode request", err)
+			return
+		}
+
+		if err := handler.VerifyEmail(ctx, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to verify email", err)
+			return
+		}
+		web.OK(w, r, log, "email verified", map[string]any{
+			"success": true,
+		})
+	}
+}
+
+// EmailVerifyResend emails a new verification link to the requesting user.
+func EmailVerifyResend(logger *slog.Logger, handler Account) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.users",
+			slog.String("author", author.Username),
+		)
+
+		if err := handler.ResendEmailVerification(ctx, author); err != nil {
+			web.Fail(w, r, log, 400, "Failed to send email verification", err)
+			return
+		}
+		web.OK(w, r, log, "email verification sent", map[string]any{
+			"success": true,
+		})
+	}
+}
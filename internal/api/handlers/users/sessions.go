@@ -0,0 +1,105 @@
+package users
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Sessions is the handler dependency for the login sessions of users.
+type Sessions interface {
+	RefreshSession(ctx context.Context, refreshToken string) (*entity.SessionTokens, error)
+	GetSessions(ctx context.Context, author *entity.User) ([]*entity.Session, error)
+	RevokeSession(ctx context.Context, author *entity.User, sessionId string) error
+	RevokeUserSessions(ctx context.Context, author *entity.User, username string) (int, error)
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token.
+func Refresh(logger *slog.Logger, handler Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := web.Log(ctx, logger, "handlers.users")
+
+		var req entity.RefreshRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode refresh request", err)
+			return
+		}
+
+		data, err := handler.RefreshSession(ctx, req.RefreshToken)
+		if err != nil {
+			web.Fail(w, r, log, 401, "Not authorized", err)
+			return
+		}
+		web.OK(w, r, log, "session refreshed", data)
+	}
+}
+
+// SessionList serves the sessions of the requesting user.
+func SessionList(logger *slog.Logger, handler Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.users",
+			slog.String("author", author.Username),
+		)
+
+		data, err := handler.GetSessions(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to get sessions", err)
+			return
+		}
+		web.OK(w, r, log, "sessions list", data)
+	}
+}
+
+// SessionRevoke ends one session of the requesting user.
+func SessionRevoke(logger *slog.Logger, handler Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		id := chi.URLParam(r, "id")
+		log := web.Log(ctx, logger, "handlers.users",
+			slog.String("author", author.Username),
+			slog.String("session", id),
+		)
+
+		err := handler.RevokeSession(ctx, author, id)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to revoke session", err)
+			return
+		}
+		web.OK(w, r, log, "session revoked", map[string]any{
+			"success": true,
+		})
+	}
+}
+
+// SessionRevokeAll ends all sessions of a user; for power users.
+func SessionRevokeAll(logger *slog.Logger, handler Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		username := chi.URLParam(r, "username")
+		log := web.Log(ctx, logger, "handlers.users",
+			slog.String("author", author.Username),
+			slog.String("target_user", username),
+		)
+
+		count, err := handler.RevokeUserSessions(ctx, author, username)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to revoke sessions", err)
+			return
+		}
+		web.OK(w, r, log, "user sessions revoked", map[string]any{
+			"success": true,
+			"revoked": count,
+		})
+	}
+}
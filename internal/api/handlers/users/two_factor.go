@@ -0,0 +1,111 @@
+package users
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// TwoFactor is the handler dependency for the TOTP second factor of users.
+type TwoFactor interface {
+	EnrollTwoFactor(ctx context.Context, author *entity.User) (*entity.TwoFactorEnrollment, error)
+	ConfirmTwoFactor(ctx context.Context, author *entity.User, code string) (*entity.TwoFactorRecovery, error)
+	DisableTwoFactor(ctx context.Context, author *entity.User, code string) error
+	ResetTwoFactor(ctx context.Context, author *entity.User, username string) error
+}
+
+// TwoFactorEnroll generates a TOTP secret for the requesting user; it is
+// enabled by TwoFactorConfirm.
+func TwoFactorEnroll(logger *slog.Logger, handler TwoFactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.users",
+			slog.String("author", author.Username),
+		)
+
+		data, err := handler.EnrollTwoFactor(ctx, author)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to enroll two-factor", err)
+			return
+		}
+		web.OK(w, r, log, "two-factor enrolled", data)
+	}
+}
+
+// TwoFactorConfirm enables the enrolled second factor with its first code
+// and serves the recovery codes.
+func TwoFactorConfirm(logger *slog.Logger, handler TwoFactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.users",
+			slog.String("author", author.Username),
+		)
+
+		var req entity.TwoFactorCodeRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode request", err)
+			return
+		}
+
+		data, err := handler.ConfirmTwoFactor(ctx, author, req.Code)
+		if err != nil {
+			web.Fail(w, r, log, 400, "Failed to confirm two-factor", err)
+			return
+		}
+		web.OK(w, r, log, "two-factor enabled", data)
+	}
+}
+
+// TwoFactorDisable removes the second factor of the requesting user.
+func TwoFactorDisable(logger *slog.Logger, handler TwoFactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := web.Log(ctx, logger, "handlers.users",
+			slog.String("author", author.Username),
+		)
+
+		var req entity.TwoFactorCodeRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode request", err)
+			return
+		}
+
+		if err := handler.DisableTwoFactor(ctx, author, req.Code); err != nil {
+			web.Fail(w, r, log, 400, "Failed to disable two-factor", err)
+			return
+		}
+		web.OK(w, r, log, "two-factor disabled", map[string]any{
+			"success": true,
+		})
+	}
+}
+
+// TwoFactorReset removes the second factor of a user who lost it; for power users.
+func TwoFactorReset(logger *slog.Logger, handler TwoFactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		username := chi.URLParam(r, "username")
+		log := web.Log(ctx, logger, "handlers.users",
+			slog.String("author", author.Username),
+			slog.String("target_user", username),
+		)
+
+		if err := handler.ResetTwoFactor(ctx, author, username); err != nil {
+			web.Fail(w, r, log, 0, "Failed to reset two-factor", err)
+			return
+		}
+		web.OK(w, r, log, "two-factor reset", map[string]any{
+			"success": true,
+		})
+	}
+}
@@ -14,7 +14,8 @@ import (
 
 type Users interface {
 	AuthenticateByToken(ctx context.Context, token string) (*entity.User, error)
-	AuthenticateUser(ctx context.Context, username, password string) (*entity.User, error)
+	AuthenticateUser(ctx context.Context, username, password, device string) (*entity.User, error)
+	AuthenticateTwoFactor(ctx context.Context, challenge, code, device string) (*entity.User, error)
 	AddUser(ctx context.Context, user *entity.User) (*entity.User, error)
 	GetUser(ctx context.Context, author *entity.User, username string) (*entity.UserInfo, error)
 	GetUsers(ctx context.Context, user *entity.User) ([]*entity.User, error)
@@ -23,6 +24,8 @@ type Users interface {
 	DeleteUser(ctx context.Context, author *entity.User, username string) error
 }
 
+// Authenticate logs in by token or password; a user with a second factor is
+// answered with a challenge, sent back with the code to complete the login.
 func Authenticate(logger *slog.Logger, handler Users) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -37,10 +40,13 @@ func Authenticate(logger *slog.Logger, handler Users) http.HandlerFunc {
 
 		var data any
 		var err error
-		if user.Username == "" {
+		switch {
+		case user.TwoFactorChallenge != "":
+			data, err = handler.AuthenticateTwoFactor(ctx, user.TwoFactorChallenge, user.TwoFactorCode, r.UserAgent())
+		case user.Username == "":
 			data, err = handler.AuthenticateByToken(ctx, user.Password)
-		} else {
-			data, err = handler.AuthenticateUser(ctx, user.Username, user.Password)
+		default:
+			data, err = handler.AuthenticateUser(ctx, user.Username, user.Password, r.UserAgent())
 		}
 		if err != nil {
 			web.Fail(w, r, log, 401, "Not authorized", err)
@@ -0,0 +1,69 @@
+package wallet
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/web"
+	"evsys-back/internal/lib/sl"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Handler is the handler dependency for prepaid wallets. Top-ups are paid
+// through POST /payment/order with mode "web" and purpose "top_up".
+type Handler interface {
+	GetWallet(ctx context.Context, author *entity.User, username string) (*entity.Wallet, error)
+	CreditWallet(ctx context.Context, author *entity.User, req *entity.WalletCreditRequest) (*entity.WalletEntry, error)
+}
+
+func loggerWith(logger *slog.Logger, r *http.Request, author *entity.User) *slog.Logger {
+	return web.Log(r.Context(), logger, "handlers.wallet",
+		slog.String("user", author.Username),
+		sl.Secret("user_id", author.UserId),
+	)
+}
+
+// Get returns the wallet of the current user, or of the user named in the
+// path for power users.
+func Get(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		username := chi.URLParam(r, "username")
+		log := loggerWith(logger, r, author).With(slog.String("username", username))
+
+		data, err := h.GetWallet(ctx, author, username)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to get wallet", err)
+			return
+		}
+		web.OK(w, r, log.With(slog.Int("balance", data.Balance)), "wallet info", data)
+	}
+}
+
+// Credit adds an operator's credit to a user's wallet.
+func Credit(logger *slog.Logger, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		author := cont.GetUser(ctx)
+		log := loggerWith(logger, r, author)
+
+		var req entity.WalletCreditRequest
+		if err := render.Bind(r, &req); err != nil {
+			web.Fail(w, r, log, 400, "Failed to decode wallet credit", err)
+			return
+		}
+		log = log.With(slog.String("username", req.Username), slog.Int("amount", req.Amount))
+
+		data, err := h.CreditWallet(ctx, author, &req)
+		if err != nil {
+			web.Fail(w, r, log, 0, "Failed to credit wallet", err)
+			return
+		}
+		web.Created(w, r, log.With(slog.Int("balance", data.Balance)), "wallet credited", data)
+	}
+}
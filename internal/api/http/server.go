@@ -3,19 +3,31 @@ package http
 import (
 	"context"
 	"evsys-back/config"
+	"evsys-back/entity"
+	"evsys-back/internal/api/handlers/apikeys"
 	centralsystem "evsys-back/internal/api/handlers/central-system"
+	"evsys-back/internal/api/handlers/groups"
 	"evsys-back/internal/api/handlers/helper"
+	"evsys-back/internal/api/handlers/invites"
+	"evsys-back/internal/api/handlers/invoices"
+	"evsys-back/internal/api/handlers/limits"
 	"evsys-back/internal/api/handlers/locations"
 	"evsys-back/internal/api/handlers/mail"
+	"evsys-back/internal/api/handlers/paymentplans"
 	"evsys-back/internal/api/handlers/payments"
+	"evsys-back/internal/api/handlers/promotions"
 	"evsys-back/internal/api/handlers/report"
+	"evsys-back/internal/api/handlers/roles"
+	"evsys-back/internal/api/handlers/tariffs"
 	"evsys-back/internal/api/handlers/transactions"
 	"evsys-back/internal/api/handlers/users"
 	"evsys-back/internal/api/handlers/usertags"
+	"evsys-back/internal/api/handlers/wallet"
 	"evsys-back/internal/api/handlers/webhooks"
 	"evsys-back/internal/api/middleware/apikey"
 	"evsys-back/internal/api/middleware/authenticate"
 	"evsys-back/internal/api/middleware/authorize"
+	"evsys-back/internal/api/middleware/idempotency"
 	"evsys-back/internal/api/middleware/timeout"
 	"evsys-back/internal/api/websocket"
 	"evsys-back/internal/lib/sl"
@@ -46,6 +58,9 @@ type Core interface {
 	helper.Helper
 	authenticate.Authenticate
 	users.Users
+	users.Sessions
+	users.Account
+	users.TwoFactor
 	usertags.UserTags
 	locations.Locations
 	centralsystem.CentralSystem
@@ -54,9 +69,24 @@ type Core interface {
 	payments.Preauthorizations
 	payments.DirectPayments
 	payments.RetryQueue
+	payments.Refunds
+	payments.Reconciliation
+	payments.PaymentEvents
 	report.Reports
 	mail.Handler
 	webhooks.Handler
+	tariffs.Handler
+	paymentplans.Handler
+	invoices.Handler
+	wallet.Handler
+	limits.Handler
+	groups.Handler
+	promotions.Handler
+	roles.Handler
+	invites.Handler
+	apikeys.Handler
+	apikey.Authenticator
+	idempotency.Store
 
 	websocket.Core
 }
@@ -74,6 +104,11 @@ func NewServer(conf *config.Config, log *slog.Logger, core Core) *Server {
 		},
 	}
 
+	// the pool runs from the start, so notices sent before the server
+	// listens do not block
+	server.pool = websocket.NewPool(server.log)
+	go server.pool.Start()
+
 	router := chi.NewRouter()
 	router.Use(timeout.Timeout(5))
 	router.Use(middleware.RequestID)
@@ -97,23 +132,67 @@ func NewServer(conf *config.Config, log *slog.Logger, core Core) *Server {
 			r.Get("/chp/{search}", locations.ListChargePoints(log, core))
 			r.Get("/point/{id}", locations.ChargePointRead(log, core))
 			r.Post("/point/{id}", locations.ChargePointSave(log, core))
+			r.Get("/point/{id}/tariff", tariffs.ChargePointTariff(log, core))
 
 			r.Get("/users/info/{name}", users.Info(log, core))
 			r.Get("/users/list", users.List(log, core))
+			r.Get("/users/sessions", users.SessionList(log, core))
+			r.Delete("/users/sessions/{id}", users.SessionRevoke(log, core))
+			r.Post("/users/email/verify/resend", users.EmailVerifyResend(log, core))
+			r.Post("/users/2fa/enroll", users.TwoFactorEnroll(log, core))
+			r.Post("/users/2fa/confirm", users.TwoFactorConfirm(log, core))
+			r.Post("/users/2fa/disable", users.TwoFactorDisable(log, core))
+			r.Put("/users/fiscal", invoices.Fiscal(log, core))
+
+			r.Get("/invoices", invoices.List(log, core))
+			r.Get("/invoices/{id}", invoices.Download(log, core))
+
+			r.Get("/wallet", wallet.Get(log, core))
+
+			r.Get("/promotions/redeemed", promotions.Redemptions(log, core))
+			r.Post("/promotions/redeem", promotions.Redeem(log, core))
 
-			// power user only routes
+			// routes for roles granting the permission
 			r.Group(func(r chi.Router) {
-				r.Use(authorize.RequirePowerUser(log))
+				r.Use(authorize.Require(log, entity.PermissionUsersWrite))
 
 				r.Post("/users/create", users.Create(log, core))
 				r.Put("/users/update/{username}", users.Update(log, core))
 				r.Delete("/users/delete/{username}", users.Delete(log, core))
+				r.Delete("/users/sessions/user/{username}", users.SessionRevokeAll(log, core))
+				r.Delete("/users/2fa/{username}", users.TwoFactorReset(log, core))
+
+				r.Get("/invites", invites.List(log, core))
+				r.Post("/invites", invites.Generate(log, core))
+				r.Delete("/invites/{code}", invites.Revoke(log, core))
 
 				r.Get("/user-tags/list", usertags.List(log, core))
 				r.Get("/user-tags/info/{idTag}", usertags.Info(log, core))
 				r.Post("/user-tags/create", usertags.Create(log, core))
 				r.Put("/user-tags/update/{idTag}", usertags.Update(log, core))
 				r.Delete("/user-tags/delete/{idTag}", usertags.Delete(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionRolesWrite))
+
+				r.Get("/roles", roles.List(log, core))
+				r.Get("/roles/permissions", roles.Permissions(log, core))
+				r.Put("/roles", roles.Save(log, core))
+				r.Delete("/roles/{name}", roles.Delete(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionApiKeysWrite))
+
+				r.Get("/api-keys", apikeys.List(log, core))
+				r.Post("/api-keys", apikeys.Create(log, core))
+				r.Post("/api-keys/{id}/rotate", apikeys.Rotate(log, core))
+				r.Delete("/api-keys/{id}", apikeys.Revoke(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionMailWrite))
 
 				r.Get("/mail/subscriptions", mail.List(log, core))
 				r.Post("/mail/subscriptions", mail.Create(log, core))
@@ -121,9 +200,35 @@ func NewServer(conf *config.Config, log *slog.Logger, core Core) *Server {
 				r.Delete("/mail/subscriptions/{id}", mail.Delete(log, core))
 				r.Post("/mail/subscriptions/{id}/send-now", mail.SendNow(log, core))
 				r.Post("/mail/test", mail.Test(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionPaymentsRead))
 
 				r.Get("/payment/retries", payments.RetryQueueList(log, core))
+				r.Get("/payment/refunds/{transactionId}", payments.RefundList(log, core))
+				r.Get("/payment/reconciliation", payments.ReconciliationReport(log, core))
+				r.Get("/payment/events", payments.EventList(log, core))
+				r.Get("/payment/events/transaction/{transactionId}", payments.EventTimeline(log, core))
+
+				r.Get("/invoices/period", invoices.Period(log, core))
+				r.Get("/wallet/{username}", wallet.Get(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionPaymentsWrite))
+
 				r.Post("/payment/retries/{transactionId}/force", payments.RetryQueueForce(log, core))
+				r.Post("/invoices/transaction/{transactionId}", invoices.IssueForTransaction(log, core))
+				r.Post("/wallet/credit", wallet.Credit(log, core))
+				r.Post("/groups/{name}/bill", groups.Bill(log, core))
+			})
+
+			r.With(authorize.Require(log, entity.PermissionPaymentsRefund)).
+				Post("/payment/refunds/{transactionId}", payments.RefundIssue(log, core))
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionWebhooksWrite))
 
 				r.Get("/webhooks/subscribers", webhooks.List(log, core))
 				r.Post("/webhooks/subscribers", webhooks.Create(log, core))
@@ -133,6 +238,52 @@ func NewServer(conf *config.Config, log *slog.Logger, core Core) *Server {
 				r.Get("/webhooks/failures", webhooks.Failures(log, core))
 			})
 
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionTariffsWrite))
+
+				r.Get("/tariffs", tariffs.List(log, core))
+				r.Get("/tariffs/{id}", tariffs.Get(log, core))
+				r.Post("/tariffs", tariffs.Create(log, core))
+				r.Put("/tariffs/{id}", tariffs.Update(log, core))
+				r.Delete("/tariffs/{id}", tariffs.Delete(log, core))
+				r.Post("/tariffs/simulate", tariffs.Simulate(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionPlansWrite))
+
+				r.Get("/payment-plans", paymentplans.List(log, core))
+				r.Get("/payment-plans/{id}", paymentplans.Get(log, core))
+				r.Post("/payment-plans", paymentplans.Create(log, core))
+				r.Put("/payment-plans/{id}", paymentplans.Update(log, core))
+				r.Post("/payment-plans/{id}/activate", paymentplans.Activate(log, core))
+				r.Post("/payment-plans/{id}/deactivate", paymentplans.Deactivate(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionLimitsWrite))
+
+				r.Get("/limits", limits.List(log, core))
+				r.Put("/limits", limits.Save(log, core))
+				r.Delete("/limits/{scope}/{subject}", limits.Delete(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionGroupsWrite))
+
+				r.Get("/groups", groups.List(log, core))
+				r.Put("/groups", groups.Save(log, core))
+				r.Get("/groups/{name}/statements", groups.Statements(log, core))
+				r.Get("/groups/statements/{id}", groups.Statement(log, core))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionPromotionsWrite))
+
+				r.Get("/promotions", promotions.List(log, core))
+				r.Put("/promotions", promotions.Save(log, core))
+			})
+
 			r.Post("/csc", centralsystem.Command(log, core))
 
 			r.Get("/transactions/active", transactions.ListActive(log, core))
@@ -142,6 +293,7 @@ func NewServer(conf *config.Config, log *slog.Logger, core Core) *Server {
 			r.Get("/transactions/info/{id}", transactions.Get(log, core))
 			r.Post("/transactions/info/{id}/email", transactions.SendMail(log, core))
 			r.Get("/transactions/info/{id}/receipt", transactions.Receipt(log, core))
+			r.Get("/transactions/info/{id}/price", transactions.Price(log, core))
 			//router.Get("/transactions/bill", s.transactionBill)
 
 			r.Get("/payment/methods", payments.List(log, core))
@@ -150,33 +302,61 @@ func NewServer(conf *config.Config, log *slog.Logger, core Core) *Server {
 			r.Post("/payment/delete", payments.Delete(log, core))
 			r.Post("/payment/order", payments.Order(log, core))
 
-			r.Get("/report/month", report.MonthlyStatistics(log, core))
-			r.Get("/report/user", report.UsersStatistics(log, core))
-			r.Get("/report/charger", report.ChargerStatistics(log, core))
-			r.Get("/report/export", report.ExportStatistics(log, core))
-			r.Get("/report/power", report.PowerStatistics(log, core))
-			r.Get("/report/uptime", report.StationUptimeStatistics(log, core))
-			r.Get("/report/status", report.StationStatusStatistics(log, core))
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.PermissionReportsRead))
+
+				r.Get("/report/month", report.MonthlyStatistics(log, core))
+				r.Get("/report/user", report.UsersStatistics(log, core))
+				r.Get("/report/charger", report.ChargerStatistics(log, core))
+				r.Get("/report/export", report.ExportStatistics(log, core))
+				r.Get("/report/power", report.PowerStatistics(log, core))
+				r.Get("/report/uptime", report.StationUptimeStatistics(log, core))
+				r.Get("/report/status", report.StationStatusStatistics(log, core))
+			})
 
 			r.Get("/log/{name}", helper.Log(log, core))
 		})
 
-		// service-to-service payment endpoints (API key auth)
-		if conf.Redsys.ApiKey != "" {
+		// service-to-service endpoints (API key auth), each route requiring
+		// a scope of the key
+		r.Group(func(r chi.Router) {
+			r.Use(apikey.New(log, core, conf.Redsys.ApiKey))
+
 			r.Group(func(r chi.Router) {
-				r.Use(apikey.New(log, conf.Redsys.ApiKey))
+				r.Use(idempotency.New(log, core))
+
+				r.With(authorize.Require(log, entity.ScopePaymentsPay)).
+					Get("/payment/pay/{transactionId}", payments.Pay(log, core))
+				r.Group(func(r chi.Router) {
+					r.Use(authorize.Require(log, entity.ScopePaymentsRefund))
 
-				r.Get("/payment/pay/{transactionId}", payments.Pay(log, core))
-				r.Get("/payment/return/{transactionId}", payments.Return(log, core))
-				r.Post("/payment/return/order/{orderId}", payments.ReturnByOrder(log, core))
+					r.Get("/payment/return/{transactionId}", payments.Return(log, core))
+					r.Post("/payment/return/order/{orderId}", payments.ReturnByOrder(log, core))
+				})
 			})
-		}
+
+			r.Group(func(r chi.Router) {
+				r.Use(authorize.Require(log, entity.ScopeReportsRead))
+
+				r.Get("/service/report/month", report.MonthlyStatistics(log, core))
+				r.Get("/service/report/user", report.UsersStatistics(log, core))
+				r.Get("/service/report/charger", report.ChargerStatistics(log, core))
+				r.Get("/service/report/export", report.ExportStatistics(log, core))
+				r.Get("/service/report/power", report.PowerStatistics(log, core))
+				r.Get("/service/report/uptime", report.StationUptimeStatistics(log, core))
+				r.Get("/service/report/status", report.StationStatusStatistics(log, core))
+			})
+		})
 
 		// requests without authorization token
 		r.Group(func(r chi.Router) {
 			r.Get("/config/{name}", helper.Config(log, core))
 			r.Post("/users/authenticate", users.Authenticate(log, core))
 			r.Post("/users/register", users.Register(log, core))
+			r.Post("/users/refresh", users.Refresh(log, core))
+			r.Post("/users/password/forgot", users.PasswordForgot(log, core))
+			r.Post("/users/password/reset", users.PasswordReset(log, core))
+			r.Post("/users/email/verify", users.EmailVerify(log, core))
 			r.Post("/payment/notify", payments.Notify(log, core))
 		})
 	})
@@ -192,6 +372,11 @@ func (s *Server) SetStatusReader(statusReader websocket.StatusReader) {
 	s.statusReader = statusReader
 }
 
+// Pool returns the pool of WebSocket connections.
+func (s *Server) Pool() *websocket.Pool {
+	return s.pool
+}
+
 func (s *Server) Start() error {
 	if s.conf == nil {
 		return fmt.Errorf("configuration not loaded")
@@ -200,9 +385,6 @@ func (s *Server) Start() error {
 		return fmt.Errorf("core handler not set")
 	}
 
-	s.pool = websocket.NewPool(s.log)
-	go s.pool.Start()
-
 	// start listening for log updates, if update received, send it to all subscribed clients
 	s.broadcaster = websocket.NewBroadcaster(s.pool, s.statusReader, s.log)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -1,6 +1,10 @@
 package apikey
 
 import (
+	"context"
+	"crypto/subtle"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
 	"evsys-back/internal/lib/api/response"
 	"evsys-back/internal/lib/sl"
 	"log/slog"
@@ -10,9 +14,25 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// Authenticator resolves an API key to the identity of the integration using it.
+type Authenticator interface {
+	AuthenticateApiKey(ctx context.Context, key string) (*entity.User, error)
+}
+
+// legacyUser is the identity of the single key set in the configuration,
+// which may pay and refund transactions.
+var legacyUser = entity.User{
+	UserId:      "apikey:config",
+	Username:    "apikey:config",
+	Permissions: []string{entity.ScopePaymentsPay, entity.ScopePaymentsRefund},
+}
+
 // New returns middleware that validates a Bearer API key from the Authorization header.
 // Used to authenticate service-to-service calls (e.g. central system -> payment endpoints).
-func New(log *slog.Logger, key string) func(next http.Handler) http.Handler {
+// The key's scopes are put in the request context as the permissions of its user,
+// so routes check them with authorize.Require. The legacy key from the configuration
+// is accepted as well when it is set.
+func New(log *slog.Logger, auth Authenticator, legacyKey string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			logger := log.With(
@@ -39,14 +59,22 @@ func New(log *slog.Logger, key string) func(next http.Handler) http.Handler {
 				return
 			}
 
-			if token != key {
-				logger.Warn("invalid api key")
-				response.Render(w, r, http.StatusUnauthorized, 2001, "Invalid API key")
-				return
+			var user *entity.User
+			if legacyKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(legacyKey)) == 1 {
+				legacy := legacyUser
+				user = &legacy
+			} else {
+				var err error
+				user, err = auth.AuthenticateApiKey(r.Context(), token)
+				if err != nil {
+					logger.With(sl.Err(err)).Warn("invalid api key")
+					response.Render(w, r, http.StatusUnauthorized, 2001, "Invalid API key")
+					return
+				}
 			}
 
-			logger.Debug("api key authenticated")
-			next.ServeHTTP(w, r)
+			logger.With(slog.String("user", user.Username)).Debug("api key authenticated")
+			next.ServeHTTP(w, r.WithContext(cont.PutUser(r.Context(), user)))
 		})
 	}
 }
@@ -10,19 +10,20 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-// RequirePowerUser returns middleware that rejects non-admin/operator users with 403.
-func RequirePowerUser(log *slog.Logger) func(next http.Handler) http.Handler {
+// Require returns middleware that rejects users whose role lacks the permission with 403.
+func Require(log *slog.Logger, permission string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user := cont.GetUser(r.Context())
-			if !user.IsPowerUser() {
+			if !user.Can(permission) {
 				log.With(
 					sl.Module("middleware.authorize"),
 					slog.String("user", user.Username),
 					slog.String("role", user.Role),
+					slog.String("permission", permission),
 					slog.String("path", r.URL.Path),
 					slog.String("request_id", middleware.GetReqID(r.Context())),
-				).Warn("access denied: not admin or operator")
+				).Warn("access denied: permission required")
 				response.Forbidden(w, r)
 				return
 			}
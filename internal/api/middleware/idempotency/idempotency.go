@@ -0,0 +1,134 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/api/cont"
+	"evsys-back/internal/lib/api/response"
+	"evsys-back/internal/lib/sl"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Header is the request header carrying the idempotency key.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set on responses replayed from a stored record.
+const ReplayedHeader = "Idempotent-Replayed"
+
+const maxKeyLength = 255
+
+type Store interface {
+	ReserveIdempotencyKey(ctx context.Context, key, fingerprint string) (*entity.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, record *entity.IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// New returns middleware that makes requests carrying an Idempotency-Key
+// header safe to retry. The first request with a key is executed and its
+// response stored; repeating it returns the stored response, and reusing the
+// key for another request is rejected with 409 Conflict. Server errors are
+// not stored, so the request can be retried with the same key. Requests
+// without the header pass through unchanged. Keys are kept per API key, so
+// integrations choosing the same key do not see each other's responses.
+func New(log *slog.Logger, store Store) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			logger := log.With(
+				sl.Module("middleware.idempotency"),
+				slog.String("path", r.URL.Path),
+				slog.String("idempotency_key", key),
+				slog.String("request_id", middleware.GetReqID(ctx)),
+			)
+			if len(key) > maxKeyLength {
+				logger.Warn("idempotency key too long")
+				response.Render(w, r, http.StatusBadRequest, 3001, "Idempotency key is too long")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				response.RenderErr(w, r, http.StatusBadRequest, 3001, "Failed to read request body", err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			key = scopedKey(ctx, key)
+
+			stored, err := store.ReserveIdempotencyKey(ctx, key, fingerprint(r, body))
+			switch {
+			case errors.Is(err, entity.ErrIdempotencyMismatch), errors.Is(err, entity.ErrIdempotencyInProgress):
+				logger.With(sl.Err(err)).Warn("idempotency conflict")
+				response.Render(w, r, http.StatusConflict, 3005, err.Error())
+				return
+			case err != nil:
+				logger.With(sl.Err(err)).Error("reserve idempotency key")
+				response.RenderErr(w, r, http.StatusInternalServerError, 3005, "Idempotency check failed", err)
+				return
+			case stored != nil:
+				logger.With(slog.Int("status", stored.Status)).Info("idempotent response replayed")
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set(ReplayedHeader, "true")
+				w.WriteHeader(stored.Status)
+				_, _ = w.Write(stored.Body)
+				return
+			}
+
+			var buf bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+			next.ServeHTTP(ww, r)
+
+			// the request context may be cancelled once the handler returns
+			ctx = context.WithoutCancel(ctx)
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError {
+				if e := store.ReleaseIdempotencyKey(ctx, key); e != nil {
+					logger.With(sl.Err(e)).Error("release idempotency key")
+				}
+				return
+			}
+			record := &entity.IdempotencyRecord{
+				Key:         key,
+				Fingerprint: fingerprint(r, body),
+				Status:      status,
+				ContentType: ww.Header().Get("Content-Type"),
+				Body:        buf.Bytes(),
+			}
+			if e := store.CompleteIdempotencyKey(ctx, record); e != nil {
+				logger.With(sl.Err(e)).Error("store idempotent response")
+			}
+		})
+	}
+}
+
+// scopedKey qualifies an idempotency key with the API key the request is
+// authenticated with.
+func scopedKey(ctx context.Context, key string) string {
+	return cont.GetUser(ctx).UserId + "/" + key
+}
+
+// fingerprint identifies a request by its method, path and body.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"context"
+	"evsys-back/entity"
+	"evsys-back/impl/core"
+	database_mock "evsys-back/impl/database-mock"
+	"evsys-back/internal/lib/api/cont"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// newTestServer serves a handler counting its calls behind the middleware;
+// it answers with the given status and the call number.
+func newTestServer(status *int) (http.Handler, *int, *core.Core) {
+	calls := 0
+	c := core.New(newTestLogger(), database_mock.NewMockDB())
+	handler := New(newTestLogger(), c)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(*status)
+		_, _ = fmt.Fprintf(w, `{"call":%d}`, calls)
+	}))
+	return handler, &calls, c
+}
+
+func send(h http.Handler, key, path, body string) *httptest.ResponseRecorder {
+	return sendAs(h, nil, key, path, body)
+}
+
+// sendAs sends a request authenticated as the given API key user.
+func sendAs(h http.Handler, user *entity.User, key, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if user != nil {
+		req = req.WithContext(cont.PutUser(req.Context(), user))
+	}
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotency(t *testing.T) {
+	status := http.StatusOK
+	h, calls, _ := newTestServer(&status)
+
+	first := send(h, "k1", "/payment/return/order/1200", `{"amount":100}`)
+	again := send(h, "k1", "/payment/return/order/1200", `{"amount":100}`)
+	assert.Equal(t, 1, *calls, "a repeated request is not executed again")
+	assert.Equal(t, http.StatusOK, again.Code)
+	assert.Equal(t, first.Body.String(), again.Body.String())
+	assert.Equal(t, "application/json", again.Header().Get("Content-Type"))
+	assert.Equal(t, "true", again.Header().Get(ReplayedHeader))
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+
+	otherBody := send(h, "k1", "/payment/return/order/1200", `{"amount":200}`)
+	assert.Equal(t, http.StatusConflict, otherBody.Code)
+	otherPath := send(h, "k1", "/payment/return/order/1201", `{"amount":100}`)
+	assert.Equal(t, http.StatusConflict, otherPath.Code)
+	assert.Equal(t, 1, *calls)
+
+	send(h, "", "/payment/pay/1", "")
+	send(h, "", "/payment/pay/1", "")
+	assert.Equal(t, 3, *calls, "requests without a key always run")
+}
+
+func TestIdempotency_ServerErrorReleasesKey(t *testing.T) {
+	status := http.StatusInternalServerError
+	h, calls, _ := newTestServer(&status)
+
+	assert.Equal(t, http.StatusInternalServerError, send(h, "k1", "/payment/pay/1", "").Code)
+	status = http.StatusOK
+	retry := send(h, "k1", "/payment/pay/1", "")
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, 2, *calls, "a failed request can be retried with the same key")
+
+	status = http.StatusBadRequest
+	send(h, "k2", "/payment/pay/2", "")
+	replay := send(h, "k2", "/payment/pay/2", "")
+	assert.Equal(t, http.StatusBadRequest, replay.Code, "client errors are replayed")
+	assert.Equal(t, 3, *calls)
+}
+
+func TestIdempotency_InProgress(t *testing.T) {
+	status := http.StatusOK
+	h, calls, c := newTestServer(&status)
+
+	req := httptest.NewRequest(http.MethodPost, "/payment/pay/1", nil)
+	stored, err := c.ReserveIdempotencyKey(context.Background(), scopedKey(req.Context(), "k1"), fingerprint(req, nil))
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	assert.Equal(t, http.StatusConflict, send(h, "k1", "/payment/pay/1", "").Code)
+	assert.Equal(t, 0, *calls)
+}
+
+func TestIdempotency_KeysPerApiKey(t *testing.T) {
+	status := http.StatusOK
+	h, calls, _ := newTestServer(&status)
+	first := &entity.ApiKey{Id: "key-1", Name: "cs"}
+	second := &entity.ApiKey{Id: "key-2", Name: "billing"}
+
+	sendAs(h, first.User(), "k1", "/payment/pay/1", "")
+	other := sendAs(h, second.User(), "k1", "/payment/pay/1", "")
+	assert.Equal(t, 2, *calls, "the same key of another integration is a new request")
+	assert.Empty(t, other.Header().Get(ReplayedHeader))
+
+	replay := sendAs(h, first.User(), "k1", "/payment/pay/1", "")
+	assert.Equal(t, "true", replay.Header().Get(ReplayedHeader))
+	assert.Equal(t, 2, *calls)
+}
@@ -75,6 +75,17 @@ func (c *Client) RemoteAddr() string {
 	return c.ws.RemoteAddr().String()
 }
 
+// UserId returns the id of the authenticated user, empty before the first
+// request (implements PoolClient)
+func (c *Client) UserId() string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.user == nil {
+		return ""
+	}
+	return c.user.UserId
+}
+
 // SendResponse sends a simple response with status and info (implements PoolClient)
 func (c *Client) SendResponse(status entity.ResponseStatus, info string) {
 	response := &entity.WsResponse{
@@ -145,7 +156,7 @@ func (c *Client) readPump() {
 
 		if c.user == nil {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			c.user, err = c.core.AuthenticateByToken(ctx, userRequest.Token)
+			user, err := c.core.AuthenticateByToken(ctx, userRequest.Token)
 			cancel()
 			if err != nil {
 				c.SendResponse(entity.Error, fmt.Sprintf("check token: %v", err))
@@ -153,13 +164,18 @@ func (c *Client) readPump() {
 			}
 
 			ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-			c.id, err = c.core.UserTag(ctx, c.user)
+			c.id, err = c.core.UserTag(ctx, user)
 			cancel()
 			if err != nil {
 				c.SendResponse(entity.Error, fmt.Sprintf("get user tag: %v", err))
 				continue
 			}
 
+			// the pool reads the user to route notices
+			c.mux.Lock()
+			c.user = user
+			c.mux.Unlock()
+
 			if c.user != nil {
 				c.logger = c.logger.With(
 					slog.String("user", c.user.Username),
@@ -13,6 +13,7 @@ type PoolClient interface {
 	SendResponse(status entity.ResponseStatus, info string)
 	WsResponse(response *entity.WsResponse)
 	RemoteAddr() string
+	UserId() string
 }
 
 // Pool manages WebSocket client connections and message broadcasting
@@ -23,9 +24,16 @@ type Pool struct {
 	broadcast  chan []byte
 	logEvent   chan *entity.WsResponse
 	chpEvent   chan *entity.WsResponse
+	userEvent  chan *userEvent
 	logger     *slog.Logger
 }
 
+// userEvent is a message for the connections of one user
+type userEvent struct {
+	userId   string
+	response *entity.WsResponse
+}
+
 // NewPool creates a new WebSocket connection pool
 func NewPool(logger *slog.Logger) *Pool {
 	return &Pool{
@@ -35,6 +43,7 @@ func NewPool(logger *slog.Logger) *Pool {
 		broadcast:  make(chan []byte),
 		logEvent:   make(chan *entity.WsResponse),
 		chpEvent:   make(chan *entity.WsResponse),
+		userEvent:  make(chan *userEvent),
 		logger:     logger,
 	}
 }
@@ -71,6 +80,12 @@ func (p *Pool) Start() {
 					client.WsResponse(message)
 				}
 			}
+		case event := <-p.userEvent:
+			for client := range p.clients {
+				if client.UserId() == event.userId {
+					client.WsResponse(event.response)
+				}
+			}
 		}
 	}
 }
@@ -99,3 +114,12 @@ func (p *Pool) SendChpEvent(msg *entity.WsResponse) {
 func (p *Pool) Broadcast(message []byte) {
 	p.broadcast <- message
 }
+
+// NotifyUser sends a message to every open connection of the user, whatever
+// the connection's subscription
+func (p *Pool) NotifyUser(userId string, msg *entity.WsResponse) {
+	if userId == "" {
+		return
+	}
+	p.userEvent <- &userEvent{userId: userId, response: msg}
+}
@@ -42,6 +42,9 @@ func FailCode(w http.ResponseWriter, r *http.Request, log *slog.Logger, status,
 		if errors.Is(err, entity.ErrNotFound) {
 			status = http.StatusNotFound
 		}
+		if errors.Is(err, entity.ErrAlreadyExists) {
+			status = http.StatusConflict
+		}
 	}
 	if err != nil {
 		log = log.With(sl.Err(err))
@@ -16,12 +16,9 @@ var (
 	once     sync.Once
 )
 
-// Valid user roles
-var validUserRoles = map[string]bool{
-	"":         true,
-	"admin":    true,
-	"operator": true,
-}
+// Role names are stored in the database; a name is lowercase letters, digits,
+// "-" and "_", starting with a letter
+var userRoleRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
 
 // OCPP 1.6 ChargePointStatus values
 var validConnectorStatuses = map[string]bool{
@@ -88,9 +85,13 @@ func validateEmailRFC(fl validator.FieldLevel) bool {
 	return emailRegex.MatchString(email)
 }
 
-// validateUserRole validates user role against allowed values
+// validateUserRole validates the format of a role name, allows empty string
 func validateUserRole(fl validator.FieldLevel) bool {
-	return validUserRoles[fl.Field().String()]
+	role := fl.Field().String()
+	if role == "" {
+		return true
+	}
+	return userRoleRegex.MatchString(role)
 }
 
 // validateConnectorStatus validates OCPP connector status
@@ -0,0 +1,241 @@
+package oidc
+
+import (
+	"encoding/json"
+	"evsys-back/entity"
+	"evsys-back/internal/lib/sl"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	defaultUserIdClaim = "sub"
+	defaultNameClaim   = "name"
+	defaultEmailClaim  = "email"
+	// clock difference allowed when checking expiry
+	leeway = time.Minute
+	// keys loaded from a url are reloaded this often, and at most this
+	// often when a token is signed by an unknown key
+	jwksRefresh     = time.Hour
+	jwksMinInterval = time.Minute
+	fetchTimeout    = 10 * time.Second
+)
+
+var algorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// Issuer is an identity provider whose JWTs authenticate users. The keys are
+// loaded from JwksUrl or, when it is empty, from the JwksFile. User ids are
+// the value of UserIdClaim prefixed with Name and a colon, so a provider
+// cannot assert the id of a local user or of another provider.
+type Issuer struct {
+	Name        string   // required
+	Url         string   // the "iss" claim
+	Audience    []string // accepted values of the "aud" claim
+	JwksUrl     string
+	JwksFile    string
+	UserIdClaim string // "sub" by default
+	NameClaim   string // "name" by default
+	EmailClaim  string // "email" by default
+}
+
+// provider is an issuer with its current keys.
+type provider struct {
+	Issuer
+	keys   *jose.JSONWebKeySet
+	loaded time.Time
+}
+
+// Verifier checks the JWTs of the configured issuers.
+type Verifier struct {
+	log       *slog.Logger
+	client    *http.Client
+	providers map[string]*provider // key: issuer url
+	mux       sync.Mutex
+}
+
+// New loads the keys of the issuers; an issuer without name, audience or
+// keys is an error.
+func New(log *slog.Logger, issuers []Issuer) (*Verifier, error) {
+	v := &Verifier{
+		log:       log.With(sl.Module("internal.oidc")),
+		client:    &http.Client{Timeout: fetchTimeout},
+		providers: make(map[string]*provider),
+	}
+	for _, issuer := range issuers {
+		if issuer.Url == "" {
+			return nil, fmt.Errorf("issuer url is empty")
+		}
+		if issuer.Name == "" {
+			return nil, fmt.Errorf("issuer %s: name is empty", issuer.Url)
+		}
+		if len(issuer.Audience) == 0 {
+			return nil, fmt.Errorf("issuer %s: audience is empty", issuer.Url)
+		}
+		if issuer.JwksUrl == "" && issuer.JwksFile == "" {
+			return nil, fmt.Errorf("issuer %s: no jwks url or file", issuer.Url)
+		}
+		if _, ok := v.providers[issuer.Url]; ok {
+			return nil, fmt.Errorf("issuer %s: configured twice", issuer.Url)
+		}
+		if issuer.UserIdClaim == "" {
+			issuer.UserIdClaim = defaultUserIdClaim
+		}
+		if issuer.NameClaim == "" {
+			issuer.NameClaim = defaultNameClaim
+		}
+		if issuer.EmailClaim == "" {
+			issuer.EmailClaim = defaultEmailClaim
+		}
+		p := &provider{Issuer: issuer}
+		if err := v.loadKeys(p); err != nil {
+			return nil, fmt.Errorf("issuer %s: %w", issuer.Url, err)
+		}
+		v.providers[issuer.Url] = p
+	}
+	return v, nil
+}
+
+// loadKeys reads the key set of the provider from its url or file.
+func (v *Verifier) loadKeys(p *provider) error {
+	var data []byte
+	var err error
+	if p.JwksUrl != "" {
+		data, err = v.fetch(p.JwksUrl)
+	} else {
+		data, err = os.ReadFile(p.JwksFile)
+	}
+	if err != nil {
+		return fmt.Errorf("loading jwks: %w", err)
+	}
+	var keys jose.JSONWebKeySet
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("decoding jwks: %w", err)
+	}
+	if len(keys.Keys) == 0 {
+		return fmt.Errorf("jwks has no keys")
+	}
+	p.keys = &keys
+	p.loaded = time.Now()
+	return nil
+}
+
+func (v *Verifier) fetch(url string) ([]byte, error) {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// signingKeys returns the keys of the key id, reloading keys of a url when
+// they are old or the id is unknown; the loaded keys are kept when a reload
+// fails.
+func (v *Verifier) signingKeys(p *provider, kid string) []jose.JSONWebKey {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	keys := p.matchingKeys(kid)
+	since := time.Since(p.loaded)
+	if p.JwksUrl != "" && (since > jwksRefresh || (len(keys) == 0 && since > jwksMinInterval)) {
+		if err := v.loadKeys(p); err != nil {
+			v.log.With(slog.String("issuer", p.Url), sl.Err(err)).Warn("reloading jwks")
+			p.loaded = time.Now()
+		}
+		keys = p.matchingKeys(kid)
+	}
+	return keys
+}
+
+func (p *provider) matchingKeys(kid string) []jose.JSONWebKey {
+	if kid == "" {
+		return p.keys.Keys
+	}
+	return p.keys.Key(kid)
+}
+
+// issuer returns the provider of the token's "iss" claim, without verifying
+// the token.
+func (v *Verifier) issuer(token string) (*jwt.JSONWebToken, *provider) {
+	parsed, err := jwt.ParseSigned(token, algorithms)
+	if err != nil {
+		return nil, nil
+	}
+	var claims jwt.Claims
+	if err = parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, nil
+	}
+	return parsed, v.providers[claims.Issuer]
+}
+
+// Accepts reports whether the token is a JWT of a configured issuer.
+func (v *Verifier) Accepts(token string) bool {
+	_, p := v.issuer(token)
+	return p != nil
+}
+
+// VerifyToken checks the signature, issuer, audience and expiry of a JWT
+// and returns the identity its claims assert.
+func (v *Verifier) VerifyToken(token string) (*entity.Identity, error) {
+	parsed, p := v.issuer(token)
+	if p == nil {
+		return nil, fmt.Errorf("unknown token issuer")
+	}
+	kid := ""
+	if len(parsed.Headers) > 0 {
+		kid = parsed.Headers[0].KeyID
+	}
+	var claims jwt.Claims
+	var custom map[string]any
+	verified := false
+	for _, key := range v.signingKeys(p, kid) {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if parsed.Claims(key.Public(), &claims, &custom) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	expected := jwt.Expected{Issuer: p.Url, AnyAudience: p.Audience, Time: time.Now()}
+	if err := claims.ValidateWithLeeway(expected, leeway); err != nil {
+		return nil, err
+	}
+	return p.identity(custom)
+}
+
+// identity maps the claims of a verified token to a user.
+func (p *provider) identity(claims map[string]any) (*entity.Identity, error) {
+	userId, _ := claims[p.UserIdClaim].(string)
+	if userId == "" {
+		return nil, fmt.Errorf("token has no %s claim", p.UserIdClaim)
+	}
+	identity := &entity.Identity{UserId: p.Name + ":" + userId}
+	identity.Name, _ = claims[p.NameClaim].(string)
+	// an address the provider has not verified is not taken
+	if verified, ok := claims["email_verified"].(bool); !ok || verified {
+		identity.Email, _ = claims[p.EmailClaim].(string)
+	}
+	return identity, nil
+}
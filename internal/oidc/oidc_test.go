@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"evsys-back/entity"
+	"evsys-back/impl/authenticator"
+	database_mock "evsys-back/impl/database-mock"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIssuer = "https://id.example.com"
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+// testKey is a signing key with its public part for a key set.
+type testKey struct {
+	private *ecdsa.PrivateKey
+	public  jose.JSONWebKey
+}
+
+func newTestKey(t *testing.T, kid string) *testKey {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &testKey{
+		private: private,
+		public:  jose.JSONWebKey{Key: &private.PublicKey, KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"},
+	}
+}
+
+func (k *testKey) sign(t *testing.T, claims map[string]any) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: k.private},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader(jose.HeaderKey("kid"), k.public.KeyID))
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	require.NoError(t, err)
+	return token
+}
+
+func jwks(t *testing.T, keys ...*testKey) []byte {
+	set := jose.JSONWebKeySet{}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.public)
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func writeJwks(t *testing.T, keys ...*testKey) string {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwks(t, keys...), 0o600))
+	return path
+}
+
+func TestVerifyToken(t *testing.T) {
+	key := newTestKey(t, "k1")
+	other := newTestKey(t, "k1")
+	verifier, err := New(newTestLogger(), []Issuer{{
+		Name:     "partner",
+		Url:      testIssuer,
+		Audience: []string{"evsys"},
+		JwksFile: writeJwks(t, key),
+	}})
+	require.NoError(t, err)
+
+	claims := func(change func(c map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss":   testIssuer,
+			"aud":   "evsys",
+			"sub":   "42",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"name":  "Jane Driver",
+			"email": "jane@example.com",
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name    string
+		token   string
+		accepts bool
+		wantErr bool
+	}{
+		{"valid", key.sign(t, claims(nil)), true, false},
+		{"other audience", key.sign(t, claims(func(c map[string]any) { c["aud"] = "other" })), true, true},
+		{"expired", key.sign(t, claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), true, true},
+		{"no expiry", key.sign(t, claims(func(c map[string]any) { delete(c, "exp") })), true, true},
+		{"no subject", key.sign(t, claims(func(c map[string]any) { delete(c, "sub") })), true, true},
+		{"other key", other.sign(t, claims(nil)), true, true},
+		{"other issuer", key.sign(t, claims(func(c map[string]any) { c["iss"] = "https://other.example.com" })), false, true},
+		{"not a jwt", "0123456789abcdef0123456789abcdef0123", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.accepts, verifier.Accepts(tt.token))
+			identity, err := verifier.VerifyToken(tt.token)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "partner:42", identity.UserId)
+			assert.Equal(t, "Jane Driver", identity.Name)
+			assert.Equal(t, "jane@example.com", identity.Email)
+		})
+	}
+
+	identity, err := verifier.VerifyToken(key.sign(t, claims(func(c map[string]any) { c["email_verified"] = false })))
+	require.NoError(t, err)
+	assert.Empty(t, identity.Email, "unverified email")
+}
+
+func TestJwksUrl(t *testing.T) {
+	first := newTestKey(t, "k1")
+	second := newTestKey(t, "k2")
+	served := jwks(t, first)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(served)
+	}))
+	defer server.Close()
+
+	verifier, err := New(newTestLogger(), []Issuer{{
+		Name:        "fleet",
+		Url:         testIssuer,
+		Audience:    []string{"evsys"},
+		JwksUrl:     server.URL,
+		UserIdClaim: "uid",
+	}})
+	require.NoError(t, err)
+	claims := map[string]any{"iss": testIssuer, "aud": []string{"evsys"}, "uid": "u-7", "exp": time.Now().Add(time.Hour).Unix()}
+
+	identity, err := verifier.VerifyToken(first.sign(t, claims))
+	require.NoError(t, err)
+	assert.Equal(t, "fleet:u-7", identity.UserId)
+
+	// the provider rotates its key
+	served = jwks(t, second)
+	_, err = verifier.VerifyToken(second.sign(t, claims))
+	assert.Error(t, err, "keys reloaded at most once a minute")
+	verifier.providers[testIssuer].loaded = time.Now().Add(-2 * jwksMinInterval)
+	_, err = verifier.VerifyToken(second.sign(t, claims))
+	assert.NoError(t, err, "unknown key id reloads the keys")
+}
+
+func TestNewIssuerErrors(t *testing.T) {
+	file := writeJwks(t, newTestKey(t, "k1"))
+	tests := []struct {
+		name   string
+		issuer Issuer
+	}{
+		{"no url", Issuer{Name: "p", Audience: []string{"evsys"}, JwksFile: file}},
+		{"no name", Issuer{Url: testIssuer, Audience: []string{"evsys"}, JwksFile: file}},
+		{"no audience", Issuer{Name: "p", Url: testIssuer, JwksFile: file}},
+		{"no keys", Issuer{Name: "p", Url: testIssuer, Audience: []string{"evsys"}}},
+		{"missing file", Issuer{Name: "p", Url: testIssuer, Audience: []string{"evsys"}, JwksFile: file + ".none"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(newTestLogger(), []Issuer{tt.issuer})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLocalUserIdNotAsserted(t *testing.T) {
+	ctx := context.Background()
+	key := newTestKey(t, "k1")
+	verifier, err := New(newTestLogger(), []Issuer{{
+		Name:     "partner",
+		Url:      testIssuer,
+		Audience: []string{"evsys"},
+		JwksFile: writeJwks(t, key),
+	}})
+	require.NoError(t, err)
+	db := database_mock.NewMockDB()
+	db.SeedUser(&entity.User{Username: "root", UserId: "u1", Role: entity.RoleAdmin})
+	auth := authenticator.New(newTestLogger(), db)
+	auth.AddTokenVerifier(verifier)
+
+	token := key.sign(t, map[string]any{"iss": testIssuer, "aud": "evsys", "sub": "u1", "exp": time.Now().Add(time.Hour).Unix()})
+	user, err := auth.AuthenticateByToken(ctx, token)
+	require.NoError(t, err)
+	assert.NotEqual(t, "root", user.Username)
+	assert.Equal(t, "partner:u1", user.UserId)
+	assert.Empty(t, user.Role)
+}
@@ -3,12 +3,14 @@ package main
 import (
 	"context"
 	"evsys-back/config"
+	"evsys-back/entity"
 	"evsys-back/impl/authenticator"
 	"evsys-back/impl/brevo"
 	"evsys-back/impl/central-system"
 	"evsys-back/impl/core"
 	"evsys-back/impl/database"
 	databasemock "evsys-back/impl/database-mock"
+	gatewaymock "evsys-back/impl/gateway-mock"
 	"evsys-back/impl/mail"
 	"evsys-back/impl/redsys"
 	"evsys-back/impl/reports"
@@ -17,6 +19,7 @@ import (
 	"evsys-back/internal/firebase"
 	"evsys-back/internal/lib/logger"
 	"evsys-back/internal/lib/sl"
+	"evsys-back/internal/oidc"
 	"flag"
 	"log/slog"
 	"os"
@@ -59,6 +62,10 @@ func main() {
 	} else {
 		auth = authenticator.New(log, mockDb)
 	}
+	auth.SetSessionLifetime(conf.Session.TokenTTL, conf.Session.RefreshTTL)
+	auth.SetAccountTokens(conf.Account.ResetTTL, conf.Account.VerifyTTL, conf.Account.VerifyEmail)
+	auth.SetInviteRequired(conf.Account.InviteRequired)
+	auth.SetTwoFactor(conf.TwoFactor.Issuer, conf.TwoFactor.RequiredRoles)
 
 	var rep *reports.Reports
 	if conf.Mongo.Enabled {
@@ -78,6 +85,29 @@ func main() {
 		auth.SetFirebase(fb)
 	}
 
+	if len(conf.Oidc) > 0 {
+		issuers := make([]oidc.Issuer, 0, len(conf.Oidc))
+		for _, p := range conf.Oidc {
+			issuers = append(issuers, oidc.Issuer{
+				Name:        p.Name,
+				Url:         p.Issuer,
+				Audience:    p.Audience,
+				JwksUrl:     p.JwksUrl,
+				JwksFile:    p.JwksFile,
+				UserIdClaim: p.UserIdClaim,
+				NameClaim:   p.NameClaim,
+				EmailClaim:  p.EmailClaim,
+			})
+		}
+		verifier, err := oidc.New(log, issuers)
+		if err != nil {
+			log.Error("oidc verifier", sl.Err(err))
+			return
+		}
+		auth.AddTokenVerifier(verifier)
+		log.With(slog.Int("issuers", len(issuers))).Info("oidc enabled")
+	}
+
 	var coreHandler *core.Core
 	if conf.Mongo.Enabled {
 		coreHandler = core.New(log, mongo)
@@ -87,6 +117,52 @@ func main() {
 	coreHandler.SetAuth(auth)
 	coreHandler.SetReports(rep)
 
+	if conf.Invoice.Enabled {
+		log.With(
+			slog.String("series", conf.Invoice.Series),
+			slog.Float64("vat_rate", conf.Invoice.VatRate),
+		).Info("invoicing enabled")
+		coreHandler.SetInvoicing(&core.InvoiceConfig{
+			Series:       conf.Invoice.Series,
+			CreditSeries: conf.Invoice.CreditSeries,
+			VatRate:      conf.Invoice.VatRate,
+			VatRates:     conf.Invoice.VatRates,
+			Issuer: entity.FiscalDetails{
+				Name:    conf.Invoice.IssuerName,
+				TaxId:   conf.Invoice.IssuerTaxId,
+				Address: conf.Invoice.IssuerAddress,
+			},
+		})
+	}
+
+	coreHandler.SetPreauthorization(&core.PreauthorizationConfig{
+		StartTimeout: conf.Preauthorization.StartTimeout,
+		MaxAge:       conf.Preauthorization.MaxAge,
+	})
+	coreHandler.SetRetryPolicy(&core.RetryPolicy{
+		Soft:      conf.PaymentRetry.Soft,
+		Technical: conf.PaymentRetry.Technical,
+	})
+	coreHandler.SetWallet(&core.WalletConfig{
+		MinStartBalance: conf.Wallet.MinStartBalance,
+		MinTopUp:        conf.Wallet.MinTopUp,
+		MaxTopUp:        conf.Wallet.MaxTopUp,
+	})
+	coreHandler.SetAccount(&core.AccountConfig{
+		ResetUrl:  conf.Account.ResetUrl,
+		VerifyUrl: conf.Account.VerifyUrl,
+	})
+	if conf.Account.VerifyEmail && !conf.Brevo.Enabled {
+		log.Warn("email verification is required, but mail is not enabled")
+	}
+	if conf.CardNotice.Enabled {
+		coreHandler.SetCardNotice(&core.CardNoticeConfig{
+			ExpiryWindow: conf.CardNotice.ExpiryWindow,
+			ResendAfter:  conf.CardNotice.ResendAfter,
+			AddCardUrl:   conf.CardNotice.AddCardUrl,
+		})
+	}
+
 	if conf.CentralSystem.Enabled {
 		log.With(
 			slog.String("url", conf.CentralSystem.Url),
@@ -110,13 +186,32 @@ func main() {
 			NotifyUrl:    conf.Redsys.NotifyUrl,
 			Currency:     conf.Redsys.Currency,
 		}, log)
-		coreHandler.SetRedsys(redsysClient)
+		coreHandler.SetPaymentGateway(redsysClient)
 		coreHandler.SetCurrency(conf.Redsys.Currency)
 		if conf.Redsys.DisablePayment {
 			log.Warn("payment processing disabled (test mode)")
 			coreHandler.SetDisablePayment(true)
 		}
 		coreHandler.StartPaymentProcessor()
+	} else if conf.FakeGateway.Enabled {
+		if conf.Env == "prod" {
+			log.Error("fake payment gateway is not allowed in production")
+			return
+		}
+		log.With(
+			slog.String("outcome", conf.FakeGateway.Outcome),
+			slog.String("notify_url", conf.FakeGateway.NotifyUrl),
+		).Warn("using fake payment gateway")
+		coreHandler.SetPaymentGateway(gatewaymock.New(gatewaymock.Config{
+			Outcome:     conf.FakeGateway.Outcome,
+			Outcomes:    conf.FakeGateway.Outcomes,
+			DeclineCode: conf.FakeGateway.DeclineCode,
+			Timeout:     conf.FakeGateway.Timeout,
+			NotifyUrl:   conf.FakeGateway.NotifyUrl,
+			Currency:    conf.FakeGateway.Currency,
+		}, log))
+		coreHandler.SetCurrency(conf.FakeGateway.Currency)
+		coreHandler.StartPaymentProcessor()
 	}
 
 	var mailService *mail.Service
@@ -143,6 +238,7 @@ func main() {
 	}
 
 	server := http.NewServer(conf, log, coreHandler)
+	coreHandler.SetNotifier(server.Pool())
 	if conf.Mongo.Enabled {
 		server.SetStatusReader(statusreader.New(log, mongo))
 	}